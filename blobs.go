@@ -42,6 +42,29 @@ func (err ErrBlobInvalidDigest) Error() string {
 		err.Digest, err.Reason)
 }
 
+// ErrBlobWriteMismatch is returned when the content of a blob read back from
+// storage after it was written does not match its digest.
+type ErrBlobWriteMismatch struct {
+	Digest digest.Digest
+	Actual digest.Digest
+}
+
+func (err ErrBlobWriteMismatch) Error() string {
+	return fmt.Sprintf("blob %v read back from storage with digest %v", err.Digest, err.Actual)
+}
+
+// ErrBlobInvalidArchive returned when a layer blob is not a valid tar
+// archive.
+type ErrBlobInvalidArchive struct {
+	Digest digest.Digest
+	Reason error
+}
+
+func (err ErrBlobInvalidArchive) Error() string {
+	return fmt.Sprintf("invalid tar archive for layer: %v, %v",
+		err.Digest, err.Reason)
+}
+
 // ErrBlobMounted returned when a blob is mounted from another repository
 // instead of initiating an upload session.
 type ErrBlobMounted struct {
@@ -214,6 +237,15 @@ type CreateOptions struct {
 		// Blob access check will be skipped if set.
 		Stat *Descriptor
 	}
+
+	// IdempotencyKey, if Key is set, identifies the upload session across
+	// retries. Creating an upload with the key of a session that is still in
+	// progress resumes that session instead of starting a new one. Keys are
+	// scoped to the principal which supplied them.
+	IdempotencyKey struct {
+		Principal string
+		Key       string
+	}
 }
 
 // BlobWriter provides a handle for inserting data into a blob store.
@@ -252,6 +284,14 @@ type BlobWriter interface {
 	Cancel(ctx context.Context) error
 }
 
+// BlobWriteFingerprinter is implemented by blob writers which can digest the
+// content stored for them so far, allowing a client resuming the write to
+// check that it matches the content sent. ErrUnsupported is returned if the
+// underlying writer cannot.
+type BlobWriteFingerprinter interface {
+	Fingerprint(ctx context.Context) (digest.Digest, error)
+}
+
 // BlobService combines the operations to access, read and write blobs. This
 // can be used to describe remote blob services.
 type BlobService interface {
@@ -29,6 +29,18 @@ type Configuration struct {
 			Disabled bool `yaml:"disabled,omitempty"`
 		} `yaml:"accesslog,omitempty"`
 
+		// Audit configures the audit log, a durable record of manifest
+		// and blob deletes kept apart from the access log.
+		Audit struct {
+			// Path is the file the audit log is appended to. If empty,
+			// no audit log is kept.
+			Path string `yaml:"path,omitempty"`
+
+			// Pulls also records each manifest pulled, with the digests
+			// of the content it references.
+			Pulls bool `yaml:"pulls,omitempty"`
+		} `yaml:"audit,omitempty"`
+
 		// Level is the granularity at which registry operations are logged.
 		Level Loglevel `yaml:"level,omitempty"`
 
@@ -145,7 +157,18 @@ type Configuration struct {
 			Prometheus struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
+				// StorageLatencyBuckets are the upper bounds, in seconds,
+				// of the storage operation latency histogram buckets.
+				StorageLatencyBuckets []float64 `yaml:"storagelatencybuckets,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			// Profiling configures the pprof and expvar endpoints.
+			Profiling struct {
+				// Enabled mounts the endpoints on the debug server.
+				Enabled bool `yaml:"enabled,omitempty"`
+				// Path is the path under which the endpoints are
+				// mounted, /debug by default.
+				Path string `yaml:"path,omitempty"`
+			} `yaml:"profiling,omitempty"`
 		} `yaml:"debug,omitempty"`
 
 		// HTTP2 configuration options
@@ -154,6 +177,24 @@ type Configuration struct {
 			// to connect via http2. If set to true, only http/1.1 is supported.
 			Disabled bool `yaml:"disabled,omitempty"`
 		} `yaml:"http2,omitempty"`
+
+		// UploadProgress configures the stream of the progress of each
+		// blob upload, served as Server-Sent Events.
+		UploadProgress struct {
+			// Enabled serves the stream.
+			Enabled bool `yaml:"enabled,omitempty"`
+			// Interval is how often the progress of an upload is polled
+			// from storage, one second by default.
+			Interval time.Duration `yaml:"interval,omitempty"`
+		} `yaml:"uploadprogress,omitempty"`
+
+		// Options configures the responses to OPTIONS requests, which
+		// always advertise the methods allowed on a route.
+		Options struct {
+			// Capabilities, if true, includes a JSON description of the
+			// features enabled on the registry in the response body.
+			Capabilities bool `yaml:"capabilities,omitempty"`
+		} `yaml:"options,omitempty"`
 	} `yaml:"http,omitempty"`
 
 	// Notifications specifies configuration about various endpoint to which
@@ -194,6 +235,9 @@ type Configuration struct {
 
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
+	// Quota limits the storage used by each repository.
+	Quota Quota `yaml:"quota,omitempty"`
+
 	// Compatibility is used for configurations of working with older or deprecated features.
 	Compatibility struct {
 		// Schema1 configures how schema1 manifests will be handled
@@ -204,6 +248,34 @@ type Configuration struct {
 			// Enabled determines if schema1 manifests should be pullable
 			Enabled bool `yaml:"enabled,omitempty"`
 		} `yaml:"schema1,omitempty"`
+		// DefaultPlatform selects the image manifest served in place of a
+		// manifest list to clients which do not support manifest lists.
+		DefaultPlatform struct {
+			// Architecture defaults to amd64.
+			Architecture string `yaml:"architecture,omitempty"`
+			// OS defaults to linux.
+			OS string `yaml:"os,omitempty"`
+		} `yaml:"defaultplatform,omitempty"`
+		// ShortDigests allows manifests to be fetched by a unique prefix of
+		// their digest, such as sha256:4f2a9c1.
+		ShortDigests struct {
+			// Enabled resolves digest prefixes on manifest GET requests.
+			Enabled bool `yaml:"enabled,omitempty"`
+			// MinLength is the shortest hex prefix that will be resolved.
+			// Defaults to 7.
+			MinLength int `yaml:"minlength,omitempty"`
+		} `yaml:"shortdigests,omitempty"`
+		// DefaultTags maps repository names to the reference, a tag or
+		// digest, of the manifest served when a manifest is fetched
+		// without a reference.
+		DefaultTags map[string]string `yaml:"defaulttags,omitempty"`
+		// PlatformFilter allows clients to fetch manifest lists pruned to
+		// the platforms they request.
+		PlatformFilter struct {
+			// Enabled filters manifest lists fetched by tag to the
+			// platforms given by the platform query parameter.
+			Enabled bool `yaml:"enabled,omitempty"`
+		} `yaml:"platformfilter,omitempty"`
 	} `yaml:"compatibility,omitempty"`
 
 	// Validation configures validation options for the registry.
@@ -223,8 +295,150 @@ type Configuration struct {
 				// Deny specifies regular expressions (https://godoc.org/regexp/syntax)
 				// that URLs in pushed manifests must not match.
 				Deny []string `yaml:"deny,omitempty"`
+				// RequireHTTPS rejects URLs in pushed manifests which do not
+				// use HTTPS, whether or not they are allowed otherwise.
+				RequireHTTPS bool `yaml:"requirehttps,omitempty"`
 			} `yaml:"urls,omitempty"`
+			// Digests configures validation for digests referenced by
+			// pushed manifests.
+			Digests struct {
+				// Strict rejects manifests referencing content by a
+				// non-canonical digest, rather than normalizing it.
+				Strict bool `yaml:"strict,omitempty"`
+			} `yaml:"digests,omitempty"`
+			// Canonical rejects manifests which are not in canonical JSON
+			// form, with sorted keys and no insignificant whitespace.
+			Canonical bool `yaml:"canonical,omitempty"`
+			// LayerOrder rejects schema1 manifests whose history does not
+			// describe a single chain of unique layers, one for each
+			// FSLayer and in the same order.
+			LayerOrder bool `yaml:"layerorder,omitempty"`
+			// Signatures configures validation of the signatures of
+			// pushed schema1 manifests.
+			Signatures struct {
+				// Algorithms lists the JWS algorithms, such as ES256, with
+				// which schema1 manifests may be signed. If empty, any
+				// algorithm is allowed.
+				Algorithms []string `yaml:"algorithms,omitempty"`
+				// RequireChildren rejects manifest lists and image
+				// indexes unless every manifest they reference is a
+				// signed schema1 manifest in the repository.
+				RequireChildren bool `yaml:"requirechildren,omitempty"`
+			} `yaml:"signatures,omitempty"`
+			// Platforms configures validation of the platform described by
+			// the image config of pushed manifests.
+			Platforms struct {
+				// Allow lists the platforms, of the form os/architecture,
+				// which image manifests may describe. If empty, any
+				// platform is allowed.
+				Allow []string `yaml:"allow,omitempty"`
+			} `yaml:"platforms,omitempty"`
+			// MediaTypes configures the manifest media types which may be
+			// pushed.
+			MediaTypes struct {
+				// Allow lists the media types of manifests which may be
+				// pushed. If empty, any supported media type is allowed.
+				Allow []string `yaml:"allow,omitempty"`
+			} `yaml:"mediatypes,omitempty"`
+			// Tags configures which tags manifests may be pushed to,
+			// beyond the characters and length allowed in any tag.
+			Tags struct {
+				// Pattern, if set, is a regular expression
+				// (https://godoc.org/regexp/syntax) which tags must match
+				// entirely.
+				Pattern string `yaml:"pattern,omitempty"`
+				// MaxLength, if positive, is the maximum length of a tag.
+				MaxLength int `yaml:"maxlength,omitempty"`
+				// RejectDigestLike rejects tags which could be mistaken
+				// for a digest, such as an algorithm name followed by
+				// hex digits.
+				RejectDigestLike bool `yaml:"rejectdigestlike,omitempty"`
+			} `yaml:"tags,omitempty"`
+			// JSON limits the structure of pushed manifests, bounding the
+			// memory used to decode them beyond the limit on their size.
+			JSON struct {
+				// MaxDepth, if positive, is the deepest that objects and
+				// arrays may be nested.
+				MaxDepth int `yaml:"maxdepth,omitempty"`
+				// MaxElements, if positive, is the most values and
+				// object keys a manifest may contain.
+				MaxElements int `yaml:"maxelements,omitempty"`
+			} `yaml:"json,omitempty"`
+			// MissingContentType is how manifests pushed without a
+			// Content-Type are handled: "schema1", the default, treats
+			// them as schema1 manifests, while "reject" rejects them.
+			MissingContentType string `yaml:"missingcontenttype,omitempty"`
+			// Quarantine stores manifests which are rejected as invalid or
+			// unverifiable for later inspection. The push still fails.
+			Quarantine bool `yaml:"quarantine,omitempty"`
+			// QuarantineLimit is the maximum number of manifests
+			// quarantined per repository. If zero, it defaults to 100.
+			QuarantineLimit int `yaml:"quarantinelimit,omitempty"`
+			// Concurrency limits the number of manifests verified at once.
+			Concurrency struct {
+				// Limit, if positive, is the maximum number of manifest
+				// pushes verified concurrently. Further pushes wait.
+				Limit int `yaml:"limit,omitempty"`
+				// MaxQueued, if positive, is the maximum number of pushes
+				// which may wait. Pushes beyond it are rejected.
+				MaxQueued int `yaml:"maxqueued,omitempty"`
+			} `yaml:"concurrency,omitempty"`
 		} `yaml:"manifests,omitempty"`
+		// Names configures validation of repository names.
+		Names struct {
+			// MaxComponents, if positive, is the maximum number of path
+			// components in the name of a repository.
+			MaxComponents int `yaml:"maxcomponents,omitempty"`
+		} `yaml:"names,omitempty"`
+		// Uploads configures validation of blob uploads.
+		Uploads struct {
+			// RequireContentLength rejects requests completing a blob
+			// upload which do not declare a Content-Length, or whose body
+			// does not match it.
+			RequireContentLength bool `yaml:"requirecontentlength,omitempty"`
+			// MaxSize, if positive, is the maximum number of bytes a
+			// single upload may receive, across all of its requests.
+			// Uploads exceeding it are canceled.
+			MaxSize int64 `yaml:"maxsize,omitempty"`
+			// ValidateArchives causes manifests to be rejected unless
+			// the blobs they reference as layers are valid tar archives.
+			ValidateArchives bool `yaml:"validatearchives,omitempty"`
+			// RunningDigest causes completed uploads to be verified only
+			// against the digest maintained as their data was received,
+			// never by reading the data back.
+			RunningDigest bool `yaml:"runningdigest,omitempty"`
+			// VerifyWrites causes the data of each completed upload to
+			// be read back from storage and checked against its digest
+			// before the blob is linked.
+			VerifyWrites bool `yaml:"verifywrites,omitempty"`
+			// Deduplicate completes uploads of content already stored by
+			// linking the existing content, discarding the upload's data.
+			Deduplicate bool `yaml:"deduplicate,omitempty"`
+			// StrictChunkOrder rejects chunks whose Content-Range does
+			// not start exactly at the number of bytes received so far.
+			StrictChunkOrder bool `yaml:"strictchunkorder,omitempty"`
+			// ResumablePut allows the PUT completing an upload to carry
+			// a Content-Range, so that it appends only the data the
+			// upload lacks. A range ending before the total length
+			// given leaves the upload open for a further PUT.
+			ResumablePut bool `yaml:"resumableput,omitempty"`
+			// BindPrincipal rejects requests continuing, completing or
+			// canceling an upload unless they are made by the principal
+			// who started it.
+			BindPrincipal bool `yaml:"bindprincipal,omitempty"`
+			// ChunkSize configures the chunk sizes advertised to clients
+			// starting an upload. Zero values are not advertised.
+			ChunkSize struct {
+				// Min is the smallest chunk clients should send, other
+				// than the last.
+				Min int64 `yaml:"min,omitempty"`
+				// Max is the largest chunk clients should send. The
+				// maximum advertised is bounded by MaxSize.
+				Max int64 `yaml:"max,omitempty"`
+				// Recommended is the chunk size clients should send.
+				Recommended int64 `yaml:"recommended,omitempty"`
+			} `yaml:"chunksize,omitempty"`
+		} `yaml:"uploads,omitempty"`
 	} `yaml:"validation,omitempty"`
 
 	// Policy configures registry policy options.
@@ -237,6 +451,26 @@ type Configuration struct {
 			// manifests. When non-empty, the registry will enforce
 			// the class in authorized resources.
 			Classes []string `yaml:"classes"`
+
+			// ExistsScope causes checks of whether a blob exists to
+			// require only the exists action on the repository, rather
+			// than pull, which implies it.
+			ExistsScope bool `yaml:"existsscope,omitempty"`
+
+			// ImmutableTags prevents tags, once pushed, from being
+			// pushed again to refer to another manifest. Repositories
+			// may override it through their stored policy.
+			ImmutableTags bool `yaml:"immutabletags,omitempty"`
+
+			// ManifestTTL, if positive, is how long after being pushed
+			// manifests expire, after which they are no longer served.
+			// Repositories may override it through their stored policy.
+			ManifestTTL time.Duration `yaml:"manifestttl,omitempty"`
+
+			// OverrideCacheTTL is how long the policy overrides stored
+			// for a repository are cached. If zero, it defaults to one
+			// minute.
+			OverrideCacheTTL time.Duration `yaml:"overridecachettl,omitempty"`
 		} `yaml:"repository,omitempty"`
 	} `yaml:"policy,omitempty"`
 }
@@ -341,6 +575,10 @@ type Health struct {
 		// Threshold is the number of times a check must fail to trigger an
 		// unhealthy state
 		Threshold int `yaml:"threshold,omitempty"`
+		// Path is the storage path of the marker written at startup and
+		// checked by the health check. If empty, a path under the
+		// registry's storage root is used.
+		Path string `yaml:"path,omitempty"`
 	} `yaml:"storagedriver,omitempty"`
 }
 
@@ -419,6 +657,34 @@ func (storage Storage) Type() string {
 			// allow configuration of delete
 		case "redirect":
 			// allow configuration of redirect
+		case "compression":
+			// allow configuration of compression
+		case "taghistory":
+			// allow configuration of tag history
+		case "readbuffer":
+			// allow configuration of read buffering
+		case "operations":
+			// allow configuration of storage operation limits
+		case "blobindex":
+			// allow configuration of the blob index
+		case "repositories":
+			// allow configuration of repository creation
+		case "contentdisposition":
+			// allow configuration of Content-Disposition headers
+		case "contenttype":
+			// allow configuration of blob Content-Type resolution
+		case "tagaliases":
+			// allow configuration of tag alias enforcement
+		case "inline":
+			// allow configuration of inline blob storage
+		case "tagevents":
+			// allow configuration of the tag event log
+		case "bloblinks":
+			// allow configuration of the blob link limit
+		case "routes":
+			// allow configuration of storage routes
+		case "multipartranges":
+			// allow configuration of multipart range responses
 		default:
 			storageType = append(storageType, k)
 		}
@@ -460,6 +726,34 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of delete
 				case "redirect":
 					// allow configuration of redirect
+				case "compression":
+					// allow configuration of compression
+				case "taghistory":
+					// allow configuration of tag history
+				case "readbuffer":
+					// allow configuration of read buffering
+				case "operations":
+					// allow configuration of storage operation limits
+				case "blobindex":
+					// allow configuration of the blob index
+				case "repositories":
+					// allow configuration of repository creation
+				case "contentdisposition":
+					// allow configuration of Content-Disposition headers
+				case "contenttype":
+					// allow configuration of blob Content-Type resolution
+				case "tagaliases":
+					// allow configuration of tag alias enforcement
+				case "inline":
+					// allow configuration of inline blob storage
+				case "tagevents":
+					// allow configuration of the tag event log
+				case "bloblinks":
+					// allow configuration of the blob link limit
+				case "routes":
+					// allow configuration of storage routes
+				case "multipartranges":
+					// allow configuration of multipart range responses
 				default:
 					types = append(types, k)
 				}
@@ -574,6 +868,9 @@ type Endpoint struct {
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	Retries           int           `yaml:"retries"`           // delivery attempts after the first before dead-lettering; zero retries indefinitely
+	DeadLetter        string        `yaml:"deadletter"`        // file to which undeliverable events are appended
+	QueueSize         int           `yaml:"queuesize"`         // maximum number of queued events; zero is unbounded
 }
 
 // Events configures notification events.
@@ -638,6 +935,17 @@ type Proxy struct {
 	Password string `yaml:"password"`
 }
 
+// Quota configures per-repository storage quotas, in bytes. A quota of zero
+// is unlimited.
+type Quota struct {
+	// Default is the quota applied to repositories not listed in
+	// Repositories.
+	Default int64 `yaml:"default,omitempty"`
+
+	// Repositories maps repository names to their quotas.
+	Repositories map[string]int64 `yaml:"repositories,omitempty"`
+}
+
 // Parse parses an input configuration yaml document into a Configuration struct
 // This should generally be capable of handling old configuration format versions
 //
@@ -23,6 +23,10 @@ var configStruct = Configuration{
 		AccessLog struct {
 			Disabled bool `yaml:"disabled,omitempty"`
 		} `yaml:"accesslog,omitempty"`
+		Audit struct {
+			Path  string `yaml:"path,omitempty"`
+			Pulls bool   `yaml:"pulls,omitempty"`
+		} `yaml:"audit,omitempty"`
 		Level     Loglevel               `yaml:"level,omitempty"`
 		Formatter string                 `yaml:"formatter,omitempty"`
 		Fields    map[string]interface{} `yaml:"fields,omitempty"`
@@ -94,13 +98,25 @@ var configStruct = Configuration{
 		Debug   struct {
 			Addr       string `yaml:"addr,omitempty"`
 			Prometheus struct {
+				Enabled               bool      `yaml:"enabled,omitempty"`
+				Path                  string    `yaml:"path,omitempty"`
+				StorageLatencyBuckets []float64 `yaml:"storagelatencybuckets,omitempty"`
+			} `yaml:"prometheus,omitempty"`
+			Profiling struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
-			} `yaml:"prometheus,omitempty"`
+			} `yaml:"profiling,omitempty"`
 		} `yaml:"debug,omitempty"`
 		HTTP2 struct {
 			Disabled bool `yaml:"disabled,omitempty"`
 		} `yaml:"http2,omitempty"`
+		UploadProgress struct {
+			Enabled  bool          `yaml:"enabled,omitempty"`
+			Interval time.Duration `yaml:"interval,omitempty"`
+		} `yaml:"uploadprogress,omitempty"`
+		Options struct {
+			Capabilities bool `yaml:"capabilities,omitempty"`
+		} `yaml:"options,omitempty"`
 	}{
 		TLS: struct {
 			Certificate string   `yaml:"certificate,omitempty"`
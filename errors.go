@@ -33,6 +33,52 @@ func (err ErrTagUnknown) Error() string {
 	return fmt.Sprintf("unknown tag=%s", err.Tag)
 }
 
+// ErrTagAliasCycle is returned if following the aliases of a tag leads back
+// to the tag.
+type ErrTagAliasCycle struct {
+	Tag string
+}
+
+func (err ErrTagAliasCycle) Error() string {
+	return fmt.Sprintf("tag alias cycle: tag=%s", err.Tag)
+}
+
+// ErrTagAliased is returned when deleting a tag would leave the aliases
+// which resolve through it dangling.
+type ErrTagAliased struct {
+	Tag     string
+	Aliases []string
+}
+
+func (err ErrTagAliased) Error() string {
+	return fmt.Sprintf("tag=%s is the target of aliases %s", err.Tag, strings.Join(err.Aliases, ", "))
+}
+
+// ErrTagPreconditionFailed is returned when a conditional tag update finds the
+// tag associated with a digest other than the one expected. An empty digest
+// means the tag does not exist.
+type ErrTagPreconditionFailed struct {
+	Tag      string
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (err ErrTagPreconditionFailed) Error() string {
+	return fmt.Sprintf("tag=%s references %q, expected %q", err.Tag, err.Actual, err.Expected)
+}
+
+// ErrTagEventCursorInvalid is returned when the tag events of a repository
+// cannot be read from a cursor, either because it is malformed or because the
+// events following it are no longer retained.
+type ErrTagEventCursorInvalid struct {
+	Cursor string
+	Reason string
+}
+
+func (err ErrTagEventCursorInvalid) Error() string {
+	return fmt.Sprintf("invalid tag event cursor %q: %s", err.Cursor, err.Reason)
+}
+
 // ErrRepositoryUnknown is returned if the named repository is not known by
 // the registry.
 type ErrRepositoryUnknown struct {
@@ -43,6 +89,28 @@ func (err ErrRepositoryUnknown) Error() string {
 	return fmt.Sprintf("unknown repository name=%s", err.Name)
 }
 
+// ErrRepositoryQuotaExceeded is returned when storing content would take a
+// repository over its storage quota.
+type ErrRepositoryQuotaExceeded struct {
+	Name  string
+	Quota int64
+}
+
+func (err ErrRepositoryQuotaExceeded) Error() string {
+	return fmt.Sprintf("repository name=%s would exceed its quota of %d bytes", err.Name, err.Quota)
+}
+
+// ErrBlobLinkLimitExceeded is returned when linking a blob into a repository
+// would take the number of repositories linking it over the limit.
+type ErrBlobLinkLimitExceeded struct {
+	Digest digest.Digest
+	Limit  int
+}
+
+func (err ErrBlobLinkLimitExceeded) Error() string {
+	return fmt.Sprintf("blob %s is already linked by the maximum of %d repositories", err.Digest, err.Limit)
+}
+
 // ErrRepositoryNameInvalid should be used to denote an invalid repository
 // name. Reason may set, indicating the cause of invalidity.
 type ErrRepositoryNameInvalid struct {
@@ -76,6 +144,41 @@ func (err ErrManifestUnknownRevision) Error() string {
 	return fmt.Sprintf("unknown manifest name=%s revision=%s", err.Name, err.Revision)
 }
 
+// ErrManifestAmbiguous is returned when a digest prefix identifies more than
+// one manifest within a repository.
+type ErrManifestAmbiguous struct {
+	Name    string
+	Prefix  string
+	Matches []digest.Digest
+}
+
+func (err ErrManifestAmbiguous) Error() string {
+	return fmt.Sprintf("ambiguous manifest name=%s prefix=%s matches=%v", err.Name, err.Prefix, err.Matches)
+}
+
+// ErrDigestLengthInvalid is returned when a digest does not have exactly
+// as many hex characters as its algorithm produces, such as a truncated
+// digest, which must not be taken for a prefix of another.
+type ErrDigestLengthInvalid struct {
+	Digest   digest.Digest
+	Expected int
+}
+
+func (err ErrDigestLengthInvalid) Error() string {
+	return fmt.Sprintf("invalid digest length: %s digests have %d hex characters: %s", err.Digest.Algorithm(), err.Expected, err.Digest)
+}
+
+// ErrManifestPlatformNotAllowed is returned when an image manifest describes
+// a platform which the registry does not accept.
+type ErrManifestPlatformNotAllowed struct {
+	OS           string
+	Architecture string
+}
+
+func (err ErrManifestPlatformNotAllowed) Error() string {
+	return fmt.Sprintf("platform not allowed: %s/%s", err.OS, err.Architecture)
+}
+
 // ErrManifestUnverified is returned when the registry is unable to verify
 // the manifest.
 type ErrManifestUnverified struct{}
@@ -84,6 +187,35 @@ func (ErrManifestUnverified) Error() string {
 	return "unverified manifest"
 }
 
+// ErrManifestNotCanonical is returned when a manifest is required to be in
+// canonical JSON form, with sorted keys and no insignificant whitespace, but
+// is not.
+type ErrManifestNotCanonical struct{}
+
+func (ErrManifestNotCanonical) Error() string {
+	return "manifest is not in canonical JSON form"
+}
+
+// ErrManifestLayerOrder is returned when the history of a schema1 manifest
+// does not describe a single chain of layers in the order of its FSLayers.
+type ErrManifestLayerOrder struct {
+	Reason string
+}
+
+func (err ErrManifestLayerOrder) Error() string {
+	return fmt.Sprintf("invalid layer order: %s", err.Reason)
+}
+
+// ErrManifestURLInsecure is returned when a layer of a manifest may be
+// fetched from a URL not using HTTPS, where HTTPS is required.
+type ErrManifestURLInsecure struct {
+	URL string
+}
+
+func (err ErrManifestURLInsecure) Error() string {
+	return fmt.Sprintf("layer URL does not use https: %s", err.URL)
+}
+
 // ErrManifestVerification provides a type to collect errors encountered
 // during manifest verification. Currently, it accepts errors of all types,
 // but it may be narrowed to those involving manifest verification.
@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    `{"schemaVersion":2,"layers":[{"size":12345678901234567890,"digest":"sha256:abc"}]}`,
+			expected: `{"layers":[{"digest":"sha256:abc","size":12345678901234567890}],"schemaVersion":2}`,
+		},
+		{
+			input:    "{\n   \"b\": \"<&>\",\n   \"a\": [ 1.50, true, null ]\n}\n",
+			expected: `{"a":[1.50,true,null],"b":"<&>"}`,
+		},
+		{
+			input:    `{"a":1,"a":2}`,
+			expected: `{"a":2}`,
+		},
+	} {
+		canonical, err := Canonicalize([]byte(tc.input))
+		if err != nil {
+			t.Fatalf("error canonicalizing %q: %v", tc.input, err)
+		}
+		if string(canonical) != tc.expected {
+			t.Fatalf("unexpected canonical form of %q: %q != %q", tc.input, canonical, tc.expected)
+		}
+
+		again, err := Canonicalize(canonical)
+		if err != nil {
+			t.Fatalf("error canonicalizing %q: %v", canonical, err)
+		}
+		if !bytes.Equal(again, canonical) {
+			t.Fatalf("canonical form changed when canonicalized: %q != %q", again, canonical)
+		}
+	}
+
+	for _, input := range []string{``, `{"a":`, `{} {}`} {
+		if _, err := Canonicalize([]byte(input)); err == nil {
+			t.Fatalf("expected error canonicalizing %q", input)
+		}
+	}
+}
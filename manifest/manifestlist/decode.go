@@ -0,0 +1,104 @@
+package manifestlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/distribution/manifest"
+)
+
+// DecodeManifests decodes the manifest list or image index read from r,
+// calling fn with each manifest descriptor in turn as it is decoded. Only the
+// descriptor being visited is held in memory, so lists referencing very many
+// manifests may be validated without decoding the whole list at once. The
+// version of the list is returned once the whole list has been read. Decoding
+// stops at the first error returned by fn, which is returned.
+func DecodeManifests(r io.Reader, fn func(ManifestDescriptor) error) (manifest.Versioned, error) {
+	var versioned manifest.Versioned
+
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return versioned, err
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return versioned, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return versioned, fmt.Errorf("unexpected token in manifest list: %v", token)
+		}
+
+		// Keys are matched as json.Unmarshal matches them, so that the
+		// descriptors visited are exactly those it would decode.
+		switch {
+		case strings.EqualFold(key, "schemaVersion"):
+			if err := dec.Decode(&versioned.SchemaVersion); err != nil {
+				return versioned, err
+			}
+		case strings.EqualFold(key, "mediaType"):
+			if err := dec.Decode(&versioned.MediaType); err != nil {
+				return versioned, err
+			}
+		case strings.EqualFold(key, "manifests"):
+			if err := decodeManifestDescriptors(dec, fn); err != nil {
+				return versioned, err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return versioned, err
+			}
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return versioned, err
+	}
+
+	return versioned, nil
+}
+
+// decodeManifestDescriptors decodes the array of manifest descriptors at the
+// current position of dec, calling fn with each. A null array is accepted,
+// as it is by json.Unmarshal.
+func decodeManifestDescriptors(dec *json.Decoder, fn func(ManifestDescriptor) error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("unexpected token in manifest list: %v", token)
+	}
+
+	for dec.More() {
+		var descriptor ManifestDescriptor
+		if err := dec.Decode(&descriptor); err != nil {
+			return err
+		}
+		if err := fn(descriptor); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads the next token from dec, which must be delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("unexpected token in manifest list: %v", token)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package manifestlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDecodeManifests(t *testing.T) {
+	var decoded []ManifestDescriptor
+	versioned, err := DecodeManifests(bytes.NewReader(expectedManifestListSerialization), func(descriptor ManifestDescriptor) error {
+		decoded = append(decoded, descriptor)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error decoding manifest list: %v", err)
+	}
+
+	var unmarshalled DeserializedManifestList
+	if err := unmarshalled.UnmarshalJSON(expectedManifestListSerialization); err != nil {
+		t.Fatalf("error unmarshaling manifest list: %v", err)
+	}
+
+	if versioned != unmarshalled.Versioned {
+		t.Fatalf("unexpected version: %#v != %#v", versioned, unmarshalled.Versioned)
+	}
+	if !reflect.DeepEqual(decoded, unmarshalled.Manifests) {
+		t.Fatalf("decoded manifests do not match unmarshaled manifests: %#v != %#v", decoded, unmarshalled.Manifests)
+	}
+
+	// Keys are matched without regard to case, as by json.Unmarshal.
+	mixedCase := bytes.Replace(expectedManifestListSerialization, []byte(`"manifests"`), []byte(`"Manifests"`), 1)
+	count := 0
+	if _, err := DecodeManifests(bytes.NewReader(mixedCase), func(ManifestDescriptor) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("error decoding manifest list: %v", err)
+	}
+	if count != len(unmarshalled.Manifests) {
+		t.Fatalf("unexpected number of decoded manifests: %d != %d", count, len(unmarshalled.Manifests))
+	}
+
+	// Decoding stops at the first error.
+	stop := errors.New("stop")
+	count = 0
+	if _, err := DecodeManifests(bytes.NewReader(expectedManifestListSerialization), func(ManifestDescriptor) error {
+		count++
+		return stop
+	}); err != stop {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("unexpected number of decoded manifests: %d != 1", count)
+	}
+
+	for _, invalid := range []string{``, `[]`, `{"manifests": {}}`, `{"manifests": [`, `{"schemaVersion": 2`} {
+		if _, err := DecodeManifests(strings.NewReader(invalid), func(ManifestDescriptor) error { return nil }); err == nil {
+			t.Fatalf("expected error decoding %q", invalid)
+		}
+	}
+}
+
+// TestDecodeManifestsBoundedMemory ensures that decoding a large manifest list
+// does not retain the manifests already visited.
+func TestDecodeManifestsBoundedMemory(t *testing.T) {
+	const entries = 100000
+
+	// The list is generated as it is read, so that only the decoder could
+	// hold it in memory.
+	pr, pw := io.Pipe()
+	go func() {
+		fmt.Fprintf(pw, `{"schemaVersion": 2, "mediaType": %q, "manifests": [`, MediaTypeManifestList)
+		for i := 0; i < entries; i++ {
+			if i > 0 {
+				io.WriteString(pw, ",")
+			}
+			fmt.Fprintf(pw, `{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": %d, "digest": %q, "platform": {"architecture": "amd64", "os": "linux", "features": ["sse4"]}}`,
+				i, digest.FromString(fmt.Sprint(i)))
+		}
+		io.WriteString(pw, "]}")
+		pw.Close()
+	}()
+
+	var before, during runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	count := 0
+	versioned, err := DecodeManifests(pr, func(descriptor ManifestDescriptor) error {
+		count++
+		if count == entries {
+			runtime.GC()
+			runtime.ReadMemStats(&during)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error decoding manifest list: %v", err)
+	}
+	if versioned != SchemaVersion {
+		t.Fatalf("unexpected version: %#v", versioned)
+	}
+	if count != entries {
+		t.Fatalf("unexpected number of decoded manifests: %d != %d", count, entries)
+	}
+
+	// The list is more than 20MB in size and would decode to more still, so
+	// a few megabytes leaves ample room for the decoder's buffers.
+	const bound = 4 << 20
+	if during.HeapAlloc > before.HeapAlloc && during.HeapAlloc-before.HeapAlloc > bound {
+		t.Fatalf("decoding retained too much memory: %d bytes", during.HeapAlloc-before.HeapAlloc)
+	}
+}
@@ -18,6 +18,22 @@ type EndpointConfig struct {
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+
+	// Retries bounds the number of times delivery of an event is retried,
+	// doubling the delay between attempts from Backoff. Events which are
+	// still not delivered are written to DeadLetter. If zero, delivery is
+	// retried indefinitely, backing off after Threshold failures.
+	Retries int
+
+	// DeadLetter is the path of a file to which undeliverable events are
+	// appended, one JSON envelope per line, for later replay. If empty,
+	// such events are dropped.
+	DeadLetter string
+
+	// QueueSize bounds the number of events awaiting delivery. Events
+	// arriving when the queue is full are written to DeadLetter. If zero,
+	// the queue is unbounded.
+	QueueSize int
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -65,8 +81,18 @@ func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
 	endpoint.Sink = newHTTPSink(
 		endpoint.url, endpoint.Timeout, endpoint.Headers,
 		endpoint.Transport, endpoint.metrics.httpStatusListener())
-	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+
+	var deadLetter events.Sink
+	if endpoint.DeadLetter != "" {
+		deadLetter = newDeadLetterSink(endpoint.DeadLetter)
+	}
+
+	if endpoint.Retries > 0 {
+		endpoint.Sink = newBoundedRetryingSink(endpoint.Sink, endpoint.Retries, endpoint.Backoff, deadLetter)
+	} else {
+		endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
+	}
+	endpoint.Sink = newBoundedEventQueue(endpoint.Sink, endpoint.QueueSize, deadLetter, endpoint.metrics.eventQueueListener())
 	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
 	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
 
@@ -116,7 +116,7 @@ func (msl *manifestServiceListener) Get(ctx context.Context, dgst digest.Digest,
 func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dgst, err := msl.ManifestService.Put(ctx, sm, options...)
 
-	if err == nil {
+	if err == nil && !validateOnly(options) {
 		if err := msl.parent.listener.ManifestPushed(msl.parent.Repository.Named(), sm, options...); err != nil {
 			dcontext.GetLogger(ctx).Errorf("error dispatching manifest push to listener: %v", err)
 		}
@@ -125,6 +125,17 @@ func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Man
 	return dgst, err
 }
 
+// validateOnly reports whether options request that a manifest only be
+// validated, in which case nothing was pushed.
+func validateOnly(options []distribution.ManifestServiceOption) bool {
+	for _, option := range options {
+		if _, ok := option.(distribution.WithValidateOnlyOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
 type blobServiceListener struct {
 	distribution.BlobStore
 	parent *repositoryListener
@@ -239,6 +250,13 @@ func (bwl *blobWriterListener) Commit(ctx context.Context, desc distribution.Des
 	return committed, err
 }
 
+func (bwl *blobWriterListener) Fingerprint(ctx context.Context) (digest.Digest, error) {
+	if fingerprinter, ok := bwl.BlobWriter.(distribution.BlobWriteFingerprinter); ok {
+		return fingerprinter.Fingerprint(ctx)
+	}
+	return "", distribution.ErrUnsupported
+}
+
 type tagServiceListener struct {
 	distribution.TagService
 	parent *repositoryListener
@@ -251,6 +269,15 @@ func (rl *repositoryListener) Tags(ctx context.Context) distribution.TagService
 	}
 }
 
+// CheckUntag passes the check through to the wrapped tag service, if it
+// implements distribution.UntagChecker.
+func (tagSL *tagServiceListener) CheckUntag(ctx context.Context, tag string) error {
+	if checker, ok := tagSL.TagService.(distribution.UntagChecker); ok {
+		return checker.CheckUntag(ctx, tag)
+	}
+	return nil
+}
+
 func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
 	if err := tagSL.TagService.Untag(ctx, tag); err != nil {
 		return err
@@ -2,16 +2,19 @@ package notifications
 
 import (
 	"container/list"
+	"encoding/json"
 	"fmt"
+	"os"
 	"sync"
+	"time"
 
 	events "github.com/docker/go-events"
 	"github.com/sirupsen/logrus"
 )
 
 // eventQueue accepts all messages into a queue for asynchronous consumption
-// by a sink. It is unbounded and thread safe but the sink must be reliable or
-// events will be dropped.
+// by a sink. It is thread safe but the sink must be reliable or events will be
+// dropped. The queue is unbounded unless created with newBoundedEventQueue.
 type eventQueue struct {
 	sink      events.Sink
 	events    *list.List
@@ -19,6 +22,12 @@ type eventQueue struct {
 	cond      *sync.Cond
 	mu        sync.Mutex
 	closed    bool
+
+	// size bounds the number of queued events if positive. Events
+	// arriving when the queue is full are written to overflow, or dropped
+	// if it is nil.
+	size     int
+	overflow events.Sink
 }
 
 // eventQueueListener is called when various events happen on the queue.
@@ -30,10 +39,19 @@ type eventQueueListener interface {
 // newEventQueue returns a queue to the provided sink. If the updater is non-
 // nil, it will be called to update pending metrics on ingress and egress.
 func newEventQueue(sink events.Sink, listeners ...eventQueueListener) *eventQueue {
+	return newBoundedEventQueue(sink, 0, nil, listeners...)
+}
+
+// newBoundedEventQueue returns a queue to the provided sink holding at most
+// size events, if size is positive. Events which do not fit are written to
+// overflow instead, or dropped if it is nil.
+func newBoundedEventQueue(sink events.Sink, size int, overflow events.Sink, listeners ...eventQueueListener) *eventQueue {
 	eq := eventQueue{
 		sink:      sink,
 		events:    list.New(),
 		listeners: listeners,
+		size:      size,
+		overflow:  overflow,
 	}
 
 	eq.cond = sync.NewCond(&eq.mu)
@@ -51,6 +69,17 @@ func (eq *eventQueue) Write(event events.Event) error {
 		return ErrSinkClosed
 	}
 
+	if eq.size > 0 && eq.events.Len() >= eq.size {
+		if eq.overflow == nil {
+			logrus.Warnf("eventqueue: queue full, dropping event")
+			return nil
+		}
+		if err := eq.overflow.Write(event); err != nil {
+			logrus.Errorf("eventqueue: queue full, error writing event to %v: %v", eq.overflow, err)
+		}
+		return nil
+	}
+
 	for _, listener := range eq.listeners {
 		listener.ingress(event)
 	}
@@ -162,3 +191,116 @@ func (imts *ignoredSink) Write(event events.Event) error {
 func (imts *ignoredSink) Close() error {
 	return nil
 }
+
+const (
+	// maxRetryBackoff caps the delay between delivery attempts made by a
+	// boundedRetryingSink.
+	maxRetryBackoff = time.Minute
+)
+
+// boundedRetryingSink retries writes to a sink a limited number of times,
+// doubling the delay between attempts. Events which still cannot be written
+// are passed to a dead letter sink, if any, rather than being retried forever.
+type boundedRetryingSink struct {
+	sink       events.Sink
+	retries    int
+	backoff    time.Duration
+	deadLetter events.Sink
+	closed     chan struct{}
+	once       sync.Once
+}
+
+func newBoundedRetryingSink(sink events.Sink, retries int, backoff time.Duration, deadLetter events.Sink) *boundedRetryingSink {
+	return &boundedRetryingSink{
+		sink:       sink,
+		retries:    retries,
+		backoff:    backoff,
+		deadLetter: deadLetter,
+		closed:     make(chan struct{}),
+	}
+}
+
+// Write attempts to write the event until it succeeds, the retries are
+// exhausted or the sink is closed.
+func (rs *boundedRetryingSink) Write(event events.Event) error {
+	backoff := rs.backoff
+	var err error
+	for attempt := 0; attempt <= rs.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-rs.closed:
+				return ErrSinkClosed
+			}
+			if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+
+		if err = rs.sink.Write(event); err == nil || err == ErrSinkClosed {
+			return err
+		}
+		logrus.WithError(err).Errorf("retryingsink: error writing event, attempt %d of %d", attempt+1, rs.retries+1)
+	}
+
+	if rs.deadLetter == nil {
+		logrus.WithError(err).Errorf("retryingsink: dropped event")
+		return nil
+	}
+	if err := rs.deadLetter.Write(event); err != nil {
+		logrus.WithError(err).Errorf("retryingsink: error writing event to %v, dropped event", rs.deadLetter)
+	}
+	return nil
+}
+
+// Close closes the sink and the underlying sink.
+func (rs *boundedRetryingSink) Close() error {
+	rs.once.Do(func() {
+		close(rs.closed)
+	})
+
+	return rs.sink.Close()
+}
+
+// deadLetterSink appends events to a file, one envelope per line, so that
+// they can be replayed to an endpoint once it recovers. The file is opened for
+// each write, as events are only written to it on failure.
+type deadLetterSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newDeadLetterSink(path string) *deadLetterSink {
+	return &deadLetterSink{path: path}
+}
+
+// Write appends the event to the dead letter file, creating it if needed.
+func (dls *deadLetterSink) Write(event events.Event) error {
+	p, err := json.Marshal(Envelope{Events: []events.Event{event}})
+	if err != nil {
+		return err
+	}
+
+	dls.mu.Lock()
+	defer dls.mu.Unlock()
+
+	f, err := os.OpenFile(dls.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(p, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close is a no-op, as the dead letter file is not held open.
+func (dls *deadLetterSink) Close() error {
+	return nil
+}
+
+func (dls *deadLetterSink) String() string {
+	return fmt.Sprintf("deadLetterSink{%s}", dls.path)
+}
@@ -1,6 +1,13 @@
 package notifications
 
 import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"time"
@@ -122,6 +129,144 @@ func TestIgnoredSink(t *testing.T) {
 	}
 }
 
+func TestEndpointRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		failures   int
+		requests   int
+		deadLetter bool
+	}{
+		{name: "recovers", failures: 2, requests: 3},
+		{name: "dead letter", failures: -1, requests: 4, deadLetter: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				requests++
+				if tc.failures < 0 || requests <= tc.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			dir, err := ioutil.TempDir("", "deadletter")
+			if err != nil {
+				t.Fatalf("error creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			deadLetterPath := filepath.Join(dir, "events")
+
+			endpoint := NewEndpoint(tc.name, server.URL, EndpointConfig{
+				Backoff:    time.Millisecond,
+				Retries:    3,
+				DeadLetter: deadLetterPath,
+			})
+
+			event := createTestEvent("push", "library/test", "manifest")
+			if err := endpoint.Write(event); err != nil {
+				t.Fatalf("unexpected error writing event: %v", err)
+			}
+			// Closing flushes the queue.
+			if err := endpoint.Close(); err != nil {
+				t.Fatalf("unexpected error closing endpoint: %v", err)
+			}
+
+			mu.Lock()
+			if requests != tc.requests {
+				t.Errorf("unexpected number of requests: %d != %d", requests, tc.requests)
+			}
+			mu.Unlock()
+
+			f, err := os.Open(deadLetterPath)
+			if !tc.deadLetter {
+				if !os.IsNotExist(err) {
+					t.Fatalf("unexpected dead letter file: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error opening dead letter file: %v", err)
+			}
+			defer f.Close()
+
+			var lines []string
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			if len(lines) != 1 {
+				t.Fatalf("unexpected number of dead letters: %d != 1", len(lines))
+			}
+
+			var envelope struct {
+				Events []Event `json:"events"`
+			}
+			if err := json.Unmarshal([]byte(lines[0]), &envelope); err != nil {
+				t.Fatalf("error decoding dead letter: %v", err)
+			}
+			if len(envelope.Events) != 1 || envelope.Events[0].ID != event.ID {
+				t.Fatalf("unexpected dead letter: %s", lines[0])
+			}
+		})
+	}
+}
+
+func TestBoundedEventQueue(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var ts, overflow testSink
+	eq := newBoundedEventQueue(&blockingSink{Sink: &ts, received: received, release: release}, 1, &overflow)
+
+	for i := 0; i < 3; i++ {
+		if err := eq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+		if i == 0 {
+			// Wait for the first event to leave the queue.
+			<-received
+		}
+	}
+
+	overflow.mu.Lock()
+	if overflow.count != 1 {
+		t.Errorf("unexpected number of overflowed events: %d != 1", overflow.count)
+	}
+	overflow.mu.Unlock()
+
+	close(release)
+	checkClose(t, eq)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.count != 2 {
+		t.Fatalf("unexpected number of delivered events: %d != 2", ts.count)
+	}
+}
+
+// blockingSink signals received on each write, then blocks until release is
+// closed.
+type blockingSink struct {
+	events.Sink
+	received chan struct{}
+	release  chan struct{}
+}
+
+func (bs *blockingSink) Write(event events.Event) error {
+	select {
+	case bs.received <- struct{}{}:
+	default:
+	}
+	<-bs.release
+	return bs.Sink.Write(event)
+}
+
 type testSink struct {
 	event  events.Event
 	count  int
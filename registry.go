@@ -78,6 +78,21 @@ func (o WithTagOption) Apply(m ManifestService) error {
 	return nil
 }
 
+// WithValidateOnly asks Put to verify the manifest as it would be verified
+// before storing it, without storing it.
+func WithValidateOnly() ManifestServiceOption {
+	return WithValidateOnlyOption{}
+}
+
+// WithValidateOnlyOption requests that a manifest only be validated
+type WithValidateOnlyOption struct{}
+
+// Apply conforms to the ManifestServiceOption interface
+func (o WithValidateOnlyOption) Apply(m ManifestService) error {
+	// no implementation
+	return nil
+}
+
 // WithManifestMediaTypes lists the media types the client wishes
 // the server to provide.
 func WithManifestMediaTypes(mediaTypes []string) ManifestServiceOption {
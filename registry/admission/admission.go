@@ -0,0 +1,58 @@
+// Package admission defines hooks deciding whether manifests may be pushed,
+// for policies the registry does not implement itself, such as requiring an
+// attestation from an external service or blocking known-bad layers.
+//
+// A hook is registered under a name with Register, typically in the init
+// function of the package implementing it, and selected by that name in the
+// admission section of the middleware configuration.
+package admission
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Hook decides whether a manifest may be pushed.
+type Hook interface {
+	// Admit is called with each manifest pushed to repository, once it has
+	// been verified and before it is stored, and with the digest it will
+	// be stored under. Returning an error rejects the push. An
+	// errcode.Error or errcode.ErrorCode is returned to the client as it
+	// is, so that a hook may reject a push with a code of its own, while
+	// any other error is reported as an internal error.
+	Admit(ctx context.Context, repository reference.Named, dgst digest.Digest, manifest distribution.Manifest) error
+}
+
+// InitFunc is the type of a Hook factory function and is used to register
+// the constructor for different hooks.
+type InitFunc func(options map[string]interface{}) (Hook, error)
+
+var hooks map[string]InitFunc
+
+func init() {
+	hooks = make(map[string]InitFunc)
+}
+
+// Register is used to register an InitFunc for a hook with the given name.
+func Register(name string, initFunc InitFunc) error {
+	if _, exists := hooks[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	hooks[name] = initFunc
+
+	return nil
+}
+
+// Get constructs a Hook with the given options using the named backend.
+func Get(name string, options map[string]interface{}) (Hook, error) {
+	if initFunc, exists := hooks[name]; exists {
+		return initFunc(options)
+	}
+
+	return nil, fmt.Errorf("no admission hook registered with name: %s", name)
+}
@@ -26,6 +26,14 @@ var (
 		Description: `Tag or digest of the target manifest.`,
 	}
 
+	tagParameterDescriptor = ParameterDescriptor{
+		Name:        "tag",
+		Type:        "string",
+		Format:      reference.TagRegexp.String(),
+		Required:    true,
+		Description: `Tag of the target alias.`,
+	}
+
 	uuidParameterDescriptor = ParameterDescriptor{
 		Name:        "uuid",
 		Type:        "opaque",
@@ -176,6 +184,48 @@ var (
 		},
 	}
 
+	quotaExceededResponseDescriptor = ResponseDescriptor{
+		Name:        "Quota Exceeded",
+		StatusCode:  http.StatusRequestEntityTooLarge,
+		Description: "Storing the content would take the repository over its storage quota.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodeQuotaExceeded,
+		},
+	}
+
+	blobLinkLimitExceededResponseDescriptor = ResponseDescriptor{
+		Name:        "Blob Link Limit Exceeded",
+		StatusCode:  http.StatusForbidden,
+		Description: "The blob is already linked by as many repositories as the registry allows.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodeBlobLinkLimitExceeded,
+		},
+	}
+
 	tooManyRequestsDescriptor = ResponseDescriptor{
 		Name:        "Too Many Requests",
 		StatusCode:  http.StatusTooManyRequests,
@@ -213,6 +263,31 @@ const (
    "signature": <JWS>
 }`
 
+	repositoryPolicyBody = `{
+   "immutableTags": <true or false, omitted to follow the configuration>
+}`
+
+	capabilitiesBody = `{
+    "version": 1,
+    "features": {
+        "push": <true or false>,
+        "delete": <true or false>,
+        "chunkedUploads": <true or false>,
+        "crossRepositoryMount": <true or false>,
+        "catalog": <true or false>,
+        "catalogPagination": <true or false>
+    },
+    "manifestSchemas": [
+        "<media type>",
+        ...
+    ]
+}`
+
+	catalogUsageBody = `{
+    "blobs": <number of blobs>,
+    "bytes": <total size of blobs>
+}`
+
 	errorsBody = `{
 	"errors:" [
 	    {
@@ -496,52 +571,62 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
-				},
-			},
-		},
-	},
-	{
-		Name:        RouteNameManifest,
-		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/{reference:" + reference.TagRegexp.String() + "|" + digest.DigestRegexp.String() + "}",
-		Entity:      "Manifest",
-		Description: "Create, update, delete and retrieve manifests.",
-		Methods: []MethodDescriptor{
-			{
-				Method:      "GET",
-				Description: "Fetch the manifest identified by `name` and `reference` where `reference` can be a tag or digest. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
-				Requests: []RequestDescriptor{
 					{
-						Headers: []ParameterDescriptor{
-							hostHeader,
-							authHeader,
-						},
-						PathParameters: []ParameterDescriptor{
-							nameParameterDescriptor,
-							referenceParameterDescriptor,
-						},
+						Name:           "Tags Sorted",
+						Description:    "Return the tags for the specified repository ordered by push time, newest first. Tags with the same push time are ordered lexically. The `n` and `last` parameters paginate the result, with `last` naming the final tag of the previous page.",
+						PathParameters: []ParameterDescriptor{nameParameterDescriptor},
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "sort",
+								Type:        "string",
+								Description: "The order of the returned tags. The only supported value is `pushed_desc`.",
+								Format:      "pushed_desc",
+								Required:    true,
+							},
+						}, paginationParameters...),
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The manifest identified by `name` and `reference`. The contents can be used to identify and resolve resources required to run the specified image.",
 								StatusCode:  http.StatusOK,
+								Description: "A list of tags for the named repository, newest first.",
 								Headers: []ParameterDescriptor{
-									digestHeader,
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
 								},
 								Body: BodyDescriptor{
-									ContentType: "<media type of manifest>",
-									Format:      manifestBody,
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tags": [
+        <tag>,
+        ...
+    ],
+}`,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "The name or reference was invalid.",
+								Name:        "Invalid Sort Order",
 								StatusCode:  http.StatusBadRequest,
+								Description: "The requested sort order is not supported.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Type",
+										Type:        "string",
+										Description: "The response body is a JSON error.",
+										Format:      "application/json; charset=utf-8",
+									},
+								},
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameInvalid,
-									ErrorCodeTagInvalid,
+									ErrorCodeSortInvalid,
 								},
 								Body: BodyDescriptor{
-									ContentType: "application/json",
+									ContentType: "application/json; charset=utf-8",
 									Format:      errorsBody,
 								},
 							},
@@ -553,9 +638,17 @@ var routeDescriptors = []RouteDescriptor{
 					},
 				},
 			},
+		},
+	},
+	{
+		Name:        RouteNameTagChanges,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/tags/changes",
+		Entity:      "Tag Changes",
+		Description: "Retrieve the changes made to the tags of a repository, so that a mirror may follow them without listing every tag. Only the most recent changes are retained. Requires the tag event log to be enabled.",
+		Methods: []MethodDescriptor{
 			{
-				Method:      "PUT",
-				Description: "Put the manifest identified by `name` and `reference` where `reference` can be a tag or digest.",
+				Method:      "GET",
+				Description: "Fetch the changes made to the tags of the repository identified by `name` since `cursor`, oldest first. Each change records a tag being created, updated to refer to another manifest, or deleted. The response includes the cursor from which to fetch the following changes.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -564,86 +657,134 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							referenceParameterDescriptor,
 						},
-						Body: BodyDescriptor{
-							ContentType: "<media type of manifest>",
-							Format:      manifestBody,
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "cursor",
+								Type:        "string",
+								Description: "The cursor returned by the previous request. If not present, changes are returned from the oldest retained.",
+								Format:      "<cursor>",
+								Required:    false,
+							},
+							{
+								Name:        "n",
+								Type:        "integer",
+								Description: "Limit the number of changes in the response. If not present, all changes since the cursor are returned.",
+								Format:      "<integer>",
+								Required:    false,
+							},
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The manifest has been accepted by the registry and is stored under the specified `name` and `tag`.",
-								StatusCode:  http.StatusCreated,
-								Headers: []ParameterDescriptor{
-									{
-										Name:        "Location",
-										Type:        "url",
-										Description: "The canonical location url of the uploaded manifest.",
-										Format:      "<url>",
-									},
-									contentLengthZeroHeader,
-									digestHeader,
+								StatusCode:  http.StatusOK,
+								Description: "The changes made since the cursor, which may be none.",
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "events": [
+        {
+            "sequence": <sequence>,
+            "action": "create" | "update" | "delete",
+            "tag": <tag>,
+            "digest": <digest>,
+            "time": <time>
+        },
+        ...
+    ],
+    "cursor": <cursor>
+}`,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Name:        "Invalid Manifest",
-								Description: "The received manifest was invalid in some way, as described by the error codes. The client should resolve the issue and retry the request.",
+								Description: "The cursor is malformed, or the changes following it are no longer retained. The tags of the repository must be listed again before following changes from the oldest retained.",
 								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeCursorInvalid,
+								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format:      errorsBody,
 								},
+							},
+							{
+								Description: "The tag event log is not enabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameInvalid,
-									ErrorCodeTagInvalid,
-									ErrorCodeManifestInvalid,
-									ErrorCodeManifestUnverified,
-									ErrorCodeBlobUnknown,
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
 							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagAlias,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_aliases/{tag:" + reference.TagRegexp.String() + "}",
+		Entity:      "Tag Alias",
+		Description: "Manage tags which alias other tags. Fetching a manifest by an alias returns the manifest its target tag currently resolves to, along with that manifest's digest. Aliases may alias other aliases, but not form a cycle.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the target of the alias identified by `name` and `tag`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
 							{
-								Name:        "Missing Layer(s)",
-								Description: "One or more layers may be missing during a manifest upload. If so, the missing layers will be enumerated in the error response.",
-								StatusCode:  http.StatusBadRequest,
-								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeBlobUnknown,
-								},
+								Description: "The tag is an alias of the returned target tag.",
+								StatusCode:  http.StatusOK,
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format: `{
-    "errors:" [{
-            "code": "BLOB_UNKNOWN",
-            "message": "blob unknown to registry",
-            "detail": {
-                "digest": "<digest>"
-            }
-        },
-        ...
-    ]
+    "tag": <tag>,
+    "target": <tag>
 }`,
 								},
 							},
+						},
+						Failures: []ResponseDescriptor{
 							{
-								Name:        "Not allowed",
-								Description: "Manifest put is not allowed because the registry is configured as a pull-through cache or for some other reason",
-								StatusCode:  http.StatusMethodNotAllowed,
+								Description: "The tag is not an alias.",
+								StatusCode:  http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									errcode.ErrorCodeUnsupported,
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
 						},
 					},
 				},
 			},
 			{
-				Method:      "DELETE",
-				Description: "Delete the manifest identified by `name` and `reference`. Note that a manifest can _only_ be deleted by `digest`.",
+				Method:      "PUT",
+				Description: "Make the tag identified by `name` and `tag` an alias of the target tag, replacing any manifest the tag held. Pushing a manifest to the tag later replaces the alias.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -652,20 +793,32 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							referenceParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+    "target": <tag>
+}`,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								StatusCode: http.StatusAccepted,
+								Description: "The alias has been created or repointed.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:   "Content-Length",
+										Type:   "integer",
+										Format: "0",
+									},
+								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Name:        "Invalid Name or Reference",
-								Description: "The specified `name` or `reference` were invalid and the delete was unable to proceed.",
+								Description: "The target was invalid, or aliasing it would create a cycle.",
 								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameInvalid,
 									ErrorCodeTagInvalid,
 								},
 								Body: BodyDescriptor{
@@ -673,16 +826,10 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
-							unauthorizedResponseDescriptor,
-							repositoryNotFoundResponseDescriptor,
-							deniedResponseDescriptor,
-							tooManyRequestsDescriptor,
 							{
-								Name:        "Unknown Manifest",
-								Description: "The specified `name` or `reference` are unknown to the registry and the delete was unable to proceed. Clients can assume the manifest was already deleted if this response is returned.",
+								Description: "The target tag is unknown to the registry.",
 								StatusCode:  http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameUnknown,
 									ErrorCodeManifestUnknown,
 								},
 								Body: BodyDescriptor{
@@ -690,97 +837,51 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
-							{
-								Name:        "Not allowed",
-								Description: "Manifest delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled.",
-								StatusCode:  http.StatusMethodNotAllowed,
-								ErrorCodes: []errcode.ErrorCode{
-									errcode.ErrorCodeUnsupported,
-								},
-							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
 						},
 					},
 				},
 			},
-		},
-	},
-
-	{
-		Name:        RouteNameBlob,
-		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/{digest:" + digest.DigestRegexp.String() + "}",
-		Entity:      "Blob",
-		Description: "Operations on blobs identified by `name` and `digest`. Used to fetch or delete layers by digest.",
-		Methods: []MethodDescriptor{
 			{
-				Method:      "GET",
-				Description: "Retrieve the blob from the registry identified by `digest`. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
+				Method:      "DELETE",
+				Description: "Remove the alias identified by `name` and `tag`, leaving the tag unknown. The target tag is unaffected.",
 				Requests: []RequestDescriptor{
 					{
-						Name: "Fetch Blob",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							digestPathParameter,
+							tagParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The blob identified by `digest` is available. The blob content will be present in the body of the request.",
-								StatusCode:  http.StatusOK,
+								Description: "The alias has been removed.",
+								StatusCode:  http.StatusAccepted,
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Content-Length",
-										Type:        "integer",
-										Description: "The length of the requested blob content.",
-										Format:      "<length>",
-									},
-									digestHeader,
-								},
-								Body: BodyDescriptor{
-									ContentType: "application/octet-stream",
-									Format:      "<blob binary data>",
-								},
-							},
-							{
-								Description: "The blob identified by `digest` is available at the provided location.",
-								StatusCode:  http.StatusTemporaryRedirect,
-								Headers: []ParameterDescriptor{
-									{
-										Name:        "Location",
-										Type:        "url",
-										Description: "The location where the layer should be accessible.",
-										Format:      "<blob location>",
+										Name:   "Content-Length",
+										Type:   "integer",
+										Format: "0",
 									},
-									digestHeader,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "There was a problem with the request that needs to be addressed by the client, such as an invalid `name` or `tag`.",
-								StatusCode:  http.StatusBadRequest,
+								Description: "The tag is not an alias.",
+								StatusCode:  http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameInvalid,
-									ErrorCodeDigestInvalid,
-								},
-								Body: BodyDescriptor{
-									ContentType: "application/json",
-									Format:      errorsBody,
+									ErrorCodeManifestUnknown,
 								},
-							},
-							{
-								Description: "The blob, identified by `name` and `digest`, is unknown to the registry.",
-								StatusCode:  http.StatusNotFound,
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format:      errorsBody,
 								},
-								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameUnknown,
-									ErrorCodeBlobUnknown,
-								},
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
@@ -788,54 +889,60 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
+				},
+			},
+		},
+	},
+	{
+		Name: RouteNameManifest,
+		// Any reference is routed, so that invalid tags are rejected with
+		// TAG_INVALID rather than as unknown routes.
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/{reference:[^/]+}",
+		Entity:      "Manifest",
+		Description: "Create, update, delete and retrieve manifests.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the manifest identified by `name` and `reference` where `reference` can be a tag or digest. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
+				Requests: []RequestDescriptor{
 					{
-						Name:        "Fetch Blob Part",
-						Description: "This endpoint may also support RFC7233 compliant range requests. Support can be detected by issuing a HEAD request. If the header `Accept-Range: bytes` is returned, range requests can be used to fetch partial content.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
-							{
-								Name:        "Range",
-								Type:        "string",
-								Description: "HTTP Range header specifying blob chunk.",
-								Format:      "bytes=<start>-<end>",
-							},
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							digestPathParameter,
+							referenceParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "platform",
+								Type:        "string",
+								Description: "If platform filtering is enabled, a manifest list fetched by tag is pruned to the manifests for the given platforms, and `Docker-Content-Digest` is the digest of the pruned list. May be given more than once.",
+								Format:      "<os>/<architecture>[/<variant>]",
+								Required:    false,
+							},
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The blob identified by `digest` is available. The specified chunk of blob content will be present in the body of the request.",
-								StatusCode:  http.StatusPartialContent,
+								Description: "The manifest identified by `name` and `reference`. The contents can be used to identify and resolve resources required to run the specified image.",
+								StatusCode:  http.StatusOK,
 								Headers: []ParameterDescriptor{
-									{
-										Name:        "Content-Length",
-										Type:        "integer",
-										Description: "The length of the requested blob chunk.",
-										Format:      "<length>",
-									},
-									{
-										Name:        "Content-Range",
-										Type:        "byte range",
-										Description: "Content range of blob chunk.",
-										Format:      "bytes <start>-<end>/<size>",
-									},
+									digestHeader,
 								},
 								Body: BodyDescriptor{
-									ContentType: "application/octet-stream",
-									Format:      "<blob binary data>",
+									ContentType: "<media type of manifest>",
+									Format:      manifestBody,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "There was a problem with the request that needs to be addressed by the client, such as an invalid `name` or `tag`.",
+								Description: "The name or reference was invalid.",
 								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
 									ErrorCodeNameInvalid,
-									ErrorCodeDigestInvalid,
+									ErrorCodeTagInvalid,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -843,10 +950,12 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							{
-								StatusCode: http.StatusNotFound,
+								Name:        "Ambiguous Digest Prefix",
+								Description: "If short digests are enabled, `reference` may be a prefix of a digest, such as `sha256:4f2a9c1`. The prefix was too short, or matched more than one manifest in the repository.",
+								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameUnknown,
-									ErrorCodeBlobUnknown,
+									ErrorCodeDigestInvalid,
+									ErrorCodeManifestAmbiguous,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -854,8 +963,16 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							{
-								Description: "The range specification cannot be satisfied for the requested content. This can happen when the range is not formatted correctly or if the range is outside of the valid size of the content.",
-								StatusCode:  http.StatusRequestedRangeNotSatisfiable,
+								Name:        "Invalid Platform",
+								Description: "A platform by which the manifest list was to be filtered was malformed.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePlatformInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
@@ -866,8 +983,8 @@ var routeDescriptors = []RouteDescriptor{
 				},
 			},
 			{
-				Method:      "DELETE",
-				Description: "Delete the blob identified by `name` and `digest`",
+				Method:      "PUT",
+				Description: "Put the manifest identified by `name` and `reference` where `reference` can be a tag or digest.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -876,184 +993,223 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							digestPathParameter,
+							referenceParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "dryrun",
+								Type:        "boolean",
+								Description: "If `true`, the manifest is verified as it would be for a push, but is neither stored nor tagged.",
+								Format:      "true",
+							},
+						},
+						Body: BodyDescriptor{
+							ContentType: "<media type of manifest>",
+							Format:      manifestBody,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								StatusCode: http.StatusAccepted,
+								Description: "The manifest has been accepted by the registry and is stored under the specified `name` and `tag`.",
+								StatusCode:  http.StatusCreated,
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Content-Length",
-										Type:        "integer",
-										Description: "0",
-										Format:      "0",
+										Name:        "Location",
+										Type:        "url",
+										Description: "The canonical location url of the uploaded manifest.",
+										Format:      "<url>",
 									},
+									contentLengthZeroHeader,
 									digestHeader,
 								},
 							},
-						},
-						Failures: []ResponseDescriptor{
 							{
-								Name:       "Invalid Name or Digest",
-								StatusCode: http.StatusBadRequest,
-								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
-									ErrorCodeNameInvalid,
+								Description: "The manifest would be accepted by the registry. Returned only for a dry run, in which case nothing is stored.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
+									digestHeader,
 								},
 							},
+						},
+						Failures: []ResponseDescriptor{
 							{
-								Description: "The blob, identified by `name` and `digest`, is unknown to the registry.",
-								StatusCode:  http.StatusNotFound,
+								Name:        "Invalid Manifest",
+								Description: "The received manifest was invalid in some way, as described by the error codes. The client should resolve the issue and retry the request.",
+								StatusCode:  http.StatusBadRequest,
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format:      errorsBody,
 								},
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeNameUnknown,
+									ErrorCodeNameInvalid,
+									ErrorCodeTagInvalid,
+									ErrorCodeManifestInvalid,
+									ErrorCodeManifestUnverified,
 									ErrorCodeBlobUnknown,
 								},
 							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							quotaExceededResponseDescriptor,
+							blobLinkLimitExceededResponseDescriptor,
 							{
-								Description: "Blob delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled",
-								StatusCode:  http.StatusMethodNotAllowed,
+								Name:        "Missing Layer(s)",
+								Description: "One or more layers may be missing during a manifest upload. If so, the missing layers will be enumerated in the error response.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUnknown,
+								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
-									Format:      errorsBody,
+									Format: `{
+    "errors:" [{
+            "code": "BLOB_UNKNOWN",
+            "message": "blob unknown to registry",
+            "detail": {
+                "digest": "<digest>"
+            }
+        },
+        ...
+    ]
+}`,
 								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Manifest put is not allowed because the registry is configured as a pull-through cache or for some other reason",
+								StatusCode:  http.StatusMethodNotAllowed,
 								ErrorCodes: []errcode.ErrorCode{
 									errcode.ErrorCodeUnsupported,
 								},
 							},
-							unauthorizedResponseDescriptor,
-							repositoryNotFoundResponseDescriptor,
-							deniedResponseDescriptor,
-							tooManyRequestsDescriptor,
 						},
 					},
 				},
 			},
-
-			// TODO(stevvooe): We may want to add a PUT request here to
-			// kickoff an upload of a blob, integrated with the blob upload
-			// API.
-		},
-	},
-
-	{
-		Name:        RouteNameBlobUpload,
-		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/",
-		Entity:      "Initiate Blob Upload",
-		Description: "Initiate a blob upload. This endpoint can be used to create resumable uploads or monolithic uploads.",
-		Methods: []MethodDescriptor{
 			{
-				Method:      "POST",
-				Description: "Initiate a resumable blob upload. If successful, an upload location will be provided to complete the upload. Optionally, if the `digest` parameter is present, the request body will be used to complete the upload in a single request.",
+				Method:      "DELETE",
+				Description: "Delete the manifest identified by `name` and `reference`. Note that a manifest can _only_ be deleted by `digest`.",
 				Requests: []RequestDescriptor{
 					{
-						Name:        "Initiate Monolithic Blob Upload",
-						Description: "Upload a blob identified by the `digest` parameter in single request. This upload will not be resumable unless a recoverable error is returned.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
-							{
-								Name:   "Content-Length",
-								Type:   "integer",
-								Format: "<length of blob>",
-							},
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-						},
-						QueryParameters: []ParameterDescriptor{
-							{
-								Name:        "digest",
-								Type:        "query",
-								Format:      "<digest>",
-								Regexp:      digest.DigestRegexp,
-								Description: `Digest of uploaded blob. If present, the upload will be completed, in a single request, with contents of the request body as the resulting blob.`,
-							},
-						},
-						Body: BodyDescriptor{
-							ContentType: "application/octect-stream",
-							Format:      "<binary data>",
+							referenceParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The blob has been created in the registry and is available at the provided location.",
-								StatusCode:  http.StatusCreated,
-								Headers: []ParameterDescriptor{
-									{
-										Name:   "Location",
-										Type:   "url",
-										Format: "<blob location>",
-									},
-									contentLengthZeroHeader,
-									dockerUploadUUIDHeader,
-								},
+								StatusCode: http.StatusAccepted,
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Name:       "Invalid Name or Digest",
-								StatusCode: http.StatusBadRequest,
+								Name:        "Invalid Name or Reference",
+								Description: "The specified `name` or `reference` were invalid and the delete was unable to proceed.",
+								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
+									ErrorCodeTagInvalid,
 								},
-							},
-							{
-								Name:        "Not allowed",
-								Description: "Blob upload is not allowed because the registry is configured as a pull-through cache or for some other reason",
-								StatusCode:  http.StatusMethodNotAllowed,
-								ErrorCodes: []errcode.ErrorCode{
-									errcode.ErrorCodeUnsupported,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
 							tooManyRequestsDescriptor,
-						},
+							{
+								Name:        "Unknown Manifest",
+								Description: "The specified `name` or `reference` are unknown to the registry and the delete was unable to proceed. Clients can assume the manifest was already deleted if this response is returned.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameUnknown,
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Manifest delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
 					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:        RouteNameDescriptor,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/{reference:" + reference.TagRegexp.String() + "|" + digest.DigestRegexp.String() + "}/descriptor",
+		Entity:      "Descriptor",
+		Description: "Resolve manifests to their descriptors, without fetching the manifest.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the descriptor of the manifest identified by `name` and `reference` where `reference` can be a tag or digest. A `HEAD` request can also be issued to this endpoint to obtain the digest in the `Docker-Content-Digest` header.",
+				Requests: []RequestDescriptor{
 					{
-						Name:        "Initiate Resumable Blob Upload",
-						Description: "Initiate a resumable blob upload with an empty request body.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
-							contentLengthZeroHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
+							referenceParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The upload has been created. The `Location` header must be used to complete the upload. The response should be identical to a `GET` request on the contents of the returned `Location` header.",
-								StatusCode:  http.StatusAccepted,
+								Description: "The descriptor of the manifest, giving its digest, media type and length.",
+								StatusCode:  http.StatusOK,
 								Headers: []ParameterDescriptor{
-									contentLengthZeroHeader,
-									{
-										Name:        "Location",
-										Type:        "url",
-										Format:      "/v2/<name>/blobs/uploads/<uuid>",
-										Description: "The location of the created upload. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
-									},
-									{
-										Name:        "Range",
-										Format:      "0-0",
-										Description: "Range header indicating the progress of the upload. When starting an upload, it will return an empty range, since no content has been received.",
-									},
-									dockerUploadUUIDHeader,
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "mediaType": "<media type of manifest>",
+    "size": <length of manifest>,
+    "digest": "<digest of manifest>"
+}`,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Name:       "Invalid Name or Digest",
-								StatusCode: http.StatusBadRequest,
+								Description: "The name or reference was invalid.",
+								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
+									ErrorCodeTagInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The manifest identified by `name` and `reference` is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
 							unauthorizedResponseDescriptor,
@@ -1062,63 +1218,52 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:        RouteNameDefaultManifest,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/",
+		Entity:      "Default Manifest",
+		Description: "Retrieve the manifest of a repository's default reference.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the manifest identified by the default reference configured for the repository `name`. The response is that of fetching the manifest by the default reference. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
+				Requests: []RequestDescriptor{
 					{
-						Name:        "Mount Blob",
-						Description: "Mount a blob identified by the `mount` parameter from another repository.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
-							contentLengthZeroHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 						},
-						QueryParameters: []ParameterDescriptor{
-							{
-								Name:        "mount",
-								Type:        "query",
-								Format:      "<digest>",
-								Regexp:      digest.DigestRegexp,
-								Description: `Digest of blob to mount from the source repository.`,
-							},
-							{
-								Name:        "from",
-								Type:        "query",
-								Format:      "<repository name>",
-								Regexp:      reference.NameRegexp,
-								Description: `Name of the source repository.`,
-							},
-						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The blob has been mounted in the repository and is available at the provided location.",
-								StatusCode:  http.StatusCreated,
+								Description: "The manifest identified by the default reference of `name`.",
+								StatusCode:  http.StatusOK,
 								Headers: []ParameterDescriptor{
-									{
-										Name:   "Location",
-										Type:   "url",
-										Format: "<blob location>",
-									},
-									contentLengthZeroHeader,
-									dockerUploadUUIDHeader,
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "<media type of manifest>",
+									Format:      manifestBody,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Name:       "Invalid Name or Digest",
-								StatusCode: http.StatusBadRequest,
+								Description: "No default reference is configured for the repository, or the manifest it identifies is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
-									ErrorCodeNameInvalid,
+									ErrorCodeManifestUnknown,
 								},
-							},
-							{
-								Name:        "Not allowed",
-								Description: "Blob mount is not allowed because the registry is configured as a pull-through cache or for some other reason",
-								StatusCode:  http.StatusMethodNotAllowed,
-								ErrorCodes: []errcode.ErrorCode{
-									errcode.ErrorCodeUnsupported,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
 							unauthorizedResponseDescriptor,
@@ -1133,50 +1278,69 @@ var routeDescriptors = []RouteDescriptor{
 	},
 
 	{
-		Name:        RouteNameBlobUploadChunk,
-		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/{uuid:[a-zA-Z0-9-_.=]+}",
-		Entity:      "Blob Upload",
-		Description: "Interact with blob uploads. Clients should never assemble URLs for this endpoint and should only take it through the `Location` header on related API requests. The `Location` header and its parameters should be preserved by clients, using the latest value returned via upload related API calls.",
+		Name:        RouteNameManifestConfig,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/{reference:" + reference.TagRegexp.String() + "|" + digest.DigestRegexp.String() + "}/config",
+		Entity:      "Manifest Config",
+		Description: "Fetch the config blob of an image manifest, without fetching the manifest.",
 		Methods: []MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "Retrieve status of upload identified by `uuid`. The primary purpose of this endpoint is to resolve the current status of a resumable upload.",
+				Description: "Fetch the config blob of the image manifest identified by `name` and `reference` where `reference` can be a tag or digest. Only image manifests which reference a config blob, such as schema2 and OCI image manifests, are supported. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
 				Requests: []RequestDescriptor{
 					{
-						Description: "Retrieve the progress of the current upload, as reported by the `Range` header.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							uuidParameterDescriptor,
+							referenceParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Name:        "Upload Progress",
-								Description: "The upload is known and in progress. The last received offset is available in the `Range` header.",
-								StatusCode:  http.StatusNoContent,
+								Description: "The config blob of the manifest is available. The blob content will be present in the body of the request.",
+								StatusCode:  http.StatusOK,
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Range",
-										Type:        "header",
-										Format:      "0-<offset>",
-										Description: "Range indicating the current progress of the upload.",
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "The length of the config blob.",
+										Format:      "<length>",
 									},
-									contentLengthZeroHeader,
-									dockerUploadUUIDHeader,
+									{
+										Name:        "Docker-Content-Digest",
+										Type:        "digest",
+										Description: "Digest of the config blob.",
+										Format:      "<digest>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/octet-stream",
+									Format:      "<blob binary data>",
+								},
+							},
+							{
+								Description: "The config blob of the manifest is available at the provided location.",
+								StatusCode:  http.StatusTemporaryRedirect,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Description: "The location where the config blob should be accessible.",
+										Format:      "<blob location>",
+									},
+									digestHeader,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "There was an error processing the upload and it must be restarted.",
+								Description: "The name or reference was invalid, or the manifest does not reference a config blob.",
 								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
-									ErrorCodeBlobUploadInvalid,
+									ErrorCodeTagInvalid,
+									ErrorCodeManifestInvalid,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -1184,10 +1348,11 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							{
-								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								Description: "The manifest identified by `name` and `reference`, or its config blob, is unknown to the registry.",
 								StatusCode:  http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeBlobUploadUnknown,
+									ErrorCodeManifestUnknown,
+									ErrorCodeBlobUnknown,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -1202,56 +1367,68 @@ var routeDescriptors = []RouteDescriptor{
 					},
 				},
 			},
+		},
+	},
+
+	{
+		Name:        RouteNameBlob,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Blob",
+		Description: "Operations on blobs identified by `name` and `digest`. Used to fetch or delete layers by digest.",
+		Methods: []MethodDescriptor{
 			{
-				Method:      "PATCH",
-				Description: "Upload a chunk of data for the specified upload.",
+				Method:      "GET",
+				Description: "Retrieve the blob from the registry identified by `digest`. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
 				Requests: []RequestDescriptor{
 					{
-						Name:        "Stream upload",
-						Description: "Upload a stream of data to upload without completing the upload.",
-						PathParameters: []ParameterDescriptor{
-							nameParameterDescriptor,
-							uuidParameterDescriptor,
-						},
+						Name: "Fetch Blob",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
 						},
-						Body: BodyDescriptor{
-							ContentType: "application/octet-stream",
-							Format:      "<binary data>",
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Name:        "Data Accepted",
-								Description: "The stream of data has been accepted and the current progress is available in the range header. The updated upload location is available in the `Location` header.",
-								StatusCode:  http.StatusNoContent,
+								Description: "The blob identified by `digest` is available. The blob content will be present in the body of the request.",
+								StatusCode:  http.StatusOK,
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Location",
-										Type:        "url",
-										Format:      "/v2/<name>/blobs/uploads/<uuid>",
-										Description: "The location of the upload. Clients should assume this changes after each request. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "The length of the requested blob content.",
+										Format:      "<length>",
 									},
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/octet-stream",
+									Format:      "<blob binary data>",
+								},
+							},
+							{
+								Description: "The blob identified by `digest` is available at the provided location.",
+								StatusCode:  http.StatusTemporaryRedirect,
+								Headers: []ParameterDescriptor{
 									{
-										Name:        "Range",
-										Type:        "header",
-										Format:      "0-<offset>",
-										Description: "Range indicating the current progress of the upload.",
+										Name:        "Location",
+										Type:        "url",
+										Description: "The location where the layer should be accessible.",
+										Format:      "<blob location>",
 									},
-									contentLengthZeroHeader,
-									dockerUploadUUIDHeader,
+									digestHeader,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "There was an error processing the upload and it must be restarted.",
+								Description: "There was a problem with the request that needs to be addressed by the client, such as an invalid `name` or `tag`.",
 								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
-									ErrorCodeBlobUploadInvalid,
+									ErrorCodeDigestInvalid,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -1259,15 +1436,16 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							{
-								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								Description: "The blob, identified by `name` and `digest`, is unknown to the registry.",
 								StatusCode:  http.StatusNotFound,
-								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeBlobUploadUnknown,
-								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format:      errorsBody,
 								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameUnknown,
+									ErrorCodeBlobUnknown,
+								},
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
@@ -1276,64 +1454,53 @@ var routeDescriptors = []RouteDescriptor{
 						},
 					},
 					{
-						Name:        "Chunked upload",
-						Description: "Upload a chunk of data to specified upload without completing the upload. The data will be uploaded to the specified Content Range.",
-						PathParameters: []ParameterDescriptor{
-							nameParameterDescriptor,
-							uuidParameterDescriptor,
-						},
+						Name:        "Fetch Blob Part",
+						Description: "This endpoint may also support RFC7233 compliant range requests. Support can be detected by issuing a HEAD request. If the header `Accept-Range: bytes` is returned, range requests can be used to fetch partial content.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
 							{
-								Name:        "Content-Range",
-								Type:        "header",
-								Format:      "<start of range>-<end of range, inclusive>",
-								Required:    true,
-								Description: "Range of bytes identifying the desired block of content represented by the body. Start must the end offset retrieved via status check plus one. Note that this is a non-standard use of the `Content-Range` header.",
-							},
-							{
-								Name:        "Content-Length",
-								Type:        "integer",
-								Format:      "<length of chunk>",
-								Description: "Length of the chunk being uploaded, corresponding the length of the request body.",
+								Name:        "Range",
+								Type:        "string",
+								Description: "HTTP Range header specifying blob chunk.",
+								Format:      "bytes=<start>-<end>",
 							},
 						},
-						Body: BodyDescriptor{
-							ContentType: "application/octet-stream",
-							Format:      "<binary chunk>",
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Name:        "Chunk Accepted",
-								Description: "The chunk of data has been accepted and the current progress is available in the range header. The updated upload location is available in the `Location` header.",
-								StatusCode:  http.StatusNoContent,
+								Description: "The blob identified by `digest` is available. The specified chunk of blob content will be present in the body of the request.",
+								StatusCode:  http.StatusPartialContent,
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Location",
-										Type:        "url",
-										Format:      "/v2/<name>/blobs/uploads/<uuid>",
-										Description: "The location of the upload. Clients should assume this changes after each request. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "The length of the requested blob chunk.",
+										Format:      "<length>",
 									},
 									{
-										Name:        "Range",
-										Type:        "header",
-										Format:      "0-<offset>",
-										Description: "Range indicating the current progress of the upload.",
+										Name:        "Content-Range",
+										Type:        "byte range",
+										Description: "Content range of blob chunk.",
+										Format:      "bytes <start>-<end>/<size>",
 									},
-									contentLengthZeroHeader,
-									dockerUploadUUIDHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/octet-stream",
+									Format:      "<blob binary data>",
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "There was an error processing the upload and it must be restarted.",
+								Description: "There was a problem with the request that needs to be addressed by the client, such as an invalid `name` or `tag`.",
 								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
-									ErrorCodeBlobUploadInvalid,
+									ErrorCodeDigestInvalid,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -1341,10 +1508,10 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							{
-								Description: "The upload is unknown to the registry. The upload must be restarted.",
-								StatusCode:  http.StatusNotFound,
+								StatusCode: http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeBlobUploadUnknown,
+									ErrorCodeNameUnknown,
+									ErrorCodeBlobUnknown,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -1352,7 +1519,7 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							{
-								Description: "The `Content-Range` specification cannot be accepted, either because it does not overlap with the current progress or it is invalid.",
+								Description: "The range specification cannot be satisfied for the requested content. This can happen when the range is not formatted correctly or if the range is outside of the valid size of the content.",
 								StatusCode:  http.StatusRequestedRangeNotSatisfiable,
 							},
 							unauthorizedResponseDescriptor,
@@ -1364,87 +1531,63 @@ var routeDescriptors = []RouteDescriptor{
 				},
 			},
 			{
-				Method:      "PUT",
-				Description: "Complete the upload specified by `uuid`, optionally appending the body as the final chunk.",
+				Method:      "DELETE",
+				Description: "Delete the blob identified by `name` and `digest`",
 				Requests: []RequestDescriptor{
 					{
-						Description: "Complete the upload, providing all the data in the body, if necessary. A request without a body will just complete the upload with previously uploaded content.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
-							{
-								Name:        "Content-Length",
-								Type:        "integer",
-								Format:      "<length of data>",
-								Description: "Length of the data being uploaded, corresponding to the length of the request body. May be zero if no data is provided.",
-							},
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							uuidParameterDescriptor,
-						},
-						QueryParameters: []ParameterDescriptor{
-							{
-								Name:        "digest",
-								Type:        "string",
-								Format:      "<digest>",
-								Regexp:      digest.DigestRegexp,
-								Required:    true,
-								Description: `Digest of uploaded blob.`,
-							},
-						},
-						Body: BodyDescriptor{
-							ContentType: "application/octet-stream",
-							Format:      "<binary data>",
+							digestPathParameter,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Name:        "Upload Complete",
-								Description: "The upload has been completed and accepted by the registry. The canonical location will be available in the `Location` header.",
-								StatusCode:  http.StatusNoContent,
+								StatusCode: http.StatusAccepted,
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Location",
-										Type:        "url",
-										Format:      "<blob location>",
-										Description: "The canonical location of the blob for retrieval",
-									},
-									{
-										Name:        "Content-Range",
-										Type:        "header",
-										Format:      "<start of range>-<end of range, inclusive>",
-										Description: "Range of bytes identifying the desired block of content represented by the body. Start must match the end of offset retrieved via status check. Note that this is a non-standard use of the `Content-Range` header.",
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "0",
+										Format:      "0",
 									},
-									contentLengthZeroHeader,
 									digestHeader,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "There was an error processing the upload and it must be restarted.",
-								StatusCode:  http.StatusBadRequest,
+								Name:       "Invalid Name or Digest",
+								StatusCode: http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
 									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
-									ErrorCodeBlobUploadInvalid,
-									errcode.ErrorCodeUnsupported,
 								},
+							},
+							{
+								Description: "The blob, identified by `name` and `digest`, is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format:      errorsBody,
 								},
-							},
-							{
-								Description: "The upload is unknown to the registry. The upload must be restarted.",
-								StatusCode:  http.StatusNotFound,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeBlobUploadUnknown,
+									ErrorCodeNameUnknown,
+									ErrorCodeBlobUnknown,
 								},
+							},
+							{
+								Description: "Blob delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled",
+								StatusCode:  http.StatusMethodNotAllowed,
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format:      errorsBody,
 								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
@@ -1454,53 +1597,81 @@ var routeDescriptors = []RouteDescriptor{
 					},
 				},
 			},
+
+			// TODO(stevvooe): We may want to add a PUT request here to
+			// kickoff an upload of a blob, integrated with the blob upload
+			// API.
+		},
+	},
+
+	{
+		Name:        RouteNameBlobUpload,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/",
+		Entity:      "Initiate Blob Upload",
+		Description: "Initiate a blob upload. This endpoint can be used to create resumable uploads or monolithic uploads.",
+		Methods: []MethodDescriptor{
 			{
-				Method:      "DELETE",
-				Description: "Cancel outstanding upload processes, releasing associated resources. If this is not called, the unfinished uploads will eventually timeout.",
+				Method:      "POST",
+				Description: "Initiate a resumable blob upload. If successful, an upload location will be provided to complete the upload. Optionally, if the `digest` parameter is present, the request body will be used to complete the upload in a single request.",
 				Requests: []RequestDescriptor{
 					{
-						Description: "Cancel the upload specified by `uuid`.",
-						PathParameters: []ParameterDescriptor{
-							nameParameterDescriptor,
-							uuidParameterDescriptor,
-						},
+						Name:        "Initiate Monolithic Blob Upload",
+						Description: "Upload a blob identified by the `digest` parameter in single request. This upload will not be resumable unless a recoverable error is returned.",
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
-							contentLengthZeroHeader,
+							{
+								Name:   "Content-Length",
+								Type:   "integer",
+								Format: "<length of blob>",
+							},
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "digest",
+								Type:        "query",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Description: `Digest of uploaded blob. If present, the upload will be completed, in a single request, with contents of the request body as the resulting blob.`,
+							},
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/octect-stream",
+							Format:      "<binary data>",
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Name:        "Upload Deleted",
-								Description: "The upload has been successfully deleted.",
-								StatusCode:  http.StatusNoContent,
+								Description: "The blob has been created in the registry and is available at the provided location.",
+								StatusCode:  http.StatusCreated,
 								Headers: []ParameterDescriptor{
+									{
+										Name:   "Location",
+										Type:   "url",
+										Format: "<blob location>",
+									},
 									contentLengthZeroHeader,
+									dockerUploadUUIDHeader,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "An error was encountered processing the delete. The client may ignore this error.",
-								StatusCode:  http.StatusBadRequest,
+								Name:       "Invalid Name or Digest",
+								StatusCode: http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
 									ErrorCodeNameInvalid,
-									ErrorCodeBlobUploadInvalid,
-								},
-								Body: BodyDescriptor{
-									ContentType: "application/json",
-									Format:      errorsBody,
 								},
 							},
 							{
-								Description: "The upload is unknown to the registry. The client may ignore this error and assume the upload has been deleted.",
-								StatusCode:  http.StatusNotFound,
+								Name:        "Not allowed",
+								Description: "Blob upload is not allowed because the registry is configured as a pull-through cache or for some other reason",
+								StatusCode:  http.StatusMethodNotAllowed,
 								ErrorCodes: []errcode.ErrorCode{
-									ErrorCodeBlobUploadUnknown,
-								},
-								Body: BodyDescriptor{
-									ContentType: "application/json",
-									Format:      errorsBody,
+									errcode.ErrorCodeUnsupported,
 								},
 							},
 							unauthorizedResponseDescriptor,
@@ -1509,30 +1680,697 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
-				},
-			},
-		},
-	},
-	{
-		Name:        RouteNameCatalog,
-		Path:        "/v2/_catalog",
-		Entity:      "Catalog",
-		Description: "List a set of available repositories in the local registry cluster. Does not provide any indication of what may be available upstream. Applications can only determine if a repository is available but not if it is not available.",
-		Methods: []MethodDescriptor{
-			{
-				Method:      "GET",
-				Description: "Retrieve a sorted, json list of repositories available in the registry.",
-				Requests: []RequestDescriptor{
 					{
-						Name:        "Catalog Fetch",
-						Description: "Request an unabridged list of repositories available.  The implementation may impose a maximum limit and return a partial set with pagination links.",
+						Name:        "Initiate Resumable Blob Upload",
+						Description: "Initiate a resumable blob upload with an empty request body.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							contentLengthZeroHeader,
+							{
+								Name:        "Idempotency-Key",
+								Type:        "string",
+								Format:      "<key>",
+								Description: "Optional key identifying the upload across client retries. If an upload started with the same key in the repository by the same user is still in progress, it is returned instead of a new upload, with a `Range` reflecting the content already received.",
+							},
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "Returns the unabridged list of repositories as a json response.",
-								StatusCode:  http.StatusOK,
+								Description: "The upload has been created. The `Location` header must be used to complete the upload. The response should be identical to a `GET` request on the contents of the returned `Location` header.",
+								StatusCode:  http.StatusAccepted,
 								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
 									{
-										Name:        "Content-Length",
+										Name:        "Location",
+										Type:        "url",
+										Format:      "/v2/<name>/blobs/uploads/<uuid>",
+										Description: "The location of the created upload. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+									},
+									{
+										Name:        "Range",
+										Format:      "0-0",
+										Description: "Range header indicating the progress of the upload. When starting an upload, it will return an empty range, since no content has been received.",
+									},
+									dockerUploadUUIDHeader,
+									{
+										Name:        "Docker-Upload-Chunk-Min-Length",
+										Type:        "integer",
+										Format:      "<length>",
+										Description: "The smallest chunk, in bytes, the registry recommends uploading, other than the last. Only present if configured.",
+									},
+									{
+										Name:        "Docker-Upload-Chunk-Max-Length",
+										Type:        "integer",
+										Format:      "<length>",
+										Description: "The largest chunk, in bytes, the registry will accept. Only present if configured.",
+									},
+									{
+										Name:        "Docker-Upload-Chunk-Recommended-Length",
+										Type:        "integer",
+										Format:      "<length>",
+										Description: "The chunk size, in bytes, the registry recommends uploading. Only present if configured.",
+									},
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "Invalid Name or Digest",
+								StatusCode: http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+					{
+						Name:        "Mount Blob",
+						Description: "Mount a blob identified by the `mount` parameter from another repository.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							contentLengthZeroHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "mount",
+								Type:        "query",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Description: `Digest of blob to mount from the source repository.`,
+							},
+							{
+								Name:        "from",
+								Type:        "query",
+								Format:      "<repository name>",
+								Regexp:      reference.NameRegexp,
+								Description: `Name of the source repository.`,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The blob has been mounted in the repository and is available at the provided location.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:   "Location",
+										Type:   "url",
+										Format: "<blob location>",
+									},
+									contentLengthZeroHeader,
+									dockerUploadUUIDHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "Invalid Name or Digest",
+								StatusCode: http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Blob mount is not allowed because the registry is configured as a pull-through cache or for some other reason",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							blobLinkLimitExceededResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:        RouteNameBlobUploadChunk,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/{uuid:[a-zA-Z0-9-_.=]+}",
+		Entity:      "Blob Upload",
+		Description: "Interact with blob uploads. Clients should never assemble URLs for this endpoint and should only take it through the `Location` header on related API requests. The `Location` header and its parameters should be preserved by clients, using the latest value returned via upload related API calls.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve status of upload identified by `uuid`. The primary purpose of this endpoint is to resolve the current status of a resumable upload.",
+				Requests: []RequestDescriptor{
+					{
+						Description: "Retrieve the progress of the current upload, as reported by the `Range` header.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Upload Progress",
+								Description: "The upload is known and in progress. The last received offset is available in the `Range` header.",
+								StatusCode:  http.StatusNoContent,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Range",
+										Type:        "header",
+										Format:      "0-<offset>",
+										Description: "Range indicating the current progress of the upload.",
+									},
+									{
+										Name:        "Docker-Upload-Fingerprint",
+										Type:        "digest",
+										Format:      "<digest>",
+										Description: "Digest of the content received so far, as stored by the registry. If it differs from the digest of the content sent, the stored content does not match, and the upload must be restarted.",
+									},
+									contentLengthZeroHeader,
+									dockerUploadUUIDHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "There was an error processing the upload and it must be restarted.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+									ErrorCodeBlobUploadInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "PATCH",
+				Description: "Upload a chunk of data for the specified upload.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Stream upload",
+						Description: "Upload a stream of data to upload without completing the upload.",
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/octet-stream",
+							Format:      "<binary data>",
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Data Accepted",
+								Description: "The stream of data has been accepted and the current progress is available in the range header. The updated upload location is available in the `Location` header.",
+								StatusCode:  http.StatusNoContent,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "/v2/<name>/blobs/uploads/<uuid>",
+										Description: "The location of the upload. Clients should assume this changes after each request. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+									},
+									{
+										Name:        "Range",
+										Type:        "header",
+										Format:      "0-<offset>",
+										Description: "Range indicating the current progress of the upload.",
+									},
+									contentLengthZeroHeader,
+									dockerUploadUUIDHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "There was an error processing the upload and it must be restarted.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+									ErrorCodeBlobUploadInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+					{
+						Name:        "Chunked upload",
+						Description: "Upload a chunk of data to specified upload without completing the upload. The data will be uploaded to the specified Content Range.",
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							{
+								Name:        "Content-Range",
+								Type:        "header",
+								Format:      "<start of range>-<end of range, inclusive>",
+								Required:    true,
+								Description: "Range of bytes identifying the desired block of content represented by the body. Start must the end offset retrieved via status check plus one. Note that this is a non-standard use of the `Content-Range` header.",
+							},
+							{
+								Name:        "Content-Length",
+								Type:        "integer",
+								Format:      "<length of chunk>",
+								Description: "Length of the chunk being uploaded, corresponding the length of the request body.",
+							},
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/octet-stream",
+							Format:      "<binary chunk>",
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Chunk Accepted",
+								Description: "The chunk of data has been accepted and the current progress is available in the range header. The updated upload location is available in the `Location` header.",
+								StatusCode:  http.StatusNoContent,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "/v2/<name>/blobs/uploads/<uuid>",
+										Description: "The location of the upload. Clients should assume this changes after each request. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+									},
+									{
+										Name:        "Range",
+										Type:        "header",
+										Format:      "0-<offset>",
+										Description: "Range indicating the current progress of the upload.",
+									},
+									contentLengthZeroHeader,
+									dockerUploadUUIDHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "There was an error processing the upload and it must be restarted.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+									ErrorCodeBlobUploadInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The `Content-Range` specification cannot be accepted, either because it does not overlap with the current progress or it is invalid.",
+								StatusCode:  http.StatusRequestedRangeNotSatisfiable,
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Complete the upload specified by `uuid`, optionally appending the body as the final chunk.",
+				Requests: []RequestDescriptor{
+					{
+						Description: "Complete the upload, providing all the data in the body, if necessary. A request without a body will just complete the upload with previously uploaded content.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							{
+								Name:        "Content-Length",
+								Type:        "integer",
+								Format:      "<length of data>",
+								Description: "Length of the data being uploaded, corresponding to the length of the request body. May be zero if no data is provided.",
+							},
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "digest",
+								Type:        "string",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Required:    true,
+								Description: `Digest of uploaded blob.`,
+							},
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/octet-stream",
+							Format:      "<binary data>",
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Upload Complete",
+								Description: "The upload has been completed and accepted by the registry. The canonical location will be available in the `Location` header.",
+								StatusCode:  http.StatusNoContent,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "<blob location>",
+										Description: "The canonical location of the blob for retrieval",
+									},
+									{
+										Name:        "Content-Range",
+										Type:        "header",
+										Format:      "<start of range>-<end of range, inclusive>",
+										Description: "Range of bytes identifying the desired block of content represented by the body. Start must match the end of offset retrieved via status check. Note that this is a non-standard use of the `Content-Range` header.",
+									},
+									contentLengthZeroHeader,
+									digestHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "There was an error processing the upload and it must be restarted.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+									ErrorCodeBlobUploadInvalid,
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							quotaExceededResponseDescriptor,
+							blobLinkLimitExceededResponseDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Cancel outstanding upload processes, releasing associated resources. If this is not called, the unfinished uploads will eventually timeout.",
+				Requests: []RequestDescriptor{
+					{
+						Description: "Cancel the upload specified by `uuid`.",
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							contentLengthZeroHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Upload Deleted",
+								Description: "The upload has been successfully deleted.",
+								StatusCode:  http.StatusNoContent,
+								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "An error was encountered processing the delete. The client may ignore this error.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+									ErrorCodeBlobUploadInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The upload is unknown to the registry. The client may ignore this error and assume the upload has been deleted.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameBlobUploadProgress,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/{uuid:[a-zA-Z0-9-_.=]+}/progress",
+		Entity:      "Blob Upload Progress",
+		Description: "Follow the progress of a blob upload as it receives data, such as to display it to a user. Requires upload progress reporting to be enabled.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Stream the progress of the upload identified by `uuid` as Server-Sent Events. A `progress` event reports the number of bytes received so far, first on connecting and then whenever it advances. An `end` event reports the final offset when the upload is completed or canceled, after which the stream closes.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A stream of events reporting the progress of the upload.",
+								Body: BodyDescriptor{
+									ContentType: "text/event-stream",
+									Format: `event: progress
+data: {"offset": <offset>}
+
+...
+
+event: end
+data: {"offset": <offset>}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The upload is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "Upload progress reporting is not enabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCatalog,
+		Path:        "/v2/_catalog",
+		Entity:      "Catalog",
+		Description: "List a set of available repositories in the local registry cluster. Does not provide any indication of what may be available upstream. Applications can only determine if a repository is available but not if it is not available.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve a sorted, json list of repositories available in the registry.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Catalog Fetch",
+						Description: "Request an unabridged list of repositories available.  The implementation may impose a maximum limit and return a partial set with pagination links.",
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the unabridged list of repositories as a json response.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		<name>,
+		...
+	]
+}`,
+								},
+							},
+						},
+					},
+					{
+						Name:            "Catalog Fetch Paginated",
+						Description:     "Return the specified portion of repositories.",
+						QueryParameters: paginationParameters,
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		<name>,
+		...
+	]
+	"next": "<url>?last=<name>&n=<last value of n>"
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCapabilities,
+		Path:        "/v2/_catalog/capabilities",
+		Entity:      "Capabilities",
+		Description: "Describe the features enabled on the registry, as determined by its configuration, so that tooling may adapt to it. The document is versioned: within a version, fields are only ever added, never removed or changed in meaning.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the capabilities document of the registry.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The capabilities document of the registry.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
 										Type:        "integer",
 										Description: "Length of the JSON response body.",
 										Format:      "<length>",
@@ -1540,33 +2378,38 @@ var routeDescriptors = []RouteDescriptor{
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
-									Format: `{
-	"repositories": [
-		<name>,
-		...
-	]
-}`,
+									Format:      capabilitiesBody,
 								},
 							},
 						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
 					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCatalogUsage,
+		Path:        "/v2/_catalog/usage",
+		Entity:      "Catalog Usage",
+		Description: "Report the number and total size of the blobs held by the registry, as recorded by the blob index. Access to this endpoint requires `*` access to the `registry:catalog` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the number and total size of the blobs held by the registry.",
+				Requests: []RequestDescriptor{
 					{
-						Name:            "Catalog Fetch Paginated",
-						Description:     "Return the specified portion of repositories.",
-						QueryParameters: paginationParameters,
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
 						Successes: []ResponseDescriptor{
 							{
-								StatusCode: http.StatusOK,
-								Body: BodyDescriptor{
-									ContentType: "application/json",
-									Format: `{
-	"repositories": [
-		<name>,
-		...
-	]
-	"next": "<url>?last=<name>&n=<last value of n>"
-}`,
-								},
+								Description: "The usage of the registry.",
+								StatusCode:  http.StatusOK,
 								Headers: []ParameterDescriptor{
 									{
 										Name:        "Content-Length",
@@ -1574,9 +2417,251 @@ var routeDescriptors = []RouteDescriptor{
 										Description: "Length of the JSON response body.",
 										Format:      "<length>",
 									},
-									linkHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      catalogUsageBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The blob index is not enabled, or has not yet been built.",
+								StatusCode:  http.StatusServiceUnavailable,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnavailable,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRepositoryRegistration,
+		Path:        "/v2/_admin/repositories/{name:" + reference.NameRegexp.String() + "}",
+		Entity:      "Repository Registration",
+		Description: "Register repositories which may be created. When automatic creation of repositories is disabled, only registered repositories are available and requests naming any other repository fail with `NAME_UNKNOWN`. Access to this endpoint requires `*` access to the `registry:repositories` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Check whether the repository identified by `name` is registered.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The repository is registered.",
+								StatusCode:  http.StatusOK,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The repository is not registered.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Register the repository identified by `name`. Registering a repository which is already registered has no effect.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The repository has been registered.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:   "Content-Length",
+										Type:   "integer",
+										Format: "0",
+									},
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The name was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Unregister the repository identified by `name`. The content of the repository is not removed. Unregistering a repository which is not registered has no effect.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The repository has been unregistered.",
+								StatusCode:  http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									{
+										Name:   "Content-Length",
+										Type:   "integer",
+										Format: "0",
+									},
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The name was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRepositoryPolicy,
+		Path:        "/v2/_admin/repositories/{name:" + reference.NameRegexp.String() + "}/_policy",
+		Entity:      "Repository Policy",
+		Description: "Override, for a single repository, policies otherwise set by the registry configuration. Fields of the policy which are not set follow the configuration. Access to this endpoint requires `*` access to the `registry:repositories` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the policy overrides of the repository identified by `name`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The policy overrides of the repository, empty if none are set.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      repositoryPolicyBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The name was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Set the policy overrides of the repository identified by `name`, replacing any set before.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format:      repositoryPolicyBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The policy overrides have been set.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The name or the policy was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+									ErrorCodePolicyInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
 						},
 					},
 				},
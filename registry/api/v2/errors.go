@@ -43,12 +43,13 @@ var (
 	})
 
 	// ErrorCodeTagInvalid is returned when the tag in the manifest does not
-	// match the provided tag.
+	// match the provided tag, or the provided tag is invalid.
 	ErrorCodeTagInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
 		Value:   "TAG_INVALID",
 		Message: "manifest tag did not match URI",
 		Description: `During a manifest upload, if the tag in the manifest
-		does not match the uri tag, this error will be returned.`,
+		does not match the uri tag, or the uri tag is not a valid tag, this
+		error will be returned.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
 
@@ -83,6 +84,17 @@ var (
 		HTTPStatusCode: http.StatusBadRequest,
 	})
 
+	// ErrorCodeManifestAmbiguous is returned when a manifest is requested by
+	// a digest prefix which matches more than one manifest.
+	ErrorCodeManifestAmbiguous = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "MANIFEST_AMBIGUOUS",
+		Message: "manifest digest prefix is ambiguous",
+		Description: `This error is returned when a manifest is requested
+		by a digest prefix which matches more than one manifest in the
+		repository. A longer prefix, or the full digest, must be provided.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
 	// ErrorCodeManifestUnverified is returned when the manifest fails
 	// signature verification.
 	ErrorCodeManifestUnverified = errcode.Register(errGroup, errcode.ErrorDescriptor{
@@ -133,4 +145,87 @@ var (
 		longer proceed.`,
 		HTTPStatusCode: http.StatusNotFound,
 	})
+
+	// ErrorCodeQuotaExceeded is returned when storing content would take a
+	// repository over its storage quota.
+	ErrorCodeQuotaExceeded = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "QUOTA_EXCEEDED",
+		Message: "repository storage quota exceeded",
+		Description: `Storing the content would take the repository over
+		its storage quota. Content must be removed from the repository, or its
+		quota raised, before the operation can succeed.`,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+	})
+
+	// ErrorCodeBlobLinkLimitExceeded is returned when linking a blob into a
+	// repository would take the number of repositories linking it over the
+	// limit.
+	ErrorCodeBlobLinkLimitExceeded = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "BLOB_LINK_LIMIT_EXCEEDED",
+		Message: "blob is linked by too many repositories",
+		Description: `The blob is already linked by as many repositories as
+		the registry allows, so cannot be pushed to or mounted in another.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeTagAliased is returned when deleting a tag would leave
+	// aliases of the tag dangling.
+	ErrorCodeTagAliased = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_ALIASED",
+		Message: "tag is the target of aliases",
+		Description: `The tag cannot be deleted because other tags are
+		aliases of it. The aliases must be removed before the tag.`,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+	})
+
+	// ErrorCodeTagImmutable is returned when pushing a manifest would move
+	// a tag which may not be changed.
+	ErrorCodeTagImmutable = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `The tag already refers to another manifest and the
+		repository does not allow tags to be changed once pushed.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodePolicyInvalid is returned when the policy overrides given for
+	// a repository cannot be parsed.
+	ErrorCodePolicyInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "POLICY_INVALID",
+		Message: "invalid repository policy",
+		Description: `The repository policy given in the request body could
+		not be parsed.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeSortInvalid is returned when a listing is requested in an
+	// order the registry does not support.
+	ErrorCodeSortInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "SORT_INVALID",
+		Message: "invalid sort order",
+		Description: `The requested sort order is not supported by the
+		endpoint.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeCursorInvalid is returned when changes are requested from a
+	// cursor which is malformed or has expired.
+	ErrorCodeCursorInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "CURSOR_INVALID",
+		Message: "invalid cursor",
+		Description: `The cursor is malformed, or the changes following it
+		are no longer retained. The listing must be started afresh.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodePlatformInvalid is returned when a manifest list is
+	// requested for a platform which is malformed.
+	ErrorCodePlatformInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PLATFORM_INVALID",
+		Message: "invalid platform",
+		Description: `A platform by which a manifest list was to be filtered
+		is not of the form os/architecture, optionally followed by
+		/variant.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
 )
@@ -5,13 +5,23 @@ import "github.com/gorilla/mux"
 // The following are definitions of the name under which all V2 routes are
 // registered. These symbols can be used to look up a route based on the name.
 const (
-	RouteNameBase            = "base"
-	RouteNameManifest        = "manifest"
-	RouteNameTags            = "tags"
-	RouteNameBlob            = "blob"
-	RouteNameBlobUpload      = "blob-upload"
-	RouteNameBlobUploadChunk = "blob-upload-chunk"
-	RouteNameCatalog         = "catalog"
+	RouteNameBase                   = "base"
+	RouteNameManifest               = "manifest"
+	RouteNameDescriptor             = "descriptor"
+	RouteNameManifestConfig         = "manifest-config"
+	RouteNameDefaultManifest        = "default-manifest"
+	RouteNameTags                   = "tags"
+	RouteNameTagChanges             = "tag-changes"
+	RouteNameTagAlias               = "tag-alias"
+	RouteNameBlob                   = "blob"
+	RouteNameBlobUpload             = "blob-upload"
+	RouteNameBlobUploadChunk        = "blob-upload-chunk"
+	RouteNameBlobUploadProgress     = "blob-upload-progress"
+	RouteNameCatalog                = "catalog"
+	RouteNameCapabilities           = "capabilities"
+	RouteNameCatalogUsage           = "catalog-usage"
+	RouteNameRepositoryRegistration = "repository-registration"
+	RouteNameRepositoryPolicy       = "repository-policy"
 )
 
 // Router builds a gorilla router with named routes for the various API
@@ -59,6 +59,76 @@ func TestRouter(t *testing.T) {
 				"reference": "sha256:abcdef01234567890",
 			},
 		},
+		{
+			RouteName:  RouteNameDefaultManifest,
+			RequestURI: "/v2/foo/bar/manifests/",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameDescriptor,
+			RequestURI: "/v2/foo/bar/manifests/tag/descriptor",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "tag",
+			},
+		},
+		{
+			RouteName:  RouteNameDescriptor,
+			RequestURI: "/v2/foo/bar/manifests/sha256:abcdef01234567890/descriptor",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "sha256:abcdef01234567890",
+			},
+		},
+		{
+			RouteName:  RouteNameManifestConfig,
+			RequestURI: "/v2/foo/bar/manifests/tag/config",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "tag",
+			},
+		},
+		{
+			RouteName:  RouteNameTagChanges,
+			RequestURI: "/v2/foo/bar/tags/changes",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameTagAlias,
+			RequestURI: "/v2/foo/bar/_aliases/stable",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"tag":  "stable",
+			},
+		},
+		{
+			RouteName:  RouteNameCapabilities,
+			RequestURI: "/v2/_catalog/capabilities",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameCatalogUsage,
+			RequestURI: "/v2/_catalog/usage",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameRepositoryRegistration,
+			RequestURI: "/v2/_admin/repositories/foo/bar",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameRepositoryPolicy,
+			RequestURI: "/v2/_admin/repositories/foo/bar/_policy",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameTags,
 			RequestURI: "/v2/foo/bar/tags/list",
@@ -136,6 +206,14 @@ func TestRouter(t *testing.T) {
 				"uuid": "RDk1MzA2RkEtRkFEMy00RTM2LThENDEtQ0YxQzkzRUY4Mjg2IA_-==",
 			},
 		},
+		{
+			RouteName:  RouteNameBlobUploadProgress,
+			RequestURI: "/v2/foo/bar/blobs/uploads/D95306FA-FAD3-4E36-8D41-CF1C93EF8286/progress",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"uuid": "D95306FA-FAD3-4E36-8D41-CF1C93EF8286",
+			},
+		},
 		{
 			// does not match
 			RouteName:  RouteNameBlobUploadChunk,
@@ -4,12 +4,49 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/docker/distribution/reference"
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 )
 
+// maxTagLength is the maximum length of a tag.
+const maxTagLength = 128
+
+var anchoredTagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// ValidateTag returns an error if tag is not a valid tag: if it is empty,
+// longer than 128 characters, resembles a digest or contains characters other
+// than letters, digits, underscores, periods and dashes, or begins with a
+// period or dash. Such tags could not be used to build storage paths safely.
+func ValidateTag(tag string) error {
+	switch {
+	case tag == "":
+		return fmt.Errorf("tag must not be empty")
+	case len(tag) > maxTagLength:
+		return fmt.Errorf("tag must be at most %d characters long", maxTagLength)
+	case strings.Contains(tag, ":"):
+		return fmt.Errorf("tag %q resembles a digest", tag)
+	case !anchoredTagRegexp.MatchString(tag):
+		return fmt.Errorf("tag %q contains invalid characters", tag)
+	}
+	return nil
+}
+
+// CanonicalizeDigest returns dgst in its canonical form, with its algorithm and
+// hex encoding lowercased, or an error if it does not use a known algorithm or
+// its encoding is malformed. References may hold such digests, which do not
+// identify content in urls.
+func CanonicalizeDigest(dgst digest.Digest) (digest.Digest, error) {
+	canonical := digest.Digest(strings.ToLower(string(dgst)))
+	if err := canonical.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest %q: %v", dgst, err)
+	}
+	return canonical, nil
+}
+
 // URLBuilder creates registry API urls from a single base endpoint. It can be
 // used to create urls for use in a registry client or server.
 //
@@ -127,6 +164,84 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildCapabilitiesURL constructs a url to get the capabilities document of
+// the registry.
+func (ub *URLBuilder) BuildCapabilitiesURL() (string, error) {
+	route := ub.cloneRoute(RouteNameCapabilities)
+
+	capabilitiesURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return capabilitiesURL.String(), nil
+}
+
+// BuildCatalogUsageURL constructs a url to get the number and total size of
+// the blobs held by the registry.
+func (ub *URLBuilder) BuildCatalogUsageURL() (string, error) {
+	route := ub.cloneRoute(RouteNameCatalogUsage)
+
+	usageURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return usageURL.String(), nil
+}
+
+// BuildTagChangesURL constructs a url to follow the changes to the tags in
+// the named repository.
+func (ub *URLBuilder) BuildTagChangesURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameTagChanges)
+
+	changesURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(changesURL, values...).String(), nil
+}
+
+// BuildTagAliasURL constructs a url to manage the alias held by the tag of
+// the reference.
+func (ub *URLBuilder) BuildTagAliasURL(ref reference.NamedTagged) (string, error) {
+	route := ub.cloneRoute(RouteNameTagAlias)
+
+	aliasURL, err := route.URL("name", ref.Name(), "tag", ref.Tag())
+	if err != nil {
+		return "", err
+	}
+
+	return aliasURL.String(), nil
+}
+
+// BuildRepositoryRegistrationURL constructs a url to register or unregister
+// the named repository.
+func (ub *URLBuilder) BuildRepositoryRegistrationURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameRepositoryRegistration)
+
+	registrationURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return registrationURL.String(), nil
+}
+
+// BuildRepositoryPolicyURL constructs a url to get or set the policy overrides
+// of the named repository.
+func (ub *URLBuilder) BuildRepositoryPolicyURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameRepositoryPolicy)
+
+	policyURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return policyURL.String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -147,9 +262,16 @@ func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
 	tagOrDigest := ""
 	switch v := ref.(type) {
 	case reference.Tagged:
+		if err := ValidateTag(v.Tag()); err != nil {
+			return "", err
+		}
 		tagOrDigest = v.Tag()
 	case reference.Digested:
-		tagOrDigest = v.Digest().String()
+		dgst, err := CanonicalizeDigest(v.Digest())
+		if err != nil {
+			return "", err
+		}
+		tagOrDigest = dgst.String()
 	default:
 		return "", fmt.Errorf("reference must have a tag or digest")
 	}
@@ -162,11 +284,91 @@ func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
 	return manifestURL.String(), nil
 }
 
+// BuildDescriptorURL constructs a url for the descriptor of the manifest
+// identified by name and reference. The argument reference may be either a tag
+// or digest.
+func (ub *URLBuilder) BuildDescriptorURL(ref reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameDescriptor)
+
+	tagOrDigest := ""
+	switch v := ref.(type) {
+	case reference.Tagged:
+		if err := ValidateTag(v.Tag()); err != nil {
+			return "", err
+		}
+		tagOrDigest = v.Tag()
+	case reference.Digested:
+		dgst, err := CanonicalizeDigest(v.Digest())
+		if err != nil {
+			return "", err
+		}
+		tagOrDigest = dgst.String()
+	default:
+		return "", fmt.Errorf("reference must have a tag or digest")
+	}
+
+	descriptorURL, err := route.URL("name", ref.Name(), "reference", tagOrDigest)
+	if err != nil {
+		return "", err
+	}
+
+	return descriptorURL.String(), nil
+}
+
+// BuildDefaultManifestURL constructs a url for the manifest of the default
+// reference of the named repository.
+func (ub *URLBuilder) BuildDefaultManifestURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameDefaultManifest)
+
+	manifestURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return manifestURL.String(), nil
+}
+
+// BuildManifestConfigURL constructs a url for the config blob of the manifest
+// identified by name and reference. The argument reference may be either a tag
+// or digest.
+func (ub *URLBuilder) BuildManifestConfigURL(ref reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameManifestConfig)
+
+	tagOrDigest := ""
+	switch v := ref.(type) {
+	case reference.Tagged:
+		if err := ValidateTag(v.Tag()); err != nil {
+			return "", err
+		}
+		tagOrDigest = v.Tag()
+	case reference.Digested:
+		dgst, err := CanonicalizeDigest(v.Digest())
+		if err != nil {
+			return "", err
+		}
+		tagOrDigest = dgst.String()
+	default:
+		return "", fmt.Errorf("reference must have a tag or digest")
+	}
+
+	configURL, err := route.URL("name", ref.Name(), "reference", tagOrDigest)
+	if err != nil {
+		return "", err
+	}
+
+	return configURL.String(), nil
+}
+
 // BuildBlobURL constructs the url for the blob identified by name and dgst.
 func (ub *URLBuilder) BuildBlobURL(ref reference.Canonical) (string, error) {
 	route := ub.cloneRoute(RouteNameBlob)
 
-	layerURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	dgst, err := CanonicalizeDigest(ref.Digest())
+	if err != nil {
+		return "", err
+	}
+
+	layerURL, err := route.URL("name", ref.Name(), "digest", dgst.String())
 	if err != nil {
 		return "", err
 	}
@@ -202,6 +404,19 @@ func (ub *URLBuilder) BuildBlobUploadChunkURL(name reference.Named, uuid string,
 	return appendValuesURL(uploadURL, values...).String(), nil
 }
 
+// BuildBlobUploadProgressURL constructs a url to follow the progress of the
+// upload identified by uuid.
+func (ub *URLBuilder) BuildBlobUploadProgressURL(name reference.Named, uuid string) (string, error) {
+	route := ub.cloneRoute(RouteNameBlobUploadProgress)
+
+	progressURL, err := route.URL("name", name.Name(), "uuid", uuid)
+	if err != nil {
+		return "", err
+	}
+
+	return progressURL.String(), nil
+}
+
 // clondedRoute returns a clone of the named route from the router. Routes
 // must be cloned to avoid modifying them during url generation.
 func (ub *URLBuilder) cloneRoute(name string) clonedRoute {
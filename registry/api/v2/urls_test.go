@@ -5,11 +5,23 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/docker/distribution/reference"
 )
 
+// unvalidatedTag is a tagged reference whose tag has not been validated, as
+// may be implemented outside the reference package.
+type unvalidatedTag struct {
+	reference.Named
+	tag string
+}
+
+func (t unvalidatedTag) Tag() string {
+	return t.tag
+}
+
 type urlBuilderTestCase struct {
 	description  string
 	expectedPath string
@@ -34,6 +46,50 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildTagsURL(fooBarRef)
 			},
 		},
+		{
+			description:  "test tag changes url",
+			expectedPath: "/v2/foo/bar/tags/changes?cursor=abc&n=10",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildTagChangesURL(fooBarRef, url.Values{"cursor": []string{"abc"}, "n": []string{"10"}})
+			},
+		},
+		{
+			description:  "build blob upload progress url",
+			expectedPath: "/v2/foo/bar/blobs/uploads/uuid-part/progress",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildBlobUploadProgressURL(fooBarRef, "uuid-part")
+			},
+		},
+		{
+			description: "test manifest url empty tag",
+			expectedErr: fmt.Errorf("tag must not be empty"),
+			build: func() (string, error) {
+				return urlBuilder.BuildManifestURL(unvalidatedTag{Named: fooBarRef, tag: ""})
+			},
+		},
+		{
+			description: "test manifest url overlong tag",
+			expectedErr: fmt.Errorf("tag must be at most 128 characters long"),
+			build: func() (string, error) {
+				return urlBuilder.BuildManifestURL(unvalidatedTag{Named: fooBarRef, tag: strings.Repeat("a", 129)})
+			},
+		},
+		{
+			description: "test manifest url digest-like tag",
+			expectedErr: fmt.Errorf(`tag "sha256:abc" resembles a digest`),
+			build: func() (string, error) {
+				return urlBuilder.BuildManifestURL(unvalidatedTag{Named: fooBarRef, tag: "sha256:abc"})
+			},
+		},
+		{
+			description: "test manifest url tag with invalid characters",
+			expectedErr: fmt.Errorf(`tag "a/b" contains invalid characters`),
+			build: func() (string, error) {
+				return urlBuilder.BuildManifestURL(unvalidatedTag{Named: fooBarRef, tag: "a/b"})
+			},
+		},
 		{
 			description:  "test manifest url tagged ref",
 			expectedPath: "/v2/foo/bar/manifests/tag",
@@ -51,6 +107,65 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildManifestURL(fooBarRef)
 			},
 		},
+		{
+			description:  "test descriptor url tagged ref",
+			expectedPath: "/v2/foo/bar/manifests/tag/descriptor",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithTag(fooBarRef, "tag")
+				return urlBuilder.BuildDescriptorURL(ref)
+			},
+		},
+		{
+			description:  "test default manifest url",
+			expectedPath: "/v2/foo/bar/manifests/",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildDefaultManifestURL(fooBarRef)
+			},
+		},
+		{
+			description:  "test manifest config url tagged ref",
+			expectedPath: "/v2/foo/bar/manifests/tag/config",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithTag(fooBarRef, "tag")
+				return urlBuilder.BuildManifestConfigURL(ref)
+			},
+		},
+		{
+			description:  "build capabilities url",
+			expectedPath: "/v2/_catalog/capabilities",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildCapabilitiesURL()
+			},
+		},
+		{
+			description:  "build tag alias url",
+			expectedPath: "/v2/foo/bar/_aliases/stable",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithTag(fooBarRef, "stable")
+				return urlBuilder.BuildTagAliasURL(ref)
+			},
+		},
+		{
+			description:  "build repository registration url",
+			expectedPath: "/v2/_admin/repositories/foo/bar",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildRepositoryRegistrationURL(fooBarRef)
+			},
+		},
+		{
+			description:  "build repository policy url",
+			expectedPath: "/v2/_admin/repositories/foo/bar/_policy",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildRepositoryPolicyURL(fooBarRef)
+			},
+		},
 		{
 			description:  "build blob url",
 			expectedPath: "/v2/foo/bar/blobs/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5",
@@ -60,6 +175,48 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildBlobURL(ref)
 			},
 		},
+		{
+			description:  "build blob url uppercase digest",
+			expectedPath: "/v2/foo/bar/blobs/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3B3692957D439AC1928219A83FAC91E7BF96C153725526874673AE1F2023F8D5")
+				return urlBuilder.BuildBlobURL(ref)
+			},
+		},
+		{
+			description: "build blob url short digest",
+			expectedErr: fmt.Errorf(`invalid digest "sha256:3b3692957d439ac1928219a83fac91e7": invalid checksum digest length`),
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7")
+				return urlBuilder.BuildBlobURL(ref)
+			},
+		},
+		{
+			description: "build blob url unknown algorithm",
+			expectedErr: fmt.Errorf(`invalid digest "md5:3b3692957d439ac1928219a83fac91e7": unsupported digest algorithm`),
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "md5:3b3692957d439ac1928219a83fac91e7")
+				return urlBuilder.BuildBlobURL(ref)
+			},
+		},
+		{
+			description:  "test manifest url uppercase digest",
+			expectedPath: "/v2/foo/bar/manifests/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3B3692957D439AC1928219A83FAC91E7BF96C153725526874673AE1F2023F8D5")
+				return urlBuilder.BuildManifestURL(ref)
+			},
+		},
+		{
+			description: "test manifest url short digest",
+			expectedErr: fmt.Errorf(`invalid digest "sha256:3b3692957d439ac1928219a83fac91e7": invalid checksum digest length`),
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7")
+				return urlBuilder.BuildManifestURL(ref)
+			},
+		},
 		{
 			description:  "build blob upload url",
 			expectedPath: "/v2/foo/bar/blobs/uploads/",
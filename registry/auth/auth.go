@@ -135,6 +135,21 @@ func (uic userInfoContext) Value(key interface{}) interface{} {
 	return uic.Context.Value(key)
 }
 
+// Principal returns the name of the authenticated principal (the username or
+// token subject) carried by the context. If the context has not been
+// authorized, the empty string is returned.
+func Principal(ctx context.Context) string {
+	if user, ok := ctx.Value(UserKey).(UserInfo); ok {
+		return user.Name
+	}
+
+	if name, ok := ctx.Value(UserNameKey).(string); ok {
+		return name
+	}
+
+	return ""
+}
+
 // WithResources returns a context with the authorized resources.
 func WithResources(ctx context.Context, resources []Resource) context.Context {
 	return resourceContext{
@@ -531,3 +531,20 @@ func TestNewAccessControllerPemBlock(t *testing.T) {
 		t.Fatal("accessController has the wrong number of certificates")
 	}
 }
+
+// TestActionSetExists ensures that the exists action is implied by pull, but
+// not the reverse.
+func TestActionSetExists(t *testing.T) {
+	if !newActionSet("pull").contains("exists") {
+		t.Fatal("pull does not imply exists")
+	}
+	if !newActionSet("exists").contains("exists") {
+		t.Fatal("exists not contained in its own set")
+	}
+	if newActionSet("exists").contains("pull") {
+		t.Fatal("exists implies pull")
+	}
+	if newActionSet("push").contains("exists") {
+		t.Fatal("push implies exists")
+	}
+}
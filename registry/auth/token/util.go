@@ -41,8 +41,12 @@ func newActionSet(actions ...string) actionSet {
 }
 
 // Contains calls StringSet.Contains() for
-// either "*" or the given action string.
+// either "*" or the given action string. The "pull" action implies "exists",
+// which only permits checking whether content exists.
 func (s actionSet) contains(action string) bool {
+	if action == "exists" && s.stringSet.contains("pull") {
+		return true
+	}
 	return s.stringSet.contains("*") || s.stringSet.contains(action)
 }
 
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// newDebugHandler returns the handler of the debug server. It serves the
+// health check endpoints and, as configured, the Prometheus metrics and the
+// pprof and expvar endpoints. Nothing else registered with the default
+// ServeMux is exposed.
+func newDebugHandler(config *configuration.Configuration) http.Handler {
+	mux := http.NewServeMux()
+
+	// The health checks register themselves with the default ServeMux.
+	mux.Handle("/debug/health", http.DefaultServeMux)
+	mux.Handle("/debug/health/", http.DefaultServeMux)
+
+	if config.HTTP.Debug.Prometheus.Enabled {
+		path := config.HTTP.Debug.Prometheus.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		log.Info("providing prometheus metrics on ", path)
+		mux.Handle(path, metrics.Handler())
+	}
+
+	if config.HTTP.Debug.Profiling.Enabled {
+		prefix := strings.TrimSuffix(config.HTTP.Debug.Profiling.Path, "/")
+		if prefix == "" {
+			prefix = "/debug"
+		}
+		log.Info("providing profiling on ", prefix)
+
+		handler := profilingHandler(prefix)
+		mux.Handle(prefix+"/pprof/", handler)
+		mux.Handle(prefix+"/vars", handler)
+	}
+
+	return mux
+}
+
+// profilingHandler serves the pprof and expvar endpoints under prefix, in
+// place of /debug, where they expect to be.
+func profilingHandler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path = "/debug" + strings.TrimPrefix(r.URL.Path, prefix)
+		u.RawPath = ""
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = &u
+		mux.ServeHTTP(w, r2)
+	})
+}
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
@@ -18,24 +23,38 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
 	"github.com/docker/distribution/manifest"
 	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/notifications"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/admission"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/storage"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/factory"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	_ "github.com/docker/distribution/registry/storage/driver/testdriver"
 	"github.com/docker/distribution/testutil"
+	events "github.com/docker/go-events"
 	"github.com/docker/libtrust"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var headerConfig = http.Header{
@@ -2032,6 +2051,13 @@ func newTestEnvMirror(t *testing.T, deleteEnabled bool) *testEnv {
 }
 
 func newTestEnv(t *testing.T, deleteEnabled bool) *testEnv {
+	config := newTestConfig(deleteEnabled)
+	return newTestEnvWithConfig(t, &config)
+}
+
+// newTestConfig returns the configuration of the environment created by
+// newTestEnv, for tests to change before creating their own.
+func newTestConfig(deleteEnabled bool) configuration.Configuration {
 	config := configuration.Configuration{
 		Storage: configuration.Storage{
 			"testdriver": configuration.Parameters{},
@@ -2045,7 +2071,7 @@ func newTestEnv(t *testing.T, deleteEnabled bool) *testEnv {
 	config.Compatibility.Schema1.Enabled = true
 	config.HTTP.Headers = headerConfig
 
-	return newTestEnvWithConfig(t, &config)
+	return config
 }
 
 func newTestEnvWithConfig(t *testing.T, config *configuration.Configuration) *testEnv {
@@ -2402,6 +2428,21 @@ func checkErr(t *testing.T, err error, msg string) {
 	}
 }
 
+// pushRandomBlob pushes a small blob of random content to the named
+// repository, returning its digest and content.
+func pushRandomBlob(t *testing.T, env *testEnv, name reference.Named) (digest.Digest, []byte) {
+	p := make([]byte, 4096)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(p))
+
+	return dgst, p
+}
+
 func createRepository(env *testEnv, t *testing.T, imageName string, tag string) digest.Digest {
 	imageNameRef, err := reference.WithName(imageName)
 	if err != nil {
@@ -2595,3 +2636,4562 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+// TestBlobAPIConditionalGet ensures that blob requests carrying the digest in
+// If-None-Match are answered with 304 Not Modified and no body.
+func TestBlobAPIConditionalGet(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	dgst, _ := pushRandomBlob(t, env, imageName)
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	resp, err := http.Head(blobURL)
+	checkErr(t, err, "heading blob")
+	resp.Body.Close()
+	checkResponse(t, "heading blob", resp, http.StatusOK)
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("no ETag returned for blob")
+	}
+
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, blobURL, nil)
+		checkErr(t, err, "building conditional request")
+		req.Header.Set("If-None-Match", etag)
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "issuing conditional request")
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		checkErr(t, err, "reading conditional response")
+
+		checkResponse(t, method+" blob with If-None-Match", resp, http.StatusNotModified)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+		if len(body) != 0 {
+			t.Fatalf("unexpected body in %s response with If-None-Match: %d bytes", method, len(body))
+		}
+	}
+}
+
+// TestManifestGetTagHistory ensures that fetching a repointed tag returns the
+// current digest and records a new entry in the tag history.
+func TestManifestGetTagHistory(t *testing.T) {
+	config := newTestConfig(false)
+	config.Storage["taghistory"] = configuration.Parameters{"limit": 10}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/history")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	var dgsts []digest.Digest
+	for i := 0; i < 2; i++ {
+		dgst := createRepository(env, t, imageName.Name(), "latest")
+		dgsts = append(dgsts, dgst)
+
+		resp, err := http.Get(manifestURL)
+		checkErr(t, err, "fetching manifest by tag")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest by tag", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+	}
+
+	if dgsts[0] == dgsts[1] {
+		t.Fatalf("non-random test data")
+	}
+
+	// Neither HEAD requests nor fetches while read-only are recorded.
+	createRepository(env, t, imageName.Name(), "latest")
+
+	resp, err := http.Head(manifestURL)
+	checkErr(t, err, "checking manifest by tag")
+	resp.Body.Close()
+	checkResponse(t, "checking manifest by tag", resp, http.StatusOK)
+
+	env.app.readOnly = true
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest by tag while read-only")
+	resp.Body.Close()
+	checkResponse(t, "fetching manifest by tag while read-only", resp, http.StatusOK)
+	env.app.readOnly = false
+
+	history, err := storage.TagHistory(env.ctx, env.app.driver, imageName.Name(), "latest")
+	checkErr(t, err, "reading tag history")
+
+	if len(history) != len(dgsts) {
+		t.Fatalf("unexpected tag history length: %d != %d", len(history), len(dgsts))
+	}
+	for i, entry := range history {
+		if entry.Digest != dgsts[i] {
+			t.Fatalf("unexpected digest in tag history entry %d: %v != %v", i, entry.Digest, dgsts[i])
+		}
+	}
+}
+
+// TestTagsListPushedDesc ensures that tags may be listed newest push first,
+// and that pagination preserves the requested order.
+func TestTagsListPushedDesc(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/sorted")
+	for _, tag := range []string{"a", "c", "b"} {
+		createRepository(env, t, imageName.Name(), tag)
+	}
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+
+	getTags := func(values url.Values) (tagsAPIResponse, http.Header) {
+		resp, err := http.Get(tagsURL + "?" + values.Encode())
+		checkErr(t, err, "listing tags")
+		defer resp.Body.Close()
+		checkResponse(t, "listing tags", resp, http.StatusOK)
+
+		var body tagsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding tags response: %v", err)
+		}
+		return body, resp.Header
+	}
+
+	body, _ := getTags(url.Values{"sort": []string{"pushed_desc"}})
+	if !reflect.DeepEqual(body.Tags, []string{"b", "c", "a"}) {
+		t.Fatalf("unexpected tag order: %v", body.Tags)
+	}
+
+	body, header := getTags(url.Values{"sort": []string{"pushed_desc"}, "n": []string{"2"}})
+	if !reflect.DeepEqual(body.Tags, []string{"b", "c"}) {
+		t.Fatalf("unexpected first page: %v", body.Tags)
+	}
+
+	linkURL, err := url.Parse(strings.Trim(strings.TrimSuffix(header.Get("Link"), `; rel="next"`), "<>"))
+	checkErr(t, err, "parsing link header")
+	next := linkURL.Query()
+	if next.Get("sort") != "pushed_desc" || next.Get("last") != "c" || next.Get("n") != "2" {
+		t.Fatalf("unexpected link header: %q", header.Get("Link"))
+	}
+
+	body, header = getTags(next)
+	if !reflect.DeepEqual(body.Tags, []string{"a"}) {
+		t.Fatalf("unexpected second page: %v", body.Tags)
+	}
+	if header.Get("Link") != "" {
+		t.Fatalf("unexpected link header on last page: %q", header.Get("Link"))
+	}
+
+	body, _ = getTags(url.Values{})
+	if !reflect.DeepEqual(body.Tags, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected default tag order: %v", body.Tags)
+	}
+
+	resp, err := http.Get(tagsURL + "?sort=size")
+	checkErr(t, err, "listing tags with unsupported order")
+	defer resp.Body.Close()
+	checkResponse(t, "listing tags with unsupported order", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "listing tags with unsupported order", resp, v2.ErrorCodeSortInvalid)
+}
+
+// TestBlobUploadIdempotencyKey ensures that starting an upload with the key of
+// an upload in progress returns that upload rather than a new one.
+func TestBlobUploadIdempotencyKey(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/idempotent")
+	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+
+	startUpload := func(key string) *http.Response {
+		req, err := http.NewRequest("POST", layerUploadURL, nil)
+		checkErr(t, err, "creating upload request")
+		req.Header.Set("Idempotency-Key", key)
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "starting upload")
+		resp.Body.Close()
+		checkResponse(t, "starting upload", resp, http.StatusAccepted)
+
+		return resp
+	}
+
+	first := startUpload("ci-job-1")
+	uuid := first.Header.Get("Docker-Upload-UUID")
+
+	content := []byte("retried layer content")
+	_, dgst := pushChunk(t, env.builder, imageName, first.Header.Get("Location"), bytes.NewReader(content), int64(len(content)))
+
+	second := startUpload("ci-job-1")
+	checkHeaders(t, second, http.Header{
+		"Docker-Upload-UUID": []string{uuid},
+		"Range":              []string{fmt.Sprintf("0-%d", len(content)-1)},
+	})
+
+	if other := startUpload("ci-job-2"); other.Header.Get("Docker-Upload-UUID") == uuid {
+		t.Fatalf("upload with a different key reused upload %s", uuid)
+	}
+
+	finishUpload(t, env.builder, imageName, second.Header.Get("Location"), dgst)
+
+	if third := startUpload("ci-job-1"); third.Header.Get("Docker-Upload-UUID") == uuid {
+		t.Fatalf("completed upload %s was reused", uuid)
+	}
+}
+
+// TestBlobUploadIdempotencyKeyPrincipal ensures that uploads started with the
+// same key by different principals are not shared, however the access
+// controller carries the principal.
+func TestBlobUploadIdempotencyKeyPrincipal(t *testing.T) {
+	for _, userKey := range []bool{false, true} {
+		func() {
+			config := newTestConfig(false)
+			config.Auth = configuration.Auth{
+				"testprincipal": configuration.Parameters{"name": "alice", "userkey": userKey},
+			}
+
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			imageName, _ := reference.WithName("foo/idempotent")
+			layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+			checkErr(t, err, "building upload url")
+
+			startUpload := func(principal string) string {
+				req, err := http.NewRequest("POST", layerUploadURL, nil)
+				checkErr(t, err, "creating upload request")
+				req.Header.Set("Idempotency-Key", "ci-job-1")
+				req.Header.Set("X-Test-Principal", principal)
+
+				resp, err := http.DefaultClient.Do(req)
+				checkErr(t, err, "starting upload")
+				resp.Body.Close()
+				checkResponse(t, "starting upload", resp, http.StatusAccepted)
+
+				return resp.Header.Get("Docker-Upload-UUID")
+			}
+
+			uuid := startUpload("alice")
+			if other := startUpload("bob"); other == uuid {
+				t.Fatalf("upload %s started by alice was reused by bob (userkey %v)", uuid, userKey)
+			}
+			if again := startUpload("alice"); again != uuid {
+				t.Fatalf("upload %s was not reused by alice (userkey %v): %s", uuid, userKey, again)
+			}
+		}()
+	}
+}
+
+// TestOptions ensures that OPTIONS requests advertise the methods enabled by
+// the configuration, optionally with a capabilities body.
+func TestOptions(t *testing.T) {
+	for _, deleteEnabled := range []bool{false, true} {
+		config := newTestConfig(deleteEnabled)
+		config.HTTP.Options.Capabilities = true
+
+		env := newTestEnvWithConfig(t, &config)
+
+		imageName, _ := reference.WithName("foo/options")
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		digestRef, _ := reference.WithDigest(imageName, digest.FromString("options"))
+		blobURL, err := env.builder.BuildBlobURL(digestRef)
+		checkErr(t, err, "building blob url")
+
+		manifestAllow := "GET, HEAD, OPTIONS, PUT"
+		blobAllow := "GET, HEAD, OPTIONS"
+		if deleteEnabled {
+			manifestAllow = "DELETE, GET, HEAD, OPTIONS, PUT"
+			blobAllow = "DELETE, GET, HEAD, OPTIONS"
+		}
+
+		for _, tc := range []struct {
+			url   string
+			allow string
+		}{
+			{manifestURL, manifestAllow},
+			{blobURL, blobAllow},
+		} {
+			req, err := http.NewRequest("OPTIONS", tc.url, nil)
+			checkErr(t, err, "creating options request")
+
+			resp, err := http.DefaultClient.Do(req)
+			checkErr(t, err, "sending options request")
+
+			checkResponse(t, "options", resp, http.StatusOK)
+			checkHeaders(t, resp, http.Header{
+				"Allow": []string{tc.allow},
+			})
+
+			var capabilities capabilitiesAPIResponse
+			if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+				t.Fatalf("error decoding capabilities: %v", err)
+			}
+			resp.Body.Close()
+			if capabilities.Delete != deleteEnabled || !capabilities.ChunkedUploads || capabilities.ReadOnly {
+				t.Fatalf("unexpected capabilities: %+v", capabilities)
+			}
+			if strings.Join(capabilities.Methods, ", ") != tc.allow {
+				t.Fatalf("unexpected capability methods: %v != %s", capabilities.Methods, tc.allow)
+			}
+		}
+
+		env.Shutdown()
+	}
+}
+
+// TestOptionsCapabilitiesAuthorization ensures that OPTIONS requests are
+// answered without authentication, but that the capabilities of the registry
+// are only described to clients allowed to read the route.
+func TestOptionsCapabilitiesAuthorization(t *testing.T) {
+	config := newTestConfig(true)
+	config.HTTP.Options.Capabilities = true
+	config.Auth = configuration.Auth{
+		"testactions": configuration.Parameters{},
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/options")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	checkErr(t, err, "building catalog url")
+
+	for _, tc := range []struct {
+		url     string
+		actions string
+		allow   string
+	}{
+		{manifestURL, "pull", "DELETE, GET, HEAD, OPTIONS, PUT"},
+		{catalogURL, "*", "GET, OPTIONS"},
+	} {
+		for _, actions := range []string{"", tc.actions} {
+			req, err := http.NewRequest("OPTIONS", tc.url, nil)
+			checkErr(t, err, "creating options request")
+			if actions != "" {
+				req.Header.Set("X-Test-Actions", actions)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			checkErr(t, err, "sending options request")
+
+			checkResponse(t, "options", resp, http.StatusOK)
+			checkHeaders(t, resp, http.Header{
+				"Allow": []string{tc.allow},
+			})
+
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			checkErr(t, err, "reading options response")
+
+			if actions == "" {
+				if len(body) != 0 {
+					t.Fatalf("unexpected capabilities for unauthorized request to %s: %s", tc.url, body)
+				}
+				if resp.Header.Get("WWW-Authenticate") != "" {
+					t.Fatalf("unexpected challenge for options request to %s", tc.url)
+				}
+				continue
+			}
+
+			var capabilities capabilitiesAPIResponse
+			if err := json.Unmarshal(body, &capabilities); err != nil {
+				t.Fatalf("error decoding capabilities: %v", err)
+			}
+			if !capabilities.Delete || strings.Join(capabilities.Methods, ", ") != tc.allow {
+				t.Fatalf("unexpected capabilities: %+v", capabilities)
+			}
+		}
+	}
+}
+
+// TestOptionsAllowedMethods ensures that the methods advertised for a route
+// follow the configuration of the registry.
+func TestOptionsAllowedMethods(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		app       App
+		routeName string
+		expected  string
+	}{
+		{"push", App{}, v2.RouteNameManifest, "GET, HEAD, OPTIONS, PUT"},
+		{"delete", App{deleteEnabled: true}, v2.RouteNameBlob, "DELETE, GET, HEAD, OPTIONS"},
+		{"delete disabled", App{}, v2.RouteNameBlob, "GET, HEAD, OPTIONS"},
+		{"read-only", App{readOnly: true, deleteEnabled: true}, v2.RouteNameManifest, "GET, HEAD, OPTIONS"},
+		{"proxy manifest", App{isCache: true, deleteEnabled: true}, v2.RouteNameManifest, "GET, HEAD, OPTIONS"},
+		{"proxy upload", App{isCache: true}, v2.RouteNameBlobUpload, "OPTIONS"},
+		{"proxy upload chunk", App{isCache: true}, v2.RouteNameBlobUploadChunk, "GET, HEAD, OPTIONS"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			methods := strings.Join(tc.app.allowedMethods(tc.routeName), ", ")
+			if methods != tc.expected {
+				t.Fatalf("unexpected methods: %s != %s", methods, tc.expected)
+			}
+		})
+	}
+}
+
+// TestManifestGetShortDigest ensures that manifests may be fetched by a unique
+// prefix of their digest, and that ambiguous prefixes are rejected.
+func TestManifestGetShortDigest(t *testing.T) {
+	config := newTestConfig(false)
+	config.Compatibility.ShortDigests.Enabled = true
+	config.Compatibility.ShortDigests.MinLength = 1
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/short")
+
+	// Push manifests until two share the first hex character of their
+	// digests, which takes at most 17.
+	var dgsts []digest.Digest
+	byFirst := make(map[byte]digest.Digest)
+	for i := 0; len(dgsts) == 0; i++ {
+		dgst := createRepository(env, t, imageName.Name(), fmt.Sprintf("tag%d", i))
+		if other, ok := byFirst[dgst.Hex()[0]]; ok && other != dgst {
+			dgsts = []digest.Digest{other, dgst}
+		}
+		byFirst[dgst.Hex()[0]] = dgst
+	}
+
+	getManifest := func(ref string) *http.Response {
+		resp, err := http.Get(env.server.URL + "/v2/" + imageName.Name() + "/manifests/" + ref)
+		checkErr(t, err, "fetching manifest")
+		return resp
+	}
+
+	for _, dgst := range dgsts {
+		prefix := dgst.Algorithm().String() + ":" + dgst.Hex()[:12]
+
+		resp := getManifest(prefix)
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest by digest prefix", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+	}
+
+	resp := getManifest("sha256:" + dgsts[0].Hex()[:1])
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by ambiguous digest prefix", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching manifest by ambiguous digest prefix", resp, v2.ErrorCodeManifestAmbiguous)
+
+	resp = getManifest("sha512:" + dgsts[0].Hex()[:12])
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by unknown digest prefix", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching manifest by unknown digest prefix", resp, v2.ErrorCodeManifestUnknown)
+}
+
+// TestManifestDigestLength ensures that digests of the wrong length are
+// rejected, whether naming a manifest or referenced by one, rather than being
+// taken for tags or digest prefixes.
+func TestManifestDigestLength(t *testing.T) {
+	for _, shortDigests := range []bool{false, true} {
+		config := newTestConfig(false)
+		config.Compatibility.ShortDigests.Enabled = shortDigests
+
+		env := newTestEnvWithConfig(t, &config)
+
+		imageName, _ := reference.WithName("foo/digestlength")
+		dgst := createRepository(env, t, imageName.Name(), "latest")
+
+		for _, ref := range []string{
+			dgst.String() + "0",
+			dgst.String() + dgst.Hex(),
+			dgst.String()[:len(dgst.String())-1],
+		} {
+			msg := fmt.Sprintf("fetching manifest %s with short digests %v", ref, shortDigests)
+			resp, err := http.Get(env.server.URL + "/v2/" + imageName.Name() + "/manifests/" + ref)
+			checkErr(t, err, msg)
+
+			if shortDigests && len(ref) < len(dgst.String()) {
+				// A truncated digest is a prefix, which is resolved.
+				checkResponse(t, msg, resp, http.StatusOK)
+			} else {
+				checkResponse(t, msg, resp, http.StatusBadRequest)
+				checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeDigestInvalid)
+			}
+			resp.Body.Close()
+		}
+
+		configDigest, _ := pushRandomBlob(t, env, imageName)
+		layerDigest, _ := pushRandomBlob(t, env, imageName)
+		m := &schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeImageConfig,
+				Size:      4096,
+				Digest:    configDigest,
+			},
+			Layers: []distribution.Descriptor{{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      4096,
+				Digest:    layerDigest[:len(layerDigest)-1],
+			}},
+		}
+
+		tagRef, _ := reference.WithTag(imageName, "truncated")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		msg := "putting manifest referencing a truncated digest"
+		resp := putManifest(t, msg, manifestURL, schema2.MediaTypeManifest, m)
+		checkResponse(t, msg, resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeDigestInvalid)
+		resp.Body.Close()
+
+		env.Shutdown()
+	}
+}
+
+func TestBlobUploadRepositoryQuota(t *testing.T) {
+	config := newTestConfig(false)
+	config.Quota = configuration.Quota{
+		Default: 10000,
+		Repositories: map[string]int64{
+			"foo/unlimited": 0,
+		},
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/quota")
+	first, p := pushRandomBlob(t, env, imageName)
+	pushRandomBlob(t, env, imageName)
+
+	// Pushing content already in the repository is not charged again.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, first, uploadURLBase, bytes.NewReader(p))
+
+	usage, err := storage.RepositoryUsage(env.ctx, env.app.driver, imageName.Name())
+	checkErr(t, err, "reading repository usage")
+	if usage != 8192 {
+		t.Fatalf("unexpected repository usage: %d != %d", usage, 8192)
+	}
+
+	p = make([]byte, 4096)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, digest.FromBytes(p), uploadURLBase, bytes.NewReader(p))
+	checkErr(t, err, "pushing layer over quota")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing layer over quota", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "pushing layer over quota", resp, v2.ErrorCodeQuotaExceeded)
+
+	unlimitedName, _ := reference.WithName("foo/unlimited")
+	for i := 0; i < 3; i++ {
+		pushRandomBlob(t, env, unlimitedName)
+	}
+}
+
+func TestBlobUploadRequireContentLength(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Uploads.RequireContentLength = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/contentlength")
+
+	p := make([]byte, 4096)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	// A body of unknown length is sent chunked, without a Content-Length.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, ioutil.NopCloser(bytes.NewReader(p)))
+	checkErr(t, err, "pushing layer without content length")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing layer without content length", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "pushing layer without content length", resp, v2.ErrorCodeSizeInvalid)
+
+	// A body shorter than the declared length is served in-process, since
+	// the http client refuses to send one.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	u, err := url.Parse(uploadURLBase)
+	checkErr(t, err, "parsing upload url")
+	u.RawQuery = url.Values{
+		"_state": u.Query()["_state"],
+		"digest": []string{dgst.String()},
+	}.Encode()
+
+	req := httptest.NewRequest("PUT", u.String(), bytes.NewReader(p))
+	req.ContentLength = int64(2 * len(p))
+	recorder := httptest.NewRecorder()
+	env.app.ServeHTTP(recorder, req)
+	resp = recorder.Result()
+	checkResponse(t, "pushing layer with mismatched content length", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "pushing layer with mismatched content length", resp, v2.ErrorCodeSizeInvalid)
+
+	// A request which is not chunked, but has no Content-Length, is also
+	// rejected, even when its data was sent in an earlier chunk.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(p), int64(len(p)))
+	u, err = url.Parse(uploadURLBase)
+	checkErr(t, err, "parsing upload url")
+	u.RawQuery = url.Values{
+		"_state": u.Query()["_state"],
+		"digest": []string{dgst.String()},
+	}.Encode()
+
+	req = httptest.NewRequest("PUT", u.String(), nil)
+	recorder = httptest.NewRecorder()
+	env.app.ServeHTTP(recorder, req)
+	resp = recorder.Result()
+	checkResponse(t, "completing upload without content length", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "completing upload without content length", resp, v2.ErrorCodeSizeInvalid)
+
+	// A correct length is accepted.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(p))
+	checkErr(t, err, "pushing layer with content length")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing layer with content length", resp, http.StatusCreated)
+}
+
+// TestBlobUploadMaxSize ensures that an upload exceeding the maximum upload
+// size across its chunks is canceled without committing any data.
+func TestBlobUploadMaxSize(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Uploads.MaxSize = 4096
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/maxsize")
+
+	p := make([]byte, 6144)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	// The first chunk fits within the limit, the second takes it over.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(p[:3072]), 3072)
+
+	resp, _, err := doPushChunk(t, uploadURLBase, bytes.NewReader(p[3072:]))
+	checkErr(t, err, "pushing chunk over the maximum size")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing chunk over the maximum size", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "pushing chunk over the maximum size", resp, v2.ErrorCodeSizeInvalid)
+
+	// The session has been aborted.
+	resp, err = http.Get(uploadURLBase)
+	checkErr(t, err, "getting status of aborted upload")
+	defer resp.Body.Close()
+	checkResponse(t, "getting status of aborted upload", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "getting status of aborted upload", resp, v2.ErrorCodeBlobUploadUnknown)
+
+	// A body of unknown length is cut off once it passes the limit.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, ioutil.NopCloser(bytes.NewReader(p)))
+	checkErr(t, err, "pushing layer over the maximum size")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing layer over the maximum size", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "pushing layer over the maximum size", resp, v2.ErrorCodeSizeInvalid)
+
+	// Nothing was committed.
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+	resp, err = http.Head(blobURL)
+	checkErr(t, err, "checking existence of oversized blob")
+	defer resp.Body.Close()
+	checkResponse(t, "checking existence of oversized blob", resp, http.StatusNotFound)
+
+	// A blob within the limit is accepted.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, digest.FromBytes(p[:4096]), uploadURLBase, bytes.NewReader(p[:4096]))
+}
+
+// TestManifestPutValidateArchives ensures that manifests may reference only
+// valid tar archives as layers when archive validation is enabled, whatever
+// the media type the layers were uploaded with.
+func TestManifestPutValidateArchives(t *testing.T) {
+	for _, validate := range []bool{false, true} {
+		config := newTestConfig(false)
+		config.Validation.Uploads.ValidateArchives = validate
+
+		env := newTestEnvWithConfig(t, &config)
+		defer env.Shutdown()
+
+		imageName, _ := reference.WithName("foo/archives")
+
+		push := func(p []byte) distribution.Descriptor {
+			dgst := digest.FromBytes(p)
+			uploadURLBase, _ := startPushLayer(t, env, imageName)
+			pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(p))
+			return distribution.Descriptor{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      int64(len(p)),
+				Digest:    dgst,
+			}
+		}
+
+		rs, _, err := testutil.CreateRandomTarFile()
+		checkErr(t, err, "creating random tar file")
+		tarFile, err := ioutil.ReadAll(rs)
+		checkErr(t, err, "reading random tar file")
+
+		var gzipped bytes.Buffer
+		gzw := gzip.NewWriter(&gzipped)
+		if _, err := gzw.Write(tarFile); err != nil {
+			t.Fatalf("error compressing tar file: %v", err)
+		}
+		checkErr(t, gzw.Close(), "compressing tar file")
+
+		garbage := make([]byte, 4096)
+		if _, err := rand.Read(garbage); err != nil {
+			t.Fatalf("error generating random blob: %v", err)
+		}
+
+		// Blobs which are not referenced as layers, such as the config, are
+		// never checked.
+		configDesc := push(garbage)
+		configDesc.MediaType = schema2.MediaTypeImageConfig
+
+		manifestWith := func(layers ...distribution.Descriptor) *schema2.Manifest {
+			return &schema2.Manifest{
+				Versioned: schema2.SchemaVersion,
+				Config:    configDesc,
+				Layers:    layers,
+			}
+		}
+
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		resp := putManifest(t, "putting manifest of tar layers", manifestURL, schema2.MediaTypeManifest, manifestWith(push(tarFile), push(gzipped.Bytes())))
+		checkResponse(t, "putting manifest of tar layers", resp, http.StatusCreated)
+		resp.Body.Close()
+
+		resp = putManifest(t, "putting manifest of random layer", manifestURL, schema2.MediaTypeManifest, manifestWith(push(garbage), push(tarFile)))
+		if validate {
+			checkResponse(t, "putting manifest of random layer", resp, http.StatusBadRequest)
+			checkBodyHasErrorCodes(t, "putting manifest of random layer", resp, v2.ErrorCodeManifestInvalid)
+		} else {
+			checkResponse(t, "putting manifest of random layer", resp, http.StatusCreated)
+		}
+		resp.Body.Close()
+	}
+}
+
+// TestStorageOperationLimit ensures that a request performing more storage
+// operations than the configured limit is rejected.
+func TestStorageOperationLimit(t *testing.T) {
+	config := newTestConfig(false)
+	config.Storage["operations"] = configuration.Parameters{"maxperrequest": 20}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/operations")
+
+	// Pushing each layer stays within the limit.
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+	}
+	m.Config.MediaType = schema2.MediaTypeImageConfig
+	m.Config.Digest, _ = pushRandomBlob(t, env, imageName)
+	m.Config.Size = 4096
+	for i := 0; i < 20; i++ {
+		dgst, _ := pushRandomBlob(t, env, imageName)
+		m.Layers = append(m.Layers, distribution.Descriptor{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    dgst,
+		})
+	}
+
+	// Verifying every layer of the manifest does not.
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, m)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "putting manifest", resp, errcode.ErrorCodeUnavailable)
+
+	// A manifest with fewer layers is accepted.
+	m.Layers = m.Layers[:1]
+	resp = putManifest(t, "putting small manifest", manifestURL, schema2.MediaTypeManifest, m)
+	defer resp.Body.Close()
+	checkResponse(t, "putting small manifest", resp, http.StatusCreated)
+}
+
+// TestManifestPutNonCanonicalDigest ensures that a manifest referencing a
+// blob by an uppercase digest is normalized, or rejected in strict mode.
+func TestManifestPutNonCanonicalDigest(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		config := newTestConfig(false)
+		config.Validation.Manifests.Digests.Strict = strict
+
+		env := newTestEnvWithConfig(t, &config)
+		defer env.Shutdown()
+
+		imageName, _ := reference.WithName("foo/digests")
+		configDigest, _ := pushRandomBlob(t, env, imageName)
+		layerDigest, _ := pushRandomBlob(t, env, imageName)
+		upperDigest := digest.NewDigestFromEncoded(layerDigest.Algorithm(), strings.ToUpper(layerDigest.Encoded()))
+
+		m := &schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeImageConfig,
+				Size:      4096,
+				Digest:    configDigest,
+			},
+			Layers: []distribution.Descriptor{
+				{
+					MediaType: schema2.MediaTypeLayer,
+					Size:      4096,
+					Digest:    upperDigest,
+				},
+			},
+		}
+
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		resp := putManifest(t, "putting manifest with uppercase digest", manifestURL, schema2.MediaTypeManifest, m)
+		defer resp.Body.Close()
+
+		if strict {
+			checkResponse(t, "putting manifest with uppercase digest", resp, http.StatusBadRequest)
+			checkBodyHasErrorCodes(t, "putting manifest with uppercase digest", resp, v2.ErrorCodeDigestInvalid)
+			continue
+		}
+
+		checkResponse(t, "putting manifest with uppercase digest", resp, http.StatusCreated)
+
+		// The reference resolves to the layer stored under its canonical
+		// digest.
+		ref, _ := reference.WithDigest(imageName, layerDigest)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+
+		resp, err = http.Head(blobURL)
+		checkErr(t, err, "heading blob by canonical digest")
+		resp.Body.Close()
+		checkResponse(t, "heading blob by canonical digest", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{layerDigest.String()},
+		})
+	}
+}
+
+func TestManifestGetPreservesRawBytes(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/raw")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	// Valid JSON with unusual whitespace and key ordering, which any
+	// re-encoding would change.
+	raw := []byte(fmt.Sprintf("{\n\t\"mediaType\" :  %q,\n\"schemaVersion\":2,\r\n"+
+		"  \"layers\": [ {\"size\":4096,\"digest\":%q,  \"mediaType\":%q} ],\n"+
+		"\"config\":{ \"digest\" : %q, \"size\": 4096, \"mediaType\": %q }  }\n\n",
+		schema2.MediaTypeManifest, layerDigest, schema2.MediaTypeLayer, configDigest, schema2.MediaTypeImageConfig))
+	dgst := digest.FromBytes(raw)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(raw))
+	checkErr(t, err, "creating manifest put request")
+	req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "putting manifest")
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	digestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	for _, u := range []string{manifestURL, digestURL} {
+		req, err := http.NewRequest("GET", u, nil)
+		checkErr(t, err, "creating manifest get request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		checkErr(t, err, "reading manifest")
+		if !bytes.Equal(p, raw) {
+			t.Fatalf("fetched manifest differs from pushed manifest:\n%q\n%q", p, raw)
+		}
+	}
+}
+
+func TestManifestPutCanonical(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.Canonical = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/canonical")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      4096,
+				Digest:    layerDigest,
+			},
+		},
+	}
+
+	indented, err := json.MarshalIndent(m, "", "   ")
+	checkErr(t, err, "marshaling manifest")
+	canonical, err := manifest.Canonicalize(indented)
+	checkErr(t, err, "canonicalizing manifest")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	for _, tc := range []struct {
+		payload  []byte
+		expected int
+	}{
+		{payload: indented, expected: http.StatusBadRequest},
+		{payload: canonical, expected: http.StatusCreated},
+	} {
+		req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(tc.payload))
+		checkErr(t, err, "creating manifest put request")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		checkResponse(t, "putting manifest", resp, tc.expected)
+
+		if tc.expected == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, "putting non-canonical manifest", resp, v2.ErrorCodeManifestInvalid)
+		} else {
+			checkHeaders(t, resp, http.Header{
+				"Docker-Content-Digest": []string{digest.FromBytes(canonical).String()},
+			})
+		}
+		resp.Body.Close()
+	}
+}
+
+// TestManifestPutPlatformAllowlist ensures that image manifests for platforms
+// which are not allowed are rejected.
+func TestManifestPutPlatformAllowlist(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.Platforms.Allow = []string{"linux/amd64"}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/platforms")
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	for _, tc := range []struct {
+		architecture string
+		expected     int
+	}{
+		{architecture: "amd64", expected: http.StatusCreated},
+		{architecture: "s390x", expected: http.StatusBadRequest},
+	} {
+		imageConfig := []byte(fmt.Sprintf(`{"architecture":%q,"os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`, tc.architecture))
+		configDigest := digest.FromBytes(imageConfig)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, configDigest, uploadURLBase, bytes.NewReader(imageConfig))
+
+		m := &schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeImageConfig,
+				Size:      int64(len(imageConfig)),
+				Digest:    configDigest,
+			},
+			Layers: []distribution.Descriptor{
+				{
+					MediaType: schema2.MediaTypeLayer,
+					Size:      4096,
+					Digest:    layerDigest,
+				},
+			},
+		}
+
+		tagRef, _ := reference.WithTag(imageName, tc.architecture)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		resp := putManifest(t, "putting "+tc.architecture+" manifest", manifestURL, schema2.MediaTypeManifest, m)
+		checkResponse(t, "putting "+tc.architecture+" manifest", resp, tc.expected)
+
+		if tc.expected == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, "putting "+tc.architecture+" manifest", resp, v2.ErrorCodeManifestInvalid)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestManifestDescriptorAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/descriptor")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      4096,
+				Digest:    layerDigest,
+			},
+		},
+	}
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, m)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	dgst := digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+
+	req, err := http.NewRequest("GET", manifestURL, nil)
+	checkErr(t, err, "creating manifest get request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	p, err := ioutil.ReadAll(resp.Body)
+	checkErr(t, err, "reading manifest")
+
+	expected := distribution.Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Size:      int64(len(p)),
+		Digest:    dgst,
+	}
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	for _, ref := range []reference.Named{tagRef, digestRef} {
+		descriptorURL, err := env.builder.BuildDescriptorURL(ref)
+		checkErr(t, err, "building descriptor url")
+
+		resp, err := http.Get(descriptorURL)
+		checkErr(t, err, "fetching descriptor")
+		checkResponse(t, "fetching descriptor", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type":          []string{"application/json"},
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+
+		var desc distribution.Descriptor
+		if err := json.NewDecoder(resp.Body).Decode(&desc); err != nil {
+			t.Fatalf("error decoding descriptor: %v", err)
+		}
+		resp.Body.Close()
+		if !reflect.DeepEqual(desc, expected) {
+			t.Fatalf("unexpected descriptor for %s: %#v != %#v", ref, desc, expected)
+		}
+	}
+
+	unknownRef, _ := reference.WithTag(imageName, "unknown")
+	descriptorURL, err := env.builder.BuildDescriptorURL(unknownRef)
+	checkErr(t, err, "building descriptor url")
+
+	resp, err = http.Get(descriptorURL)
+	checkErr(t, err, "fetching descriptor of unknown tag")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching descriptor of unknown tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching descriptor of unknown tag", resp, v2.ErrorCodeManifestUnknown)
+}
+
+// TestManifestConfigAPI ensures that the config blob of a manifest can be
+// fetched by the manifest's tag or digest.
+func TestManifestConfigAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/config")
+	configDigest, configBlob := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      int64(len(configBlob)),
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      4096,
+				Digest:    layerDigest,
+			},
+		},
+	}
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, m)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	dgst := digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	for _, ref := range []reference.Named{tagRef, digestRef} {
+		configURL, err := env.builder.BuildManifestConfigURL(ref)
+		checkErr(t, err, "building manifest config url")
+
+		resp, err := http.Get(configURL)
+		checkErr(t, err, "fetching manifest config")
+		checkResponse(t, "fetching manifest config", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{configDigest.String()},
+		})
+
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		checkErr(t, err, "reading manifest config")
+		if !bytes.Equal(p, configBlob) {
+			t.Fatalf("unexpected config blob for %s", ref)
+		}
+	}
+
+	unknownRef, _ := reference.WithTag(imageName, "unknown")
+	configURL, err := env.builder.BuildManifestConfigURL(unknownRef)
+	checkErr(t, err, "building manifest config url")
+
+	resp, err = http.Get(configURL)
+	checkErr(t, err, "fetching config of unknown tag")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching config of unknown tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching config of unknown tag", resp, v2.ErrorCodeManifestUnknown)
+
+	// Once the config blob is removed from storage, the manifest no longer
+	// resolves to it.
+	configPath := fmt.Sprintf("/docker/registry/v2/blobs/%s/%s/%s", configDigest.Algorithm(), configDigest.Hex()[:2], configDigest.Hex())
+	if err := env.app.driver.Delete(env.ctx, configPath); err != nil {
+		t.Fatalf("error removing config blob: %v", err)
+	}
+
+	configURL, err = env.builder.BuildManifestConfigURL(tagRef)
+	checkErr(t, err, "building manifest config url")
+
+	resp, err = http.Get(configURL)
+	checkErr(t, err, "fetching absent config")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching absent config", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching absent config", resp, v2.ErrorCodeBlobUnknown)
+}
+
+// TestBlobUploadCompletionResponses ensures that monolithic and chunked
+// uploads are completed with the same status and headers, distinct from the
+// response starting an upload session.
+func TestBlobUploadCompletionResponses(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/completion")
+
+	checkCompleted := func(msg string, resp *http.Response, dgst digest.Digest) {
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusCreated)
+
+		ref, _ := reference.WithDigest(imageName, dgst)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+
+		checkHeaders(t, resp, http.Header{
+			"Location":              []string{blobURL},
+			"Content-Length":        []string{"0"},
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+	}
+
+	randomBlob := func() (io.ReadSeeker, digest.Digest) {
+		rs, dgst, err := testutil.CreateRandomTarFile()
+		checkErr(t, err, "creating random layer")
+		return rs, digest.Digest(dgst)
+	}
+
+	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+
+	// Starting a session is accepted, rather than created.
+	resp, err := http.Post(layerUploadURL, "", nil)
+	checkErr(t, err, "starting layer upload")
+	defer resp.Body.Close()
+	checkResponse(t, "starting layer upload", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{
+		"Location":           []string{"*"},
+		"Range":              []string{"0-0"},
+		"Docker-Upload-UUID": []string{"*"},
+	})
+
+	// Monolithic upload, in a single POST.
+	rs, dgst := randomBlob()
+	u, err := url.Parse(layerUploadURL)
+	checkErr(t, err, "parsing upload url")
+	u.RawQuery = url.Values{"digest": []string{dgst.String()}}.Encode()
+	resp, err = http.Post(u.String(), "application/octet-stream", rs)
+	checkErr(t, err, "posting monolithic upload")
+	checkCompleted("posting monolithic upload", resp, dgst)
+
+	// Monolithic upload, in a single PUT following the POST.
+	rs, dgst = randomBlob()
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, rs)
+	checkErr(t, err, "putting monolithic upload")
+	checkCompleted("putting monolithic upload", resp, dgst)
+
+	// Chunked upload, completed by an empty PUT.
+	rs, dgst = randomBlob()
+	length, err := rs.Seek(0, io.SeekEnd)
+	checkErr(t, err, "seeking layer")
+	_, err = rs.Seek(0, io.SeekStart)
+	checkErr(t, err, "seeking layer")
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, rs, length)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, nil)
+	checkErr(t, err, "completing chunked upload")
+	checkCompleted("completing chunked upload", resp, dgst)
+}
+
+// TestTagAlias ensures that fetching a manifest through an alias returns the
+// manifest its target currently resolves to.
+func TestTagAlias(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/aliases")
+	first := createRepository(env, t, imageName.Name(), "v2.3")
+
+	putAlias := func(msg, alias, target string) *http.Response {
+		tagRef, _ := reference.WithTag(imageName, alias)
+		aliasURL, err := env.builder.BuildTagAliasURL(tagRef)
+		checkErr(t, err, "building alias url")
+
+		req, err := http.NewRequest("PUT", aliasURL, strings.NewReader(fmt.Sprintf(`{"target": %q}`, target)))
+		checkErr(t, err, "building alias request")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	checkResolves := func(msg, tag string, expected digest.Digest) {
+		tagRef, _ := reference.WithTag(imageName, tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		resp, err := http.Get(manifestURL)
+		checkErr(t, err, msg)
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{expected.String()},
+		})
+
+		p, err := ioutil.ReadAll(resp.Body)
+		checkErr(t, err, "reading manifest")
+		var fetched schema1.SignedManifest
+		checkErr(t, json.Unmarshal(p, &fetched), "decoding manifest")
+		if fetched.Tag != "v2.3" {
+			t.Fatalf("%s: unexpected manifest tag: %q", msg, fetched.Tag)
+		}
+	}
+
+	resp := putAlias("creating alias", "stable", "v2.3")
+	defer resp.Body.Close()
+	checkResponse(t, "creating alias", resp, http.StatusCreated)
+
+	checkResolves("fetching through alias", "stable", first)
+
+	stableRef, _ := reference.WithTag(imageName, "stable")
+	aliasURL, err := env.builder.BuildTagAliasURL(stableRef)
+	checkErr(t, err, "building alias url")
+	resp, err = http.Get(aliasURL)
+	checkErr(t, err, "fetching alias")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching alias", resp, http.StatusOK)
+	var alias tagAliasAPIResponse
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&alias), "decoding alias")
+	if alias.Tag != "stable" || alias.Target != "v2.3" {
+		t.Fatalf("unexpected alias: %#v", alias)
+	}
+
+	// Repointing the target is followed by the alias.
+	second := createRepository(env, t, imageName.Name(), "v2.3")
+	if second == first {
+		t.Fatal("expected a new manifest for the target")
+	}
+	checkResolves("fetching through repointed alias", "stable", second)
+
+	// Aliases which would form a cycle are rejected.
+	resp = putAlias("creating alias cycle", "v2.3", "stable")
+	defer resp.Body.Close()
+	checkResponse(t, "creating alias cycle", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "creating alias cycle", resp, v2.ErrorCodeTagInvalid)
+
+	resp = putAlias("aliasing unknown tag", "beta", "missing")
+	defer resp.Body.Close()
+	checkResponse(t, "aliasing unknown tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "aliasing unknown tag", resp, v2.ErrorCodeManifestUnknown)
+
+	resp, err = httpDelete(aliasURL)
+	checkErr(t, err, "removing alias")
+	defer resp.Body.Close()
+	checkResponse(t, "removing alias", resp, http.StatusAccepted)
+
+	stableManifestURL, err := env.builder.BuildManifestURL(stableRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Get(stableManifestURL)
+	checkErr(t, err, "fetching through removed alias")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching through removed alias", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching through removed alias", resp, v2.ErrorCodeManifestUnknown)
+}
+
+// TestManifestDeleteTagAliased ensures that deleting a manifest whose tag is
+// the target of aliases is rejected under the strict policy before the
+// manifest is deleted.
+func TestManifestDeleteTagAliased(t *testing.T) {
+	config := newTestConfig(true)
+	config.Storage["tagaliases"] = configuration.Parameters{"ondelete": "strict"}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/aliaseddelete")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	stableRef, _ := reference.WithTag(imageName, "stable")
+	aliasURL, err := env.builder.BuildTagAliasURL(stableRef)
+	checkErr(t, err, "building alias url")
+	req, err := http.NewRequest("PUT", aliasURL, strings.NewReader(`{"target": "latest"}`))
+	checkErr(t, err, "building alias request")
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "creating alias")
+	defer resp.Body.Close()
+	checkResponse(t, "creating alias", resp, http.StatusCreated)
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestDigestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	resp, err = httpDelete(manifestDigestURL)
+	checkErr(t, err, "deleting aliased manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting aliased manifest", resp, http.StatusPreconditionFailed)
+	checkBodyHasErrorCodes(t, "deleting aliased manifest", resp, v2.ErrorCodeTagAliased)
+
+	// The rejected delete leaves the manifest in place.
+	resp, err = http.Get(manifestDigestURL)
+	checkErr(t, err, "fetching manifest after rejected delete")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest after rejected delete", resp, http.StatusOK)
+
+	stableManifestURL, err := env.builder.BuildManifestURL(stableRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Get(stableManifestURL)
+	checkErr(t, err, "fetching through alias after rejected delete")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching through alias after rejected delete", resp, http.StatusOK)
+}
+
+// actionsAccessController grants only the actions listed, comma separated,
+// in the X-Test-Actions header of the request.
+type actionsAccessController struct{}
+
+type actionsChallenge []auth.Access
+
+func (c actionsChallenge) Error() string {
+	return fmt.Sprintf("access denied: %v", []auth.Access(c))
+}
+
+func (c actionsChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="test"`)
+}
+
+func (ac *actionsAccessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	r, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	granted := strings.Split(r.Header.Get("X-Test-Actions"), ",")
+	var denied actionsChallenge
+	for _, access := range accessRecords {
+		found := false
+		for _, action := range granted {
+			found = found || action == access.Action
+		}
+		if !found {
+			denied = append(denied, access)
+		}
+	}
+	if len(denied) > 0 {
+		return nil, denied
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: "ci"}), nil
+}
+
+func init() {
+	auth.Register("testactions", func(options map[string]interface{}) (auth.AccessController, error) {
+		return &actionsAccessController{}, nil
+	})
+}
+
+// TestBlobExistsScope ensures that, with the exists scope enabled, checking
+// whether a blob exists only requires the exists action, which does not
+// permit fetching the blob.
+func TestBlobExistsScope(t *testing.T) {
+	config := newTestConfig(false)
+	config.Auth = configuration.Auth{
+		"testactions": configuration.Parameters{},
+	}
+	config.Policy.Repository.ExistsScope = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/exists")
+	p := make([]byte, 1024)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	// Push the blob with full access.
+	repository, err := env.app.registry.Repository(env.ctx, imageName)
+	checkErr(t, err, "getting repository")
+	_, err = repository.Blobs(env.ctx).Put(env.ctx, "application/octet-stream", p)
+	checkErr(t, err, "putting blob")
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	do := func(method, actions string) *http.Response {
+		req, err := http.NewRequest(method, blobURL, nil)
+		checkErr(t, err, "creating request")
+		req.Header.Set("X-Test-Actions", actions)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching blob")
+		return resp
+	}
+
+	resp := do("HEAD", "exists")
+	defer resp.Body.Close()
+	checkResponse(t, "checking blob with exists scope", resp, http.StatusOK)
+
+	resp = do("GET", "exists")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob with exists scope", resp, http.StatusUnauthorized)
+
+	resp = do("GET", "pull")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob with pull scope", resp, http.StatusOK)
+}
+
+// TestBlobUploadChunkSize ensures that the configured chunk sizes are
+// advertised when an upload is started, with the maximum bounded by the
+// maximum upload size.
+func TestBlobUploadChunkSize(t *testing.T) {
+	for _, tc := range []struct {
+		maxSize  int64
+		expected http.Header
+	}{
+		{
+			expected: http.Header{
+				"Docker-Upload-Chunk-Min-Length":         []string{"1024"},
+				"Docker-Upload-Chunk-Max-Length":         []string{"1048576"},
+				"Docker-Upload-Chunk-Recommended-Length": []string{"65536"},
+			},
+		},
+		{
+			maxSize: 524288,
+			expected: http.Header{
+				"Docker-Upload-Chunk-Min-Length":         []string{"1024"},
+				"Docker-Upload-Chunk-Max-Length":         []string{"524288"},
+				"Docker-Upload-Chunk-Recommended-Length": []string{"65536"},
+			},
+		},
+	} {
+		config := newTestConfig(false)
+		config.Validation.Uploads.MaxSize = tc.maxSize
+		config.Validation.Uploads.ChunkSize.Min = 1024
+		config.Validation.Uploads.ChunkSize.Max = 1048576
+		config.Validation.Uploads.ChunkSize.Recommended = 65536
+
+		env := newTestEnvWithConfig(t, &config)
+		defer env.Shutdown()
+
+		imageName, _ := reference.WithName("foo/chunksize")
+		layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+		checkErr(t, err, "building upload url")
+
+		resp, err := http.Post(layerUploadURL, "", nil)
+		checkErr(t, err, "starting upload")
+		defer resp.Body.Close()
+		checkResponse(t, "starting upload", resp, http.StatusAccepted)
+		checkHeaders(t, resp, tc.expected)
+	}
+
+	// Nothing is advertised unless configured.
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/chunksize")
+	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+
+	resp, err := http.Post(layerUploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload", resp, http.StatusAccepted)
+	for _, name := range []string{"Docker-Upload-Chunk-Min-Length", "Docker-Upload-Chunk-Max-Length", "Docker-Upload-Chunk-Recommended-Length"} {
+		if v := resp.Header.Get(name); v != "" {
+			t.Fatalf("unexpected %s header: %q", name, v)
+		}
+	}
+}
+
+// TestBlobUploadFingerprint ensures that the upload status reports a
+// fingerprint of the content stored for an upload, which changes if the
+// stored content no longer matches the content sent.
+func TestBlobUploadFingerprint(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/fingerprint")
+
+	p := make([]byte, 2048)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+
+	uploadURLBase, uuid := startPushLayer(t, env, imageName)
+
+	fingerprint := func(msg string) digest.Digest {
+		resp, err := http.Get(uploadURLBase)
+		checkErr(t, err, msg)
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusNoContent)
+
+		dgst, err := digest.Parse(resp.Header.Get("Docker-Upload-Fingerprint"))
+		if err != nil {
+			t.Fatalf("%s: invalid fingerprint: %v", msg, err)
+		}
+		return dgst
+	}
+
+	if dgst := fingerprint("getting status of empty upload"); dgst != digest.FromBytes(nil) {
+		t.Fatalf("unexpected fingerprint of empty upload: %s", dgst)
+	}
+
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(p[:1024]), 1024)
+
+	sent := digest.FromBytes(p[:1024])
+	if dgst := fingerprint("getting status of upload"); dgst != sent {
+		t.Fatalf("unexpected fingerprint of upload: %s != %s", dgst, sent)
+	}
+
+	// Replace the stored content with different content of the same length.
+	var dataPath string
+	err := storagedriver.WalkFallback(env.ctx, env.app.driver, "/", func(fi storagedriver.FileInfo) error {
+		if strings.HasSuffix(fi.Path(), "/_uploads/"+uuid+"/data") {
+			dataPath = fi.Path()
+		}
+		return nil
+	})
+	checkErr(t, err, "walking storage")
+	if dataPath == "" {
+		t.Fatalf("no data found for upload %s", uuid)
+	}
+
+	mutated := append([]byte(nil), p[:1024]...)
+	mutated[0] ^= 0xff
+	checkErr(t, env.app.driver.PutContent(env.ctx, dataPath, mutated), "replacing upload data")
+
+	if dgst := fingerprint("getting status of altered upload"); dgst == sent {
+		t.Fatalf("fingerprint unchanged after upload content was altered: %s", dgst)
+	} else if dgst != digest.FromBytes(mutated) {
+		t.Fatalf("unexpected fingerprint of altered upload: %s != %s", dgst, digest.FromBytes(mutated))
+	}
+}
+
+// TestManifestPullAuditLog ensures that, when enabled, a manifest pull is
+// recorded in the audit log with the digests of the content the manifest
+// references.
+func TestManifestPullAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-")
+	checkErr(t, err, "creating audit log directory")
+	defer os.RemoveAll(dir)
+	auditPath := path.Join(dir, "audit.log")
+
+	config := newTestConfig(false)
+	config.Auth = configuration.Auth{
+		"testprincipal": configuration.Parameters{"name": "alice"},
+	}
+	config.Log.Audit.Path = auditPath
+	config.Log.Audit.Pulls = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/pullaudited")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	checkErr(t, err, "reading manifest")
+	fetched, _, err := distribution.UnmarshalManifest(resp.Header.Get("Content-Type"), body)
+	checkErr(t, err, "decoding fetched manifest")
+	var references []digest.Digest
+	for _, desc := range fetched.References() {
+		references = append(references, desc.Digest)
+	}
+	if len(references) == 0 {
+		t.Fatalf("expected the manifest to reference layers")
+	}
+
+	// The pull is recorded in the background.
+	var p []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		p, err = ioutil.ReadFile(auditPath)
+		checkErr(t, err, "reading audit log")
+		if len(p) > 0 {
+			break
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected a single audit entry, got %d: %s", len(lines), p)
+	}
+
+	var entry auditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("error decoding audit entry: %v", err)
+	}
+	entry.Time = time.Time{}
+
+	expected := auditEntry{
+		Action:     "pull manifest",
+		Principal:  "alice",
+		Repository: imageName.Name(),
+		Digest:     dgst,
+		References: references,
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Fatalf("unexpected audit entry: %+v != %+v", entry, expected)
+	}
+}
+
+// TestManifestDeleteAuditLog ensures that a manifest delete is recorded in
+// the audit log, with the principal who performed it, before it is
+// acknowledged.
+func TestManifestDeleteAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-")
+	checkErr(t, err, "creating audit log directory")
+	defer os.RemoveAll(dir)
+	auditPath := path.Join(dir, "audit.log")
+
+	config := newTestConfig(true)
+	config.Auth = configuration.Auth{
+		"testprincipal": configuration.Parameters{"name": "alice"},
+	}
+	config.Log.Audit.Path = auditPath
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/audited")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+
+	before := time.Now().UTC().Add(-time.Second)
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	p, err := ioutil.ReadFile(auditPath)
+	checkErr(t, err, "reading audit log")
+
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected a single audit entry, got %d: %s", len(lines), p)
+	}
+
+	var entry auditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("error decoding audit entry: %v", err)
+	}
+
+	if entry.Time.Before(before) || entry.Time.After(time.Now().UTC()) {
+		t.Fatalf("unexpected audit entry time: %v", entry.Time)
+	}
+	entry.Time = time.Time{}
+
+	expected := auditEntry{
+		Action:     "delete manifest",
+		Principal:  "alice",
+		Repository: imageName.Name(),
+		Digest:     dgst,
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Fatalf("unexpected audit entry: %+v != %+v", entry, expected)
+	}
+}
+
+// TestManifestDeleteAuditLogFailure ensures that a manifest is not deleted if
+// its delete cannot be recorded in the audit log.
+func TestManifestDeleteAuditLogFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-")
+	checkErr(t, err, "creating audit log directory")
+	defer os.RemoveAll(dir)
+
+	config := newTestConfig(true)
+	config.Log.Audit.Path = path.Join(dir, "audit.log")
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/audited")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+
+	// Writes to the closed log fail.
+	env.app.auditLog.file.Close()
+
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusInternalServerError)
+
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching manifest after failed delete", resp, http.StatusOK)
+}
+
+// TestBlobUploadStrictChunkOrder ensures that chunks must continue an upload
+// from its current length when strict chunk ordering is enabled, and that
+// rejected chunks report the offset expected.
+func TestBlobUploadStrictChunkOrder(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Uploads.StrictChunkOrder = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/chunkorder")
+
+	p := make([]byte, 4096)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	pushRange := func(uploadURLBase string, start, end int) *http.Response {
+		u, err := url.Parse(uploadURLBase)
+		checkErr(t, err, "parsing upload url")
+		u.RawQuery = url.Values{
+			"_state": u.Query()["_state"],
+		}.Encode()
+
+		req, err := http.NewRequest("PATCH", u.String(), bytes.NewReader(p[start:end+1]))
+		checkErr(t, err, "creating chunk request")
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", start, end))
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "pushing chunk")
+		return resp
+	}
+
+	checkRejected := func(msg string, resp *http.Response, expected string) {
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusNotFound)
+		_, body, _ := checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeBlobUploadInvalid)
+		if !bytes.Contains(body, []byte(expected)) {
+			t.Fatalf("expected %s to report %q: %s", msg, expected, body)
+		}
+	}
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+
+	resp := pushRange(uploadURLBase, 0, 1023)
+	defer resp.Body.Close()
+	checkResponse(t, "pushing first chunk", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{"Range": []string{"0-1023"}})
+	uploadURLBase = resp.Header.Get("Location")
+
+	checkRejected("pushing gapped chunk", pushRange(uploadURLBase, 2048, 3071), "expected offset 1024")
+	checkRejected("pushing overlapping chunk", pushRange(uploadURLBase, 512, 2047), "expected offset 1024")
+
+	// The rejected chunks were discarded, so the upload continues in order.
+	for _, r := range [][2]int{{1024, 2047}, {2048, 4095}} {
+		resp := pushRange(uploadURLBase, r[0], r[1])
+		resp.Body.Close()
+		checkResponse(t, "pushing chunk in order", resp, http.StatusAccepted)
+		checkHeaders(t, resp, http.Header{"Range": []string{fmt.Sprintf("0-%d", r[1])}})
+		uploadURLBase = resp.Header.Get("Location")
+	}
+
+	finishUpload(t, env.builder, imageName, uploadURLBase, dgst)
+}
+
+// TestBlobUploadResumablePut ensures that a PUT carrying a Content-Range
+// appends to the data already received, leaving the upload open until the
+// whole blob is received, and that the range must continue the upload.
+func TestBlobUploadResumablePut(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Uploads.ResumablePut = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/resumableput")
+
+	p := make([]byte, 4096)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	putRange := func(uploadURLBase string, start, end int) *http.Response {
+		u, err := url.Parse(uploadURLBase)
+		checkErr(t, err, "parsing upload url")
+		u.RawQuery = url.Values{
+			"_state": u.Query()["_state"],
+			"digest": []string{dgst.String()},
+		}.Encode()
+
+		req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(p[start:end+1]))
+		checkErr(t, err, "creating put request")
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(p)))
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting range")
+		return resp
+	}
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+
+	resp := putRange(uploadURLBase, 0, 2047)
+	resp.Body.Close()
+	checkResponse(t, "putting first half", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{"Range": []string{"0-2047"}})
+	uploadURLBase = resp.Header.Get("Location")
+
+	resp = putRange(uploadURLBase, 1024, 4095)
+	checkResponse(t, "putting overlapping range", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "putting overlapping range", resp, v2.ErrorCodeBlobUploadInvalid)
+	resp.Body.Close()
+
+	resp = putRange(uploadURLBase, 2048, 4095)
+	resp.Body.Close()
+	checkResponse(t, "putting remainder", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	checkErr(t, err, "reading blob")
+	if !bytes.Equal(body, p) {
+		t.Fatalf("blob assembled from ranges does not match the data pushed")
+	}
+}
+
+// TestBlobUploadBindPrincipal ensures that an upload may only be continued,
+// completed or canceled by the principal who started it, however the access
+// controller carries the principal.
+func TestBlobUploadBindPrincipal(t *testing.T) {
+	for _, userKey := range []bool{false, true} {
+		func() {
+			config := newTestConfig(false)
+			config.Validation.Uploads.BindPrincipal = true
+			config.Auth = configuration.Auth{
+				"testprincipal": configuration.Parameters{"name": "alice", "userkey": userKey},
+			}
+
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			imageName, _ := reference.WithName("foo/bindprincipal")
+
+			p := make([]byte, 4096)
+			if _, err := rand.Read(p); err != nil {
+				t.Fatalf("error generating random blob: %v", err)
+			}
+			dgst := digest.FromBytes(p)
+
+			uploadURLBase, _ := startPushLayer(t, env, imageName)
+
+			u, err := url.Parse(uploadURLBase)
+			checkErr(t, err, "parsing upload url")
+			u.RawQuery = url.Values{
+				"_state": u.Query()["_state"],
+				"digest": []string{dgst.String()},
+			}.Encode()
+			finishURL := u.String()
+
+			for _, method := range []string{"PATCH", "PUT", "DELETE"} {
+				target := uploadURLBase
+				if method == "PUT" {
+					target = finishURL
+				}
+				req, err := http.NewRequest(method, target, bytes.NewReader(p))
+				checkErr(t, err, "creating request")
+				req.Header.Set("X-Test-Principal", "mallory")
+
+				resp, err := http.DefaultClient.Do(req)
+				checkErr(t, err, "sending request as another principal")
+				checkResponse(t, method+" as another principal", resp, http.StatusForbidden)
+				checkBodyHasErrorCodes(t, method+" as another principal", resp, errcode.ErrorCodeDenied)
+				resp.Body.Close()
+			}
+
+			resp, err := doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(p))
+			checkErr(t, err, "completing upload as its principal")
+			defer resp.Body.Close()
+			checkResponse(t, "completing upload as its principal", resp, http.StatusCreated)
+		}()
+	}
+}
+
+// TestDefaultManifest ensures that fetching a manifest without a reference
+// serves the manifest of the repository's configured default.
+func TestDefaultManifest(t *testing.T) {
+	config := newTestConfig(false)
+	config.Compatibility.DefaultTags = map[string]string{
+		"foo/defaulted": "stable",
+		"foo/dangling":  "missing",
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/defaulted", "stable")
+	createRepository(env, t, "foo/dangling", "stable")
+	createRepository(env, t, "foo/undefaulted", "stable")
+
+	defaulted, _ := reference.WithName("foo/defaulted")
+	defaultURL, err := env.builder.BuildDefaultManifestURL(defaulted)
+	checkErr(t, err, "building default manifest url")
+
+	resp, err := http.Get(defaultURL)
+	checkErr(t, err, "fetching default manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching default manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	// A repository without a default, or whose default does not resolve,
+	// has no default manifest.
+	for _, name := range []string{"foo/dangling", "foo/undefaulted"} {
+		named, _ := reference.WithName(name)
+		defaultURL, err := env.builder.BuildDefaultManifestURL(named)
+		checkErr(t, err, "building default manifest url")
+
+		resp, err := http.Get(defaultURL)
+		checkErr(t, err, "fetching default manifest")
+		checkResponse(t, "fetching default manifest of "+name, resp, http.StatusNotFound)
+		checkBodyHasErrorCodes(t, "fetching default manifest of "+name, resp, v2.ErrorCodeManifestUnknown)
+		resp.Body.Close()
+	}
+}
+
+// TestManifestMediaTypeAllowlist ensures that only manifests of the allowed
+// media types may be pushed, and that the allowed media types are advertised.
+func TestManifestMediaTypeAllowlist(t *testing.T) {
+	config := newTestConfig(false)
+	config.HTTP.Options.Capabilities = true
+	config.Validation.Manifests.MediaTypes.Allow = []string{v1.MediaTypeImageManifest}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/mediatypes")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	schema2Manifest := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+	resp := putManifest(t, "putting schema2 manifest", manifestURL, schema2.MediaTypeManifest, schema2Manifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting schema2 manifest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting schema2 manifest", resp, v2.ErrorCodeManifestInvalid)
+
+	ociManifest := &ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: v1.MediaTypeImageLayerGzip,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+	resp = putManifest(t, "putting OCI manifest", manifestURL, v1.MediaTypeImageManifest, ociManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting OCI manifest", resp, http.StatusCreated)
+
+	req, err := http.NewRequest("OPTIONS", manifestURL, nil)
+	checkErr(t, err, "creating options request")
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "sending options request")
+	defer resp.Body.Close()
+	checkResponse(t, "options", resp, http.StatusOK)
+
+	var capabilities capabilitiesAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+		t.Fatalf("error decoding capabilities: %v", err)
+	}
+	if !reflect.DeepEqual(capabilities.ManifestMediaTypes, []string{v1.MediaTypeImageManifest}) {
+		t.Fatalf("unexpected manifest media types: %v", capabilities.ManifestMediaTypes)
+	}
+}
+
+// TestCapabilities ensures that the capabilities document reflects the
+// features enabled by the configuration.
+func TestCapabilities(t *testing.T) {
+	allSchemas := []string{
+		manifestlist.MediaTypeManifestList,
+		schema1.MediaTypeSignedManifest,
+		schema2.MediaTypeManifest,
+		v1.MediaTypeImageIndex,
+		v1.MediaTypeImageManifest,
+	}
+
+	for _, tc := range []struct {
+		name      string
+		configure func(config *configuration.Configuration)
+		features  capabilitiesFeatures
+		schemas   []string
+	}{
+		{
+			name:      "default",
+			configure: func(config *configuration.Configuration) {},
+			features: capabilitiesFeatures{
+				Push:                 true,
+				ChunkedUploads:       true,
+				CrossRepositoryMount: true,
+				Catalog:              true,
+				CatalogPagination:    true,
+			},
+			schemas: allSchemas,
+		},
+		{
+			name: "delete without schema1",
+			configure: func(config *configuration.Configuration) {
+				config.Storage["delete"] = configuration.Parameters{"enabled": true}
+				config.Compatibility.Schema1.Enabled = false
+			},
+			features: capabilitiesFeatures{
+				Push:                 true,
+				Delete:               true,
+				ChunkedUploads:       true,
+				CrossRepositoryMount: true,
+				Catalog:              true,
+				CatalogPagination:    true,
+			},
+			schemas: []string{
+				manifestlist.MediaTypeManifestList,
+				schema2.MediaTypeManifest,
+				v1.MediaTypeImageIndex,
+				v1.MediaTypeImageManifest,
+			},
+		},
+		{
+			name: "allowed media types",
+			configure: func(config *configuration.Configuration) {
+				config.Validation.Manifests.MediaTypes.Allow = []string{v1.MediaTypeImageManifest, v1.MediaTypeImageIndex}
+			},
+			features: capabilitiesFeatures{
+				Push:                 true,
+				ChunkedUploads:       true,
+				CrossRepositoryMount: true,
+				Catalog:              true,
+				CatalogPagination:    true,
+			},
+			schemas: []string{v1.MediaTypeImageIndex, v1.MediaTypeImageManifest},
+		},
+		{
+			name: "read-only",
+			configure: func(config *configuration.Configuration) {
+				config.Storage["delete"] = configuration.Parameters{"enabled": true}
+				config.Storage["maintenance"]["readonly"] = map[interface{}]interface{}{"enabled": true}
+			},
+			features: capabilitiesFeatures{
+				Catalog:           true,
+				CatalogPagination: true,
+			},
+			schemas: []string{},
+		},
+	} {
+		config := newTestConfig(false)
+		tc.configure(&config)
+
+		env := newTestEnvWithConfig(t, &config)
+
+		capabilitiesURL, err := env.builder.BuildCapabilitiesURL()
+		checkErr(t, err, "building capabilities url")
+
+		resp, err := http.Get(capabilitiesURL)
+		checkErr(t, err, "fetching capabilities")
+		checkResponse(t, "fetching capabilities", resp, http.StatusOK)
+
+		var capabilities capabilitiesDocument
+		if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+			t.Fatalf("%s: error decoding capabilities: %v", tc.name, err)
+		}
+		resp.Body.Close()
+
+		if capabilities.Version != 1 {
+			t.Fatalf("%s: unexpected version: %d", tc.name, capabilities.Version)
+		}
+		if capabilities.Features != tc.features {
+			t.Fatalf("%s: unexpected features: %+v != %+v", tc.name, capabilities.Features, tc.features)
+		}
+		if !reflect.DeepEqual(capabilities.ManifestSchemas, tc.schemas) {
+			t.Fatalf("%s: unexpected manifest schemas: %v != %v", tc.name, capabilities.ManifestSchemas, tc.schemas)
+		}
+
+		env.Shutdown()
+	}
+}
+
+// TestCatalogUsage ensures that the usage of the registry is served from the
+// blob index once it has been built, and is unavailable until then.
+func TestCatalogUsage(t *testing.T) {
+	config := newTestConfig(false)
+	config.Storage["blobindex"] = configuration.Parameters{"enabled": true}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/usage")
+	var size int64
+	for i := 0; i < 3; i++ {
+		_, content := pushRandomBlob(t, env, imageName)
+		size += int64(len(content))
+	}
+
+	usageURL, err := env.builder.BuildCatalogUsageURL()
+	checkErr(t, err, "building usage url")
+
+	resp, err := http.Get(usageURL)
+	checkErr(t, err, "fetching usage of unbuilt index")
+	checkResponse(t, "fetching usage of unbuilt index", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "fetching usage of unbuilt index", resp, errcode.ErrorCodeUnavailable)
+	resp.Body.Close()
+
+	if err := storage.NewBlobIndex(env.app.driver).Rebuild(env.ctx); err != nil {
+		t.Fatalf("error rebuilding blob index: %v", err)
+	}
+
+	resp, err = http.Get(usageURL)
+	checkErr(t, err, "fetching usage")
+	checkResponse(t, "fetching usage", resp, http.StatusOK)
+
+	var usage storage.BlobUsage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		t.Fatalf("error decoding usage: %v", err)
+	}
+	resp.Body.Close()
+
+	if usage.Blobs != 3 || usage.Bytes != size {
+		t.Fatalf("unexpected usage: %+v != {Blobs:3 Bytes:%d}", usage, size)
+	}
+
+	// Without the blob index, usage is unavailable.
+	env = newTestEnv(t, false)
+	defer env.Shutdown()
+
+	usageURL, err = env.builder.BuildCatalogUsageURL()
+	checkErr(t, err, "building usage url")
+
+	resp, err = http.Get(usageURL)
+	checkErr(t, err, "fetching usage without index")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching usage without index", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "fetching usage without index", resp, errcode.ErrorCodeUnavailable)
+}
+
+// TestManifestPutDigestLikeTag ensures that manifests cannot be pushed to
+// tags resembling digests when this is configured, and that references to
+// digests are still resolved as digests.
+func TestManifestPutDigestLikeTag(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.Tags.RejectDigestLike = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/digestliketags")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	latestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	manifestsURL := strings.TrimSuffix(latestURL, "latest")
+
+	var dgst digest.Digest
+	for _, tc := range []struct {
+		tag      string
+		expected int
+	}{
+		{tag: "latest", expected: http.StatusCreated},
+		{tag: "sha256-fixes", expected: http.StatusCreated},
+		{tag: "sha256abc", expected: http.StatusBadRequest},
+		{tag: "sha256-" + layerDigest.Hex(), expected: http.StatusBadRequest},
+		{tag: "SHA512_ABC123", expected: http.StatusBadRequest},
+		{tag: layerDigest.Hex(), expected: http.StatusBadRequest},
+	} {
+		msg := fmt.Sprintf("putting manifest to tag %q", tc.tag)
+		resp := putManifest(t, msg, manifestsURL+url.PathEscape(tc.tag), schema2.MediaTypeManifest, m)
+		checkResponse(t, msg, resp, tc.expected)
+		if tc.expected == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeTagInvalid)
+		} else {
+			dgst = digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+		}
+		resp.Body.Close()
+	}
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	digestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	req, _ := http.NewRequest("GET", digestURL, nil)
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest by digest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by digest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+}
+
+// TestRepositoryNameMaxComponents ensures that requests for repositories
+// whose names have more path components than configured are rejected.
+func TestRepositoryNameMaxComponents(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Names.MaxComponents = 3
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, tc := range []struct {
+		name     string
+		expected int
+	}{
+		{name: "foo/bar", expected: http.StatusAccepted},
+		{name: "foo/bar/baz", expected: http.StatusAccepted},
+		{name: "foo/bar/baz/qux", expected: http.StatusBadRequest},
+		{name: "a/b/c/d/e", expected: http.StatusBadRequest},
+	} {
+		imageName, _ := reference.WithName(tc.name)
+		uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+		checkErr(t, err, "building upload url")
+
+		msg := fmt.Sprintf("starting upload to %q", tc.name)
+		resp, err := http.Post(uploadURL, "", nil)
+		checkErr(t, err, msg)
+		checkResponse(t, msg, resp, tc.expected)
+		if tc.expected == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeNameInvalid)
+		}
+		resp.Body.Close()
+	}
+}
+
+// TestManifestPutInvalidTag ensures that manifests may only be pushed to
+// valid tags, which satisfy the configured pattern and length.
+func TestManifestPutInvalidTag(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.Tags.Pattern = `v[0-9.]+`
+	config.Validation.Manifests.Tags.MaxLength = 16
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/tags")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+
+	tagRef, _ := reference.WithTag(imageName, "v1.0")
+	validURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	manifestsURL := strings.TrimSuffix(validURL, "v1.0")
+
+	for _, tc := range []struct {
+		tag      string
+		expected int
+		code     errcode.ErrorCode
+	}{
+		{tag: "v1.0", expected: http.StatusCreated},
+		{tag: strings.Repeat("v", 129), expected: http.StatusBadRequest, code: v2.ErrorCodeTagInvalid},
+		{tag: "v1.0.0.0.0.0.0.0.0", expected: http.StatusBadRequest, code: v2.ErrorCodeTagInvalid},
+		// References resembling digests are parsed as digests, so a short
+		// one is rejected as an invalid digest.
+		{tag: "sha256:abcdef", expected: http.StatusBadRequest, code: v2.ErrorCodeDigestInvalid},
+		{tag: ".v1", expected: http.StatusBadRequest, code: v2.ErrorCodeTagInvalid},
+		{tag: "v1\x00", expected: http.StatusBadRequest, code: v2.ErrorCodeTagInvalid},
+		{tag: "v1 0", expected: http.StatusBadRequest, code: v2.ErrorCodeTagInvalid},
+		{tag: "latest", expected: http.StatusBadRequest, code: v2.ErrorCodeTagInvalid},
+	} {
+		msg := fmt.Sprintf("putting manifest to tag %q", tc.tag)
+		resp := putManifest(t, msg, manifestsURL+url.PathEscape(tc.tag), schema2.MediaTypeManifest, m)
+		checkResponse(t, msg, resp, tc.expected)
+		if tc.expected == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, msg, resp, tc.code)
+		}
+		resp.Body.Close()
+	}
+}
+
+// TestManifestPutMissingContentType ensures that manifests pushed without a
+// Content-Type are treated as schema1 manifests, unless configured to be
+// rejected.
+func TestManifestPutMissingContentType(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		expected int
+	}{
+		{policy: "", expected: http.StatusCreated},
+		{policy: "schema1", expected: http.StatusCreated},
+		{policy: "reject", expected: http.StatusBadRequest},
+	} {
+		config := newTestConfig(false)
+		config.Validation.Manifests.MissingContentType = tc.policy
+
+		env := newTestEnvWithConfig(t, &config)
+
+		imageName, _ := reference.WithName("foo/contenttype")
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		rs, layerDigest, err := testutil.CreateRandomTarFile()
+		checkErr(t, err, "creating random layer")
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+		signedManifest, err := schema1.Sign(&schema1.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 1,
+			},
+			Name:     imageName.Name(),
+			Tag:      "latest",
+			FSLayers: []schema1.FSLayer{{BlobSum: layerDigest}},
+			History:  []schema1.History{{V1Compatibility: ""}},
+		}, env.pk)
+		checkErr(t, err, "signing manifest")
+
+		msg := fmt.Sprintf("putting manifest without Content-Type with policy %q", tc.policy)
+		resp := putManifest(t, msg, manifestURL, "", signedManifest)
+		checkResponse(t, msg, resp, tc.expected)
+		if tc.expected == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeManifestInvalid)
+		} else {
+			checkHeaders(t, resp, http.Header{
+				"Docker-Content-Digest": []string{digest.FromBytes(signedManifest.Canonical).String()},
+			})
+		}
+		resp.Body.Close()
+
+		// Declaring the schema is always accepted.
+		msg = fmt.Sprintf("putting manifest with Content-Type with policy %q", tc.policy)
+		resp = putManifest(t, msg, manifestURL, schema1.MediaTypeSignedManifest, signedManifest)
+		checkResponse(t, msg, resp, http.StatusCreated)
+		resp.Body.Close()
+
+		env.Shutdown()
+	}
+}
+
+// TestManifestPutNestingLimit ensures that manifests whose JSON is nested too
+// deeply, or contains too many elements, are rejected before being decoded,
+// even where the offending elements would otherwise be ignored.
+func TestManifestPutNestingLimit(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.JSON.MaxDepth = 16
+	config.Validation.Manifests.JSON.MaxElements = 1000
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/nesting")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{
+			name: "nested",
+			body: `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","extra":` +
+				strings.Repeat("[", 1000) + strings.Repeat("]", 1000) + `}`,
+		},
+		{
+			name: "elements",
+			body: `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","extra":[` +
+				strings.Repeat("0,", 2000) + `0]}`,
+		},
+	} {
+		msg := fmt.Sprintf("putting %s manifest", tc.name)
+		resp := putManifest(t, msg, manifestURL, schema2.MediaTypeManifest, json.RawMessage(tc.body))
+		checkResponse(t, msg, resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeManifestInvalid)
+		resp.Body.Close()
+	}
+}
+
+// TestStorageRoutes ensures that repositories are stored by the driver of the
+// storage route matching their name, and that the catalog lists the
+// repositories of every driver.
+func TestStorageRoutes(t *testing.T) {
+	config := newTestConfig(false)
+	config.Storage["routes"] = configuration.Parameters{
+		"public":  map[interface{}]interface{}{"inmemory": nil},
+		"private": map[interface{}]interface{}{"inmemory": nil},
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	drivers := map[string]storagedriver.StorageDriver{
+		"other/app": env.app.driver,
+	}
+	for _, route := range env.app.storageRoutes {
+		drivers[route.prefix+"/app"] = route.driver
+	}
+
+	digests := make(map[string]digest.Digest)
+	for name := range drivers {
+		digests[name] = createRepository(env, t, name, "latest")
+	}
+
+	for name, dgst := range digests {
+		imageName, _ := reference.WithName(name)
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		resp, err := http.Get(manifestURL)
+		checkErr(t, err, "fetching manifest")
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+		resp.Body.Close()
+
+		blobPath := "/docker/registry/v2/blobs/sha256/" + dgst.Hex()[:2] + "/" + dgst.Hex() + "/data"
+		repoPath := "/docker/registry/v2/repositories/" + name
+		for other, driver := range drivers {
+			for _, p := range []string{blobPath, repoPath} {
+				_, err := driver.Stat(context.Background(), p)
+				switch {
+				case other == name && err != nil:
+					t.Fatalf("%s not stored by the driver of %s: %v", p, name, err)
+				case other != name && err == nil:
+					t.Fatalf("%s unexpectedly stored by the driver of %s", p, other)
+				}
+			}
+		}
+	}
+
+	var repositories []string
+	values := url.Values{"n": []string{"2"}}
+	for {
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		checkErr(t, err, "building catalog url")
+
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		checkResponse(t, "fetching catalog", resp, http.StatusOK)
+
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		resp.Body.Close()
+		repositories = append(repositories, ctlg.Repositories...)
+
+		if resp.Header.Get("Link") == "" {
+			break
+		}
+		values.Set("last", ctlg.Repositories[len(ctlg.Repositories)-1])
+	}
+
+	expected := []string{"other/app", "private/app", "public/app"}
+	if !reflect.DeepEqual(repositories, expected) {
+		t.Fatalf("unexpected catalog: %v != %v", repositories, expected)
+	}
+}
+
+// TestBlobUploadProgress ensures that the progress of an upload is streamed
+// as it receives chunks, ending once the upload is completed.
+func TestBlobUploadProgress(t *testing.T) {
+	config := newTestConfig(false)
+	config.HTTP.UploadProgress.Enabled = true
+	config.HTTP.UploadProgress.Interval = 10 * time.Millisecond
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/progress")
+	uploadURLBase, uploadUUID := startPushLayer(t, env, imageName)
+
+	unknownURL, err := env.builder.BuildBlobUploadProgressURL(imageName, "unknown")
+	checkErr(t, err, "building upload progress url")
+	resp, err := http.Get(unknownURL)
+	checkErr(t, err, "fetching progress of unknown upload")
+	checkResponse(t, "fetching progress of unknown upload", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching progress of unknown upload", resp, v2.ErrorCodeBlobUploadUnknown)
+	resp.Body.Close()
+
+	progressURL, err := env.builder.BuildBlobUploadProgressURL(imageName, uploadUUID)
+	checkErr(t, err, "building upload progress url")
+	resp, err = http.Get(progressURL)
+	checkErr(t, err, "fetching upload progress")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching upload progress", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type": []string{"text/event-stream"},
+	})
+
+	type progressEvent struct {
+		event  string
+		offset int64
+	}
+	events := make(chan progressEvent)
+	go func() {
+		defer close(events)
+		var e progressEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var data struct {
+					Offset int64 `json:"offset"`
+				}
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+					t.Errorf("error decoding event data %q: %v", line, err)
+					return
+				}
+				e.offset = data.Offset
+			case line == "":
+				events <- e
+				e = progressEvent{}
+			}
+		}
+	}()
+
+	expectEvent := func(expected progressEvent) {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("stream ended before %+v", expected)
+			}
+			if e != expected {
+				t.Fatalf("unexpected event: %+v != %+v", e, expected)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %+v", expected)
+		}
+	}
+
+	expectEvent(progressEvent{event: "progress", offset: 0})
+
+	var content []byte
+	for i := 0; i < 3; i++ {
+		chunk := make([]byte, 1024)
+		if _, err := rand.Read(chunk); err != nil {
+			t.Fatalf("error generating chunk: %v", err)
+		}
+		content = append(content, chunk...)
+
+		uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(chunk), int64(len(content)))
+		expectEvent(progressEvent{event: "progress", offset: int64(len(content))})
+	}
+
+	finishUpload(t, env.builder, imageName, uploadURLBase, digest.FromBytes(content))
+	expectEvent(progressEvent{event: "end", offset: int64(len(content))})
+
+	if _, ok := <-events; ok {
+		t.Fatal("stream continued after the upload ended")
+	}
+}
+
+// TestBlobContentDisposition ensures that blobs are served with a
+// Content-Disposition header naming them after their digest only when
+// enabled.
+func TestBlobContentDisposition(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		config := newTestConfig(false)
+		config.Storage["contentdisposition"] = configuration.Parameters{
+			"enabled": enabled,
+		}
+
+		env := newTestEnvWithConfig(t, &config)
+		defer env.Shutdown()
+
+		imageName, _ := reference.WithName("foo/disposition")
+		dgst, _ := pushRandomBlob(t, env, imageName)
+
+		ref, _ := reference.WithDigest(imageName, dgst)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+
+		resp, err := http.Get(blobURL)
+		checkErr(t, err, "fetching blob")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching blob", resp, http.StatusOK)
+
+		disposition := resp.Header.Get("Content-Disposition")
+		if !enabled {
+			if disposition != "" {
+				t.Fatalf("unexpected Content-Disposition header: %q", disposition)
+			}
+			continue
+		}
+
+		expected := fmt.Sprintf(`attachment; filename="sha256-%s"`, dgst.Hex())
+		if disposition != expected {
+			t.Fatalf("unexpected Content-Disposition header: %q != %q", disposition, expected)
+		}
+	}
+}
+
+// TestBlobMultipartRanges ensures that a request for two ranges of a blob is
+// served a multipart/byteranges response only when enabled, and the whole
+// blob otherwise.
+func TestBlobMultipartRanges(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		config := newTestConfig(false)
+		config.Storage["multipartranges"] = configuration.Parameters{
+			"enabled": enabled,
+		}
+
+		env := newTestEnvWithConfig(t, &config)
+
+		imageName, _ := reference.WithName("foo/multipartranges")
+		dgst, content := pushRandomBlob(t, env, imageName)
+
+		ref, _ := reference.WithDigest(imageName, dgst)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+
+		req, _ := http.NewRequest("GET", blobURL, nil)
+		req.Header.Set("Range", "bytes=0-9,100-199")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching blob ranges")
+
+		if !enabled {
+			checkResponse(t, "fetching blob ranges", resp, http.StatusOK)
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			checkErr(t, err, "reading blob")
+			if !bytes.Equal(body, content) {
+				t.Fatalf("expected the whole blob to be served")
+			}
+			env.Shutdown()
+			continue
+		}
+
+		checkResponse(t, "fetching blob ranges", resp, http.StatusPartialContent)
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		checkErr(t, err, "parsing Content-Type")
+		if mediaType != "multipart/byteranges" {
+			t.Fatalf("unexpected Content-Type: %q", resp.Header.Get("Content-Type"))
+		}
+
+		expected := []struct {
+			start, end int
+		}{
+			{0, 9},
+			{100, 199},
+		}
+		mr := multipart.NewReader(resp.Body, params["boundary"])
+		for _, r := range expected {
+			part, err := mr.NextPart()
+			checkErr(t, err, "reading part")
+
+			contentRange := fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, len(content))
+			if part.Header.Get("Content-Range") != contentRange {
+				t.Fatalf("unexpected Content-Range: %q != %q", part.Header.Get("Content-Range"), contentRange)
+			}
+			body, err := ioutil.ReadAll(part)
+			checkErr(t, err, "reading part body")
+			if !bytes.Equal(body, content[r.start:r.end+1]) {
+				t.Fatalf("part %s does not match the blob", contentRange)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Fatalf("expected two parts, got error %v", err)
+		}
+		resp.Body.Close()
+
+		env.Shutdown()
+	}
+}
+
+// TestBlobUploadPurgedSession ensures that replaying the state of an upload
+// whose session has been purged reports that the session expired.
+func TestBlobUploadPurgedSession(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/purged")
+
+	p := make([]byte, 2048)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	dgst := digest.FromBytes(p)
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(p[:1024]), 1024)
+
+	if _, errs := storage.PurgeUploads(env.ctx, env.app.driver, time.Now().Add(time.Hour), true); len(errs) > 0 {
+		t.Fatalf("unexpected errors purging uploads: %v", errs)
+	}
+
+	checkExpired := func(msg string, resp *http.Response) {
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusNotFound)
+		_, body, _ := checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeBlobUploadUnknown)
+		if !bytes.Contains(body, []byte("expired")) {
+			t.Fatalf("expected %s to report an expired session: %s", msg, body)
+		}
+	}
+
+	resp, _, err := doPushChunk(t, uploadURLBase, bytes.NewReader(p[1024:]))
+	checkErr(t, err, "pushing chunk to purged upload")
+	checkExpired("pushing chunk to purged upload", resp)
+
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(p[1024:]))
+	checkErr(t, err, "completing purged upload")
+	checkExpired("completing purged upload", resp)
+}
+
+// TestManifestPutDryRun ensures that a dry run manifest push reports the
+// errors a push would, without storing or tagging the manifest.
+func TestManifestPutDryRun(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/dryrun")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	dryRunURL := manifestURL + "?dryrun=true"
+
+	rs, layerDigest, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+
+	signedManifest, err := schema1.Sign(&schema1.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     imageName.Name(),
+		Tag:      "latest",
+		FSLayers: []schema1.FSLayer{{BlobSum: layerDigest}},
+		History:  []schema1.History{{V1Compatibility: ""}},
+	}, env.pk)
+	checkErr(t, err, "signing manifest")
+	dgst := digest.FromBytes(signedManifest.Canonical)
+
+	storedPaths := func() []string {
+		var paths []string
+		err := storagedriver.WalkFallback(env.ctx, env.app.driver, "/", func(fi storagedriver.FileInfo) error {
+			paths = append(paths, fi.Path())
+			return nil
+		})
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				t.Fatalf("unexpected error walking storage: %v", err)
+			}
+		}
+		return paths
+	}
+
+	before := storedPaths()
+	resp := putManifest(t, "dry run with missing layer", dryRunURL, "", signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "dry run with missing layer", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "dry run with missing layer", resp, v2.ErrorCodeManifestBlobUnknown)
+
+	if after := storedPaths(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("dry run modified storage: %v != %v", after, before)
+	}
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	before = storedPaths()
+	resp = putManifest(t, "dry run", dryRunURL, "", signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "dry run", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	if after := storedPaths(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("dry run modified storage: %v != %v", after, before)
+	}
+
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest after dry run")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest after dry run", resp, http.StatusNotFound)
+}
+
+// TestManifestVerificationConcurrency ensures that concurrent manifest pushes
+// beyond the configured limit wait for a verification slot, and are rejected
+// once the queue is full.
+func TestManifestVerificationConcurrency(t *testing.T) {
+	const limit, pushes = 2, 6
+
+	config := newTestConfig(false)
+	config.Validation.Manifests.Concurrency.Limit = limit
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/concurrent")
+
+	requests := make([]*http.Request, pushes)
+	payloads := make([][]byte, pushes)
+	for i := range requests {
+		rs, dgst, err := testutil.CreateRandomTarFile()
+		checkErr(t, err, "creating random layer")
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, dgst, uploadURLBase, rs)
+
+		tag := fmt.Sprintf("tag%d", i)
+		signedManifest, err := schema1.Sign(&schema1.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 1,
+			},
+			Name:     imageName.Name(),
+			Tag:      tag,
+			FSLayers: []schema1.FSLayer{{BlobSum: dgst}},
+			History:  []schema1.History{{V1Compatibility: ""}},
+		}, env.pk)
+		checkErr(t, err, "signing manifest")
+		_, payloads[i], err = signedManifest.Payload()
+		checkErr(t, err, "getting manifest payload")
+
+		tagRef, _ := reference.WithTag(imageName, tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		requests[i], err = http.NewRequest("PUT", manifestURL, bytes.NewReader(payloads[i]))
+		checkErr(t, err, "creating manifest request")
+	}
+
+	// Hold every slot so that all pushes must queue.
+	limiter := env.app.manifestVerifications
+	var held []func()
+	for i := 0; i < limit; i++ {
+		release, err := limiter.acquire(env.ctx)
+		checkErr(t, err, "acquiring verification slot")
+		held = append(held, release)
+	}
+
+	statuses := make(chan int, pushes)
+	for _, req := range requests {
+		go func(req *http.Request) {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(req)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); atomic.LoadInt64(&limiter.waiting) < pushes; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued verifications, got %d", pushes, atomic.LoadInt64(&limiter.waiting))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, release := range held {
+		release()
+	}
+
+	for i := 0; i < pushes; i++ {
+		if status := <-statuses; status != http.StatusCreated {
+			t.Fatalf("unexpected status pushing manifest: %d", status)
+		}
+	}
+
+	if peak := atomic.LoadInt64(&limiter.peak); peak > limit {
+		t.Fatalf("%d manifests were verified concurrently, limit is %d", peak, limit)
+	}
+
+	// With the queue capped and already full, further pushes are rejected
+	// rather than queued.
+	limiter.maxQueued = 1
+	for i := 0; i < limit; i++ {
+		release, err := limiter.acquire(env.ctx)
+		checkErr(t, err, "acquiring verification slot")
+		defer release()
+	}
+	atomic.AddInt64(&limiter.waiting, 1) // stands in for a queued push
+	defer atomic.AddInt64(&limiter.waiting, -1)
+
+	req, err := http.NewRequest("PUT", requests[0].URL.String(), bytes.NewReader(payloads[0]))
+	checkErr(t, err, "creating manifest request")
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "pushing manifest with full queue")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing manifest with full queue", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "pushing manifest with full queue", resp, errcode.ErrorCodeUnavailable)
+}
+
+// TestRepositoryRegistration ensures that, with automatic creation of
+// repositories disabled, pushes to unregistered repositories are rejected
+// until the repository is registered.
+func TestRepositoryRegistration(t *testing.T) {
+	config := newTestConfig(false)
+	config.Storage["repositories"] = configuration.Parameters{
+		"autocreate": false,
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/registered")
+	registrationURL, err := env.builder.BuildRepositoryRegistrationURL(imageName)
+	checkErr(t, err, "building registration url")
+
+	resp, err := http.Get(registrationURL)
+	checkErr(t, err, "checking unregistered repository")
+	defer resp.Body.Close()
+	checkResponse(t, "checking unregistered repository", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "checking unregistered repository", resp, v2.ErrorCodeNameUnknown)
+
+	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+
+	resp, err = http.Post(layerUploadURL, "", nil)
+	checkErr(t, err, "starting upload to unregistered repository")
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload to unregistered repository", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "starting upload to unregistered repository", resp, v2.ErrorCodeNameUnknown)
+
+	req, err := http.NewRequest("PUT", registrationURL, nil)
+	checkErr(t, err, "building registration request")
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "registering repository")
+	defer resp.Body.Close()
+	checkResponse(t, "registering repository", resp, http.StatusCreated)
+
+	resp, err = http.Get(registrationURL)
+	checkErr(t, err, "checking registered repository")
+	defer resp.Body.Close()
+	checkResponse(t, "checking registered repository", resp, http.StatusOK)
+
+	pushRandomBlob(t, env, imageName)
+
+	resp, err = httpDelete(registrationURL)
+	checkErr(t, err, "unregistering repository")
+	defer resp.Body.Close()
+	checkResponse(t, "unregistering repository", resp, http.StatusAccepted)
+
+	resp, err = http.Post(layerUploadURL, "", nil)
+	checkErr(t, err, "starting upload to unregistered repository")
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload to unregistered repository", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "starting upload to unregistered repository", resp, v2.ErrorCodeNameUnknown)
+}
+
+func TestManifestPutQuarantine(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.Quarantine = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/quarantine")
+	unsignedManifest := &schema1.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name: imageName.Name(),
+		Tag:  "latest",
+		FSLayers: []schema1.FSLayer{
+			{
+				BlobSum: digest.FromString("layer"),
+			},
+		},
+		History: []schema1.History{
+			{
+				V1Compatibility: "",
+			},
+		},
+	}
+
+	payload, err := json.MarshalIndent(unsignedManifest, "", "   ")
+	checkErr(t, err, "marshaling manifest")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(payload))
+	checkErr(t, err, "creating manifest put request")
+	req.Header.Set("Content-Type", schema1.MediaTypeSignedManifest)
+
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "putting unsigned manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "putting unsigned manifest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting unsigned manifest", resp, v2.ErrorCodeManifestInvalid)
+
+	quarantined, reason, err := storage.QuarantinedManifest(env.ctx, env.app.driver, imageName.Name(), digest.FromBytes(payload))
+	checkErr(t, err, "reading quarantined manifest")
+	if !bytes.Equal(quarantined, payload) {
+		t.Fatalf("quarantined manifest differs from pushed manifest:\n%s\n%s", quarantined, payload)
+	}
+	if reason == "" {
+		t.Fatal("expected reason for quarantine")
+	}
+
+	// The quarantined manifest is not served.
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching quarantined manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching quarantined manifest", resp, http.StatusNotFound)
+}
+
+// TestManifestPutDryRunQuarantine ensures that a dry run of a manifest which
+// fails verification does not quarantine it.
+func TestManifestPutDryRunQuarantine(t *testing.T) {
+	config := newTestConfig(false)
+	config.Validation.Manifests.Quarantine = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/dryrunquarantine")
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    digest.FromString("config"),
+		},
+		Layers: []distribution.Descriptor{
+			{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      4096,
+				Digest:    digest.FromString("layer"),
+			},
+		},
+	}
+	payload, err := json.MarshalIndent(m, "", "   ")
+	checkErr(t, err, "marshaling manifest")
+	dgst := digest.FromBytes(payload)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "dry run with missing blobs", manifestURL+"?dryrun=true", schema2.MediaTypeManifest, m)
+	defer resp.Body.Close()
+	checkResponse(t, "dry run with missing blobs", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "dry run with missing blobs", resp, v2.ErrorCodeManifestBlobUnknown)
+
+	if _, _, err := storage.QuarantinedManifest(env.ctx, env.app.driver, imageName.Name(), dgst); err == nil {
+		t.Fatal("dry run quarantined manifest")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("unexpected error reading quarantined manifest: %v", err)
+	}
+
+	// The same push, made in earnest, is quarantined.
+	resp = putManifest(t, "putting manifest with missing blobs", manifestURL, schema2.MediaTypeManifest, m)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest with missing blobs", resp, http.StatusBadRequest)
+
+	_, _, err = storage.QuarantinedManifest(env.ctx, env.app.driver, imageName.Name(), dgst)
+	checkErr(t, err, "reading quarantined manifest")
+}
+
+// TestManifestListDefaultPlatform ensures that a client which does not
+// support manifest lists is served the manifest for the configured default
+// platform when pulling a manifest list by tag.
+func TestManifestListDefaultPlatform(t *testing.T) {
+	config := newTestConfig(false)
+	config.Compatibility.DefaultPlatform.Architecture = "arm64"
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/platforms")
+	amd64Digest := createRepository(env, t, imageName.Name(), "amd64")
+	arm64Digest := createRepository(env, t, imageName.Name(), "arm64")
+
+	var descriptors []manifestlist.ManifestDescriptor
+	for arch, dgst := range map[string]digest.Digest{"amd64": amd64Digest, "arm64": arm64Digest} {
+		descriptors = append(descriptors, manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{
+				Digest:    dgst,
+				MediaType: schema1.MediaTypeSignedManifest,
+			},
+			Platform: manifestlist.PlatformSpec{
+				Architecture: arch,
+				OS:           "linux",
+			},
+		})
+	}
+
+	manifestList, err := manifestlist.FromDescriptors(descriptors)
+	checkErr(t, err, "creating manifest list")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest list", manifestURL, manifestlist.MediaTypeManifestList, manifestList)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest list", resp, http.StatusCreated)
+
+	// Fetch without accepting manifest lists, as a schema1-only client would.
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest list as schema1 client")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest list as schema1 client", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{arm64Digest.String()},
+	})
+
+	var fetched schema1.SignedManifest
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatalf("error decoding fetched manifest: %v", err)
+	}
+	if digest.FromBytes(fetched.Canonical) != arm64Digest {
+		t.Fatalf("unexpected manifest returned for default platform")
+	}
+}
+
+// TestManifestListPlatformFilter ensures that a manifest list fetched by tag
+// may be pruned to the requested platforms.
+func TestManifestListPlatformFilter(t *testing.T) {
+	config := newTestConfig(false)
+	config.Compatibility.PlatformFilter.Enabled = true
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/platforms")
+	platforms := []manifestlist.PlatformSpec{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}
+
+	var descriptors []manifestlist.ManifestDescriptor
+	for _, platform := range platforms {
+		dgst := createRepository(env, t, imageName.Name(), platform.Architecture)
+		descriptors = append(descriptors, manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{
+				Digest:    dgst,
+				MediaType: schema1.MediaTypeSignedManifest,
+			},
+			Platform: platform,
+		})
+	}
+
+	manifestList, err := manifestlist.FromDescriptors(descriptors)
+	checkErr(t, err, "creating manifest list")
+	_, payload, err := manifestList.Payload()
+	checkErr(t, err, "getting manifest list payload")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest list", manifestURL, manifestlist.MediaTypeManifestList, manifestList)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest list", resp, http.StatusCreated)
+
+	fetch := func(msg, query string) *http.Response {
+		req, err := http.NewRequest("GET", manifestURL+query, nil)
+		checkErr(t, err, msg)
+		req.Header.Set("Accept", manifestlist.MediaTypeManifestList)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	resp = fetch("fetching unfiltered manifest list", "")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching unfiltered manifest list", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{digest.FromBytes(payload).String()},
+	})
+
+	resp = fetch("fetching filtered manifest list", "?platform=linux/arm/v7")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching filtered manifest list", resp, http.StatusOK)
+
+	p, err := ioutil.ReadAll(resp.Body)
+	checkErr(t, err, "reading filtered manifest list")
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{manifestlist.MediaTypeManifestList},
+		"Docker-Content-Digest": []string{digest.FromBytes(p).String()},
+	})
+
+	var filtered manifestlist.DeserializedManifestList
+	if err := json.Unmarshal(p, &filtered); err != nil {
+		t.Fatalf("error decoding filtered manifest list: %v", err)
+	}
+	if len(filtered.Manifests) != 1 || !reflect.DeepEqual(filtered.Manifests[0], descriptors[2]) {
+		t.Fatalf("expected manifest list pruned to %#v, got %#v", descriptors[2], filtered.Manifests)
+	}
+
+	resp = fetch("fetching manifest list for unknown platform", "?platform=windows/amd64")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest list for unknown platform", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching manifest list for unknown platform", resp, v2.ErrorCodeManifestUnknown)
+
+	resp = fetch("fetching manifest list for invalid platform", "?platform=linux")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest list for invalid platform", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching manifest list for invalid platform", resp, v2.ErrorCodePlatformInvalid)
+}
+
+// TestProxyBlobGetServedLocally ensures that a blob pulled through the proxy
+// is stored locally, so that subsequent pulls do not require the remote.
+func TestProxyBlobGetServedLocally(t *testing.T) {
+	truthConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	truthConfig.HTTP.Headers = headerConfig
+
+	truthEnv := newTestEnvWithConfig(t, &truthConfig)
+	defer truthEnv.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	dgst, layer := pushRandomBlob(t, truthEnv, imageName)
+
+	proxyConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+		},
+		Proxy: configuration.Proxy{
+			RemoteURL: truthEnv.server.URL,
+		},
+	}
+	proxyConfig.HTTP.Headers = headerConfig
+
+	proxyEnv := newTestEnvWithConfig(t, &proxyConfig)
+	defer proxyEnv.Shutdown()
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := proxyEnv.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	resp, err := http.Get(blobURL)
+	checkErr(t, err, "fetching blob from proxy")
+	checkResponse(t, "fetching blob from proxy", resp, http.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading blob from proxy")
+	if !bytes.Equal(body, layer) {
+		t.Fatalf("unexpected blob content from proxy")
+	}
+
+	// The blob is committed to local storage in the background, so allow
+	// some time for that to complete.
+	local, err := storage.NewRegistry(proxyEnv.ctx, proxyEnv.app.driver)
+	checkErr(t, err, "creating local registry")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := local.BlobStatter().Stat(proxyEnv.ctx, dgst); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("blob not stored locally by proxy")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Take the remote away. The blob must now be served locally.
+	truthEnv.server.CloseClientConnections()
+	truthEnv.server.Close()
+
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob from proxy without remote")
+	checkResponse(t, "fetching blob from proxy without remote", resp, http.StatusOK)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading blob from proxy without remote")
+
+	if !bytes.Equal(body, layer) {
+		t.Fatalf("unexpected blob content served locally")
+	}
+}
+
+// principalAccessController authorizes every request, attributing it to the
+// principal named in the X-Test-Principal header or, if absent, to the
+// configured default principal. If userKey is set, the principal is carried
+// only under auth.UserKey, as some access controllers carry it.
+type principalAccessController struct {
+	name    string
+	userKey bool
+}
+
+func (ac *principalAccessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	name := ac.name
+	if r, err := dcontext.GetRequest(ctx); err == nil && r.Header.Get("X-Test-Principal") != "" {
+		name = r.Header.Get("X-Test-Principal")
+	}
+
+	if ac.userKey {
+		return context.WithValue(ctx, auth.UserKey, auth.UserInfo{Name: name}), nil
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: name}), nil
+}
+
+func init() {
+	auth.Register("testprincipal", func(options map[string]interface{}) (auth.AccessController, error) {
+		name, _ := options["name"].(string)
+		userKey, _ := options["userkey"].(bool)
+		return &principalAccessController{name: name, userKey: userKey}, nil
+	})
+}
+
+// eventRecorder is an events.Sink which records every event written to it.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []notifications.Event
+}
+
+func (er *eventRecorder) Write(event events.Event) error {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	er.events = append(er.events, event.(notifications.Event))
+	return nil
+}
+
+func (er *eventRecorder) Close() error {
+	return nil
+}
+
+func (er *eventRecorder) Events() []notifications.Event {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return append([]notifications.Event(nil), er.events...)
+}
+
+// TestManifestPushEventPrincipal ensures the authenticated principal is
+// threaded through the request context into emitted notification events.
+func TestManifestPushEventPrincipal(t *testing.T) {
+	config := newTestConfig(false)
+	config.Auth = configuration.Auth{
+		"testprincipal": configuration.Parameters{"name": "alice"},
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	recorder := &eventRecorder{}
+	env.app.events.sink = recorder
+
+	dgst := createRepository(env, t, "foo/principal", "latest")
+
+	var found bool
+	for _, event := range recorder.Events() {
+		if event.Action != notifications.EventActionPush || event.Target.Digest != dgst {
+			continue
+		}
+		found = true
+		if event.Actor.Name != "alice" {
+			t.Fatalf("unexpected actor on manifest push event: %q != %q", event.Actor.Name, "alice")
+		}
+	}
+	if !found {
+		t.Fatalf("no push event recorded for manifest %s", dgst)
+	}
+}
+
+// redirectDriverFactory creates in-memory drivers which can produce URLs for
+// their content, so that content may be served by redirect. If the
+// "honorrange" parameter is true, the URLs are reported to honor Range
+// headers.
+type redirectDriverFactory struct{}
+
+func (factory *redirectDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	d := &redirectDriver{StorageDriver: inmemory.New()}
+	if honorRange, _ := parameters["honorrange"].(bool); honorRange {
+		return &rangeRedirectDriver{d}, nil
+	}
+	return d, nil
+}
+
+func init() {
+	factory.Register("redirectdriver", &redirectDriverFactory{})
+}
+
+type redirectDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d *redirectDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "https://storage.example.com" + path, nil
+}
+
+// rangeRedirectDriver is a redirectDriver whose URLs honor Range headers.
+type rangeRedirectDriver struct {
+	*redirectDriver
+}
+
+func (d *rangeRedirectDriver) URLsHonorRange() bool {
+	return true
+}
+
+func TestManifestGetRedirectSize(t *testing.T) {
+	config := newTestConfig(false)
+	delete(config.Storage, "testdriver")
+	config.Storage["redirectdriver"] = configuration.Parameters{}
+	config.Storage["redirect"] = configuration.Parameters{"manifestsize": 1024}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/redirect")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	small := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":4096,"digest":%q},"layers":[{"mediaType":%q,"size":4096,"digest":%q}]}`,
+		schema2.MediaTypeManifest, schema2.MediaTypeImageConfig, configDigest, schema2.MediaTypeLayer, layerDigest))
+	// Trailing whitespace is preserved, so pads the manifest past the
+	// limit without changing what it describes.
+	large := append(append([]byte(nil), small...), bytes.Repeat([]byte(" "), 2048)...)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, testcase := range []struct {
+		raw      []byte
+		redirect bool
+	}{
+		{raw: small},
+		{raw: large, redirect: true},
+	} {
+		dgst := digest.FromBytes(testcase.raw)
+		digestRef, _ := reference.WithDigest(imageName, dgst)
+		manifestURL, err := env.builder.BuildManifestURL(digestRef)
+		checkErr(t, err, "building manifest url")
+
+		req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(testcase.raw))
+		checkErr(t, err, "creating manifest put request")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		resp.Body.Close()
+		checkResponse(t, "putting manifest", resp, http.StatusCreated)
+
+		req, err = http.NewRequest("GET", manifestURL, nil)
+		checkErr(t, err, "creating manifest get request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+
+		resp, err = client.Do(req)
+		checkErr(t, err, "fetching manifest")
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		checkErr(t, err, "reading manifest")
+
+		headers := http.Header{
+			"Content-Type":          []string{schema2.MediaTypeManifest},
+			"Docker-Content-Digest": []string{dgst.String()},
+		}
+		if testcase.redirect {
+			checkResponse(t, "fetching large manifest", resp, http.StatusTemporaryRedirect)
+			checkHeaders(t, resp, headers)
+
+			location := resp.Header.Get("Location")
+			if !strings.HasPrefix(location, "https://storage.example.com/") || !strings.Contains(location, dgst.Hex()) {
+				t.Fatalf("unexpected redirect location for manifest %s: %q", dgst, location)
+			}
+			continue
+		}
+
+		checkResponse(t, "fetching small manifest", resp, http.StatusOK)
+		checkHeaders(t, resp, headers)
+		if !bytes.Equal(p, testcase.raw) {
+			t.Fatalf("fetched manifest differs from pushed manifest:\n%q\n%q", p, testcase.raw)
+		}
+	}
+}
+
+// TestManifestGetRedirectCompressed ensures that a manifest above the
+// redirect size which is stored compressed is served by the registry, as the
+// backend would serve it compressed.
+func TestManifestGetRedirectCompressed(t *testing.T) {
+	config := newTestConfig(false)
+	delete(config.Storage, "testdriver")
+	config.Storage["redirectdriver"] = configuration.Parameters{}
+	config.Storage["redirect"] = configuration.Parameters{"manifestsize": 1024}
+	config.Storage["compression"] = configuration.Parameters{"manifests": true}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/redirect")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	raw := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":4096,"digest":%q},"layers":[{"mediaType":%q,"size":4096,"digest":%q}]}`,
+		schema2.MediaTypeManifest, schema2.MediaTypeImageConfig, configDigest, schema2.MediaTypeLayer, layerDigest))
+	raw = append(raw, bytes.Repeat([]byte(" "), 2048)...)
+
+	dgst := digest.FromBytes(raw)
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(raw))
+	checkErr(t, err, "creating manifest put request")
+	req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "putting manifest")
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err = http.NewRequest("GET", manifestURL, nil)
+	checkErr(t, err, "creating manifest get request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+
+	resp, err = client.Do(req)
+	checkErr(t, err, "fetching manifest")
+	p, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading manifest")
+
+	checkResponse(t, "fetching compressed manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{schema2.MediaTypeManifest},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+	if !bytes.Equal(p, raw) {
+		t.Fatalf("fetched manifest differs from pushed manifest:\n%q\n%q", p, raw)
+	}
+}
+
+// TestBlobGetRangeRedirect ensures that a request for a range of a blob is
+// only redirected if the URL redirected to honors the range, or if
+// configured to, and is otherwise served as partial content.
+func TestBlobGetRangeRedirect(t *testing.T) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, testcase := range []struct {
+		honorRange bool
+		policy     string
+		ranged     bool
+		redirect   bool
+	}{
+		{ranged: false, redirect: true},
+		{ranged: true, redirect: false},
+		{honorRange: true, ranged: true, redirect: true},
+		{honorRange: true, policy: "serve", ranged: true, redirect: false},
+		{policy: "redirect", ranged: true, redirect: true},
+	} {
+		name := fmt.Sprintf("honorrange=%t ranges=%q ranged=%t", testcase.honorRange, testcase.policy, testcase.ranged)
+
+		config := newTestConfig(false)
+		delete(config.Storage, "testdriver")
+		config.Storage["redirectdriver"] = configuration.Parameters{"honorrange": testcase.honorRange}
+		if testcase.policy != "" {
+			config.Storage["redirect"] = configuration.Parameters{"ranges": testcase.policy}
+		}
+
+		env := newTestEnvWithConfig(t, &config)
+
+		imageName, _ := reference.WithName("foo/rangeredirect")
+		dgst, p := pushRandomBlob(t, env, imageName)
+
+		ref, _ := reference.WithDigest(imageName, dgst)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+
+		req, err := http.NewRequest("GET", blobURL, nil)
+		checkErr(t, err, "creating blob get request")
+		if testcase.ranged {
+			req.Header.Set("Range", "bytes=100-199")
+		}
+
+		resp, err := client.Do(req)
+		checkErr(t, err, "fetching blob")
+
+		switch {
+		case testcase.redirect:
+			checkResponse(t, name, resp, http.StatusTemporaryRedirect)
+		default:
+			checkResponse(t, name, resp, http.StatusPartialContent)
+			checkHeaders(t, resp, http.Header{
+				"Content-Range":  []string{fmt.Sprintf("bytes 100-199/%d", len(p))},
+				"Content-Length": []string{"100"},
+			})
+
+			body, err := ioutil.ReadAll(resp.Body)
+			checkErr(t, err, "reading partial content")
+			if !bytes.Equal(body, p[100:200]) {
+				t.Fatalf("%s: unexpected partial content", name)
+			}
+		}
+		resp.Body.Close()
+		env.Shutdown()
+	}
+}
+
+func TestRepositoryPolicyImmutableTags(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	immutableName, _ := reference.WithName("foo/immutable")
+	mutableName, _ := reference.WithName("foo/mutable")
+
+	policyURL, err := env.builder.BuildRepositoryPolicyURL(immutableName)
+	checkErr(t, err, "building policy url")
+
+	req, err := http.NewRequest("PUT", policyURL, strings.NewReader(`{"immutableTags":true}`))
+	checkErr(t, err, "creating policy put request")
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "putting policy")
+	resp.Body.Close()
+	checkResponse(t, "putting policy", resp, http.StatusNoContent)
+
+	resp, err = http.Get(policyURL)
+	checkErr(t, err, "fetching policy")
+	checkResponse(t, "fetching policy", resp, http.StatusOK)
+	var policy storage.RepositoryPolicy
+	err = json.NewDecoder(resp.Body).Decode(&policy)
+	resp.Body.Close()
+	checkErr(t, err, "decoding policy")
+	if policy.ImmutableTags == nil || !*policy.ImmutableTags {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+
+	// retag pushes a new manifest under the tag latest, which
+	// createRepository has already pushed.
+	retag := func(name reference.Named) *http.Response {
+		configDigest, _ := pushRandomBlob(t, env, name)
+		layerDigest, _ := pushRandomBlob(t, env, name)
+		raw := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":4096,"digest":%q},"layers":[{"mediaType":%q,"size":4096,"digest":%q}]}`,
+			schema2.MediaTypeManifest, schema2.MediaTypeImageConfig, configDigest, schema2.MediaTypeLayer, layerDigest))
+
+		tagRef, _ := reference.WithTag(name, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(raw))
+		checkErr(t, err, "creating manifest put request")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		return resp
+	}
+
+	dgst := createRepository(env, t, immutableName.Name(), "latest")
+	resp = retag(immutableName)
+	checkResponse(t, "moving immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "moving immutable tag", resp, v2.ErrorCodeTagImmutable)
+	resp.Body.Close()
+
+	repo, err := env.app.registry.Repository(env.ctx, immutableName)
+	checkErr(t, err, "getting repository")
+	desc, err := repo.Tags(env.ctx).Get(env.ctx, "latest")
+	checkErr(t, err, "getting tag")
+	if desc.Digest != dgst {
+		t.Fatalf("immutable tag was moved: %s != %s", desc.Digest, dgst)
+	}
+
+	// Other repositories follow the configuration, which allows tags to be
+	// moved.
+	createRepository(env, t, mutableName.Name(), "latest")
+	resp = retag(mutableName)
+	checkResponse(t, "moving mutable tag", resp, http.StatusCreated)
+	resp.Body.Close()
+}
+
+// TestBlobUploadCompleteWithoutDigest ensures that an upload cannot be
+// completed, committing content which was never verified, without a digest.
+// The session remains usable, so the client may retry with one.
+func TestBlobUploadCompleteWithoutDigest(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/nodigest")
+	content := []byte("content without a digest")
+	dgst := digest.FromBytes(content)
+
+	// finish completes the upload at uploadURLBase with body, without a
+	// digest parameter.
+	finish := func(msg, uploadURLBase string, body []byte) {
+		u, err := url.Parse(uploadURLBase)
+		checkErr(t, err, "parsing upload url")
+		u.RawQuery = url.Values{"_state": u.Query()["_state"]}.Encode()
+
+		req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(body))
+		checkErr(t, err, "creating upload completion request")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeDigestInvalid)
+	}
+
+	// Monolithic: the content is sent with the completing request.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	finish("completing monolithic upload without digest", uploadURLBase, content)
+
+	// Chunked: the content has already been received.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(content), int64(len(content)))
+	finish("completing chunked upload without digest", uploadURLBase, nil)
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+	resp, err := http.Head(blobURL)
+	checkErr(t, err, "checking blob")
+	resp.Body.Close()
+	checkResponse(t, "checking blob completed without digest", resp, http.StatusNotFound)
+
+	// The chunked session may still be completed with a digest.
+	finishUpload(t, env.builder, imageName, uploadURLBase, dgst)
+	resp, err = http.Head(blobURL)
+	checkErr(t, err, "checking blob")
+	resp.Body.Close()
+	checkResponse(t, "checking blob completed with digest", resp, http.StatusOK)
+}
+
+// countingDriverFactory creates drivers sharing a single in-memory driver,
+// which count the paths statted, so that several apps may be tested against
+// the same content.
+type countingDriverFactory struct {
+	driver *countingDriver
+}
+
+func (factory *countingDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+type countingDriver struct {
+	storagedriver.StorageDriver
+
+	mu    sync.Mutex
+	stats map[string]int
+}
+
+func (d *countingDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	d.mu.Lock()
+	d.stats[path]++
+	d.mu.Unlock()
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func (d *countingDriver) statCount(path string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats[path]
+}
+
+// cacheMisses returns the number of blob descriptor cache misses recorded.
+func cacheMisses(t *testing.T) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	checkErr(t, err, "gathering metrics")
+	for _, family := range families {
+		if family.GetName() != "registry_storage_cache_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "type" && label.GetValue() == "Miss" {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// TestManifestGetPrefetchLayers ensures that fetching a manifest warms the
+// blob descriptor cache for its layers, so that fetching them hits the
+// cache.
+func TestManifestGetPrefetchLayers(t *testing.T) {
+	driver := &countingDriver{StorageDriver: inmemory.New(), stats: make(map[string]int)}
+	factory.Register("countingdriver", &countingDriverFactory{driver: driver})
+
+	newEnv := func(prefetch int) *testEnv {
+		config := newTestConfig(false)
+		delete(config.Storage, "testdriver")
+		config.Storage["countingdriver"] = configuration.Parameters{}
+		config.Storage["cache"] = configuration.Parameters{"blobdescriptor": "inmemory"}
+		if prefetch > 0 {
+			config.Storage["cache"]["prefetchlayers"] = prefetch
+		}
+		return newTestEnvWithConfig(t, &config)
+	}
+
+	// Content is pushed through one app, so that the caches of the others
+	// start cold.
+	env := newEnv(0)
+	imageName, _ := reference.WithName("foo/prefetch")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, m)
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	resp.Body.Close()
+	env.Shutdown()
+
+	layerData := "/docker/registry/v2/blobs/" + layerDigest.Algorithm().String() + "/" + layerDigest.Hex()[:2] + "/" + layerDigest.Hex() + "/data"
+
+	for _, prefetch := range []int{0, 2} {
+		env := newEnv(prefetch)
+
+		stats := driver.statCount(layerData)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		req, err := http.NewRequest("GET", manifestURL, nil)
+		checkErr(t, err, "creating manifest get request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		resp.Body.Close()
+
+		if prefetch > 0 {
+			// Wait for the layer to be statted, then for its descriptor
+			// to be cached.
+			deadline := time.Now().Add(5 * time.Second)
+			for driver.statCount(layerData) == stats {
+				if time.Now().After(deadline) {
+					t.Fatalf("layer was not prefetched")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		ref, _ := reference.WithDigest(imageName, layerDigest)
+		layerURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building layer url")
+
+		misses := cacheMisses(t)
+		resp, err = http.Get(layerURL)
+		checkErr(t, err, "fetching layer")
+		checkResponse(t, "fetching layer", resp, http.StatusOK)
+		resp.Body.Close()
+
+		hit := cacheMisses(t) == misses
+		if hit != (prefetch > 0) {
+			t.Fatalf("unexpected cache hit %v for layer fetch with prefetch %d", hit, prefetch)
+		}
+
+		env.Shutdown()
+	}
+}
+
+func TestTagChanges(t *testing.T) {
+	config := newTestConfig(true)
+	config.Storage["tagevents"] = configuration.Parameters{"limit": 10}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/changes")
+	getChanges := func(msg string, values url.Values) tagChangesAPIResponse {
+		changesURL, err := env.builder.BuildTagChangesURL(imageName, values)
+		checkErr(t, err, "building tag changes url")
+
+		resp, err := http.Get(changesURL)
+		checkErr(t, err, msg)
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusOK)
+
+		var body tagChangesAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: error decoding response: %v", msg, err)
+		}
+		return body
+	}
+
+	checkEvents := func(msg string, events []storage.TagEvent, expected []storage.TagEvent) {
+		if len(events) != len(expected) {
+			t.Fatalf("%s: expected %d events, got %#v", msg, len(expected), events)
+		}
+		for i, event := range events {
+			if event.Action != expected[i].Action || event.Tag != expected[i].Tag || event.Digest != expected[i].Digest {
+				t.Fatalf("%s: unexpected event %d: %#v, expected %#v", msg, i, event, expected[i])
+			}
+		}
+	}
+
+	// Push a tag, repoint it, push another, then delete the manifest the
+	// first tag refers to.
+	first := createRepository(env, t, imageName.Name(), "latest")
+	second := createRepository(env, t, imageName.Name(), "latest")
+	third := createRepository(env, t, imageName.Name(), "stable")
+
+	digestRef, _ := reference.WithDigest(imageName, second)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	expected := []storage.TagEvent{
+		{Action: storage.TagEventCreate, Tag: "latest", Digest: first},
+		{Action: storage.TagEventUpdate, Tag: "latest", Digest: second},
+		{Action: storage.TagEventCreate, Tag: "stable", Digest: third},
+		{Action: storage.TagEventDelete, Tag: "latest"},
+	}
+
+	all := getChanges("fetching all changes", url.Values{})
+	if all.Name != imageName.Name() {
+		t.Fatalf("unexpected name: %q", all.Name)
+	}
+	checkEvents("fetching all changes", all.Events, expected)
+
+	// The cursor advances past the events returned.
+	page := getChanges("fetching first page", url.Values{"n": []string{"2"}})
+	checkEvents("fetching first page", page.Events, expected[:2])
+	page = getChanges("fetching second page", url.Values{"cursor": []string{page.Cursor}})
+	checkEvents("fetching second page", page.Events, expected[2:])
+	if page.Cursor != all.Cursor {
+		t.Fatalf("expected cursor %q after last event, got %q", all.Cursor, page.Cursor)
+	}
+
+	// Nothing has changed since the last cursor.
+	page = getChanges("fetching from last cursor", url.Values{"cursor": []string{all.Cursor}})
+	checkEvents("fetching from last cursor", page.Events, nil)
+	if page.Cursor != all.Cursor {
+		t.Fatalf("expected cursor %q to be unchanged, got %q", all.Cursor, page.Cursor)
+	}
+
+	// Later changes are seen from the cursor.
+	fourth := createRepository(env, t, imageName.Name(), "stable")
+	page = getChanges("fetching later changes", url.Values{"cursor": []string{all.Cursor}})
+	checkEvents("fetching later changes", page.Events, []storage.TagEvent{
+		{Action: storage.TagEventUpdate, Tag: "stable", Digest: fourth},
+	})
+
+	changesURL, err := env.builder.BuildTagChangesURL(imageName, url.Values{"cursor": []string{"bogus"}})
+	checkErr(t, err, "building tag changes url")
+	resp, err = http.Get(changesURL)
+	checkErr(t, err, "fetching changes from invalid cursor")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching changes from invalid cursor", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching changes from invalid cursor", resp, v2.ErrorCodeCursorInvalid)
+}
+
+// errorCodeBlobDenied is returned by denyBlobsHook when rejecting manifests.
+var errorCodeBlobDenied = errcode.Register("test.admission", errcode.ErrorDescriptor{
+	Value:          "BLOB_DENIED",
+	Message:        "manifest references a denied blob",
+	HTTPStatusCode: http.StatusForbidden,
+})
+
+// denyBlobsHook is an admission.Hook rejecting manifests which reference a
+// given blob.
+type denyBlobsHook struct {
+	dgst digest.Digest
+}
+
+func (h *denyBlobsHook) Admit(ctx context.Context, repository reference.Named, dgst digest.Digest, manifest distribution.Manifest) error {
+	for _, desc := range manifest.References() {
+		if desc.Digest == h.dgst {
+			return errorCodeBlobDenied.WithDetail(desc.Digest)
+		}
+	}
+	return nil
+}
+
+func init() {
+	admission.Register("testdenyblobs", func(options map[string]interface{}) (admission.Hook, error) {
+		dgst, _ := options["digest"].(string)
+		return &denyBlobsHook{dgst: digest.Digest(dgst)}, nil
+	})
+}
+
+// TestManifestPutAdmission ensures that manifests rejected by a configured
+// admission hook are not stored, and that the error code of the hook is
+// returned.
+func TestManifestPutAdmission(t *testing.T) {
+	imageName, _ := reference.WithName("foo/admission")
+
+	denied := make([]byte, 4096)
+	if _, err := rand.Read(denied); err != nil {
+		t.Fatalf("error generating random blob: %v", err)
+	}
+	deniedDigest := digest.FromBytes(denied)
+
+	config := newTestConfig(false)
+	config.Middleware = map[string][]configuration.Middleware{
+		"admission": {{
+			Name:    "testdenyblobs",
+			Options: configuration.Parameters{"digest": deniedDigest.String()},
+		}},
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	allowedDigest, _ := pushRandomBlob(t, env, imageName)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, deniedDigest, uploadURLBase, bytes.NewReader(denied))
+
+	newManifest := func(layer digest.Digest) *schema2.Manifest {
+		return &schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeImageConfig,
+				Size:      4096,
+				Digest:    configDigest,
+			},
+			Layers: []distribution.Descriptor{{
+				MediaType: schema2.MediaTypeLayer,
+				Size:      4096,
+				Digest:    layer,
+			}},
+		}
+	}
+
+	for _, tc := range []struct {
+		tag      string
+		layer    digest.Digest
+		expected int
+	}{
+		{tag: "allowed", layer: allowedDigest, expected: http.StatusCreated},
+		{tag: "denied", layer: deniedDigest, expected: http.StatusForbidden},
+	} {
+		tagRef, _ := reference.WithTag(imageName, tc.tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+
+		msg := fmt.Sprintf("putting manifest to tag %q", tc.tag)
+		resp := putManifest(t, msg, manifestURL, schema2.MediaTypeManifest, newManifest(tc.layer))
+		checkResponse(t, msg, resp, tc.expected)
+		if tc.expected == http.StatusForbidden {
+			checkBodyHasErrorCodes(t, msg, resp, errorCodeBlobDenied)
+		}
+		resp.Body.Close()
+
+		msg = fmt.Sprintf("fetching manifest by tag %q", tc.tag)
+		req, _ := http.NewRequest("GET", manifestURL, nil)
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		if tc.expected == http.StatusCreated {
+			checkResponse(t, msg, resp, http.StatusOK)
+		} else {
+			checkResponse(t, msg, resp, http.StatusNotFound)
+		}
+		resp.Body.Close()
+	}
+}
+
+type slowDriverFactory struct {
+	driver storagedriver.StorageDriver
+}
+
+func (factory *slowDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+// slowDriver delays reads and stats by its latency, unless their context is
+// done first.
+type slowDriver struct {
+	storagedriver.StorageDriver
+
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+func (d *slowDriver) setLatency(latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.latency = latency
+}
+
+func (d *slowDriver) delay(ctx context.Context) error {
+	d.mu.Lock()
+	latency := d.latency
+	d.mu.Unlock()
+
+	select {
+	case <-time.After(latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *slowDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if err := d.delay(ctx); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *slowDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if err := d.delay(ctx); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+// TestStorageOperationBudget ensures that a request whose storage operations
+// are slow fails with 503 once its budget is spent, rather than waiting on
+// each operation in turn.
+func TestStorageOperationBudget(t *testing.T) {
+	driver := &slowDriver{StorageDriver: inmemory.New()}
+	factory.Register("slowdriver", &slowDriverFactory{driver: &base.Base{StorageDriver: driver}})
+
+	config := newTestConfig(false)
+	delete(config.Storage, "testdriver")
+	config.Storage["slowdriver"] = configuration.Parameters{}
+
+	// Content is pushed through an app without a budget, so that pushing
+	// the large layer does not exceed it.
+	env := newTestEnvWithConfig(t, &config)
+	imageName, _ := reference.WithName("foo/budget")
+	tag := "latest"
+	createRepository(env, t, imageName.Name(), tag)
+	env.Shutdown()
+
+	const budget = 300 * time.Millisecond
+	config.Storage["operations"] = configuration.Parameters{
+		"budget":      budget.String(),
+		"anticipated": 4,
+	}
+	env = newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	tagRef, _ := reference.WithTag(imageName, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Each operation would take longer than the whole budget.
+	driver.setLatency(10 * time.Second)
+
+	start := time.Now()
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	elapsed := time.Since(start)
+
+	checkResponse(t, "fetching manifest from slow storage", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "fetching manifest from slow storage", resp, errcode.ErrorCodeUnavailable)
+	if elapsed > 2*budget {
+		t.Fatalf("request failed after %v, beyond its budget of %v", elapsed, budget)
+	}
+}
+
+// TestManifestExpiry ensures that manifests, along with their descriptors and
+// configs, are no longer served once their TTL has passed, and are removed by
+// the expiry sweep.
+func TestManifestExpiry(t *testing.T) {
+	imageName, _ := reference.WithName("foo/expiry")
+
+	config := newTestConfig(false)
+	config.Policy.Repository.ManifestTTL = time.Hour
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+	manifest := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+
+	tagRef, _ := reference.WithTag(imageName, "preview")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest", tagURL, schema2.MediaTypeManifest, manifest)
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	checkErr(t, err, "parsing manifest digest")
+	resp.Body.Close()
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	digestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	fetch := func(msg, u string, expected int) {
+		req, _ := http.NewRequest("GET", u, nil)
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, expected)
+		if expected == http.StatusNotFound {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeManifestUnknown)
+		}
+	}
+
+	descriptorURL, err := env.builder.BuildDescriptorURL(tagRef)
+	checkErr(t, err, "building descriptor url")
+	configURL, err := env.builder.BuildManifestConfigURL(tagRef)
+	checkErr(t, err, "building manifest config url")
+
+	fetch("fetching manifest by tag", tagURL, http.StatusOK)
+	fetch("fetching manifest by digest", digestURL, http.StatusOK)
+	fetch("fetching descriptor by tag", descriptorURL, http.StatusOK)
+	fetch("fetching config by tag", configURL, http.StatusOK)
+
+	later := time.Now().Add(2 * time.Hour)
+	env.app.now = func() time.Time { return later }
+
+	fetch("fetching expired manifest by tag", tagURL, http.StatusNotFound)
+	fetch("fetching expired manifest by digest", digestURL, http.StatusNotFound)
+	fetch("fetching descriptor of expired manifest by tag", descriptorURL, http.StatusNotFound)
+	fetch("fetching config of expired manifest by tag", configURL, http.StatusNotFound)
+
+	registry, err := storage.NewRegistry(env.ctx, env.app.driver)
+	checkErr(t, err, "creating registry")
+	expired, errs := storage.ExpireManifests(env.ctx, env.app.driver, registry, func(string) (time.Duration, error) {
+		return time.Hour, nil
+	}, later)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors expiring manifests: %v", errs)
+	}
+	if len(expired) != 1 || expired[0].Digest != dgst || len(expired[0].Tags) != 1 || expired[0].Tags[0] != "preview" {
+		t.Fatalf("expected %s tagged preview to expire, got %v", dgst, expired)
+	}
+
+	pushedAt, err := storage.ManifestPushedAt(env.ctx, env.app.driver, imageName.Name(), dgst)
+	checkErr(t, err, "getting manifest push time")
+	if !pushedAt.IsZero() {
+		t.Fatalf("expected expired manifest to be removed")
+	}
+
+	tags, err := storage.TagPushedAt(env.ctx, env.app.driver, imageName.Name(), "preview")
+	checkErr(t, err, "getting tag push time")
+	if !tags.IsZero() {
+		t.Fatalf("expected tag of expired manifest to be removed")
+	}
+}
+
+type tagFailingDriverFactory struct {
+	driver storagedriver.StorageDriver
+}
+
+func (factory *tagFailingDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+// tagFailingDriver fails writes of the current link of tags while failing
+// is set, as though the registry crashed after storing a manifest but before
+// tagging it.
+type tagFailingDriver struct {
+	storagedriver.StorageDriver
+
+	mu      sync.Mutex
+	failing bool
+}
+
+func (d *tagFailingDriver) setFailing(failing bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failing = failing
+}
+
+func (d *tagFailingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	d.mu.Lock()
+	failing := d.failing
+	d.mu.Unlock()
+
+	if failing && strings.HasSuffix(path, "/current/link") {
+		return fmt.Errorf("simulated crash writing %s", path)
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// TestManifestPutAtomicRepositoryCreation ensures that, with atomic
+// repository creation, a repository whose first manifest put fails before the
+// manifest is tagged is not listed in the catalog until a put succeeds.
+func TestManifestPutAtomicRepositoryCreation(t *testing.T) {
+	driver := &tagFailingDriver{StorageDriver: inmemory.New()}
+	factory.Register("tagfailingdriver", &tagFailingDriverFactory{driver: driver})
+
+	config := newTestConfig(false)
+	delete(config.Storage, "testdriver")
+	config.Storage["tagfailingdriver"] = configuration.Parameters{}
+	config.Storage["repositories"] = configuration.Parameters{"atomiccreate": true}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/created")
+	configDigest, _ := pushRandomBlob(t, env, imageName)
+	layerDigest, _ := pushRandomBlob(t, env, imageName)
+
+	m := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      4096,
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      4096,
+			Digest:    layerDigest,
+		}},
+	}
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	catalog := func() []string {
+		catalogURL, err := env.builder.BuildCatalogURL()
+		checkErr(t, err, "building catalog url")
+
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching catalog", resp, http.StatusOK)
+
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		return ctlg.Repositories
+	}
+
+	// The manifest is stored, but tagging it fails.
+	driver.setFailing(true)
+	resp := putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, m)
+	checkResponse(t, "putting manifest", resp, http.StatusInternalServerError)
+	resp.Body.Close()
+
+	if repositories := catalog(); len(repositories) != 0 {
+		t.Fatalf("unexpected repositories listed after failed creation: %v", repositories)
+	}
+
+	// A later put completes the creation.
+	driver.setFailing(false)
+	resp = putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, m)
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	resp.Body.Close()
+
+	if repositories := catalog(); !reflect.DeepEqual(repositories, []string{imageName.Name()}) {
+		t.Fatalf("unexpected repositories listed after creation: %v", repositories)
+	}
+}
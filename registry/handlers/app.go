@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/distribution"
@@ -23,6 +24,7 @@ import (
 	prometheus "github.com/docker/distribution/metrics"
 	"github.com/docker/distribution/notifications"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/admission"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/auth"
@@ -33,6 +35,7 @@ import (
 	memorycache "github.com/docker/distribution/registry/storage/cache/memory"
 	rediscache "github.com/docker/distribution/registry/storage/cache/redis"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/factory"
 	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
 	"github.com/docker/distribution/version"
@@ -51,6 +54,10 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultStorageHealthPath is the storage path of the marker checked by the
+// storage driver health check if the configuration does not specify it.
+const defaultStorageHealthPath = "/docker/registry/v2/health"
+
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
@@ -87,6 +94,90 @@ type App struct {
 
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
+
+	// now returns the current time, against which the expiry of manifests
+	// is judged.
+	now func() time.Time
+
+	// deleteEnabled is true if manifests and blobs may be deleted
+	deleteEnabled bool
+
+	// tagHistoryLimit, if positive, is the number of digests recorded in
+	// the history of each tag fetched
+	tagHistoryLimit int
+
+	// blobIndex, if not nil, is the maintained index of the blob store, from
+	// which the usage of the registry is served
+	blobIndex *storage.BlobIndex
+
+	// tagEventsEnabled is true if changes to tags are recorded in the tag
+	// event log of each repository
+	tagEventsEnabled bool
+
+	// manifestRedirectSize, if positive, is the size in bytes above which
+	// manifests are served by redirecting to the storage backend
+	manifestRedirectSize int
+
+	// maxStorageOperations, if positive, is the maximum number of storage
+	// operations a single request may perform
+	maxStorageOperations int
+
+	// atomicRepositoryCreation, if true, keeps a repository out of the
+	// catalog until its first manifest put has succeeded
+	atomicRepositoryCreation bool
+
+	// storageBudget, if positive, is the time a request may spend on
+	// storage operations, shared between storageBudgetOperations
+	// operations.
+	storageBudget           time.Duration
+	storageBudgetOperations int
+
+	// manifestMediaTypes, if not empty, lists the media types of manifests
+	// which may be pushed
+	manifestMediaTypes []string
+
+	// tagPattern, if set, matches the tags manifests may be pushed to
+	tagPattern *regexp.Regexp
+
+	// nameMaxComponents, if positive, is the maximum number of path
+	// components of the names of repositories.
+	nameMaxComponents int
+
+	// tagMaxLength, if positive, is the maximum length of the tags
+	// manifests may be pushed to
+	tagMaxLength int
+
+	// rejectDigestLikeTags rejects tags which could be mistaken for a
+	// digest
+	rejectDigestLikeTags bool
+
+	// rejectMissingContentType rejects manifests pushed without a
+	// Content-Type, rather than treating them as schema1 manifests.
+	rejectMissingContentType bool
+
+	// manifestMaxDepth and manifestMaxElements, if positive, limit the
+	// nesting depth and number of JSON elements of pushed manifests
+	manifestMaxDepth    int
+	manifestMaxElements int
+
+	// storageRoutes store the repositories under some name prefixes
+	// with drivers other than driver
+	storageRoutes storageRoutes
+
+	// layerPrefetchConcurrency, if positive, is the number of blobs
+	// referenced by a fetched manifest whose descriptors are prefetched
+	// into the blob descriptor cache at once
+	layerPrefetchConcurrency int
+
+	// manifestVerifications, if set, bounds the number of manifest pushes
+	// verified concurrently
+	manifestVerifications *verificationLimiter
+
+	// auditLog, if set, records manifest and blob deletes
+	auditLog *auditLog
+
+	// repositoryPolicies caches the policy overrides of repositories
+	repositoryPolicies *repositoryPolicies
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -98,6 +189,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		Context: ctx,
 		router:  v2.RouterWithPrefix(config.HTTP.Prefix),
 		isCache: config.Proxy.RemoteURL != "",
+		now:     time.Now,
 	}
 
 	// Register the handler dispatchers.
@@ -105,11 +197,21 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		return http.HandlerFunc(apiBase)
 	})
 	app.register(v2.RouteNameManifest, manifestDispatcher)
+	app.register(v2.RouteNameDescriptor, descriptorDispatcher)
+	app.register(v2.RouteNameManifestConfig, configDispatcher)
+	app.register(v2.RouteNameDefaultManifest, defaultManifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
+	app.register(v2.RouteNameCapabilities, capabilitiesDispatcher)
+	app.register(v2.RouteNameCatalogUsage, catalogUsageDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	app.register(v2.RouteNameTagChanges, tagChangesDispatcher)
+	app.register(v2.RouteNameTagAlias, tagAliasDispatcher)
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameBlobUploadProgress, uploadProgressDispatcher)
+	app.register(v2.RouteNameRepositoryRegistration, registrationDispatcher)
+	app.register(v2.RouteNameRepositoryPolicy, policyDispatcher)
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -118,6 +220,12 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 	storageParams["useragent"] = fmt.Sprintf("docker-distribution/%s %s", version.Version, runtime.Version())
 
+	if buckets := config.HTTP.Debug.Prometheus.StorageLatencyBuckets; len(buckets) > 0 {
+		if err := base.SetLatencyBuckets(buckets); err != nil {
+			panic(err)
+		}
+	}
+
 	var err error
 	app.driver, err = factory.Create(config.Storage.Type(), storageParams)
 	if err != nil {
@@ -127,7 +235,16 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		panic(err)
 	}
 
+	if routes, ok := config.Storage["routes"]; ok {
+		app.storageRoutes, err = parseStorageRoutes(routes)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	purgeConfig := uploadPurgeDefaultConfig()
+	var scrubConfig, compactConfig, expiryConfig map[interface{}]interface{}
+	var migrateLayout bool
 	if mc, ok := config.Storage["maintenance"]; ok {
 		if v, ok := mc["uploadpurging"]; ok {
 			purgeConfig, ok = v.(map[interface{}]interface{})
@@ -135,6 +252,31 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("uploadpurging config key must contain additional keys")
 			}
 		}
+		if v, ok := mc["scrubbing"]; ok {
+			scrubConfig, ok = v.(map[interface{}]interface{})
+			if !ok {
+				panic("scrubbing config key must contain additional keys")
+			}
+		}
+		if v, ok := mc["uploadcompaction"]; ok {
+			compactConfig, ok = v.(map[interface{}]interface{})
+			if !ok {
+				panic("uploadcompaction config key must contain additional keys")
+			}
+		}
+		if v, ok := mc["manifestexpiry"]; ok {
+			expiryConfig, ok = v.(map[interface{}]interface{})
+			if !ok {
+				panic("manifestexpiry config key must contain additional keys")
+			}
+		}
+		if v, ok := mc["layoutmigration"]; ok {
+			layoutMigration, ok := v.(map[interface{}]interface{})
+			if !ok {
+				panic("layoutmigration config key must contain additional keys")
+			}
+			migrateLayout = layoutMigration["enabled"] == true
+		}
 		if v, ok := mc["readonly"]; ok {
 			readOnly, ok := v.(map[interface{}]interface{})
 			if !ok {
@@ -149,18 +291,49 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	drivers := []storagedriver.StorageDriver{app.driver}
+	for _, route := range app.storageRoutes {
+		drivers = append(drivers, route.driver)
+	}
+	for _, driver := range drivers {
+		if migrateLayout {
+			// Blobs are unreadable until moved, so the migration completes
+			// before requests are served.
+			if _, err := storage.MigrateLayout(app, driver); err != nil {
+				panic(fmt.Sprintf("could not migrate storage layout: %v", err))
+			}
+		}
+		startUploadPurger(app, driver, dcontext.GetLogger(app), purgeConfig)
+		startBlobScrubber(app, driver, dcontext.GetLogger(app), scrubConfig)
+		startUploadCompactor(app, driver, dcontext.GetLogger(app), compactConfig)
+		startManifestExpirer(app, driver, dcontext.GetLogger(app), expiryConfig)
+	}
 
 	app.driver, err = applyStorageMiddleware(app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
 	}
+	for _, route := range app.storageRoutes {
+		route.driver, err = applyStorageMiddleware(route.driver, config.Middleware["storage"])
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	app.repositoryPolicies = newRepositoryPolicies(app.driverFor, config.Policy.Repository.OverrideCacheTTL)
 
 	app.configureSecret(config)
 	app.configureEvents(config)
 	app.configureRedis(config)
 	app.configureLogHook(config)
 
+	if config.Log.Audit.Path != "" {
+		app.auditLog, err = newAuditLog(config.Log.Audit.Path, config.Log.Audit.Pulls)
+		if err != nil {
+			panic(fmt.Sprintf("could not open audit log: %v", err))
+		}
+	}
+
 	options := registrymiddleware.GetRegistryOptions()
 	if config.Compatibility.Schema1.TrustKey != "" {
 		app.trustKey, err = libtrust.LoadKeyFile(config.Compatibility.Schema1.TrustKey)
@@ -200,10 +373,214 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		if ok {
 			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
 				options = append(options, storage.EnableDelete)
+				app.deleteEnabled = true
+			}
+		}
+	}
+
+	if c, ok := config.Storage["compression"]; ok {
+		m, ok := c["manifests"]
+		if ok {
+			if compressManifests, ok := m.(bool); ok && compressManifests {
+				options = append(options, storage.EnableManifestCompression)
+			}
+		}
+	}
+
+	if th, ok := config.Storage["taghistory"]; ok {
+		switch limit := th["limit"].(type) {
+		case int:
+			if limit < 0 {
+				panic(fmt.Sprintf("invalid taghistory limit: %d", limit))
+			}
+			app.tagHistoryLimit = limit
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for taghistory limit: %#v", th["limit"]))
+		}
+	}
+
+	if te, ok := config.Storage["tagevents"]; ok {
+		switch limit := te["limit"].(type) {
+		case int:
+			options = append(options, storage.TagEventLog(limit))
+			app.tagEventsEnabled = true
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for tagevents limit: %#v", te["limit"]))
+		}
+	}
+
+	if bl, ok := config.Storage["bloblinks"]; ok {
+		switch limit := bl["limit"].(type) {
+		case int:
+			options = append(options, storage.BlobLinkLimit(limit))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for bloblinks limit: %#v", bl["limit"]))
+		}
+	}
+
+	if rb, ok := config.Storage["readbuffer"]; ok {
+		switch size := rb["size"].(type) {
+		case int:
+			options = append(options, storage.ReadBufferSize(size))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for readbuffer size: %#v", rb["size"]))
+		}
+		switch size := rb["copysize"].(type) {
+		case int:
+			options = append(options, storage.CopyBufferSize(size))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for readbuffer copysize: %#v", rb["copysize"]))
+		}
+	}
+
+	if il, ok := config.Storage["inline"]; ok {
+		switch size := il["maxsize"].(type) {
+		case int:
+			options = append(options, storage.InlineBlobSize(int64(size)))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for inline maxsize: %#v", il["maxsize"]))
+		}
+	}
+
+	if bi, ok := config.Storage["blobindex"]; ok {
+		switch enabled := bi["enabled"].(type) {
+		case bool:
+			if enabled {
+				options = append(options, storage.EnableBlobIndex)
+				app.blobIndex = storage.NewBlobIndex(app.driver)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for blobindex enabled: %#v", bi["enabled"]))
+		}
+	}
+
+	if cd, ok := config.Storage["contentdisposition"]; ok {
+		switch enabled := cd["enabled"].(type) {
+		case bool:
+			if enabled {
+				options = append(options, storage.EnableContentDisposition)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for contentdisposition enabled: %#v", cd["enabled"]))
+		}
+	}
+
+	if mr, ok := config.Storage["multipartranges"]; ok {
+		switch enabled := mr["enabled"].(type) {
+		case bool:
+			if enabled {
+				options = append(options, storage.EnableMultipartRanges)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for multipartranges enabled: %#v", mr["enabled"]))
+		}
+	}
+
+	if ct, ok := config.Storage["contenttype"]; ok {
+		switch defaultType := ct["default"].(type) {
+		case string:
+			options = append(options, storage.DefaultBlobContentType(defaultType))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for contenttype default: %#v", ct["default"]))
+		}
+
+		switch sniff := ct["sniff"].(type) {
+		case bool:
+			if sniff {
+				options = append(options, storage.EnableContentTypeSniffing)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for contenttype sniff: %#v", ct["sniff"]))
+		}
+
+		switch preserve := ct["preserve"].(type) {
+		case bool:
+			if preserve {
+				options = append(options, storage.PreserveBlobMediaTypes)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for contenttype preserve: %#v", ct["preserve"]))
+		}
+	}
+
+	if ta, ok := config.Storage["tagaliases"]; ok {
+		switch policy := ta["ondelete"].(type) {
+		case string:
+			options = append(options, storage.TagAliasDeletes(policy))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for tagaliases ondelete: %#v", ta["ondelete"]))
+		}
+	}
+
+	if repos, ok := config.Storage["repositories"]; ok {
+		switch autoCreate := repos["autocreate"].(type) {
+		case bool:
+			if !autoCreate {
+				options = append(options, storage.DisableRepositoryAutoCreate)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for repositories autocreate: %#v", repos["autocreate"]))
+		}
+		switch atomicCreate := repos["atomiccreate"].(type) {
+		case bool:
+			if atomicCreate {
+				app.atomicRepositoryCreation = true
+				options = append(options, storage.EnableAtomicRepositoryCreation)
+			}
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for repositories atomiccreate: %#v", repos["atomiccreate"]))
+		}
+	}
+
+	if ops, ok := config.Storage["operations"]; ok {
+		switch limit := ops["maxperrequest"].(type) {
+		case int:
+			app.maxStorageOperations = limit
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for operations maxperrequest: %#v", ops["maxperrequest"]))
+		}
+
+		switch budget := ops["budget"].(type) {
+		case string:
+			d, err := time.ParseDuration(budget)
+			if err != nil {
+				panic(fmt.Sprintf("invalid operations budget: %v", err))
 			}
+			app.storageBudget = d
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for operations budget: %#v", ops["budget"]))
+		}
+
+		switch anticipated := ops["anticipated"].(type) {
+		case int:
+			app.storageBudgetOperations = anticipated
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for operations anticipated: %#v", ops["anticipated"]))
 		}
 	}
 
+	if config.Quota.Default > 0 || len(config.Quota.Repositories) > 0 {
+		options = append(options, storage.RepositoryQuotas(config.Quota.Default, config.Quota.Repositories))
+	}
+
 	// configure redirects
 	var redirectDisabled bool
 	if redirectConfig, ok := config.Storage["redirect"]; ok {
@@ -211,12 +588,33 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		switch v := v.(type) {
 		case bool:
 			redirectDisabled = v
+		case nil:
 		default:
 			panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
 		}
+
+		switch size := redirectConfig["manifestsize"].(type) {
+		case int:
+			if size < 0 {
+				panic(fmt.Sprintf("invalid redirect manifestsize: %d", size))
+			}
+			app.manifestRedirectSize = size
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for redirect manifestsize: %#v", redirectConfig["manifestsize"]))
+		}
+
+		switch policy := redirectConfig["ranges"].(type) {
+		case string:
+			options = append(options, storage.RedirectRanges(policy))
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for redirect ranges: %#v", redirectConfig["ranges"]))
+		}
 	}
 	if redirectDisabled {
 		dcontext.GetLogger(app).Infof("backend redirection disabled")
+		app.manifestRedirectSize = 0
 	} else {
 		options = append(options, storage.EnableRedirect)
 	}
@@ -256,6 +654,78 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				options = append(options, storage.ManifestURLsDenyRegexp(re))
 			}
 		}
+		if config.Validation.Manifests.URLs.RequireHTTPS {
+			options = append(options, storage.ManifestURLsRequireHTTPS)
+		}
+
+		if config.Validation.Manifests.Digests.Strict {
+			options = append(options, storage.EnableStrictDigests)
+		}
+
+		if config.Validation.Manifests.Canonical {
+			options = append(options, storage.RequireCanonicalManifests)
+		}
+
+		if config.Validation.Manifests.LayerOrder {
+			options = append(options, storage.EnforceLayerOrder)
+		}
+
+		if len(config.Validation.Manifests.Signatures.Algorithms) > 0 {
+			options = append(options, storage.AllowedSignatureAlgorithms(config.Validation.Manifests.Signatures.Algorithms))
+		}
+
+		if config.Validation.Manifests.Signatures.RequireChildren {
+			options = append(options, storage.RequireSignedChildren)
+		}
+
+		if config.Validation.Uploads.ValidateArchives {
+			options = append(options, storage.ValidateLayerArchives)
+		}
+
+		if config.Validation.Uploads.RunningDigest {
+			options = append(options, storage.RequireRunningDigest)
+		}
+
+		if config.Validation.Uploads.VerifyWrites {
+			options = append(options, storage.VerifyBlobWrites)
+		}
+
+		if config.Validation.Uploads.Deduplicate {
+			options = append(options, storage.DeduplicateUploads)
+		}
+
+		if len(config.Validation.Manifests.Platforms.Allow) > 0 {
+			options = append(options, storage.AllowedPlatforms(config.Validation.Manifests.Platforms.Allow))
+		}
+
+		app.manifestMediaTypes = config.Validation.Manifests.MediaTypes.Allow
+
+		if pattern := config.Validation.Manifests.Tags.Pattern; pattern != "" {
+			app.tagPattern = regexp.MustCompile(fmt.Sprintf("^(?:%s)$", pattern))
+		}
+		app.nameMaxComponents = config.Validation.Names.MaxComponents
+		app.tagMaxLength = config.Validation.Manifests.Tags.MaxLength
+		app.rejectDigestLikeTags = config.Validation.Manifests.Tags.RejectDigestLike
+		app.manifestMaxDepth = config.Validation.Manifests.JSON.MaxDepth
+		app.manifestMaxElements = config.Validation.Manifests.JSON.MaxElements
+
+		switch config.Validation.Manifests.MissingContentType {
+		case "", "schema1":
+		case "reject":
+			app.rejectMissingContentType = true
+		default:
+			panic(fmt.Sprintf("invalid manifests missingcontenttype: %q", config.Validation.Manifests.MissingContentType))
+		}
+	}
+
+	if limit := config.Validation.Manifests.Concurrency.Limit; limit > 0 {
+		app.manifestVerifications = newVerificationLimiter(limit, config.Validation.Manifests.Concurrency.MaxQueued)
+	}
+
+	if hooks, err := admissionHooks(config.Middleware["admission"]); err != nil {
+		panic(err)
+	} else if len(hooks) > 0 {
+		options = append(options, storage.AdmitManifests(hooks...))
 	}
 
 	// configure storage caches
@@ -291,6 +761,18 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				dcontext.GetLogger(app).Warnf("unknown cache type %q, caching disabled", config.Storage["cache"])
 			}
 		}
+
+		switch concurrency := cc["prefetchlayers"].(type) {
+		case int:
+			if app.registry == nil {
+				dcontext.GetLogger(app).Warnf("cache prefetchlayers requires a blobdescriptor cache, prefetching disabled")
+				break
+			}
+			app.layerPrefetchConcurrency = concurrency
+		case nil:
+		default:
+			panic(fmt.Sprintf("invalid type for cache prefetchlayers: %#v", cc["prefetchlayers"]))
+		}
 	}
 
 	if app.registry == nil {
@@ -301,6 +783,26 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	if len(app.storageRoutes) > 0 {
+		if config.Proxy.RemoteURL != "" {
+			panic("storage routes cannot be configured for a proxy cache")
+		}
+
+		// Blob descriptors cached for one backend must not be served for
+		// another, so each route has a cache of its own, if in memory.
+		for _, route := range app.storageRoutes {
+			routeOptions := options
+			if cc, ok := config.Storage["cache"]; ok && (cc["blobdescriptor"] == "inmemory" || cc["layerinfo"] == "inmemory") {
+				routeOptions = append(routeOptions[:len(routeOptions):len(routeOptions)], storage.BlobDescriptorCacheProvider(memorycache.NewInMemoryBlobDescriptorCacheProvider()))
+			}
+			route.registry, err = storage.NewRegistry(app, route.driver, routeOptions...)
+			if err != nil {
+				panic("could not create registry: " + err.Error())
+			}
+		}
+		app.registry = &routedNamespace{Namespace: app.registry, routes: app.storageRoutes}
+	}
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
@@ -335,6 +837,51 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	return app
 }
 
+// storageHealthProbe checks the health of a storage driver by the presence of
+// a marker it writes, so that the check does not depend on any content and
+// shows that the driver accepts writes as well as reads.
+type storageHealthProbe struct {
+	driver storagedriver.StorageDriver
+	path   string
+
+	mu      sync.Mutex
+	written bool
+}
+
+// write writes the marker of the probe.
+func (p *storageHealthProbe) write(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.driver.PutContent(ctx, p.path, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return err
+	}
+	p.written = true
+	return nil
+}
+
+// check checks that the marker of the probe is present, writing it first if
+// it has yet to be written, or has since disappeared.
+func (p *storageHealthProbe) check(ctx context.Context) error {
+	p.mu.Lock()
+	written := p.written
+	p.mu.Unlock()
+
+	if !written {
+		if err := p.write(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, err := p.driver.Stat(ctx, p.path)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		p.mu.Lock()
+		p.written = false
+		p.mu.Unlock()
+	}
+	return err
+}
+
 // RegisterHealthChecks is an awful hack to defer health check registration
 // control to callers. This should only ever be called once per registry
 // process, typically in a main function. The correct way would be register
@@ -357,12 +904,20 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 			interval = defaultCheckInterval
 		}
 
+		probePath := app.Config.Health.StorageDriver.Path
+		if probePath == "" {
+			probePath = defaultStorageHealthPath
+		}
+		if !storagedriver.PathRegexp.MatchString(probePath) {
+			panic(fmt.Sprintf("invalid storage driver health check path %q", probePath))
+		}
+
+		probe := &storageHealthProbe{driver: app.driver, path: probePath}
+		if err := probe.write(app); err != nil {
+			dcontext.GetLogger(app).Errorf("error writing storage driver health marker %s: %v", probePath, err)
+		}
 		storageDriverCheck := func() error {
-			_, err := app.driver.Stat(app, "/") // "/" should always exist
-			if _, ok := err.(storagedriver.PathNotFoundError); ok {
-				err = nil // pass this through, backend is responding, but this path doesn't exist.
-			}
-			return err
+			return probe.check(app)
 		}
 
 		if app.Config.Health.StorageDriver.Threshold != 0 {
@@ -425,7 +980,18 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 // passed through the application filters and context will be constructed at
 // request time.
 func (app *App) register(routeName string, dispatch dispatchFunc) {
-	handler := app.dispatcher(dispatch)
+	dispatcher := app.dispatcher(dispatch)
+	options := app.optionsHandler(routeName)
+
+	// OPTIONS is answered without dispatching, so that clients can discover
+	// the methods allowed on a route before authenticating.
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			options.ServeHTTP(w, r)
+			return
+		}
+		dispatcher.ServeHTTP(w, r)
+	})
 
 	// Chain the handler with prometheus instrumented handler
 	if app.Config.HTTP.Debug.Prometheus.Enabled {
@@ -462,6 +1028,9 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 			Headers:           endpoint.Headers,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
+			Retries:           endpoint.Retries,
+			DeadLetter:        endpoint.DeadLetter,
+			QueueSize:         endpoint.QueueSize,
 		})
 
 		sinks = append(sinks, endpoint)
@@ -661,6 +1230,12 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		context := app.context(w, r)
+		if app.maxStorageOperations > 0 {
+			context.Context = storagedriver.WithOperationLimit(context.Context, int64(app.maxStorageOperations))
+		}
+		if app.storageBudget > 0 {
+			context.Context = storagedriver.WithOperationBudget(context.Context, app.storageBudget, app.storageBudgetOperations)
+		}
 
 		if err := app.authorized(w, r, context); err != nil {
 			dcontext.GetLogger(context).Warnf("error authorizing context: %v", err)
@@ -673,14 +1248,14 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// sync up context on the request.
 		r = r.WithContext(context)
 
-		if app.nameRequired(r) {
-			nameRef, err := reference.WithName(getName(context))
+		// The repository registration and policy routes name a repository
+		// which need not be available, so the repository is not resolved
+		// for them.
+		if app.nameRequired(r) && !routeIs(r, v2.RouteNameRepositoryRegistration) && !routeIs(r, v2.RouteNameRepositoryPolicy) {
+			nameRef, err := app.parseName(getName(context))
 			if err != nil {
 				dcontext.GetLogger(context).Errorf("error parsing reference from context: %v", err)
-				context.Errors = append(context.Errors, distribution.ErrRepositoryNameInvalid{
-					Name:   getName(context),
-					Reason: err,
-				})
+				context.Errors = append(context.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
 				if err := errcode.ServeJSON(w, context.Errors); err != nil {
 					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 				}
@@ -725,6 +1300,19 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		dispatch(context, r).ServeHTTP(w, r)
+
+		// Storage errors surface from handlers in many forms, so a request
+		// cut short by the operation limit is reported uniformly here, unless
+		// the handler has already started its response.
+		status, _ := context.Value("http.response.status").(int)
+		if status == 0 && storagedriver.OperationLimitExceeded(context) {
+			context.Errors = errcode.Errors{errcode.ErrorCodeUnavailable.WithDetail(
+				fmt.Sprintf("request exceeded the limit of %d storage operations", app.maxStorageOperations))}
+		} else if status == 0 && storagedriver.OperationBudgetExceeded(context) {
+			context.Errors = errcode.Errors{errcode.ErrorCodeUnavailable.WithDetail(
+				fmt.Sprintf("request exceeded its storage time budget of %v", app.storageBudget))}
+		}
+
 		// Automated error response handling here. Handlers may return their
 		// own errors if they need different behavior (such as range errors
 		// for layer upload).
@@ -815,32 +1403,21 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 		return nil // access controller is not enabled.
 	}
 
-	var accessRecords []auth.Access
-
-	if repo != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
-		if fromRepo := r.FormValue("from"); fromRepo != "" {
-			// mounting a blob from one repository to another requires pull (GET)
-			// access to the source repository.
-			accessRecords = appendAccessRecords(accessRecords, "GET", fromRepo)
-		}
-	} else {
-		// Only allow the name not to be set on the base route.
-		if app.nameRequired(r) {
-			// For this to be properly secured, repo must always be set for a
-			// resource that may make a modification. The only condition under
-			// which name is not set and we still allow access is when the
-			// base route is accessed. This section prevents us from making
-			// that mistake elsewhere in the code, allowing any operation to
-			// proceed.
-			if err := errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized); err != nil {
-				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
-			}
-			return fmt.Errorf("forbidden: no repository name")
+	if repo == "" && app.nameRequired(r) {
+		// For this to be properly secured, repo must always be set for a
+		// resource that may make a modification. The only condition under
+		// which name is not set and we still allow access is when the
+		// base route is accessed. This section prevents us from making
+		// that mistake elsewhere in the code, allowing any operation to
+		// proceed.
+		if err := errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized); err != nil {
+			dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 		}
-		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		return fmt.Errorf("forbidden: no repository name")
 	}
 
+	accessRecords := app.accessRecords(r, r.Method, repo)
+
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
 	if err != nil {
 		switch err := err.(type) {
@@ -871,6 +1448,45 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	return nil
 }
 
+// accessRecords returns the access required to make a request to the route
+// matched by r with the given method, on the named repository.
+func (app *App) accessRecords(r *http.Request, method, repo string) []auth.Access {
+	var accessRecords []auth.Access
+
+	if repo != "" && (routeIs(r, v2.RouteNameRepositoryRegistration) || routeIs(r, v2.RouteNameRepositoryPolicy)) {
+		// Registering repositories and setting their policies are
+		// administrative operations, rather than operations on the named
+		// repository.
+		accessRecords = append(accessRecords, auth.Access{
+			Resource: auth.Resource{
+				Type: "registry",
+				Name: "repositories",
+			},
+			Action: "*",
+		})
+	} else if repo != "" && method == "HEAD" && routeIs(r, v2.RouteNameBlob) && app.Config.Policy.Repository.ExistsScope {
+		// Checking whether a blob exists reveals none of its content.
+		accessRecords = append(accessRecords, auth.Access{
+			Resource: auth.Resource{
+				Type: "repository",
+				Name: repo,
+			},
+			Action: "exists",
+		})
+	} else if repo != "" {
+		accessRecords = appendAccessRecords(accessRecords, method, repo)
+		if fromRepo := r.FormValue("from"); fromRepo != "" {
+			// mounting a blob from one repository to another requires pull (GET)
+			// access to the source repository.
+			accessRecords = appendAccessRecords(accessRecords, "GET", fromRepo)
+		}
+	} else {
+		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+	}
+
+	return accessRecords
+}
+
 // eventBridge returns a bridge for the current request, configured with the
 // correct actor and source.
 func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listener {
@@ -889,7 +1505,32 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameCapabilities && routeName != v2.RouteNameCatalogUsage
+}
+
+// parseName parses the name of a repository, returning
+// distribution.ErrRepositoryNameInvalid if it is not valid or has more path
+// components than are allowed.
+func (app *App) parseName(name string) (reference.Named, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, distribution.ErrRepositoryNameInvalid{Name: name, Reason: err}
+	}
+	if max := app.nameMaxComponents; max > 0 {
+		if components := strings.Count(name, "/") + 1; components > max {
+			return nil, distribution.ErrRepositoryNameInvalid{
+				Name:   name,
+				Reason: fmt.Errorf("name has %d path components, exceeding the maximum of %d", components, max),
+			}
+		}
+	}
+	return named, nil
+}
+
+// routeIs reports whether the request was routed to the named route.
+func routeIs(r *http.Request, routeName string) bool {
+	route := mux.CurrentRoute(r)
+	return route != nil && route.GetName() == routeName
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -942,7 +1583,7 @@ func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []a
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	if routeName == v2.RouteNameCatalog || routeName == v2.RouteNameCatalogUsage {
 		resource := auth.Resource{
 			Type: "registry",
 			Name: "catalog",
@@ -982,6 +1623,23 @@ func applyRepoMiddleware(ctx context.Context, repository distribution.Repository
 	return repository, nil
 }
 
+// admissionHooks constructs the configured manifest admission hooks, skipping
+// those disabled.
+func admissionHooks(middlewares []configuration.Middleware) ([]admission.Hook, error) {
+	var hooks []admission.Hook
+	for _, mw := range middlewares {
+		if mw.Disabled {
+			continue
+		}
+		hook, err := admission.Get(mw.Name, mw.Options)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure admission hook (%s): %v", mw.Name, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
 // applyStorageMiddleware wraps a storage driver with the configured middlewares
 func applyStorageMiddleware(driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, error) {
 	for _, mw := range middlewares {
@@ -1073,3 +1731,170 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		}
 	}()
 }
+
+func badBlobScrubConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse blob scrubbing configuration: %s", reason))
+}
+
+// startBlobScrubber schedules a goroutine which will periodically verify the
+// content of every blob against its digest, reporting those which do not
+// match. Scrubbing is disabled unless enabled in config.
+func startBlobScrubber(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+	if config["enabled"] != true {
+		return
+	}
+
+	intervalDuration := 168 * time.Hour
+	if interval, ok := config["interval"]; ok {
+		intervalStr, ok := interval.(string)
+		if !ok {
+			badBlobScrubConfig("interval is not a string")
+		}
+
+		var err error
+		intervalDuration, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			badBlobScrubConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
+		}
+	}
+
+	var opts storage.ScrubOptions
+	switch rate := config["bytespersecond"].(type) {
+	case int:
+		if rate < 0 {
+			badBlobScrubConfig("bytespersecond must not be negative")
+		}
+		opts.BytesPerSecond = int64(rate)
+	case nil:
+	default:
+		badBlobScrubConfig("bytespersecond is not an integer")
+	}
+
+	if quarantine, ok := config["quarantine"]; ok {
+		opts.Quarantine, ok = quarantine.(bool)
+		if !ok {
+			badBlobScrubConfig("cannot parse quarantine")
+		}
+	}
+
+	go func() {
+		rand.Seed(time.Now().Unix())
+		jitter := time.Duration(rand.Int()%60) * time.Minute
+		log.Infof("Starting blob scrub in %s", jitter)
+		time.Sleep(jitter)
+
+		for {
+			corrupt, errs := storage.ScrubBlobs(ctx, storageDriver, opts)
+			for _, err := range errs {
+				log.Errorf("error scrubbing blobs: %v", err)
+			}
+			log.Infof("Blob scrub finished. Num corrupt=%d, num errors=%d, corrupt=%v", len(corrupt), len(errs), corrupt)
+			log.Infof("Starting blob scrub in %s", intervalDuration)
+			time.Sleep(intervalDuration)
+		}
+	}()
+}
+
+func badUploadCompactionConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse upload compaction configuration: %s", reason))
+}
+
+// startUploadCompactor schedules a goroutine which will periodically delete
+// orphaned files from upload directories. Compaction is disabled unless
+// enabled in config.
+func startUploadCompactor(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+	if config["enabled"] != true {
+		return
+	}
+
+	parseDuration := func(key string, def time.Duration) time.Duration {
+		v, ok := config[key]
+		if !ok {
+			return def
+		}
+		s, ok := v.(string)
+		if !ok {
+			badUploadCompactionConfig(fmt.Sprintf("%s is not a string", key))
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			badUploadCompactionConfig(fmt.Sprintf("Cannot parse %s: %s", key, err.Error()))
+		}
+		return d
+	}
+	intervalDuration := parseDuration("interval", time.Hour)
+	ageDuration := parseDuration("age", time.Hour)
+
+	go func() {
+		rand.Seed(time.Now().Unix())
+		jitter := time.Duration(rand.Int()%60) * time.Minute
+		log.Infof("Starting upload compaction in %s", jitter)
+		time.Sleep(jitter)
+
+		for {
+			_, errs := storage.CompactUploads(ctx, storageDriver, time.Now().Add(-ageDuration))
+			for _, err := range errs {
+				log.Errorf("error compacting uploads: %v", err)
+			}
+			log.Infof("Starting upload compaction in %s", intervalDuration)
+			time.Sleep(intervalDuration)
+		}
+	}()
+}
+
+func badManifestExpiryConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse manifest expiry configuration: %s", reason))
+}
+
+// startManifestExpirer schedules a goroutine which will periodically remove
+// expired manifests, and the tags referring to them, from the repositories
+// held by storageDriver. Expiry sweeps are disabled unless enabled in config.
+func startManifestExpirer(app *App, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+	if config["enabled"] != true {
+		return
+	}
+
+	intervalDuration := time.Hour
+	if v, ok := config["interval"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			badManifestExpiryConfig("interval is not a string")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			badManifestExpiryConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
+		}
+		intervalDuration = d
+	}
+
+	registry, err := storage.NewRegistry(app, storageDriver)
+	if err != nil {
+		badManifestExpiryConfig(err.Error())
+	}
+
+	go func() {
+		rand.Seed(time.Now().Unix())
+		jitter := time.Duration(rand.Int()%60) * time.Minute
+		log.Infof("Starting manifest expiry in %s", jitter)
+		time.Sleep(jitter)
+
+		for {
+			if !app.readOnly {
+				// Policies are read from storage, rather than the cache
+				// of the app, as sweeps are infrequent.
+				_, errs := storage.ExpireManifests(app, storageDriver, registry, func(name string) (time.Duration, error) {
+					policy, err := storage.GetRepositoryPolicy(app, storageDriver, name)
+					if err != nil {
+						return 0, err
+					}
+					return app.manifestTTLOf(policy), nil
+				}, app.now())
+				for _, err := range errs {
+					log.Errorf("error expiring manifests: %v", err)
+				}
+			}
+			log.Infof("Starting manifest expiry in %s", intervalDuration)
+			time.Sleep(intervalDuration)
+		}
+	}()
+}
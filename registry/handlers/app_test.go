@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -16,6 +17,7 @@ import (
 	_ "github.com/docker/distribution/registry/auth/silly"
 	"github.com/docker/distribution/registry/storage"
 	memorycache "github.com/docker/distribution/registry/storage/cache/memory"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/testdriver"
 )
 
@@ -137,6 +139,61 @@ func TestAppDispatcher(t *testing.T) {
 	}
 }
 
+// TestAppDispatcherOperationLimit ensures that requests exceeding the storage
+// operation limit are failed, unless their response has already been written.
+func TestAppDispatcherOperationLimit(t *testing.T) {
+	app := &App{
+		Config:               &configuration.Configuration{},
+		Context:              context.Background(),
+		router:               v2.Router(),
+		maxStorageOperations: 1,
+	}
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	// exceedLimit returns a dispatcher which exceeds the operation limit,
+	// after writing its response if written is set.
+	exceedLimit := func(written bool) dispatchFunc {
+		return func(ctx *Context, r *http.Request) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if written {
+					w.WriteHeader(http.StatusOK)
+				}
+				for i := 0; i < 2; i++ {
+					storagedriver.CountOperation(ctx)
+				}
+			})
+		}
+	}
+
+	for _, testcase := range []struct {
+		written  bool
+		expected int
+	}{
+		{written: false, expected: http.StatusServiceUnavailable},
+		{written: true, expected: http.StatusOK},
+	} {
+		app.register(v2.RouteNameBase, exceedLimit(testcase.written))
+
+		resp, err := http.Get(server.URL + "/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != testcase.expected {
+			t.Fatalf("unexpected status code with response written %t: %v != %v", testcase.written, resp.StatusCode, testcase.expected)
+		}
+		if testcase.written && len(body) != 0 {
+			t.Fatalf("unexpected errors appended to written response: %s", body)
+		}
+	}
+}
+
 // TestNewApp covers the creation of an application via NewApp with a
 // configuration.
 func TestNewApp(t *testing.T) {
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/opencontainers/go-digest"
+)
+
+// auditQueueSize is the number of pull records which may await writing
+// before further records are dropped.
+const auditQueueSize = 1024
+
+// auditEntry is a single record of the audit log.
+type auditEntry struct {
+	Time       time.Time     `json:"time"`
+	Action     string        `json:"action"`
+	Principal  string        `json:"principal,omitempty"`
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	// References are the digests of the content referenced by the
+	// manifest pulled, for pull records.
+	References []digest.Digest `json:"references,omitempty"`
+}
+
+// auditLog appends a record of each destructive operation to a file, one
+// JSON object per line. Records are synced to disk before being reported
+// written, and are written before the operation is made. Pulls, if
+// recorded, are written in the background, so as not to delay them.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+
+	pulls chan auditEntry
+}
+
+// newAuditLog opens the audit log at path, creating it if necessary. If
+// pulls is set, the log accepts records of pulls.
+func newAuditLog(path string, pulls bool) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &auditLog{file: file}
+	if pulls {
+		l.pulls = make(chan auditEntry, auditQueueSize)
+		go l.writePulls()
+	}
+	return l, nil
+}
+
+// record writes an entry for action on the content dgst of repository,
+// performed by the principal authorized in ctx.
+func (l *auditLog) record(ctx context.Context, action, repository string, dgst digest.Digest) error {
+	return l.write(auditEntry{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Principal:  auth.Principal(ctx),
+		Repository: repository,
+		Digest:     dgst,
+	})
+}
+
+// recordPull queues an entry for the pull of the manifest dgst of
+// repository, referencing the content references, by the principal
+// authorized in ctx. It does not wait for the entry to be written. If too
+// many entries are queued, the entry is dropped and an error logged.
+func (l *auditLog) recordPull(ctx context.Context, repository string, dgst digest.Digest, references []digest.Digest) {
+	if l.pulls == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:       time.Now().UTC(),
+		Action:     "pull manifest",
+		Principal:  auth.Principal(ctx),
+		Repository: repository,
+		Digest:     dgst,
+		References: references,
+	}
+	select {
+	case l.pulls <- entry:
+	default:
+		dcontext.GetLogger(ctx).Errorf("audit log queue full, dropping record of pull of %s@%s", repository, dgst)
+	}
+}
+
+// writePulls writes the queued pull entries, in the order they were queued.
+func (l *auditLog) writePulls() {
+	for entry := range l.pulls {
+		if err := l.write(entry); err != nil {
+			dcontext.GetLogger(context.Background()).Errorf("error recording pull of %s@%s in audit log: %v", entry.Repository, entry.Digest, err)
+		}
+	}
+}
+
+// write appends entry to the log, syncing it to disk.
+func (l *auditLog) write(entry auditEntry) error {
+	p, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(p, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
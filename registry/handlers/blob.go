@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/docker/distribution"
@@ -66,6 +67,15 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Blobs are immutable, so a client presenting the digest as its ETag
+	// already has the content and need not be redirected or sent the body.
+	if etagMatch(r, desc.Digest.String()) {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest))
+		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
 		context.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -77,6 +87,16 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 	context.GetLogger(bh).Debug("DeleteBlob")
 
+	// The delete is recorded before it is made, so that no delete goes
+	// unrecorded.
+	if bh.App.auditLog != nil {
+		if err := bh.App.auditLog.record(bh, "delete blob", bh.Repository.Named().Name(), bh.Digest); err != nil {
+			context.GetLogger(bh).Errorf("error recording blob delete in audit log: %v", err)
+			bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail("failed to record delete in audit log"))
+			return
+		}
+	}
+
 	blobs := bh.Repository.Blobs(bh)
 	err := blobs.Delete(bh, bh.Digest)
 	if err != nil {
@@ -1,15 +1,21 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/distribution"
 	dcontext "github.com/docker/distribution/context"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/auth"
 	"github.com/docker/distribution/registry/storage"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/gorilla/handlers"
@@ -74,6 +80,12 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	// Retried pushes may supply the same key to resume their earlier session
+	// rather than abandoning it.
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		options = append(options, storage.WithIdempotencyKey(auth.Principal(buh), key))
+	}
+
 	blobs := buh.Repository.Blobs(buh)
 	upload, err := blobs.Create(buh, options...)
 
@@ -82,17 +94,14 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 			if err := buh.writeBlobCreatedHeaders(w, ebm.Descriptor); err != nil {
 				buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
-		} else if _, ok := err.(storagedriver.QuotaExceededError); ok {
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
-		} else if err == distribution.ErrUnsupported {
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
 		} else {
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			buh.appendCreateError(err)
 		}
 		return
 	}
 
 	buh.Upload = upload
+	buh.State.Principal = auth.Principal(buh)
 
 	if err := buh.blobUploadResponse(w, r, true); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -100,6 +109,7 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	}
 
 	w.Header().Set("Docker-Upload-UUID", buh.Upload.ID())
+	buh.setChunkSizeHeaders(w)
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -119,6 +129,18 @@ func (buh *blobUploadHandler) GetUploadStatus(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if fingerprinter, ok := buh.Upload.(distribution.BlobWriteFingerprinter); ok {
+		fingerprint, err := fingerprinter.Fingerprint(buh)
+		switch err {
+		case nil:
+			w.Header().Set("Docker-Upload-Fingerprint", fingerprint.String())
+		case distribution.ErrUnsupported:
+		default:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
 	w.Header().Set("Docker-Upload-UUID", buh.UUID)
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -138,14 +160,11 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 	}
 
 	// TODO(dmcgowan): support Content-Range header to seek and write range
+	if buh.strictChunkOrder() && !buh.checkChunkRange(r) {
+		return
+	}
 
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PATCH"); err != nil {
-		switch err := err.(type) {
-		case storagedriver.QuotaExceededError:
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
-		default:
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
-		}
+	if _, ok := buh.copyPayload(w, r, "blob PATCH"); !ok {
 		return
 	}
 
@@ -169,14 +188,10 @@ func (buh *blobUploadHandler) PostBlobData(w http.ResponseWriter, r *http.Reques
 // BlobUploadComplete takes the final request of a blob upload. The request may
 // include all the blob data or no blob data. Any data provided is received and
 // verified. If successful, the blob is linked into the blob store and 201
-// Created is returned with the canonical url of the blob.
+// Created is returned with the canonical url of the blob, whether the upload
+// was monolithic or chunked. This is distinct from the 202 Accepted returned
+// when an upload session is started or a chunk is received.
 func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.Request) {
-	var err error
-	if buh.Upload == nil {
-		blobs := buh.Repository.Blobs(buh)
-		buh.Upload, err = blobs.Create(buh)
-	}
-
 	dgstStr := r.FormValue("digest") // TODO(stevvooe): Support multiple digest parameters!
 
 	if dgstStr == "" {
@@ -192,13 +207,59 @@ func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
-		switch err := err.(type) {
-		case storagedriver.QuotaExceededError:
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
-		default:
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+	// A request without a Content-Length has a ContentLength of zero unless
+	// it is chunked, so the header itself is checked.
+	requireLength := buh.requireContentLength()
+	if requireLength && r.Header.Get("Content-Length") == "" {
+		buh.Errors = append(buh.Errors, v2.ErrorCodeSizeInvalid.WithDetail("Content-Length required"))
+		return
+	}
+
+	if buh.Upload == nil {
+		// A monolithic POST completes an upload in a single request, so
+		// the session is created here.
+		upload, err := buh.Repository.Blobs(buh).Create(buh)
+		if err != nil {
+			buh.appendCreateError(err)
+			return
+		}
+		buh.Upload = upload
+	}
+
+	// A PUT may resume an upload, carrying only the data the upload lacks,
+	// with a Content-Range placing it in the whole. If the range ends
+	// before the total length given, the upload is left open for another.
+	partial := false
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" && r.Method == http.MethodPut && buh.resumablePut() {
+		start, end, total, err := parseResumeRange(contentRange)
+		if err != nil {
+			buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadInvalid.WithDetail(err.Error()))
+			return
+		}
+		if !buh.checkRangeOffset(r, contentRange, start, end) {
+			return
 		}
+		partial = total >= 0 && end+1 < total
+	}
+
+	received, ok := buh.copyPayload(w, r, "blob PUT")
+	if !ok {
+		return
+	}
+
+	if requireLength && received != r.ContentLength {
+		buh.Errors = append(buh.Errors, v2.ErrorCodeSizeInvalid.WithDetail(
+			fmt.Sprintf("received %d bytes, Content-Length was %d", received, r.ContentLength)))
+		return
+	}
+
+	if partial {
+		if err := buh.blobUploadResponse(w, r, false); err != nil {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
@@ -214,6 +275,13 @@ func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.
 		switch err := err.(type) {
 		case distribution.ErrBlobInvalidDigest:
 			buh.Errors = append(buh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		case distribution.ErrBlobWriteMismatch:
+			dcontext.GetLogger(buh).Errorf("error verifying written upload: %v", err)
+			buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadInvalid.WithDetail(err))
+		case distribution.ErrRepositoryQuotaExceeded:
+			buh.Errors = append(buh.Errors, v2.ErrorCodeQuotaExceeded.WithDetail(err))
+		case distribution.ErrBlobLinkLimitExceeded:
+			buh.Errors = append(buh.Errors, v2.ErrorCodeBlobLinkLimitExceeded.WithDetail(err))
 		case storagedriver.QuotaExceededError:
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
 		case errcode.Error:
@@ -247,6 +315,234 @@ func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.
 	}
 }
 
+// appendCreateError records the error returned creating an upload session.
+func (buh *blobUploadHandler) appendCreateError(err error) {
+	if _, ok := err.(storagedriver.QuotaExceededError); ok {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
+	} else if _, ok := err.(distribution.ErrBlobLinkLimitExceeded); ok {
+		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobLinkLimitExceeded.WithDetail(err))
+	} else if err == distribution.ErrUnsupported {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
+	} else {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
+
+// requireContentLength reports whether requests completing an upload must
+// declare a Content-Length matching their body.
+func (buh *blobUploadHandler) requireContentLength() bool {
+	validation := buh.App.Config.Validation
+	return validation.Enabled && validation.Uploads.RequireContentLength
+}
+
+// bindPrincipal reports whether only the principal who started an upload may
+// continue, complete or cancel it.
+func (buh *blobUploadHandler) bindPrincipal() bool {
+	validation := buh.App.Config.Validation
+	return validation.Enabled && validation.Uploads.BindPrincipal
+}
+
+// setChunkSizeHeaders advertises the configured chunk sizes to a client
+// starting an upload. The maximum advertised is bounded by the maximum
+// upload size.
+func (buh *blobUploadHandler) setChunkSizeHeaders(w http.ResponseWriter) {
+	validation := buh.App.Config.Validation
+	if !validation.Enabled {
+		return
+	}
+
+	chunkSize := validation.Uploads.ChunkSize
+	max := chunkSize.Max
+	if maxSize := validation.Uploads.MaxSize; maxSize > 0 && (max <= 0 || maxSize < max) {
+		max = maxSize
+	}
+
+	if chunkSize.Min > 0 {
+		w.Header().Set("Docker-Upload-Chunk-Min-Length", strconv.FormatInt(chunkSize.Min, 10))
+	}
+	if max > 0 {
+		w.Header().Set("Docker-Upload-Chunk-Max-Length", strconv.FormatInt(max, 10))
+	}
+	if chunkSize.Recommended > 0 {
+		w.Header().Set("Docker-Upload-Chunk-Recommended-Length", strconv.FormatInt(chunkSize.Recommended, 10))
+	}
+}
+
+// strictChunkOrder reports whether chunks carrying a Content-Range must
+// start exactly where the data received so far ends.
+func (buh *blobUploadHandler) strictChunkOrder() bool {
+	validation := buh.App.Config.Validation
+	return validation.Enabled && validation.Uploads.StrictChunkOrder
+}
+
+// checkChunkRange verifies that the Content-Range of a chunk, if any,
+// continues the upload from its current length, without leaving a gap or
+// overlapping data already received. It reports false if an error was
+// recorded.
+func (buh *blobUploadHandler) checkChunkRange(r *http.Request) bool {
+	contentRange := r.Header.Get("Content-Range")
+	if contentRange == "" {
+		return true
+	}
+
+	start, end, err := parseChunkRange(contentRange)
+	if err != nil {
+		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadInvalid.WithDetail(err.Error()))
+		return false
+	}
+
+	return buh.checkRangeOffset(r, contentRange, start, end)
+}
+
+// checkRangeOffset verifies that the data of the request, in the range from
+// start to end inclusive of contentRange, continues the upload from its
+// current length. It reports false if an error was recorded.
+func (buh *blobUploadHandler) checkRangeOffset(r *http.Request, contentRange string, start, end int64) bool {
+	if r.ContentLength >= 0 && end-start+1 != r.ContentLength {
+		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadInvalid.WithDetail(
+			fmt.Sprintf("Content-Range %s does not match Content-Length %d", contentRange, r.ContentLength)))
+		return false
+	}
+
+	size := buh.Upload.Size()
+	switch {
+	case start > size:
+		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadInvalid.WithDetail(
+			fmt.Sprintf("chunk at offset %d leaves a gap in the upload; expected offset %d", start, size)))
+		return false
+	case start < size:
+		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadInvalid.WithDetail(
+			fmt.Sprintf("chunk at offset %d overlaps data already received; expected offset %d", start, size)))
+		return false
+	}
+
+	return true
+}
+
+// parseChunkRange parses a chunk Content-Range of the form
+// "<start>-<end, inclusive>".
+func parseChunkRange(contentRange string) (start, end int64, err error) {
+	parts := strings.SplitN(contentRange, "-", 2)
+	if len(parts) == 2 {
+		start, err = strconv.ParseInt(parts[0], 10, 64)
+		if err == nil {
+			end, err = strconv.ParseInt(parts[1], 10, 64)
+		}
+		if err == nil && start >= 0 && end >= start {
+			return start, end, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+}
+
+// resumablePut reports whether a PUT carrying a Content-Range may resume an
+// upload with part of its data.
+func (buh *blobUploadHandler) resumablePut() bool {
+	validation := buh.App.Config.Validation
+	return validation.Enabled && validation.Uploads.ResumablePut
+}
+
+// parseResumeRange parses the Content-Range of a PUT resuming an upload, of
+// the form "[bytes ]<start>-<end, inclusive>[/<total length>]". The total
+// is -1 if it is not given or is "*".
+func parseResumeRange(contentRange string) (start, end, total int64, err error) {
+	rng := strings.TrimPrefix(contentRange, "bytes ")
+	total = -1
+	if i := strings.LastIndex(rng, "/"); i >= 0 {
+		if length := rng[i+1:]; length != "*" {
+			total, err = strconv.ParseInt(length, 10, 64)
+			if err != nil || total <= 0 {
+				return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+			}
+		}
+		rng = rng[:i]
+	}
+
+	start, end, err = parseChunkRange(rng)
+	if err != nil || (total >= 0 && end >= total) {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return start, end, total, nil
+}
+
+// copyPayload copies the body of the request to the upload, returning the
+// number of bytes received. If the body would take the upload over the
+// maximum upload size, the upload is canceled, discarding the data received
+// so far. It reports false if an error was recorded.
+func (buh *blobUploadHandler) copyPayload(w http.ResponseWriter, r *http.Request, action string) (int64, bool) {
+	remaining := int64(-1)
+	validation := buh.App.Config.Validation
+	if maxSize := validation.Uploads.MaxSize; validation.Enabled && maxSize > 0 {
+		if remaining = maxSize - buh.Upload.Size(); remaining < 0 {
+			remaining = 0
+		}
+
+		// Refuse a body declared too large before reading any of it.
+		if r.ContentLength > remaining {
+			buh.cancelOversizedUpload(maxSize)
+			return 0, false
+		}
+	}
+
+	if remaining >= 0 {
+		r.Body = &limitedBody{ReadCloser: r.Body, remaining: remaining}
+	}
+
+	received, err := copyFullPayload(buh, w, r, buh.Upload, 0, action)
+	if err != nil {
+		if err == errUploadTooLarge {
+			buh.cancelOversizedUpload(validation.Uploads.MaxSize)
+			return received, false
+		}
+
+		switch err := err.(type) {
+		case storagedriver.QuotaExceededError:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
+		default:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+		}
+		return received, false
+	}
+
+	return received, true
+}
+
+// errUploadTooLarge is returned by a limitedBody when more data is read than
+// it allows.
+var errUploadTooLarge = errors.New("upload exceeds maximum size")
+
+// limitedBody is a request body which fails with errUploadTooLarge once more
+// than remaining bytes are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	// Read one byte more than allowed, to detect a body which exceeds the
+	// limit rather than merely reaching it.
+	if int64(len(p)) > lb.remaining+1 {
+		p = p[:lb.remaining+1]
+	}
+
+	n, err := lb.ReadCloser.Read(p)
+	lb.remaining -= int64(n)
+	if lb.remaining < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// cancelOversizedUpload cancels an upload which exceeded the maximum upload
+// size, so that none of its data is kept.
+func (buh *blobUploadHandler) cancelOversizedUpload(maxSize int64) {
+	if err := buh.Upload.Cancel(buh); err != nil {
+		dcontext.GetLogger(buh).Errorf("error canceling oversized upload: %v", err)
+	}
+	buh.Errors = append(buh.Errors, v2.ErrorCodeSizeInvalid.WithDetail(
+		fmt.Sprintf("upload exceeds maximum size of %d bytes", maxSize)))
+}
+
 // CancelBlobUpload cancels an in-progress upload of a blob.
 func (buh *blobUploadHandler) CancelBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if buh.Upload == nil {
@@ -287,13 +583,25 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 		})
 	}
 
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && buh.bindPrincipal() {
+		if principal := auth.Principal(ctx); principal != state.Principal {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				dcontext.GetLogger(ctx).Warnf("upload %s started by %q resumed by %q", state.UUID, state.Principal, principal)
+				buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("upload was started by another principal"))
+			})
+		}
+	}
+
 	blobs := ctx.Repository.Blobs(buh)
 	upload, err := blobs.Resume(buh, buh.UUID)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error resolving upload: %v", err)
 		if err == distribution.ErrBlobUploadUnknown {
+			// The state was signed by this registry, so the session existed
+			// but has since been purged or canceled.
+			detail := fmt.Sprintf("upload session started at %s has expired; start a new upload", state.StartedAt.UTC().Format(time.RFC3339))
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown.WithDetail(err))
+				buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown.WithDetail(detail))
 			})
 		}
 
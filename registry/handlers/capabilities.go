@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// capabilitiesVersion is the version of the capabilities document. Fields
+// may be added to the document without changing it, but it must be changed
+// if fields are removed or change in meaning.
+const capabilitiesVersion = 1
+
+// capabilitiesDocument describes the features enabled on the registry, so
+// that tooling may adapt to different deployments.
+type capabilitiesDocument struct {
+	Version  int                  `json:"version"`
+	Features capabilitiesFeatures `json:"features"`
+
+	// ManifestSchemas lists the media types of manifests which may be
+	// pushed, in lexical order.
+	ManifestSchemas []string `json:"manifestSchemas"`
+}
+
+// capabilitiesFeatures reports whether each optional feature of the registry
+// is enabled.
+type capabilitiesFeatures struct {
+	Push                 bool `json:"push"`
+	Delete               bool `json:"delete"`
+	ChunkedUploads       bool `json:"chunkedUploads"`
+	CrossRepositoryMount bool `json:"crossRepositoryMount"`
+	Catalog              bool `json:"catalog"`
+	CatalogPagination    bool `json:"catalogPagination"`
+}
+
+// capabilitiesDispatcher takes the request context and builds the handler
+// for the capabilities document.
+func capabilitiesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := json.Marshal(ctx.App.capabilities())
+			if err != nil {
+				ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+			w.Write(p)
+		}),
+	}
+}
+
+// capabilities returns the capabilities document of the app, derived from its
+// configuration.
+func (app *App) capabilities() capabilitiesDocument {
+	pushable := app.pushable()
+	catalog := app.router.Get(v2.RouteNameCatalog) != nil
+
+	return capabilitiesDocument{
+		Version: capabilitiesVersion,
+		Features: capabilitiesFeatures{
+			Push:                 pushable,
+			Delete:               app.deletable(),
+			ChunkedUploads:       pushable,
+			CrossRepositoryMount: pushable,
+			Catalog:              catalog,
+			CatalogPagination:    catalog,
+		},
+		ManifestSchemas: app.manifestSchemas(),
+	}
+}
+
+// manifestSchemas returns the media types of manifests which may be pushed,
+// in lexical order. Unless restricted by the configuration, these are all
+// those supported, omitting schema1 unless it is enabled.
+func (app *App) manifestSchemas() []string {
+	schemas := []string{}
+	if !app.pushable() {
+		return schemas
+	}
+
+	if len(app.manifestMediaTypes) > 0 {
+		schemas = append(schemas, app.manifestMediaTypes...)
+	} else {
+		for _, mediaType := range distribution.ManifestMediaTypes() {
+			switch mediaType {
+			case "application/json":
+				// Accepted for schema1 manifests, but not a schema of its
+				// own.
+				continue
+			case schema1.MediaTypeSignedManifest, schema1.MediaTypeManifest:
+				if !app.Config.Compatibility.Schema1.Enabled {
+					continue
+				}
+			}
+			schemas = append(schemas, mediaType)
+		}
+	}
+
+	sort.Strings(schemas)
+	return schemas
+}
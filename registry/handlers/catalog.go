@@ -77,17 +77,65 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// catalogUsageDispatcher takes the request context and builds the handler
+// for the usage of the registry.
+func catalogUsageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	catalogHandler := &catalogHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(catalogHandler.GetUsage),
+	}
+}
+
+// GetUsage returns the number and total size of the blobs held by the
+// registry. It is served from the blob index alone, so is unavailable unless
+// the index is enabled and has been built.
+func (ch *catalogHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if ch.App.blobIndex == nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnavailable.WithDetail("the blob index is not enabled"))
+		return
+	}
+
+	built, err := ch.App.blobIndex.Built(ch)
+	if err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if !built {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnavailable.WithDetail("the blob index has not been built"))
+		return
+	}
+
+	usage, err := ch.App.blobIndex.Usage(ch)
+	if err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	p, err := json.Marshal(usage)
+	if err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+	w.Write(p)
+}
+
 // Use the original URL from the request to create a new URL for
-// the link header
+// the link header. Query parameters other than n and last are preserved.
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
 	calledURL, err := url.Parse(origURL)
 	if err != nil {
 		return "", err
 	}
 
-	v := url.Values{}
-	v.Add("n", strconv.Itoa(maxEntries))
-	v.Add("last", lastEntry)
+	v := calledURL.Query()
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("last", lastEntry)
 
 	calledURL.RawQuery = v.Encode()
 
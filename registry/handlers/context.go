@@ -80,7 +80,7 @@ func getUploadUUID(ctx context.Context) (uuid string) {
 // getUserName attempts to resolve a username from the context and request. If
 // a username cannot be resolved, the empty string is returned.
 func getUserName(ctx context.Context, r *http.Request) string {
-	username := dcontext.GetStringValue(ctx, auth.UserNameKey)
+	username := auth.Principal(ctx)
 
 	// Fallback to request user with basic auth
 	if username == "" {
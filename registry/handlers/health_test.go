@@ -1,17 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/docker/distribution/configuration"
-	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/health"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
 )
 
 func TestFileHealthCheck(t *testing.T) {
@@ -208,3 +211,95 @@ func TestHTTPHealthCheck(t *testing.T) {
 		t.Fatal("expected 0 items in health check results")
 	}
 }
+
+// faultyDriver fails writes or stats when told to.
+type faultyDriver struct {
+	storagedriver.StorageDriver
+
+	mu                sync.Mutex
+	failPut, failStat bool
+}
+
+func (d *faultyDriver) fail(put, stat bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failPut, d.failStat = put, stat
+}
+
+func (d *faultyDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	d.mu.Lock()
+	fail := d.failPut
+	d.mu.Unlock()
+	if fail {
+		return errors.New("injected put failure")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (d *faultyDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	d.mu.Lock()
+	fail := d.failStat
+	d.mu.Unlock()
+	if fail {
+		return nil, errors.New("injected stat failure")
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func TestStorageDriverHealthCheck(t *testing.T) {
+	interval := 100 * time.Millisecond
+	const probePath = "/health/probe"
+
+	for _, tc := range []struct {
+		name              string
+		failPut, failStat bool
+	}{
+		{name: "healthy"},
+		{name: "unwritable", failPut: true},
+		{name: "unreadable", failStat: true},
+	} {
+		config := &configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": configuration.Parameters{},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+		}
+		config.Health.StorageDriver.Enabled = true
+		config.Health.StorageDriver.Interval = interval
+		config.Health.StorageDriver.Path = probePath
+
+		app := NewApp(context.Background(), config)
+		driver := &faultyDriver{StorageDriver: app.driver}
+		driver.fail(tc.failPut, tc.failStat)
+		app.driver = driver
+
+		healthRegistry := health.NewRegistry()
+		app.RegisterHealthChecks(healthRegistry)
+
+		// Wait for health check to happen
+		<-time.After(3 * interval)
+
+		status := healthRegistry.CheckStatus()
+		healthy := !tc.failPut && !tc.failStat
+		if healthy && len(status) != 0 {
+			t.Fatalf("%s: expected 0 items in health check results, got %v", tc.name, status)
+		}
+		if !healthy && len(status) != 1 {
+			t.Fatalf("%s: expected 1 item in health check results, got %v", tc.name, status)
+		}
+
+		_, err := driver.StorageDriver.Stat(context.Background(), probePath)
+		if !tc.failPut && err != nil {
+			t.Fatalf("%s: expected health marker to be written: %v", tc.name, err)
+		}
+
+		// The check recovers once the driver does.
+		driver.fail(false, false)
+		<-time.After(3 * interval)
+		if status := healthRegistry.CheckStatus(); len(status) != 0 {
+			t.Fatalf("%s: expected 0 items in health check results after recovery, got %v", tc.name, status)
+		}
+	}
+}
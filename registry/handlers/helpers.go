@@ -20,12 +20,13 @@ func closeResources(handler http.Handler, closers ...io.Closer) http.Handler {
 	})
 }
 
-// copyFullPayload copies the payload of an HTTP request to destWriter. If it
-// receives less content than expected, and the client disconnected during the
-// upload, it avoids sending a 400 error to keep the logs cleaner.
+// copyFullPayload copies the payload of an HTTP request to destWriter,
+// returning the number of bytes copied. If it receives less content than
+// expected, and the client disconnected during the upload, it avoids sending a
+// 400 error to keep the logs cleaner.
 //
 // The copy will be limited to `limit` bytes, if limit is greater than zero.
-func copyFullPayload(ctx context.Context, responseWriter http.ResponseWriter, r *http.Request, destWriter io.Writer, limit int64, action string) error {
+func copyFullPayload(ctx context.Context, responseWriter http.ResponseWriter, r *http.Request, destWriter io.Writer, limit int64, action string) (int64, error) {
 	// Get a channel that tells us if the client disconnects
 	clientClosed := r.Context().Done()
 	var body = r.Body
@@ -52,15 +53,15 @@ func copyFullPayload(ctx context.Context, responseWriter http.ResponseWriter, r
 				"copied":        copied,
 				"contentLength": r.ContentLength,
 			}, "error", "copied", "contentLength").Error("client disconnected during " + action)
-			return errors.New("client disconnected")
+			return copied, errors.New("client disconnected")
 		default:
 		}
 	}
 
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("unknown error reading request payload: %v", err)
-		return err
+		return copied, err
 	}
 
-	return nil
+	return copied, nil
 }
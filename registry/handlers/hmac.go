@@ -22,6 +22,9 @@ type blobUploadState struct {
 
 	// StartedAt is the original start time of the upload.
 	StartedAt time.Time
+
+	// Principal is the authenticated user who started the upload, if any.
+	Principal string
 }
 
 type hmacKey string
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// checkJSONStructure scans the JSON document p, without decoding it into
+// memory, returning an error if its objects and arrays are nested more than
+// maxDepth deep or it contains more than maxElements values and object keys.
+// Either limit is ignored unless positive. Malformed JSON is not reported, so
+// that it is rejected by the decoder with the usual error.
+func checkJSONStructure(p []byte, maxDepth, maxElements int) error {
+	if maxDepth <= 0 && maxElements <= 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+
+	var depth, elements int
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+
+		if delim, ok := tok.(json.Delim); ok {
+			if delim == '}' || delim == ']' {
+				depth--
+				continue
+			}
+
+			depth++
+			if maxDepth > 0 && depth > maxDepth {
+				return fmt.Errorf("manifest is nested more than %d levels deep", maxDepth)
+			}
+		}
+
+		elements++
+		if maxElements > 0 && elements > maxElements {
+			return fmt.Errorf("manifest contains more than %d elements", maxElements)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// errVerificationQueueFull is returned when a manifest verification cannot
+// start immediately and the wait queue is already at capacity.
+var errVerificationQueueFull = errors.New("too many manifest verifications are queued")
+
+// verificationLimiter bounds the number of manifest verifications running
+// at once. Verifications beyond the limit wait for a free slot, up to an
+// optional maximum number of waiters.
+type verificationLimiter struct {
+	slots     chan struct{}
+	maxQueued int64
+
+	waiting  int64
+	inFlight int64
+	peak     int64
+}
+
+// newVerificationLimiter returns a limiter allowing limit concurrent
+// verifications. If maxQueued is positive, at most maxQueued further
+// verifications may wait for a slot.
+func newVerificationLimiter(limit, maxQueued int) *verificationLimiter {
+	return &verificationLimiter{
+		slots:     make(chan struct{}, limit),
+		maxQueued: int64(maxQueued),
+	}
+}
+
+// acquire blocks until a verification slot is available, the queue is full
+// or ctx is done. On success, the returned function must be called to
+// release the slot.
+func (l *verificationLimiter) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.started(), nil
+	default:
+	}
+
+	if waiting := atomic.AddInt64(&l.waiting, 1); l.maxQueued > 0 && waiting > l.maxQueued {
+		atomic.AddInt64(&l.waiting, -1)
+		return nil, errVerificationQueueFull
+	}
+	defer atomic.AddInt64(&l.waiting, -1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.started(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *verificationLimiter) started() func() {
+	n := atomic.AddInt64(&l.inFlight, 1)
+	for {
+		peak := atomic.LoadInt64(&l.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&l.peak, peak, n) {
+			break
+		}
+	}
+
+	return func() {
+		atomic.AddInt64(&l.inFlight, -1)
+		<-l.slots
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"mime"
 	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/docker/distribution"
@@ -17,6 +19,7 @@ import (
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/storage"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
@@ -24,12 +27,17 @@ import (
 )
 
 // These constants determine which architecture and OS to choose from a
-// manifest list when downconverting it to a schema1 manifest.
+// manifest list when downconverting it for an old client, unless a default
+// platform is configured.
 const (
 	defaultArch         = "amd64"
 	defaultOS           = "linux"
 	maxManifestBodySize = 4 << 20
 	imageClass          = "image"
+
+	// defaultQuarantineLimit is the number of rejected manifests quarantined
+	// per repository if no limit is configured.
+	defaultQuarantineLimit = 100
 )
 
 type storageType int
@@ -46,16 +54,18 @@ const (
 // manifestDispatcher takes the request context and builds the
 // appropriate handler for handling manifest requests.
 func manifestDispatcher(ctx *Context, r *http.Request) http.Handler {
-	manifestHandler := &manifestHandler{
-		Context: ctx,
+	manifestHandler := newManifestHandler(ctx)
+
+	if err := manifestHandler.validateDigestLength(); err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err.Error()))
+		})
 	}
-	reference := getReference(ctx)
-	dgst, err := digest.Parse(reference)
-	if err != nil {
-		// We just have a tag
-		manifestHandler.Tag = reference
-	} else {
-		manifestHandler.Digest = dgst
+
+	if err := manifestHandler.validateTag(r.Method); err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeTagInvalid.WithDetail(err.Error()))
+		})
 	}
 
 	mhandler := handlers.MethodHandler{
@@ -71,6 +81,70 @@ func manifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 	return mhandler
 }
 
+// descriptorDispatcher takes the request context and builds the handler for
+// resolving manifests to their descriptors.
+func descriptorDispatcher(ctx *Context, r *http.Request) http.Handler {
+	manifestHandler := newManifestHandler(ctx)
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(manifestHandler.GetDescriptor),
+		"HEAD": http.HandlerFunc(manifestHandler.GetDescriptor),
+	}
+}
+
+// configDispatcher takes the request context and builds the handler for
+// serving the config blobs of image manifests.
+func configDispatcher(ctx *Context, r *http.Request) http.Handler {
+	manifestHandler := newManifestHandler(ctx)
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(manifestHandler.GetConfig),
+		"HEAD": http.HandlerFunc(manifestHandler.GetConfig),
+	}
+}
+
+// defaultManifestDispatcher takes the request context and builds the handler
+// for fetching the manifest of the repository's default reference.
+func defaultManifestDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ref, ok := ctx.Config.Compatibility.DefaultTags[ctx.Repository.Named().Name()]
+	if !ok {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeManifestUnknown.WithDetail("no reference given and no default is configured"))
+		})
+	}
+
+	manifestHandler := &manifestHandler{
+		Context: ctx,
+	}
+	if dgst, err := digest.Parse(ref); err == nil {
+		manifestHandler.Digest = dgst
+	} else {
+		manifestHandler.Tag = ref
+	}
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(manifestHandler.GetManifest),
+		"HEAD": http.HandlerFunc(manifestHandler.GetManifest),
+	}
+}
+
+// newManifestHandler returns a manifestHandler for the reference in ctx.
+func newManifestHandler(ctx *Context) *manifestHandler {
+	manifestHandler := &manifestHandler{
+		Context: ctx,
+	}
+	reference := getReference(ctx)
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		// We just have a tag
+		manifestHandler.Tag = reference
+	} else {
+		manifestHandler.Digest = dgst
+	}
+
+	return manifestHandler
+}
+
 // manifestHandler handles http operations on image manifests.
 type manifestHandler struct {
 	*Context
@@ -80,6 +154,110 @@ type manifestHandler struct {
 	Digest digest.Digest
 }
 
+// validateDigestLength returns an error if the request names a digest whose
+// hex portion is not as long as its algorithm produces, which would otherwise
+// be taken for a tag. A shorter digest is allowed where digest prefixes are
+// resolved.
+func (imh *manifestHandler) validateDigestLength() error {
+	i := strings.Index(imh.Tag, ":")
+	if i < 0 {
+		return nil
+	}
+
+	err := storage.ValidateDigestLength(digest.Digest(imh.Tag))
+	if lengthErr, ok := err.(distribution.ErrDigestLengthInvalid); ok {
+		if imh.App.Config.Compatibility.ShortDigests.Enabled && len(imh.Tag)-i-1 < lengthErr.Expected {
+			return nil
+		}
+	}
+	return err
+}
+
+// validateTag returns an error if the request names a tag which is not valid.
+// Since any reference is routed to manifests, this guards against tags which
+// could not be used to build storage paths safely. Tags pushed to must also
+// satisfy the configured pattern and length and, if configured, not resemble
+// a digest. A reference containing a colon
+// is taken to be a digest prefix, rather than an invalid tag, where digest
+// prefixes are resolved.
+func (imh *manifestHandler) validateTag(method string) error {
+	if imh.Tag == "" {
+		return nil
+	}
+	if method != http.MethodPut && imh.App.Config.Compatibility.ShortDigests.Enabled && strings.Contains(imh.Tag, ":") {
+		return nil
+	}
+
+	if err := v2.ValidateTag(imh.Tag); err != nil {
+		return err
+	}
+	if method != http.MethodPut {
+		return nil
+	}
+
+	if max := imh.App.tagMaxLength; max > 0 && len(imh.Tag) > max {
+		return fmt.Errorf("tag must be at most %d characters long", max)
+	}
+	if pattern := imh.App.tagPattern; pattern != nil && !pattern.MatchString(imh.Tag) {
+		return fmt.Errorf("tag %q does not match %q", imh.Tag, imh.App.Config.Validation.Manifests.Tags.Pattern)
+	}
+	if imh.App.rejectDigestLikeTags && digestLikeTagRegexp.MatchString(imh.Tag) {
+		return fmt.Errorf("tag %q resembles a digest", imh.Tag)
+	}
+	return nil
+}
+
+// digestLikeTagRegexp matches tags which could be mistaken for a digest: the
+// name of a digest algorithm followed by hex digits, with or without a
+// separator, or the hex digits of a digest alone.
+var digestLikeTagRegexp = regexp.MustCompile(`(?i)^(?:(?:sha256|sha384|sha512)[-_.]?[a-f0-9]+|[a-f0-9]{64}|[a-f0-9]{96}|[a-f0-9]{128})$`)
+
+// defaultShortDigestMinLength is the shortest digest prefix resolved if the
+// configuration does not specify one.
+const defaultShortDigestMinLength = 7
+
+// resolveDigestPrefix replaces a reference which is a digest prefix with the
+// digest of the manifest it identifies, if short digests are enabled. It
+// reports whether the request may proceed.
+func (imh *manifestHandler) resolveDigestPrefix() bool {
+	shortDigests := imh.App.Config.Compatibility.ShortDigests
+
+	// Tags cannot contain a colon, so a reference containing one which did
+	// not parse as a digest is a digest prefix.
+	i := strings.Index(imh.Tag, ":")
+	if !shortDigests.Enabled || i < 0 {
+		return true
+	}
+
+	minLength := shortDigests.MinLength
+	if minLength <= 0 {
+		minLength = defaultShortDigestMinLength
+	}
+	if len(imh.Tag)-i-1 < minLength {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(fmt.Sprintf("digest prefix must be at least %d characters", minLength)))
+		return false
+	}
+
+	dgst, err := storage.ResolveManifestDigestPrefix(imh, imh.App.driverFor(imh.Repository.Named().Name()), imh.Repository.Named().Name(), imh.Tag)
+	if err != nil {
+		switch err.(type) {
+		case distribution.ErrManifestUnknownRevision:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		case distribution.ErrManifestAmbiguous:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestAmbiguous.WithDetail(err))
+		case distribution.ErrDigestLengthInvalid:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		default:
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return false
+	}
+
+	imh.Tag = ""
+	imh.Digest = dgst
+	return true
+}
+
 // GetManifest fetches the image manifest from the storage backend, if it exists.
 func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("GetImageManifest")
@@ -88,6 +266,10 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		imh.Errors = append(imh.Errors, err)
 		return
 	}
+
+	if !imh.resolveDigestPrefix() {
+		return
+	}
 	var supports [numStorageTypes]bool
 
 	// this parsing of Accept headers is not quite as full-featured as godoc.org's parser, but we don't care about "q=" values
@@ -122,14 +304,28 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		tags := imh.Repository.Tags(imh)
 		desc, err := tags.Get(imh, imh.Tag)
 		if err != nil {
-			if _, ok := err.(distribution.ErrTagUnknown); ok {
+			switch err.(type) {
+			case distribution.ErrTagUnknown, distribution.ErrTagAliasCycle:
 				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
-			} else {
+			default:
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
 			return
 		}
 		imh.Digest = desc.Digest
+
+		// Only fetches are recorded, and never while the registry is
+		// read-only.
+		if limit := imh.App.tagHistoryLimit; limit > 0 && r.Method == http.MethodGet && !imh.readOnly {
+			err := storage.RecordTagResolution(imh, imh.App.driverFor(imh.Repository.Named().Name()), imh.Repository.Named().Name(), imh.Tag, imh.Digest, limit)
+			if err != nil {
+				dcontext.GetLogger(imh).Errorf("error recording resolution of tag %q: %v", imh.Tag, err)
+			}
+		}
+	}
+
+	if !imh.checkUnexpired() {
+		return
 	}
 
 	if etagMatch(r, imh.Digest.String()) {
@@ -170,10 +366,15 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithMessage("OCI manifest found, but accept header does not support OCI manifests"))
 		return
 	}
-	if manifestType == ociImageIndexSchema && !supports[ociImageIndexSchema] {
+	// OCI indexes fetched by tag may be resolved to the default platform
+	// below, in the same way as manifest lists.
+	if imh.Tag == "" && manifestType == ociImageIndexSchema && !supports[ociImageIndexSchema] {
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithMessage("OCI index found, but accept header does not support OCI indexes"))
 		return
 	}
+	// Converted manifests are not stored, so cannot be served by redirect.
+	converted := false
+
 	// Only rewrite schema2 manifests when they are being fetched by tag.
 	// If they are being fetched by digest, we can't return something not
 	// matching the digest.
@@ -185,22 +386,25 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			return
 		}
-	} else if imh.Tag != "" && manifestType == manifestlistSchema && !supports[manifestlistSchema] {
-		// Rewrite manifest in schema1 format
-		dcontext.GetLogger(imh).Infof("rewriting manifest list %s in schema1 format to support old client", imh.Digest.String())
+		converted = true
+	} else if imh.Tag != "" && (manifestType == manifestlistSchema || manifestType == ociImageIndexSchema) && !supports[manifestType] {
+		// Resolve the list to the image manifest for the default platform
+		arch, os := imh.defaultPlatform()
+		dcontext.GetLogger(imh).Infof("resolving manifest list %s to %s/%s manifest to support old client", imh.Digest.String(), os, arch)
 
 		// Find the image manifest corresponding to the default
 		// platform
 		var manifestDigest digest.Digest
 		for _, manifestDescriptor := range manifestList.Manifests {
-			if manifestDescriptor.Platform.Architecture == defaultArch && manifestDescriptor.Platform.OS == defaultOS {
+			if manifestDescriptor.Platform.Architecture == arch && manifestDescriptor.Platform.OS == os {
 				manifestDigest = manifestDescriptor.Digest
 				break
 			}
 		}
 
 		if manifestDigest == "" {
-			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown)
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithMessage(
+				fmt.Sprintf("manifest list found, but it has no manifest for the default platform %s/%s", os, arch)))
 			return
 		}
 
@@ -220,23 +424,263 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			if err != nil {
 				return
 			}
+			converted = true
+		} else if _, isOCImanifest := manifest.(*ocischema.DeserializedManifest); isOCImanifest && !supports[ociSchema] {
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithMessage("OCI manifest found, but accept header does not support OCI manifests"))
+			return
 		} else {
 			imh.Digest = manifestDigest
 		}
+	} else if platforms := r.URL.Query()["platform"]; imh.Tag != "" && isManifestList && len(platforms) > 0 && imh.App.Config.Compatibility.PlatformFilter.Enabled {
+		manifest, err = imh.filterManifestList(manifestList, platforms)
+		if err != nil {
+			return
+		}
+		converted = true
+	}
+
+	// Warm the blob descriptor cache for the blobs the client is about to
+	// fetch while the manifest is served.
+	if imh.App.layerPrefetchConcurrency > 0 && r.Method == http.MethodGet {
+		served := make(chan struct{})
+		defer close(served)
+		imh.prefetchLayers(manifest, served)
 	}
 
+	// Serve the payload exactly as it was pushed: re-encoding it could
+	// reorder keys or change whitespace, and so change its digest.
 	ct, p, err := manifest.Payload()
 	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 
+	if imh.App.auditLog != nil && r.Method == http.MethodGet {
+		references := manifest.References()
+		digests := make([]digest.Digest, len(references))
+		for i, desc := range references {
+			digests[i] = desc.Digest
+		}
+		imh.App.auditLog.recordPull(imh, imh.Repository.Named().Name(), imh.Digest, digests)
+	}
+
 	w.Header().Set("Content-Type", ct)
-	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
 	w.Header().Set("Etag", fmt.Sprintf(`"%s"`, imh.Digest))
+
+	if limit := imh.App.manifestRedirectSize; limit > 0 && len(p) > limit && !converted {
+		redirectURL, err := storage.ManifestURL(imh, imh.App.driverFor(imh.Repository.Named().Name()), imh.Digest, int64(len(p)), r.Method)
+		switch err.(type) {
+		case nil:
+			// Manifests stored inline or compressed are served
+			// by the registry.
+			if redirectURL != "" {
+				http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+				return
+			}
+		case storagedriver.ErrUnsupportedMethod:
+			// Fall back to serving the manifest inline.
+		default:
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
 	w.Write(p)
 }
 
+// GetDescriptor returns the descriptor of the manifest, giving its digest,
+// media type and length, without the manifest itself. Unlike GetManifest, the
+// manifest is neither converted nor resolved to a platform.
+func (imh *manifestHandler) GetDescriptor(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(imh).Debug("GetDescriptor")
+	manifest, ok := imh.resolveManifest()
+	if !ok {
+		return
+	}
+
+	mediaType, p, err := manifest.Payload()
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(distribution.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(p)),
+		Digest:    imh.Digest,
+	}); err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// GetConfig serves the config blob of the image manifest identified by the
+// request, sparing clients which only inspect the image configuration from
+// fetching the manifest first.
+func (imh *manifestHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(imh).Debug("GetConfig")
+	manifest, ok := imh.resolveManifest()
+	if !ok {
+		return
+	}
+
+	var config distribution.Descriptor
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		config = m.Config
+	case *ocischema.DeserializedManifest:
+		config = m.Config
+	default:
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail("manifest does not reference a config blob"))
+		return
+	}
+
+	bh := &blobHandler{
+		Context: imh.Context,
+		Digest:  config.Digest,
+	}
+	bh.GetBlob(w, r)
+}
+
+// resolveManifest fetches the manifest identified by the request, resolving
+// a tag or digest prefix to the digest of the manifest. It reports whether
+// the manifest was found and has not expired, recording an error if not.
+func (imh *manifestHandler) resolveManifest() (distribution.Manifest, bool) {
+	manifests, err := imh.Repository.Manifests(imh)
+	if err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return nil, false
+	}
+
+	if !imh.resolveDigestPrefix() {
+		return nil, false
+	}
+
+	var options []distribution.ManifestServiceOption
+	if imh.Tag != "" {
+		desc, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
+		if err != nil {
+			switch err.(type) {
+			case distribution.ErrTagUnknown, distribution.ErrTagAliasCycle:
+				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			default:
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
+			return nil, false
+		}
+		imh.Digest = desc.Digest
+		options = append(options, distribution.WithTag(imh.Tag))
+	}
+
+	if !imh.checkUnexpired() {
+		return nil, false
+	}
+
+	manifest, err := manifests.Get(imh, imh.Digest, options...)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		} else {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return nil, false
+	}
+
+	return manifest, true
+}
+
+// checkUnexpired reports whether the manifest identified by the digest of the
+// request has not expired from the repository, recording an error if it has,
+// so that an expired manifest is unknown to every route serving it.
+func (imh *manifestHandler) checkUnexpired() bool {
+	expired, err := imh.App.manifestExpired(imh, imh.Repository.Named().Name(), imh.Digest)
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+	if expired {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(fmt.Sprintf("manifest %s has expired", imh.Digest)))
+		return false
+	}
+	return true
+}
+
+// defaultPlatform returns the architecture and OS of the image manifest served
+// in place of a manifest list to clients which do not support manifest lists.
+func (imh *manifestHandler) defaultPlatform() (arch, os string) {
+	arch, os = defaultArch, defaultOS
+
+	platform := imh.App.Config.Compatibility.DefaultPlatform
+	if platform.Architecture != "" {
+		arch = platform.Architecture
+	}
+	if platform.OS != "" {
+		os = platform.OS
+	}
+
+	return arch, os
+}
+
+// filterManifestList prunes manifestList to the manifests for the given
+// platforms, each of the form os/architecture[/variant], where a platform
+// without a variant matches every variant. The digest served becomes that of
+// the pruned list.
+func (imh *manifestHandler) filterManifestList(manifestList *manifestlist.DeserializedManifestList, platforms []string) (distribution.Manifest, error) {
+	var specs []manifestlist.PlatformSpec
+	for _, platform := range platforms {
+		parts := strings.Split(platform, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] == "") {
+			err := fmt.Errorf("invalid platform %q", platform)
+			imh.Errors = append(imh.Errors, v2.ErrorCodePlatformInvalid.WithDetail(err))
+			return nil, err
+		}
+
+		spec := manifestlist.PlatformSpec{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			spec.Variant = parts[2]
+		}
+		specs = append(specs, spec)
+	}
+
+	var descriptors []manifestlist.ManifestDescriptor
+	for _, descriptor := range manifestList.Manifests {
+		for _, spec := range specs {
+			if descriptor.Platform.OS == spec.OS && descriptor.Platform.Architecture == spec.Architecture &&
+				(spec.Variant == "" || descriptor.Platform.Variant == spec.Variant) {
+				descriptors = append(descriptors, descriptor)
+				break
+			}
+		}
+	}
+
+	if len(descriptors) == 0 {
+		err := fmt.Errorf("manifest list found, but it has no manifest for the platforms %s", strings.Join(platforms, ", "))
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithMessage(err.Error()))
+		return nil, err
+	}
+
+	filtered, err := manifestlist.FromDescriptorsWithMediaType(descriptors, manifestList.MediaType)
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, err
+	}
+	_, p, err := filtered.Payload()
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, err
+	}
+
+	dcontext.GetLogger(imh).Infof("filtering manifest list %s to platforms %s", imh.Digest, strings.Join(platforms, ", "))
+	imh.Digest = digest.FromBytes(p)
+	return filtered, nil
+}
+
 func (imh *manifestHandler) convertSchema2Manifest(schema2Manifest *schema2.DeserializedManifest) (distribution.Manifest, error) {
 	targetDescriptor := schema2Manifest.Target()
 	blobs := imh.Repository.Blobs(imh)
@@ -295,20 +739,51 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// A dry run verifies the manifest as a push would, but neither stores
+	// nor tags it.
+	dryRun := r.URL.Query().Get("dryrun") == "true"
+
 	var jsonBuf bytes.Buffer
-	if err := copyFullPayload(imh, w, r, &jsonBuf, maxManifestBodySize, "image manifest PUT"); err != nil {
+	if _, err := copyFullPayload(imh, w, r, &jsonBuf, maxManifestBodySize, "image manifest PUT"); err != nil {
 		// copyFullPayload reports the error if necessary
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err.Error()))
 		return
 	}
 
+	if imh.App.manifestVerifications != nil {
+		release, err := imh.App.manifestVerifications.acquire(imh)
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnavailable.WithDetail(err.Error()))
+			return
+		}
+		defer release()
+	}
+
 	mediaType := r.Header.Get("Content-Type")
+	if mediaType == "" && imh.App.rejectMissingContentType {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail("a Content-Type identifying the manifest schema is required"))
+		return
+	}
+
+	if err := checkJSONStructure(jsonBuf.Bytes(), imh.App.manifestMaxDepth, imh.App.manifestMaxElements); err != nil {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err.Error()))
+		return
+	}
+
 	manifest, desc, err := distribution.UnmarshalManifest(mediaType, jsonBuf.Bytes())
 	if err != nil {
+		if !dryRun {
+			imh.quarantineManifest(jsonBuf.Bytes(), err)
+		}
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err))
 		return
 	}
 
+	if !imh.manifestMediaTypeAllowed(desc.MediaType) {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(fmt.Sprintf("manifest media type %s is not accepted", desc.MediaType)))
+		return
+	}
+
 	if imh.Digest != "" {
 		if desc.Digest != imh.Digest {
 			dcontext.GetLogger(imh).Errorf("payload digest does match: %q != %q", desc.Digest, imh.Digest)
@@ -334,12 +809,32 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	if imh.Tag != "" {
 		options = append(options, distribution.WithTag(imh.Tag))
 	}
+	if dryRun {
+		options = append(options, distribution.WithValidateOnly())
+	}
 
 	if err := imh.applyResourcePolicy(manifest); err != nil {
 		imh.Errors = append(imh.Errors, err)
 		return
 	}
 
+	if imh.Tag != "" && !imh.tagMutable() {
+		return
+	}
+
+	// A new repository is marked as being created until its first manifest
+	// is stored and tagged, so that it is not listed if the put fails part
+	// way.
+	var creating bool
+	if imh.App.atomicRepositoryCreation && !dryRun {
+		name := imh.Repository.Named().Name()
+		creating, err = storage.BeginRepositoryCreation(imh, imh.App.driverFor(name), name)
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
@@ -354,6 +849,9 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		}
 		switch err := err.(type) {
 		case distribution.ErrManifestVerification:
+			if !dryRun {
+				imh.quarantineManifest(jsonBuf.Bytes(), err)
+			}
 			for _, verificationError := range err {
 				switch verificationError := verificationError.(type) {
 				case distribution.ErrManifestBlobUnknown:
@@ -362,6 +860,10 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					imh.Errors = append(imh.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
 				case distribution.ErrManifestUnverified:
 					imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnverified)
+				case distribution.ErrDigestLengthInvalid:
+					imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(verificationError))
+				case distribution.ErrManifestNotCanonical, distribution.ErrManifestPlatformNotAllowed, distribution.ErrManifestLayerOrder, distribution.ErrBlobInvalidArchive, distribution.ErrManifestURLInsecure:
+					imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(verificationError))
 				default:
 					if verificationError == digest.ErrDigestInvalidFormat {
 						imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid)
@@ -370,9 +872,13 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					}
 				}
 			}
+		case distribution.ErrRepositoryQuotaExceeded:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeQuotaExceeded.WithDetail(err))
+		case distribution.ErrBlobLinkLimitExceeded:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeBlobLinkLimitExceeded.WithDetail(err))
 		case storagedriver.QuotaExceededError:
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
-		case errcode.Error:
+		case errcode.Error, errcode.ErrorCode:
 			imh.Errors = append(imh.Errors, err)
 		default:
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -380,6 +886,12 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if dryRun {
+		w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	// Tag this manifest
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
@@ -391,6 +903,14 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 
 	}
 
+	if creating {
+		name := imh.Repository.Named().Name()
+		if err := storage.FinishRepositoryCreation(imh, imh.App.driverFor(name), name); err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
 	// Construct a canonical url for the uploaded manifest.
 	ref, err := reference.WithDigest(imh.Repository.Named(), imh.Digest)
 	if err != nil {
@@ -413,6 +933,46 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
 }
 
+// manifestMediaTypeAllowed reports whether manifests of the given media type
+// may be pushed.
+func (imh *manifestHandler) manifestMediaTypeAllowed(mediaType string) bool {
+	if len(imh.App.manifestMediaTypes) == 0 {
+		return true
+	}
+	for _, allowed := range imh.App.manifestMediaTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineManifest stores payload, a manifest rejected for reason, for later
+// inspection if quarantine is configured. Failing to do so is only logged, as
+// the client is sent the original rejection regardless.
+func (imh *manifestHandler) quarantineManifest(payload []byte, reason error) {
+	if !imh.App.Config.Validation.Manifests.Quarantine {
+		return
+	}
+
+	limit := imh.App.Config.Validation.Manifests.QuarantineLimit
+	if limit <= 0 {
+		limit = defaultQuarantineLimit
+	}
+
+	dgst, err := storage.QuarantineManifest(imh, imh.App.driverFor(imh.Repository.Named().Name()), imh.Repository.Named().Name(), payload, reason, limit)
+	if err == storage.ErrQuarantineFull {
+		dcontext.GetLogger(imh).Warnf("not quarantining rejected manifest: %v", err)
+		return
+	}
+	if err != nil {
+		dcontext.GetLogger(imh).Errorf("error quarantining rejected manifest: %v", err)
+		return
+	}
+
+	dcontext.GetLoggerWithField(imh, "quarantine.digest", dgst).Infof("quarantined rejected manifest: %v", reason)
+}
+
 // applyResourcePolicy checks whether the resource class matches what has
 // been authorized and allowed by the policy configuration.
 func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest) error {
@@ -484,6 +1044,39 @@ func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest)
 
 }
 
+// tagMutable reports whether the tag of the request may be pushed to refer
+// to the manifest being pushed, recording an error if not. A tag which does
+// not exist, or already refers to the manifest, may always be pushed.
+func (imh *manifestHandler) tagMutable() bool {
+	name := imh.Repository.Named().Name()
+	immutable, err := imh.App.immutableTags(imh, name)
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+	if !immutable {
+		return true
+	}
+
+	desc, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return true
+		}
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+	if desc.Digest == imh.Digest {
+		return true
+	}
+
+	imh.Errors = append(imh.Errors, v2.ErrorCodeTagImmutable.WithDetail(map[string]string{
+		"tag":    imh.Tag,
+		"digest": desc.Digest.String(),
+	}))
+	return false
+}
+
 // DeleteManifest removes the manifest with the given digest from the registry.
 func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("DeleteImageManifest")
@@ -499,6 +1092,38 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	tagService := imh.Repository.Tags(imh)
+	referencedTags, err := tagService.Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
+	if err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	// Tags which cannot be untagged because of their aliases are found
+	// before the manifest is deleted, so that it is left in place.
+	if checker, ok := tagService.(distribution.UntagChecker); ok {
+		for _, tag := range referencedTags {
+			if err := checker.CheckUntag(imh, tag); err != nil {
+				if err, ok := err.(distribution.ErrTagAliased); ok {
+					imh.Errors = append(imh.Errors, v2.ErrorCodeTagAliased.WithDetail(err))
+					return
+				}
+				imh.Errors = append(imh.Errors, err)
+				return
+			}
+		}
+	}
+
+	// The delete is recorded before it is made, so that no delete goes
+	// unrecorded.
+	if imh.App.auditLog != nil {
+		if err := imh.App.auditLog.record(imh, "delete manifest", imh.Repository.Named().Name(), imh.Digest); err != nil {
+			dcontext.GetLogger(imh).Errorf("error recording manifest delete in audit log: %v", err)
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail("failed to record delete in audit log"))
+			return
+		}
+	}
+
 	err = manifests.Delete(imh, imh.Digest)
 	if err != nil {
 		switch err {
@@ -518,15 +1143,12 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	tagService := imh.Repository.Tags(imh)
-	referencedTags, err := tagService.Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
-	if err != nil {
-		imh.Errors = append(imh.Errors, err)
-		return
-	}
-
 	for _, tag := range referencedTags {
 		if err := tagService.Untag(imh, tag); err != nil {
+			if err, ok := err.(distribution.ErrTagAliased); ok {
+				imh.Errors = append(imh.Errors, v2.ErrorCodeTagAliased.WithDetail(err))
+				return
+			}
 			imh.Errors = append(imh.Errors, err)
 			return
 		}
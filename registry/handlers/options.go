@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	dcontext "github.com/docker/distribution/context"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// capabilitiesAPIResponse describes the features enabled on the registry. It
+// is returned in response to OPTIONS requests if configured.
+type capabilitiesAPIResponse struct {
+	Methods        []string `json:"methods"`
+	Delete         bool     `json:"delete"`
+	ChunkedUploads bool     `json:"chunkedUploads"`
+	ReadOnly       bool     `json:"readOnly"`
+
+	// ManifestMediaTypes lists the media types of manifests which may be
+	// pushed, if restricted.
+	ManifestMediaTypes []string `json:"manifestMediaTypes,omitempty"`
+}
+
+// optionsHandler returns a handler answering OPTIONS requests to the named
+// route with the methods it supports, given the configuration of the app.
+// OPTIONS requests are answered without authentication, as CORS preflight
+// requests carry no credentials, so the capabilities body, which describes the
+// configuration of the registry, is only included for requests the access
+// controller would allow to read the route.
+func (app *App) optionsHandler(routeName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for headerName, headerValues := range app.Config.HTTP.Headers {
+			for _, value := range headerValues {
+				w.Header().Add(headerName, value)
+			}
+		}
+
+		methods := app.allowedMethods(routeName)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+
+		if !app.Config.HTTP.Options.Capabilities || !app.capabilitiesAuthorized(w, r) {
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		p, err := json.Marshal(capabilitiesAPIResponse{
+			Methods:        methods,
+			Delete:         app.deletable(),
+			ChunkedUploads: app.pushable(),
+			ReadOnly:       app.readOnly,
+
+			ManifestMediaTypes: app.manifestMediaTypes,
+		})
+		if err != nil {
+			dcontext.GetLogger(r.Context()).Errorf("error encoding capabilities: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(p)
+	})
+}
+
+// capabilitiesAuthorized reports whether the capabilities of the route
+// matched by r may be described to the client making the request: whether it
+// would be authorized to make a GET request to the route. No challenge is
+// written to w if it would not.
+func (app *App) capabilitiesAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if app.accessController == nil {
+		return true
+	}
+
+	context := app.context(w, r)
+	repo := getName(context)
+	if repo == "" && app.nameRequired(r) {
+		return false
+	}
+
+	if _, err := app.accessController.Authorized(context.Context, app.accessRecords(r, "GET", repo)...); err != nil {
+		if _, ok := err.(auth.Challenge); !ok {
+			dcontext.GetLogger(context).Errorf("error checking authorization: %v", err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// pushable reports whether content may be pushed to the registry. A pull
+// through cache rejects pushes, since its content comes from the remote.
+func (app *App) pushable() bool {
+	return !app.readOnly && !app.isCache
+}
+
+// deletable reports whether manifests and blobs may be deleted from the
+// registry.
+func (app *App) deletable() bool {
+	return app.pushable() && app.deleteEnabled
+}
+
+// allowedMethods returns the methods supported by the named route, in lexical
+// order. It mirrors the methods registered by the route's dispatcher, omitting
+// those the configuration would cause to fail: writes while read-only, pushes
+// to a pull through cache and deletes unless they are enabled.
+func (app *App) allowedMethods(routeName string) []string {
+	var methods []string
+	writable := !app.readOnly
+	pushable := app.pushable()
+	deletable := app.deletable()
+
+	switch routeName {
+	case v2.RouteNameCatalog, v2.RouteNameCapabilities, v2.RouteNameCatalogUsage, v2.RouteNameTags, v2.RouteNameTagChanges, v2.RouteNameBlobUploadProgress:
+		methods = []string{"GET"}
+	case v2.RouteNameBase, v2.RouteNameDescriptor, v2.RouteNameManifestConfig, v2.RouteNameDefaultManifest:
+		methods = []string{"GET", "HEAD"}
+	case v2.RouteNameManifest:
+		methods = []string{"GET", "HEAD"}
+		if deletable {
+			methods = append(methods, "DELETE")
+		}
+		if pushable {
+			methods = append(methods, "PUT")
+		}
+	case v2.RouteNameBlob:
+		methods = []string{"GET", "HEAD"}
+		if deletable {
+			methods = append(methods, "DELETE")
+		}
+	case v2.RouteNameBlobUpload:
+		if pushable {
+			methods = []string{"POST"}
+		}
+	case v2.RouteNameBlobUploadChunk:
+		methods = []string{"GET", "HEAD"}
+		if pushable {
+			methods = append(methods, "DELETE", "PATCH", "PUT")
+		}
+	case v2.RouteNameTagAlias, v2.RouteNameRepositoryRegistration:
+		methods = []string{"GET"}
+		if writable {
+			methods = append(methods, "DELETE", "PUT")
+		}
+	case v2.RouteNameRepositoryPolicy:
+		methods = []string{"GET"}
+		if writable {
+			methods = append(methods, "PUT")
+		}
+	}
+
+	methods = append(methods, "OPTIONS")
+	sort.Strings(methods)
+	return methods
+}
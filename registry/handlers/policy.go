@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultPolicyCacheTTL is how long the policy overrides of a repository are
+// cached if the configuration does not specify it.
+const defaultPolicyCacheTTL = time.Minute
+
+// repositoryPolicies caches the policy overrides stored for repositories, so
+// that they need not be read from storage on every request. Overrides set
+// through another registry instance take effect once its cached entry
+// expires.
+type repositoryPolicies struct {
+	driverFor func(name string) storagedriver.StorageDriver
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	entries map[string]repositoryPolicyEntry
+}
+
+type repositoryPolicyEntry struct {
+	policy  storage.RepositoryPolicy
+	expires time.Time
+}
+
+func newRepositoryPolicies(driverFor func(name string) storagedriver.StorageDriver, ttl time.Duration) *repositoryPolicies {
+	if ttl <= 0 {
+		ttl = defaultPolicyCacheTTL
+	}
+	return &repositoryPolicies{
+		driverFor: driverFor,
+		ttl:       ttl,
+		entries:   make(map[string]repositoryPolicyEntry),
+	}
+}
+
+// get returns the policy overrides of the named repository.
+func (rp *repositoryPolicies) get(ctx context.Context, name string) (storage.RepositoryPolicy, error) {
+	rp.mu.Lock()
+	entry, ok := rp.entries[name]
+	rp.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.policy, nil
+	}
+
+	policy, err := storage.GetRepositoryPolicy(ctx, rp.driverFor(name), name)
+	if err != nil {
+		return storage.RepositoryPolicy{}, err
+	}
+
+	rp.cache(name, policy)
+	return policy, nil
+}
+
+// put stores policy as the policy overrides of the named repository.
+func (rp *repositoryPolicies) put(ctx context.Context, name string, policy storage.RepositoryPolicy) error {
+	if err := storage.PutRepositoryPolicy(ctx, rp.driverFor(name), name, policy); err != nil {
+		return err
+	}
+
+	rp.cache(name, policy)
+	return nil
+}
+
+func (rp *repositoryPolicies) cache(name string, policy storage.RepositoryPolicy) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	// Expired entries are dropped as others are cached, so that the cache
+	// does not grow with every repository ever seen.
+	now := time.Now()
+	for n, entry := range rp.entries {
+		if !now.Before(entry.expires) {
+			delete(rp.entries, n)
+		}
+	}
+
+	rp.entries[name] = repositoryPolicyEntry{
+		policy:  policy,
+		expires: now.Add(rp.ttl),
+	}
+}
+
+// immutableTags reports whether tags of the named repository may not be moved
+// once pushed, following the configuration unless the repository overrides
+// it.
+func (app *App) immutableTags(ctx context.Context, name string) (bool, error) {
+	policy, err := app.repositoryPolicies.get(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	if policy.ImmutableTags != nil {
+		return *policy.ImmutableTags, nil
+	}
+	return app.Config.Policy.Repository.ImmutableTags, nil
+}
+
+// manifestTTL returns how long after being pushed manifests of the named
+// repository expire, following the configuration unless the repository
+// overrides it. Manifests never expire if the TTL is not positive.
+func (app *App) manifestTTL(ctx context.Context, name string) (time.Duration, error) {
+	policy, err := app.repositoryPolicies.get(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.manifestTTLOf(policy), nil
+}
+
+// manifestTTLOf returns how long after being pushed manifests of a repository
+// with the given policy overrides expire.
+func (app *App) manifestTTLOf(policy storage.RepositoryPolicy) time.Duration {
+	if policy.ManifestTTL != nil {
+		// Stored overrides are validated when put.
+		ttl, _ := time.ParseDuration(*policy.ManifestTTL)
+		return ttl
+	}
+	return app.Config.Policy.Repository.ManifestTTL
+}
+
+// manifestExpired reports whether the manifest identified by dgst has expired
+// from the named repository.
+func (app *App) manifestExpired(ctx context.Context, name string, dgst digest.Digest) (bool, error) {
+	ttl, err := app.manifestTTL(ctx, name)
+	if err != nil || ttl <= 0 {
+		return false, err
+	}
+
+	return storage.ManifestExpired(ctx, app.driverFor(name), name, dgst, ttl, app.now())
+}
+
+// policyDispatcher takes the request context and builds the handler for
+// managing the policy overrides of a repository.
+func policyDispatcher(ctx *Context, r *http.Request) http.Handler {
+	policyHandler := &policyHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(policyHandler.GetPolicy),
+	}
+
+	if !ctx.readOnly {
+		mhandler["PUT"] = http.HandlerFunc(policyHandler.PutPolicy)
+	}
+
+	return mhandler
+}
+
+// policyHandler handles http operations on the policy overrides of
+// repositories.
+type policyHandler struct {
+	*Context
+}
+
+// name returns the validated name of the repository the request refers to.
+// As with registrations, the repository is not resolved for this route, so
+// the name is checked here.
+func (ph *policyHandler) name() (string, bool) {
+	name := getName(ph)
+	if _, err := ph.App.parseName(name); err != nil {
+		ph.Errors = append(ph.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
+		return "", false
+	}
+	return name, true
+}
+
+// GetPolicy returns the policy overrides of the repository.
+func (ph *policyHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	name, ok := ph.name()
+	if !ok {
+		return
+	}
+
+	// Read from storage, rather than the cache, so that the response
+	// reflects overrides set through any registry instance.
+	policy, err := storage.GetRepositoryPolicy(ph, ph.App.driverFor(name), name)
+	if err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(policy); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// PutPolicy replaces the policy overrides of the repository with those given
+// in the request body.
+func (ph *policyHandler) PutPolicy(w http.ResponseWriter, r *http.Request) {
+	name, ok := ph.name()
+	if !ok {
+		return
+	}
+
+	var policy storage.RepositoryPolicy
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		ph.Errors = append(ph.Errors, v2.ErrorCodePolicyInvalid.WithDetail(err.Error()))
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		ph.Errors = append(ph.Errors, v2.ErrorCodePolicyInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	if err := ph.App.repositoryPolicies.put(ph, name, policy); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/manifest/manifestlist"
+)
+
+// prefetchLayers stats the blobs referenced by an image manifest in the
+// background, so that their descriptors are cached by the time the client
+// fetches them. At most layerPrefetchConcurrency blobs are statted at once.
+//
+// Prefetching outlives the request, but is abandoned if the request is
+// cancelled before served is closed, as when the client disconnects before
+// the manifest has been served. Manifest lists are not prefetched, since
+// they reference manifests which the client may never fetch.
+func (imh *manifestHandler) prefetchLayers(manifest distribution.Manifest, served <-chan struct{}) {
+	if _, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+		return
+	}
+	references := manifest.References()
+	if len(references) == 0 {
+		return
+	}
+
+	// The app context, unlike that of the request, is not cancelled when
+	// the handler returns.
+	ctx, cancel := context.WithCancel(dcontext.WithLogger(imh.App, dcontext.GetLogger(imh)))
+	requestDone := imh.Context.Done()
+	go func() {
+		select {
+		case <-requestDone:
+			select {
+			case <-served:
+				// The request completed normally.
+			default:
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+
+	blobs := imh.Repository.Blobs(ctx)
+	concurrency := imh.App.layerPrefetchConcurrency
+	go func() {
+		defer cancel()
+
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, desc := range references {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				dcontext.GetLogger(ctx).Debugf("layer prefetch cancelled: %v", ctx.Err())
+				wg.Wait()
+				return
+			}
+
+			wg.Add(1)
+			go func(desc distribution.Descriptor) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				if _, err := blobs.Stat(ctx, desc.Digest); err != nil && ctx.Err() == nil {
+					dcontext.GetLoggerWithField(ctx, "blob", desc.Digest).Debugf("error prefetching layer: %v", err)
+				}
+			}(desc)
+		}
+		wg.Wait()
+	}()
+}
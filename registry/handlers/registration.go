@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// registrationDispatcher takes the request context and builds the
+// appropriate handler for handling repository registration requests.
+func registrationDispatcher(ctx *Context, r *http.Request) http.Handler {
+	registrationHandler := &registrationHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(registrationHandler.GetRegistration),
+	}
+
+	if !ctx.readOnly {
+		mhandler["PUT"] = http.HandlerFunc(registrationHandler.PutRegistration)
+		mhandler["DELETE"] = http.HandlerFunc(registrationHandler.DeleteRegistration)
+	}
+
+	return mhandler
+}
+
+// registrationHandler handles http operations on repository registrations.
+type registrationHandler struct {
+	*Context
+}
+
+// name returns the validated name of the repository the request refers to.
+// The dispatcher does not resolve the repository for this route, so the name
+// is checked here.
+func (rh *registrationHandler) name() (string, bool) {
+	name := getName(rh)
+	if _, err := rh.App.parseName(name); err != nil {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
+		return "", false
+	}
+	return name, true
+}
+
+// GetRegistration responds with 200 if the repository is registered.
+func (rh *registrationHandler) GetRegistration(w http.ResponseWriter, r *http.Request) {
+	name, ok := rh.name()
+	if !ok {
+		return
+	}
+
+	registered, err := storage.RepositoryRegistered(rh, rh.App.driverFor(name), name)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if !registered {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeNameUnknown.WithDetail(distribution.ErrRepositoryUnknown{Name: name}))
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
+// PutRegistration registers the repository.
+func (rh *registrationHandler) PutRegistration(w http.ResponseWriter, r *http.Request) {
+	name, ok := rh.name()
+	if !ok {
+		return
+	}
+
+	if err := storage.RegisterRepository(rh, rh.App.driverFor(name), name); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DeleteRegistration unregisters the repository, leaving its content in
+// place.
+func (rh *registrationHandler) DeleteRegistration(w http.ResponseWriter, r *http.Request) {
+	name, ok := rh.name()
+	if !ok {
+		return
+	}
+
+	if err := storage.UnregisterRepository(rh, rh.App.driverFor(name), name); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
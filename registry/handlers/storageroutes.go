@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+// storageRoute stores the repositories under a name prefix with a storage
+// driver other than that of the app.
+type storageRoute struct {
+	prefix   string
+	driver   storagedriver.StorageDriver
+	registry distribution.Namespace
+}
+
+// matches reports whether the named repository is stored by the route, being
+// named by the prefix or nested beneath it.
+func (route *storageRoute) matches(name string) bool {
+	return name == route.prefix || strings.HasPrefix(name, route.prefix+"/")
+}
+
+// storageRoutes are the storage routes of an app, ordered so that the longest
+// prefix matching a repository name is found first.
+type storageRoutes []*storageRoute
+
+// match returns the route storing the named repository, or nil if it is
+// stored by the app's own driver.
+func (routes storageRoutes) match(name string) *storageRoute {
+	for _, route := range routes {
+		if route.matches(name) {
+			return route
+		}
+	}
+	return nil
+}
+
+// parseStorageRoutes creates the drivers of the routes configured in the
+// routes section of the storage configuration. Each route maps a repository
+// name prefix to a storage driver, configured as in the storage section
+// itself, by a single key naming the driver.
+func parseStorageRoutes(config map[string]interface{}) (storageRoutes, error) {
+	var routes storageRoutes
+	for prefix, v := range config {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" {
+			return nil, fmt.Errorf("storage route prefix must not be empty")
+		}
+		if _, err := reference.WithName(prefix); err != nil {
+			return nil, fmt.Errorf("storage route prefix %q is not a repository name: %v", prefix, err)
+		}
+
+		drivers, ok := v.(map[interface{}]interface{})
+		if !ok || len(drivers) != 1 {
+			return nil, fmt.Errorf("storage route %q must configure exactly one driver", prefix)
+		}
+
+		for k, v := range drivers {
+			name, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("storage route %q driver name must be a string", prefix)
+			}
+
+			parameters := make(map[string]interface{})
+			switch p := v.(type) {
+			case nil:
+			case map[interface{}]interface{}:
+				for k, v := range p {
+					key, ok := k.(string)
+					if !ok {
+						return nil, fmt.Errorf("storage route %q parameter names must be strings", prefix)
+					}
+					parameters[key] = v
+				}
+			default:
+				return nil, fmt.Errorf("storage route %q parameters must be a map", prefix)
+			}
+
+			driver, err := factory.Create(name, parameters)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create driver of storage route %q: %v", prefix, err)
+			}
+			routes = append(routes, &storageRoute{prefix: prefix, driver: driver})
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	return routes, nil
+}
+
+// driverFor returns the storage driver storing the named repository.
+func (app *App) driverFor(name string) storagedriver.StorageDriver {
+	if route := app.storageRoutes.match(name); route != nil {
+		return route.driver
+	}
+	return app.driver
+}
+
+// routedNamespace routes the operations on each repository to the registry
+// of the storage route matching its name, or to the embedded registry if none
+// does. Blobs and BlobStatter, which are not scoped to a repository, are those
+// of the embedded registry.
+type routedNamespace struct {
+	distribution.Namespace
+	routes storageRoutes
+}
+
+var _ distribution.RepositoryRemover = &routedNamespace{}
+
+func (rn *routedNamespace) registryFor(name string) distribution.Namespace {
+	if route := rn.routes.match(name); route != nil {
+		return route.registry
+	}
+	return rn.Namespace
+}
+
+func (rn *routedNamespace) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	return rn.registryFor(name.Name()).Repository(ctx, name)
+}
+
+// Repositories merges the catalogs of all the registries, in lexical order.
+// Each registry only lists the repositories routed to it, so that
+// repositories stored before a route was configured are not listed.
+func (rn *routedNamespace) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	if len(repos) == 0 {
+		return 0, nil
+	}
+
+	var merged []string
+	done := true
+	for _, registry := range append([]distribution.Namespace{rn.Namespace}, rn.routeRegistries()...) {
+		names, exhausted, err := rn.routedRepositories(ctx, registry, len(repos), last)
+		if err != nil {
+			return 0, err
+		}
+		merged = append(merged, names...)
+		done = done && exhausted
+	}
+
+	sort.Strings(merged)
+	if len(merged) > len(repos) {
+		merged = merged[:len(repos)]
+		done = false
+	}
+
+	n := copy(repos, merged)
+	if done {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (rn *routedNamespace) routeRegistries() []distribution.Namespace {
+	registries := make([]distribution.Namespace, len(rn.routes))
+	for i, route := range rn.routes {
+		registries[i] = route.registry
+	}
+	return registries
+}
+
+// routedRepositories returns up to n repositories of registry following last
+// which are routed to it, and whether they are the last.
+func (rn *routedNamespace) routedRepositories(ctx context.Context, registry distribution.Namespace, n int, last string) ([]string, bool, error) {
+	var names []string
+	buf := make([]string, n)
+	for len(names) < n {
+		filled, err := registry.Repositories(ctx, buf, last)
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		for _, name := range buf[:filled] {
+			if rn.registryFor(name) != registry {
+				continue
+			}
+			if len(names) == n {
+				return names, false, nil
+			}
+			names = append(names, name)
+		}
+		if err == io.EOF || filled == 0 {
+			return names, true, nil
+		}
+		last = buf[filled-1]
+	}
+	return names, false, nil
+}
+
+func (rn *routedNamespace) Remove(ctx context.Context, name reference.Named) error {
+	remover, ok := rn.registryFor(name.Name()).(distribution.RepositoryRemover)
+	if !ok {
+		return fmt.Errorf("registry of %s does not support removing repositories", name.Name())
+	}
+	return remover.Remove(ctx, name)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// tagAliasDispatcher constructs the handler for managing tag aliases.
+func tagAliasDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagAliasHandler := &tagAliasHandler{
+		Context: ctx,
+		Tag:     dcontext.GetStringValue(ctx, "vars.tag"),
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(tagAliasHandler.GetTagAlias),
+	}
+
+	if !ctx.readOnly {
+		mhandler["PUT"] = http.HandlerFunc(tagAliasHandler.PutTagAlias)
+		mhandler["DELETE"] = http.HandlerFunc(tagAliasHandler.DeleteTagAlias)
+	}
+
+	return mhandler
+}
+
+// tagAliasHandler handles requests for the alias held by a tag.
+type tagAliasHandler struct {
+	*Context
+
+	// Tag is the alias the request refers to.
+	Tag string
+}
+
+type tagAliasAPIResponse struct {
+	Tag    string `json:"tag"`
+	Target string `json:"target"`
+}
+
+type tagAliasAPIRequest struct {
+	Target string `json:"target"`
+}
+
+// GetTagAlias returns the target of the alias.
+func (tah *tagAliasHandler) GetTagAlias(w http.ResponseWriter, r *http.Request) {
+	target, ok := tah.target()
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(tagAliasAPIResponse{
+		Tag:    tah.Tag,
+		Target: target,
+	}); err != nil {
+		tah.Errors = append(tah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// PutTagAlias makes the tag an alias of the target given in the request body.
+func (tah *tagAliasHandler) PutTagAlias(w http.ResponseWriter, r *http.Request) {
+	var request tagAliasAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		tah.Errors = append(tah.Errors, v2.ErrorCodeTagInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	if _, err := reference.WithTag(tah.Repository.Named(), request.Target); err != nil {
+		tah.Errors = append(tah.Errors, v2.ErrorCodeTagInvalid.WithDetail(map[string]string{"target": request.Target}))
+		return
+	}
+
+	if tah.App.rejectDigestLikeTags && digestLikeTagRegexp.MatchString(tah.Tag) {
+		tah.Errors = append(tah.Errors, v2.ErrorCodeTagInvalid.WithDetail(fmt.Sprintf("tag %q resembles a digest", tah.Tag)))
+		return
+	}
+
+	err := storage.SetTagAlias(tah, tah.App.driverFor(tah.Repository.Named().Name()), tah.Repository.Named().Name(), tah.Tag, request.Target)
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrTagAliasCycle:
+			tah.Errors = append(tah.Errors, v2.ErrorCodeTagInvalid.WithDetail(err))
+		case distribution.ErrTagUnknown:
+			tah.Errors = append(tah.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		default:
+			tah.Errors = append(tah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DeleteTagAlias removes the alias, leaving the tag unknown.
+func (tah *tagAliasHandler) DeleteTagAlias(w http.ResponseWriter, r *http.Request) {
+	if _, ok := tah.target(); !ok {
+		return
+	}
+
+	if err := storage.RemoveTagAlias(tah, tah.App.driverFor(tah.Repository.Named().Name()), tah.Repository.Named().Name(), tah.Tag); err != nil {
+		tah.Errors = append(tah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// target returns the target of the alias, recording an error if the tag is
+// not an alias.
+func (tah *tagAliasHandler) target() (string, bool) {
+	target, err := storage.TagAlias(tah, tah.App.driverFor(tah.Repository.Named().Name()), tah.Repository.Named().Name(), tah.Tag)
+	if err != nil {
+		tah.Errors = append(tah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return "", false
+	}
+	if target == "" {
+		tah.Errors = append(tah.Errors, v2.ErrorCodeManifestUnknown.WithDetail(map[string]string{"alias": tah.Tag}))
+		return "", false
+	}
+	return target, true
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// tagChangesDispatcher constructs the handler for following changes to the
+// tags of a repository.
+func tagChangesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagChangesHandler := &tagChangesHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(tagChangesHandler.GetTagChanges),
+	}
+}
+
+// tagChangesHandler handles requests for the changes to the tags of a
+// repository.
+type tagChangesHandler struct {
+	*Context
+}
+
+type tagChangesAPIResponse struct {
+	Name   string             `json:"name"`
+	Events []storage.TagEvent `json:"events"`
+	Cursor string             `json:"cursor"`
+}
+
+// GetTagChanges returns the changes to the tags of the repository since the
+// cursor given, with the cursor from which to fetch the following changes.
+// If n is given, at most n changes are returned.
+func (tch *tagChangesHandler) GetTagChanges(w http.ResponseWriter, r *http.Request) {
+	if !tch.App.tagEventsEnabled {
+		tch.Errors = append(tch.Errors, errcode.ErrorCodeUnsupported.WithDetail("tag event log is not enabled"))
+		return
+	}
+
+	q := r.URL.Query()
+	maxEntries, err := strconv.Atoi(q.Get("n"))
+	if err != nil || maxEntries < 0 {
+		maxEntries = 0
+	}
+
+	name := tch.Repository.Named().Name()
+	events, cursor, err := storage.TagEvents(tch, tch.App.driverFor(name), name, q.Get("cursor"), maxEntries)
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrTagEventCursorInvalid:
+			tch.Errors = append(tch.Errors, v2.ErrorCodeCursorInvalid.WithDetail(err))
+		default:
+			tch.Errors = append(tch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+	if events == nil {
+		events = []storage.TagEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(tagChangesAPIResponse{
+		Name:   name,
+		Events: events,
+		Cursor: cursor,
+	}); err != nil {
+		tch.Errors = append(tch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -3,13 +3,20 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
 	"github.com/gorilla/handlers"
 )
 
+// tagSortPushedDesc orders tags by push time, newest first.
+const tagSortPushedDesc = "pushed_desc"
+
 // tagsDispatcher constructs the tags handler api endpoint.
 func tagsDispatcher(ctx *Context, r *http.Request) http.Handler {
 	tagsHandler := &tagsHandler{
@@ -31,10 +38,19 @@ type tagsAPIResponse struct {
 	Tags []string `json:"tags"`
 }
 
-// GetTags returns a json list of tags for a specific image name.
+// GetTags returns a json list of tags for a specific image name. Tags are
+// listed lexically unless the sort parameter requests otherwise. If n is
+// given, at most n tags are returned, with a Link header to the next page.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	q := r.URL.Query()
+	order := q.Get("sort")
+	if order != "" && order != tagSortPushedDesc {
+		th.Errors = append(th.Errors, v2.ErrorCodeSortInvalid.WithDetail(map[string]string{"sort": order}))
+		return
+	}
+
 	tagService := th.Repository.Tags(th)
 	tags, err := tagService.All(th)
 	if err != nil {
@@ -49,6 +65,43 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lastEntry := q.Get("last")
+	if order == tagSortPushedDesc {
+		tags, err = th.sortByPushTime(tags)
+		if err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		// Push order has no natural successor for an arbitrary string, so
+		// last must name a listed tag. If it is gone, start over.
+		if lastEntry != "" {
+			for i, tag := range tags {
+				if tag == lastEntry {
+					tags = tags[i+1:]
+					break
+				}
+			}
+		}
+	} else {
+		sort.Strings(tags)
+		if lastEntry != "" {
+			tags = tags[sort.Search(len(tags), func(i int) bool { return tags[i] > lastEntry }):]
+		}
+	}
+
+	if maxEntries, err := strconv.Atoi(q.Get("n")); err == nil && maxEntries >= 0 && len(tags) > maxEntries {
+		tags = tags[:maxEntries]
+		if maxEntries > 0 {
+			urlStr, err := createLinkEntry(r.URL.String(), maxEntries, tags[maxEntries-1])
+			if err != nil {
+				th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+			w.Header().Set("Link", urlStr)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	enc := json.NewEncoder(w)
@@ -60,3 +113,26 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// sortByPushTime orders tags newest push first, breaking ties lexically.
+func (th *tagsHandler) sortByPushTime(tags []string) ([]string, error) {
+	name := th.Repository.Named().Name()
+	pushedAt := make(map[string]time.Time, len(tags))
+	for _, tag := range tags {
+		t, err := storage.TagPushedAt(th, th.App.driverFor(name), name, tag)
+		if err != nil {
+			return nil, err
+		}
+		pushedAt[tag] = t
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		ti, tj := pushedAt[tags[i]], pushedAt[tags[j]]
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return tags[i] < tags[j]
+	})
+
+	return tags, nil
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// defaultUploadProgressInterval is how often the progress of an upload is
+// polled if the configuration does not specify it.
+const defaultUploadProgressInterval = time.Second
+
+// uploadProgressDispatcher constructs the handler for following the progress
+// of a blob upload.
+func uploadProgressDispatcher(ctx *Context, r *http.Request) http.Handler {
+	uploadProgressHandler := &uploadProgressHandler{
+		Context: ctx,
+		UUID:    getUploadUUID(ctx),
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(uploadProgressHandler.GetUploadProgress),
+	}
+}
+
+// uploadProgressHandler handles requests for the progress of a blob upload.
+type uploadProgressHandler struct {
+	*Context
+
+	// UUID identifies the upload followed.
+	UUID string
+}
+
+// GetUploadProgress streams the number of bytes received by the upload as
+// Server-Sent Events: a progress event on connecting and whenever it
+// advances, then an end event once the upload is completed or canceled. The
+// stream is polled from storage, so reflects data received by any registry
+// instance.
+func (uph *uploadProgressHandler) GetUploadProgress(w http.ResponseWriter, r *http.Request) {
+	config := uph.App.Config.HTTP.UploadProgress
+	if !config.Enabled {
+		uph.Errors = append(uph.Errors, errcode.ErrorCodeUnsupported.WithDetail("upload progress reporting is not enabled"))
+		return
+	}
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultUploadProgressInterval
+	}
+
+	name := uph.Repository.Named().Name()
+	driver := uph.App.driverFor(name)
+	offset, err := storage.UploadOffset(uph, driver, name, uph.UUID)
+	if err != nil {
+		if err == distribution.ErrBlobUploadUnknown {
+			uph.Errors = append(uph.Errors, v2.ErrorCodeBlobUploadUnknown)
+		} else {
+			uph.Errors = append(uph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, offset int64) bool {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: {\"offset\":%d}\n\n", event, offset); err != nil {
+			return false
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	if !send("progress", offset) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			// The client disconnected.
+			return
+		}
+
+		current, err := storage.UploadOffset(uph, driver, name, uph.UUID)
+		switch {
+		case err == distribution.ErrBlobUploadUnknown:
+			send("end", offset)
+			return
+		case err != nil:
+			// The response has begun, so the error can only be logged.
+			// The stream ends, and the client may reconnect.
+			dcontext.GetLogger(uph).Errorf("error polling upload progress: %v", err)
+			return
+		case current != offset:
+			offset = current
+			if !send("progress", offset) {
+				return
+			}
+		}
+	}
+}
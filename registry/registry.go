@@ -16,7 +16,6 @@ import (
 
 	logstash "github.com/bshuster-repo/logrus-logstash-hook"
 	"github.com/bugsnag/bugsnag-go"
-	"github.com/docker/go-metrics"
 	gorhandlers "github.com/gorilla/handlers"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -54,12 +53,12 @@ var ServeCmd = &cobra.Command{
 		}
 
 		if config.HTTP.Debug.Addr != "" {
-			go func(addr string) {
+			go func(addr string, handler http.Handler) {
 				log.Infof("debug server listening %v", addr)
-				if err := http.ListenAndServe(addr, nil); err != nil {
+				if err := http.ListenAndServe(addr, handler); err != nil {
 					log.Fatalf("error listening on debug interface: %v", err)
 				}
-			}(config.HTTP.Debug.Addr)
+			}(config.HTTP.Debug.Addr, newDebugHandler(config))
 		}
 
 		registry, err := NewRegistry(ctx, config)
@@ -67,15 +66,6 @@ var ServeCmd = &cobra.Command{
 			log.Fatalln(err)
 		}
 
-		if config.HTTP.Debug.Prometheus.Enabled {
-			path := config.HTTP.Debug.Prometheus.Path
-			if path == "" {
-				path = "/metrics"
-			}
-			log.Info("providing prometheus metrics on ", path)
-			http.Handle(path, metrics.Handler())
-		}
-
 		if err = registry.ListenAndServe(); err != nil {
 			log.Fatalln(err)
 		}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
@@ -157,3 +158,33 @@ log:
 		t.Error("field baz not configured correctly; expected 'xyzzy' got: ", val)
 	}
 }
+
+// TestDebugHandler ensures that the profiling endpoints are only served by
+// the debug server when enabled, under the configured path.
+func TestDebugHandler(t *testing.T) {
+	get := func(handler http.Handler, path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	config := &configuration.Configuration{}
+	handler := newDebugHandler(config)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"} {
+		if code := get(handler, path); code != http.StatusNotFound {
+			t.Fatalf("unexpected status for %s with profiling disabled: %d", path, code)
+		}
+	}
+
+	config.HTTP.Debug.Profiling.Enabled = true
+	config.HTTP.Debug.Profiling.Path = "/_debug/"
+	handler = newDebugHandler(config)
+	for _, path := range []string{"/_debug/pprof/", "/_debug/pprof/cmdline", "/_debug/pprof/goroutine", "/_debug/vars"} {
+		if code := get(handler, path); code != http.StatusOK {
+			t.Fatalf("unexpected status for %s with profiling enabled: %d", path, code)
+		}
+	}
+	if code := get(handler, "/debug/pprof/"); code != http.StatusNotFound {
+		t.Fatalf("unexpected status for default path with profiling moved: %d", code)
+	}
+}
@@ -3,12 +3,15 @@ package registry
 import (
 	"fmt"
 	"os"
+	"time"
 
 	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/storage"
 	"github.com/docker/distribution/registry/storage/driver/factory"
 	"github.com/docker/distribution/version"
 	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
 	"github.com/spf13/cobra"
 )
 
@@ -17,8 +20,14 @@ var showVersion bool
 func init() {
 	RootCmd.AddCommand(ServeCmd)
 	RootCmd.AddCommand(GCCmd)
+	RootCmd.AddCommand(MissingBlobsCmd)
+	RootCmd.AddCommand(RebuildTagsCmd)
+	RootCmd.AddCommand(MigrateLayoutCmd)
+	RootCmd.AddCommand(ReclaimBlobCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	GCCmd.Flags().DurationVar(&gracePeriod, "grace-period", 0, "keep unreferenced blobs written less than this long ago, such as 1h")
+	GCCmd.Flags().BoolVar(&rebuildBlobIndex, "rebuild-blob-index", false, "rebuild the blob index from the blob store before collecting")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -38,6 +47,8 @@ var RootCmd = &cobra.Command{
 
 var dryRun bool
 var removeUntagged bool
+var rebuildBlobIndex bool
+var gracePeriod time.Duration
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
 var GCCmd = &cobra.Command{
@@ -71,7 +82,21 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		registry, err := storage.NewRegistry(ctx, driver, storage.Schema1SigningKey(k))
+		options := []storage.RegistryOption{storage.Schema1SigningKey(k)}
+		if bi, ok := config.Storage["blobindex"]; ok {
+			if enabled, ok := bi["enabled"].(bool); ok && enabled {
+				options = append(options, storage.EnableBlobIndex)
+			}
+		}
+
+		if rebuildBlobIndex {
+			if err := storage.NewBlobIndex(driver).Rebuild(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rebuild blob index: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, options...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
 			os.Exit(1)
@@ -80,6 +105,7 @@ var GCCmd = &cobra.Command{
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
+			GracePeriod:    gracePeriod,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
@@ -87,3 +113,240 @@ var GCCmd = &cobra.Command{
 		}
 	},
 }
+
+// MissingBlobsCmd is the cobra command that corresponds to the missing-blobs
+// subcommand
+var MissingBlobsCmd = &cobra.Command{
+	Use:   "missing-blobs <config>",
+	Short: "`missing-blobs` lists blobs referenced by manifests but missing from storage",
+	Long:  "`missing-blobs` lists blobs referenced by manifests but missing from storage, such as after a partial restore, along with the manifests referencing them",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		k, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, storage.Schema1SigningKey(k))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		missing := 0
+		err = storage.FindMissingBlobs(ctx, driver, registry, func(blob storage.MissingBlob) error {
+			missing++
+			fmt.Printf("%s\t%s@%s\n", blob.Digest, blob.Repository, blob.Manifest)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to find missing blobs: %v", err)
+			os.Exit(1)
+		}
+
+		if missing > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+// RebuildTagsCmd is the cobra command that corresponds to the rebuild-tags
+// subcommand
+var RebuildTagsCmd = &cobra.Command{
+	Use:   "rebuild-tags <config> <repository>...",
+	Short: "`rebuild-tags` restores missing tags from the tags named by manifests",
+	Long:  "`rebuild-tags` restores missing tags of the given repositories from the tags named by their schema1 manifests, such as after the tag index is damaged, and lists tags which could not be restored",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "no repositories given")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		k, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, storage.Schema1SigningKey(k))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		conflicts := 0
+		for _, name := range args[1:] {
+			named, err := reference.WithName(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid repository name %s: %v", name, err)
+				os.Exit(1)
+			}
+
+			repository, err := registry.Repository(ctx, named)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to construct repository %s: %v", name, err)
+				os.Exit(1)
+			}
+
+			found, err := storage.RebuildTags(ctx, repository)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rebuild tags of %s: %v", name, err)
+				os.Exit(1)
+			}
+
+			for _, conflict := range found {
+				conflicts++
+				fmt.Printf("%s:%s\tcurrent=%s\tclaimed by %v\n", name, conflict.Tag, conflict.Current, conflict.Claimants)
+			}
+		}
+
+		if conflicts > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+// MigrateLayoutCmd is the cobra command that corresponds to the
+// migrate-layout subcommand
+var MigrateLayoutCmd = &cobra.Command{
+	Use:   "migrate-layout <config>",
+	Short: "`migrate-layout` rewrites storage to the current layout",
+	Long:  "`migrate-layout` moves content written in an older storage layout to its paths in the current layout and records the current layout version. An interrupted migration is resumed by running it again. Registries using the storage should be stopped while it runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		moved, err := storage.MigrateLayout(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate storage layout after moving %d blobs: %v", moved, err)
+			os.Exit(1)
+		}
+		fmt.Printf("storage layout is version %d, moved %d blobs\n", storage.CurrentLayoutVersion, moved)
+	},
+}
+
+// ReclaimBlobCmd is the cobra command that corresponds to the reclaim-blob
+// subcommand
+var ReclaimBlobCmd = &cobra.Command{
+	Use:   "reclaim-blob <config> <repository> <digest>",
+	Short: "`reclaim-blob` deletes a blob and its content if no other repository links it",
+	Long:  "`reclaim-blob` deletes a blob from a repository and removes its content from storage at once, to relieve pressure on storage, provided no other repository links the blob. Nothing is deleted otherwise. Registries using the storage should be stopped or read-only while it runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "a repository and a digest are required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		named, err := reference.WithName(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid repository name %s: %v", args[1], err)
+			os.Exit(1)
+		}
+
+		dgst, err := digest.Parse(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid digest %s: %v", args[2], err)
+			os.Exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		k, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, storage.Schema1SigningKey(k), storage.EnableDelete)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		if err := storage.ReclaimBlob(ctx, driver, registry, named, dgst); err != nil {
+			if _, ok := err.(storage.ErrBlobReferenced); ok {
+				fmt.Fprintf(os.Stderr, "not reclaimed: %v\n", err)
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "failed to reclaim blob: %v", err)
+			os.Exit(1)
+		}
+	},
+}
@@ -2,18 +2,27 @@ package storage
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http/httptest"
 	"path"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/storage/cache/memory"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/docker/distribution/registry/storage/driver/testdriver"
 	"github.com/docker/distribution/testutil"
 	"github.com/opencontainers/go-digest"
@@ -514,6 +523,73 @@ func TestBlobMount(t *testing.T) {
 	}
 }
 
+// TestBlobMountPreservesMediaType ensures that, with media types preserved, a
+// blob mounted into a repository is described and served there with the media
+// type and size it was put with.
+func TestBlobMountPreservesMediaType(t *testing.T) {
+	ctx := context.Background()
+	sourceImageName, _ := reference.WithName("foo/source")
+	imageName, _ := reference.WithName("foo/target")
+	registry, err := NewRegistry(ctx, inmemory.New(), BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider()), PreserveBlobMediaTypes)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	sourceRepository, err := registry.Repository(ctx, sourceImageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	const mediaType = "application/vnd.example.config.v1+json"
+	content := []byte(`{"example": true}`)
+	desc, err := sourceRepository.Blobs(ctx).Put(ctx, mediaType, content)
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if desc.MediaType != mediaType {
+		t.Fatalf("unexpected media type: %q != %q", desc.MediaType, mediaType)
+	}
+
+	canonicalRef, err := reference.WithDigest(sourceImageName, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs := repository.Blobs(ctx)
+	_, err = bs.Create(ctx, WithMountFrom(canonicalRef))
+	ebm, ok := err.(distribution.ErrBlobMounted)
+	if !ok {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+	if ebm.Descriptor.MediaType != mediaType || ebm.Descriptor.Size != int64(len(content)) {
+		t.Fatalf("unexpected mounted descriptor: %v", ebm.Descriptor)
+	}
+
+	statDesc, err := bs.Stat(ctx, desc.Digest)
+	if err != nil {
+		t.Fatalf("unexpected error checking for existence: %v", err)
+	}
+	if statDesc.MediaType != mediaType || statDesc.Size != int64(len(content)) {
+		t.Fatalf("unexpected descriptor in target repository: %v", statDesc)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("HEAD", "/", nil)
+	if err := bs.ServeBlob(ctx, w, r, desc.Digest); err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != mediaType {
+		t.Fatalf("unexpected Content-Type: %q != %q", contentType, mediaType)
+	}
+	if contentLength := w.Header().Get("Content-Length"); contentLength != fmt.Sprint(len(content)) {
+		t.Fatalf("unexpected Content-Length: %q != %d", contentLength, len(content))
+	}
+}
+
 // TestLayerUploadZeroLength uploads zero-length
 func TestLayerUploadZeroLength(t *testing.T) {
 	ctx := context.Background()
@@ -532,6 +608,402 @@ func TestLayerUploadZeroLength(t *testing.T) {
 	simpleUpload(t, bs, []byte{}, digestSha256Empty)
 }
 
+// uploadReadDriver counts the readers opened on upload data.
+type uploadReadDriver struct {
+	storagedriver.StorageDriver
+	uploadReads int
+}
+
+func (d *uploadReadDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if strings.Contains(path, "/_uploads/") {
+		d.uploadReads++
+	}
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+// TestRequireRunningDigestWithoutResumption ensures that the running digest
+// cannot be required when it could not be resumed between requests.
+func TestRequireRunningDigestWithoutResumption(t *testing.T) {
+	_, err := NewRegistry(context.Background(), inmemory.New(), RequireRunningDigest, DisableDigestResumption)
+	if err == nil {
+		t.Fatal("expected error requiring running digest without resumption")
+	}
+}
+
+// TestBlobUploadRunningDigest ensures that, when the running digest is
+// required, chunked uploads resumed across registry instances are verified
+// without reading their data back.
+func TestBlobUploadRunningDigest(t *testing.T) {
+	if !resumableDigestSupported {
+		t.Skip("resumable digests are not supported by this build")
+	}
+
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := &uploadReadDriver{StorageDriver: inmemory.New()}
+
+	// blobs returns the blob store of a new registry instance.
+	blobs := func() distribution.BlobStore {
+		registry, err := NewRegistry(ctx, driver, RequireRunningDigest)
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		return repository.Blobs(ctx)
+	}
+
+	// upload writes each chunk in a separate session of a separate
+	// instance, returning the session for completion.
+	upload := func(chunks ...[]byte) distribution.BlobWriter {
+		bw, err := blobs().Create(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		for _, chunk := range chunks {
+			if bw, err = blobs().Resume(ctx, bw.ID()); err != nil {
+				t.Fatalf("unexpected error resuming upload: %v", err)
+			}
+			if _, err := bw.Write(chunk); err != nil {
+				t.Fatalf("unexpected error writing chunk: %v", err)
+			}
+			if err := bw.Close(); err != nil {
+				t.Fatalf("unexpected error closing upload: %v", err)
+			}
+		}
+		bw, err = blobs().Resume(ctx, bw.ID())
+		if err != nil {
+			t.Fatalf("unexpected error resuming upload: %v", err)
+		}
+		return bw
+	}
+
+	content := make([]byte, 3072)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("error generating content: %v", err)
+	}
+	chunks := [][]byte{content[:1024], content[1024:2048], content[2048:]}
+	dgst := digest.FromBytes(content)
+
+	_, err := upload(chunks...).Commit(ctx, distribution.Descriptor{Digest: digest.FromString("other")})
+	if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+		t.Fatalf("expected ErrBlobInvalidDigest committing with the wrong digest, got %v", err)
+	}
+
+	desc, err := upload(chunks...).Commit(ctx, distribution.Descriptor{Digest: dgst})
+	if err != nil {
+		t.Fatalf("unexpected error committing upload: %v", err)
+	}
+	if desc.Digest != dgst || desc.Size != int64(len(content)) {
+		t.Fatalf("unexpected descriptor: %v", desc)
+	}
+
+	if driver.uploadReads != 0 {
+		t.Fatalf("upload data was read back %d times", driver.uploadReads)
+	}
+
+	// Without its saved state, the running digest of an upload is
+	// unavailable, and the upload is not read back to compute it.
+	bw := upload(chunks...)
+	hashStatesPath, err := pathFor(uploadHashStatePathSpec{
+		name: imageName.String(),
+		id:   bw.ID(),
+		alg:  digest.Canonical,
+		list: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Delete(ctx, hashStatesPath); err != nil {
+		t.Fatalf("error removing hash states: %v", err)
+	}
+
+	bw, err = blobs().Resume(ctx, bw.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	_, err = bw.Commit(ctx, distribution.Descriptor{Digest: dgst})
+	if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+		t.Fatalf("expected ErrBlobInvalidDigest without a running digest, got %v", err)
+	}
+	if driver.uploadReads != 0 {
+		t.Fatalf("upload data was read back %d times", driver.uploadReads)
+	}
+}
+
+// corruptReadDriver flips the first byte of upload data read back from it,
+// as a backend silently corrupting writes would.
+type corruptReadDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d *corruptReadDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || !strings.Contains(path, "/_uploads/") {
+		return rc, err
+	}
+	defer rc.Close()
+
+	p, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 && len(p) > 0 {
+		p[0] ^= 0xff
+	}
+	return ioutil.NopCloser(bytes.NewReader(p)), nil
+}
+
+// TestBlobUploadVerifyWrites ensures that, when writes are verified, an
+// upload whose data is corrupted by the backend is rejected and not linked,
+
+// writeCountingDriver counts the uploads assembled by committing their
+// writers, and the files moved into place.
+type writeCountingDriver struct {
+	storagedriver.StorageDriver
+	commits, moves int
+}
+
+func (d *writeCountingDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+	return &commitCountingWriter{FileWriter: fw, commits: &d.commits}, nil
+}
+
+func (d *writeCountingDriver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	d.moves++
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+type commitCountingWriter struct {
+	storagedriver.FileWriter
+	commits *int
+}
+
+func (w *commitCountingWriter) Commit() error {
+	*w.commits++
+	return w.FileWriter.Commit()
+}
+
+// TestBlobUploadDeduplicate ensures that, when uploads are deduplicated, an
+// upload of content already stored is linked without its data being
+// assembled or moved, while other uploads complete as usual.
+func TestBlobUploadDeduplicate(t *testing.T) {
+	ctx := context.Background()
+	driver := &writeCountingDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, driver, DeduplicateUploads)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	blobs := func(name string) distribution.BlobStore {
+		named, _ := reference.WithName(name)
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		return repository.Blobs(ctx)
+	}
+
+	content := make([]byte, 1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("error generating content: %v", err)
+	}
+	desc := distribution.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}
+
+	if _, err := addBlob(ctx, blobs("foo/first"), desc, bytes.NewReader(content)); err != nil {
+		t.Fatalf("unexpected error committing upload: %v", err)
+	}
+	if driver.commits != 1 || driver.moves != 1 {
+		t.Fatalf("expected new content to be assembled and moved once, got %d commits and %d moves", driver.commits, driver.moves)
+	}
+
+	second := blobs("foo/second")
+	if _, err := addBlob(ctx, second, desc, bytes.NewReader(content)); err != nil {
+		t.Fatalf("unexpected error committing duplicate upload: %v", err)
+	}
+	if driver.commits != 1 || driver.moves != 1 {
+		t.Fatalf("expected duplicate content not to be written, got %d commits and %d moves", driver.commits, driver.moves)
+	}
+
+	p, err := second.Get(ctx, desc.Digest)
+	if err != nil {
+		t.Fatalf("error getting duplicate blob: %v", err)
+	}
+	if !bytes.Equal(p, content) {
+		t.Fatalf("unexpected content of duplicate blob")
+	}
+
+	uploadsPath, err := pathFor(uploadDataPathSpec{name: "foo/second", id: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := driver.List(ctx, path.Dir(path.Dir(uploadsPath))); err == nil && len(entries) != 0 {
+		t.Fatalf("expected upload resources to be removed, got %v", entries)
+	}
+
+	// Content which does not match the declared digest is rejected, even
+	// though content of the digest is stored.
+	other := make([]byte, len(content))
+	if _, err := addBlob(ctx, blobs("foo/third"), desc, bytes.NewReader(other)); err == nil {
+		t.Fatal("expected mismatched upload to fail")
+	} else if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+		t.Fatalf("expected ErrBlobInvalidDigest, got %v", err)
+	}
+}
+
+// even though it matched its digest as it was received.
+func TestBlobUploadVerifyWrites(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+
+	content := make([]byte, 1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("error generating content: %v", err)
+	}
+	dgst := digest.FromBytes(content)
+
+	for _, testcase := range []struct {
+		name    string
+		driver  storagedriver.StorageDriver
+		options []RegistryOption
+		err     bool
+	}{
+		{name: "unverified", driver: &corruptReadDriver{StorageDriver: inmemory.New()}},
+		{name: "verified", driver: &corruptReadDriver{StorageDriver: inmemory.New()}, options: []RegistryOption{VerifyBlobWrites}, err: true},
+		{name: "intact", driver: inmemory.New(), options: []RegistryOption{VerifyBlobWrites}},
+	} {
+		registry, err := NewRegistry(ctx, testcase.driver, testcase.options...)
+		if err != nil {
+			t.Fatalf("%s: error creating registry: %v", testcase.name, err)
+		}
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("%s: unexpected error getting repo: %v", testcase.name, err)
+		}
+		bs := repository.Blobs(ctx)
+
+		_, err = addBlob(ctx, bs, distribution.Descriptor{Digest: dgst, Size: int64(len(content))}, bytes.NewReader(content))
+		if !testcase.err {
+			if err != nil {
+				t.Fatalf("%s: unexpected error committing upload: %v", testcase.name, err)
+			}
+			continue
+		}
+
+		mismatch, ok := err.(distribution.ErrBlobWriteMismatch)
+		if !ok {
+			t.Fatalf("%s: expected ErrBlobWriteMismatch, got %v", testcase.name, err)
+		}
+		if mismatch.Digest != dgst || mismatch.Actual == dgst {
+			t.Fatalf("%s: unexpected mismatch: %v", testcase.name, mismatch)
+		}
+		if _, err := bs.Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+			t.Fatalf("%s: expected corrupted blob not to be linked, got %v", testcase.name, err)
+		}
+	}
+}
+
+func TestBlobInline(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+
+	registry, err := NewRegistry(ctx, driver, InlineBlobSize(1024), RepositoryQuotas(1<<20, nil))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	var usage int64
+	for _, testcase := range []struct {
+		name   string
+		size   int
+		inline bool
+	}{
+		{name: "tiny", size: 100, inline: true},
+		{name: "large", size: 4096},
+	} {
+		content := make([]byte, testcase.size)
+		if _, err := rand.Read(content); err != nil {
+			t.Fatalf("%s: error generating content: %v", testcase.name, err)
+		}
+		dgst := digest.FromBytes(content)
+
+		if _, err := addBlob(ctx, bs, distribution.Descriptor{Digest: dgst, Size: int64(len(content))}, bytes.NewReader(content)); err != nil {
+			t.Fatalf("%s: unexpected error committing upload: %v", testcase.name, err)
+		}
+		usage += int64(len(content))
+
+		desc, err := bs.Stat(ctx, dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error statting blob: %v", testcase.name, err)
+		}
+		if desc.Size != int64(len(content)) {
+			t.Fatalf("%s: unexpected size: %d != %d", testcase.name, desc.Size, len(content))
+		}
+
+		p, err := bs.Get(ctx, dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error getting blob: %v", testcase.name, err)
+		}
+		if !bytes.Equal(p, content) {
+			t.Fatalf("%s: unexpected content from Get", testcase.name)
+		}
+
+		rc, err := bs.Open(ctx, dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error opening blob: %v", testcase.name, err)
+		}
+		p, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error reading blob: %v", testcase.name, err)
+		}
+		if !bytes.Equal(p, content) {
+			t.Fatalf("%s: unexpected content from Open", testcase.name)
+		}
+
+		for _, method := range []string{"GET", "HEAD"} {
+			req := httptest.NewRequest(method, "/", nil)
+			w := httptest.NewRecorder()
+			if err := bs.ServeBlob(ctx, w, req, dgst); err != nil {
+				t.Fatalf("%s: unexpected error serving blob: %v", testcase.name, err)
+			}
+			if w.Header().Get("Content-Length") != fmt.Sprint(len(content)) {
+				t.Fatalf("%s: unexpected Content-Length for %s: %q", testcase.name, method, w.Header().Get("Content-Length"))
+			}
+			if method == "GET" && !bytes.Equal(w.Body.Bytes(), content) {
+				t.Fatalf("%s: unexpected content served", testcase.name)
+			}
+		}
+
+		dataPath, _ := pathFor(blobDataPathSpec{digest: dgst})
+		inlinePath, _ := pathFor(blobInlineDataPathSpec{digest: dgst})
+		if _, err := driver.Stat(ctx, inlinePath); (err == nil) != testcase.inline {
+			t.Fatalf("%s: unexpected inline storage: %v", testcase.name, err)
+		}
+		if _, err := driver.Stat(ctx, dataPath); (err == nil) == testcase.inline {
+			t.Fatalf("%s: unexpected data file storage: %v", testcase.name, err)
+		}
+	}
+
+	used, err := RepositoryUsage(ctx, driver, imageName.Name())
+	if err != nil {
+		t.Fatalf("unexpected error reading usage: %v", err)
+	}
+	if used != usage {
+		t.Fatalf("unexpected usage: %d != %d", used, usage)
+	}
+}
+
 func simpleUpload(t *testing.T, bs distribution.BlobIngester, blob []byte, expectedDigest digest.Digest) {
 	ctx := context.Background()
 	wr, err := bs.Create(ctx)
@@ -611,3 +1083,176 @@ func addBlob(ctx context.Context, bs distribution.BlobIngester, desc distributio
 
 	return wr.Commit(ctx, desc)
 }
+
+// TestServeBlobContentType ensures that blobs are served with their stored
+// media type, falling back to sniffing their content, if enabled, and to the
+// default Content-Type.
+func TestServeBlobContentType(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write([]byte("layer content")); err != nil {
+		t.Fatalf("error compressing content: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("error compressing content: %v", err)
+	}
+
+	for _, tc := range []struct {
+		options          []RegistryOption
+		stored           string
+		gzipped, unknown string
+	}{
+		{
+			stored:  "application/vnd.example.config+json",
+			gzipped: "application/octet-stream",
+			unknown: "application/octet-stream",
+		},
+		{
+			options: []RegistryOption{DefaultBlobContentType("text/plain"), EnableContentTypeSniffing},
+			stored:  "application/vnd.example.config+json",
+			gzipped: schema2.MediaTypeLayer,
+			unknown: "text/plain",
+		},
+	} {
+		options := append([]RegistryOption{BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider())}, tc.options...)
+		registry, err := NewRegistry(ctx, inmemory.New(), options...)
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		bs := repository.Blobs(ctx)
+
+		// contentType uploads p, with the given media type, and returns the
+		// Content-Type it is served with.
+		contentType := func(p []byte, mediaType string) string {
+			dgst := digest.FromBytes(p)
+
+			wr, err := bs.Create(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error starting upload: %v", err)
+			}
+			if _, err := wr.Write(p); err != nil {
+				t.Fatalf("unexpected error writing upload: %v", err)
+			}
+			if _, err := wr.Commit(ctx, distribution.Descriptor{Digest: dgst, MediaType: mediaType}); err != nil {
+				t.Fatalf("unexpected error committing upload: %v", err)
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			if err := bs.ServeBlob(ctx, w, req, dgst); err != nil {
+				t.Fatalf("unexpected error serving blob: %v", err)
+			}
+			if !bytes.Equal(w.Body.Bytes(), p) {
+				t.Fatalf("unexpected blob content served")
+			}
+			return w.Header().Get("Content-Type")
+		}
+
+		if ct := contentType([]byte(`{"config":true}`), tc.stored); ct != tc.stored {
+			t.Fatalf("unexpected Content-Type for stored media type: %q != %q", ct, tc.stored)
+		}
+		if ct := contentType(gzipped.Bytes(), ""); ct != tc.gzipped {
+			t.Fatalf("unexpected Content-Type for gzipped blob: %q != %q", ct, tc.gzipped)
+		}
+		if ct := contentType([]byte("unknown content"), ""); ct != tc.unknown {
+			t.Fatalf("unexpected Content-Type for unknown blob: %q != %q", ct, tc.unknown)
+		}
+	}
+}
+
+// readCountingDriver counts the bytes read through its readers.
+type readCountingDriver struct {
+	storagedriver.StorageDriver
+	read int64
+}
+
+func (d *readCountingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: rc, n: &d.read}, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (rc *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := rc.ReadCloser.Read(p)
+	atomic.AddInt64(rc.n, int64(n))
+	return n, err
+}
+
+// slowClientWriter is a response writer for a client which consumes each
+// write slowly, checking how far the backend was read ahead of it.
+type slowClientWriter struct {
+	*httptest.ResponseRecorder
+	t        *testing.T
+	driver   *readCountingDriver
+	maxWrite int
+	maxAhead int64
+}
+
+func (w *slowClientWriter) Write(p []byte) (int, error) {
+	if len(p) > w.maxWrite {
+		w.t.Fatalf("write of %d bytes exceeds the copy buffer of %d", len(p), w.maxWrite)
+	}
+	if ahead := atomic.LoadInt64(&w.driver.read) - int64(w.Body.Len()); ahead > w.maxAhead {
+		w.t.Fatalf("backend read %d bytes ahead of the client, expected at most %d", ahead, w.maxAhead)
+	}
+	time.Sleep(time.Millisecond)
+	return w.ResponseRecorder.Write(p)
+}
+
+// TestServeBlobBackpressure ensures that blobs are copied to clients through
+// a bounded buffer, so that the backend is read no faster than the client
+// consumes.
+func TestServeBlobBackpressure(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	const readBufferSize, copyBufferSize = 64 << 10, 16 << 10
+
+	driver := &readCountingDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, driver, ReadBufferSize(readBufferSize), CopyBufferSize(copyBufferSize))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	p := make([]byte, 1<<20)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating content: %v", err)
+	}
+	desc, err := bs.Put(ctx, "application/octet-stream", p)
+	if err != nil {
+		t.Fatalf("error putting blob: %v", err)
+	}
+
+	atomic.StoreInt64(&driver.read, 0)
+	w := &slowClientWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		t:                t,
+		driver:           driver,
+		maxWrite:         copyBufferSize,
+		maxAhead:         readBufferSize + copyBufferSize,
+	}
+	if err := bs.ServeBlob(ctx, w, httptest.NewRequest("GET", "/", nil), desc.Digest); err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if !bytes.Equal(w.Body.Bytes(), p) {
+		t.Fatalf("unexpected blob content served")
+	}
+}
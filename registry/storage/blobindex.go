@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// BlobIndex is an index of every blob in the registry's blob store and its
+// size. Enumerating the index avoids walking the blob store, which holds the
+// data of every blob, and statting each blob to find its size.
+//
+// The blob store updates the index after each blob is written, and the
+// vacuum after each blob is removed, so the index may briefly lag the blob
+// store. Rebuild restores the index from the blob store should it be lost or
+// corrupted, or if it was not enabled while blobs were written. The index is
+// only known to be complete once it has been rebuilt, which Built reports;
+// until then, the blob store is walked in its place.
+type BlobIndex struct {
+	driver driver.StorageDriver
+}
+
+// NewBlobIndex returns the index of the blob store held by driver.
+func NewBlobIndex(driver driver.StorageDriver) *BlobIndex {
+	return &BlobIndex{driver: driver}
+}
+
+// Add records the blob described by desc in the index.
+func (bi *BlobIndex) Add(ctx context.Context, desc distribution.Descriptor) error {
+	entryPath, err := pathFor(blobIndexEntryPathSpec{digest: desc.Digest})
+	if err != nil {
+		return err
+	}
+
+	return bi.driver.PutContent(ctx, entryPath, []byte(strconv.FormatInt(desc.Size, 10)))
+}
+
+// Remove removes the blob identified by dgst from the index. Removing a blob
+// which is not indexed is not an error.
+func (bi *BlobIndex) Remove(ctx context.Context, dgst digest.Digest) error {
+	entryPath, err := pathFor(blobIndexEntryPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	if err := bi.driver.Delete(ctx, entryPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the descriptor of the blob identified by dgst, as recorded in
+// the index, or distribution.ErrBlobUnknown if it is not indexed.
+func (bi *BlobIndex) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	entryPath, err := pathFor(blobIndexEntryPathSpec{digest: dgst})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	content, err := bi.driver.GetContent(ctx, entryPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.Descriptor{}, distribution.ErrBlobUnknown
+		}
+		return distribution.Descriptor{}, err
+	}
+
+	return parseBlobIndexEntry(dgst, content)
+}
+
+// Built reports whether the index has been rebuilt from the blob store, so
+// that it holds every blob written while it was enabled.
+func (bi *BlobIndex) Built(ctx context.Context) (bool, error) {
+	builtPath, err := pathFor(blobIndexBuiltPathSpec{})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := bi.driver.Stat(ctx, builtPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Enumerate calls ingester with the descriptor of each indexed blob.
+func (bi *BlobIndex) Enumerate(ctx context.Context, ingester func(desc distribution.Descriptor) error) error {
+	indexPath, err := pathFor(blobIndexPathSpec{})
+	if err != nil {
+		return err
+	}
+	builtPath, err := pathFor(blobIndexBuiltPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	err = bi.driver.Walk(ctx, indexPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || fileInfo.Path() == builtPath {
+			return nil
+		}
+
+		dgst, err := digestFromPath(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		content, err := bi.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		desc, err := parseBlobIndexEntry(dgst, content)
+		if err != nil {
+			return err
+		}
+
+		return ingester(desc)
+	})
+
+	// An index to which no blob has been added is empty.
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// BlobUsage summarizes the blobs held by the blob store.
+type BlobUsage struct {
+	// Blobs is the number of blobs.
+	Blobs int64 `json:"blobs"`
+
+	// Bytes is the total size of the blobs, as stored.
+	Bytes int64 `json:"bytes"`
+}
+
+// Usage returns the number and total size of the indexed blobs.
+func (bi *BlobIndex) Usage(ctx context.Context) (BlobUsage, error) {
+	var usage BlobUsage
+	err := bi.Enumerate(ctx, func(desc distribution.Descriptor) error {
+		usage.Blobs++
+		usage.Bytes += desc.Size
+		return nil
+	})
+	return usage, err
+}
+
+// Rebuild rebuilds the index by walking the blob store, indexing every blob
+// in it and removing entries for blobs which no longer exist. The index is
+// then marked as built.
+func (bi *BlobIndex) Rebuild(ctx context.Context) error {
+	bs := &blobStore{driver: bi.driver}
+	// The walk finds blobs in every form, whether or not it is enabled.
+	statter := &blobStatter{driver: bi.driver, inline: true, compressed: true}
+
+	stored := make(map[digest.Digest]struct{})
+	err := bs.Enumerate(ctx, func(dgst digest.Digest) error {
+		desc, err := statter.Stat(ctx, dgst)
+		if err != nil {
+			return err
+		}
+
+		stored[dgst] = struct{}{}
+		return bi.Add(ctx, desc)
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	// Entries are identified by their paths alone, so that corrupted entries
+	// are also found.
+	indexPath, err := pathFor(blobIndexPathSpec{})
+	if err != nil {
+		return err
+	}
+	builtPath, err := pathFor(blobIndexBuiltPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	err = bi.driver.Walk(ctx, indexPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || fileInfo.Path() == builtPath {
+			return nil
+		}
+
+		dgst, err := digestFromPath(fileInfo.Path())
+		if _, ok := stored[dgst]; err != nil || !ok {
+			stale = append(stale, fileInfo.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	for _, entryPath := range stale {
+		dcontext.GetLogger(ctx).Infof("removing stale blob index entry: %s", entryPath)
+		if err := bi.driver.Delete(ctx, entryPath); err != nil {
+			return err
+		}
+	}
+
+	return bi.driver.PutContent(ctx, builtPath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// parseBlobIndexEntry parses the content of the index entry for dgst.
+func parseBlobIndexEntry(dgst digest.Digest, content []byte) (distribution.Descriptor, error) {
+	size, err := strconv.ParseInt(string(content), 10, 64)
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("invalid blob index entry for %s: %v", dgst, err)
+	}
+
+	return distribution.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		Size:      size,
+	}, nil
+}
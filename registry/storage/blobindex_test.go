@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// storedBlobs returns the size of each blob in the blob store, found by
+// walking it.
+func storedBlobs(t *testing.T, d driver.StorageDriver) map[digest.Digest]int64 {
+	ctx := context.Background()
+	statter := &blobStatter{driver: d}
+	blobs := make(map[digest.Digest]int64)
+	err := (&blobStore{driver: d}).Enumerate(ctx, func(dgst digest.Digest) error {
+		desc, err := statter.Stat(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		blobs[dgst] = desc.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error walking blob store: %v", err)
+	}
+	return blobs
+}
+
+// indexedBlobs returns the size of each blob in the blob index.
+func indexedBlobs(t *testing.T, d driver.StorageDriver) map[digest.Digest]int64 {
+	blobs := make(map[digest.Digest]int64)
+	err := NewBlobIndex(d).Enumerate(context.Background(), func(desc distribution.Descriptor) error {
+		blobs[desc.Digest] = desc.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error enumerating blob index: %v", err)
+	}
+	return blobs
+}
+
+func TestBlobIndex(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d, EnableBlobIndex)
+	repo := makeRepository(t, registry, "foo/index")
+	image := uploadRandomSchema2Image(t, repo)
+
+	stored := storedBlobs(t, d)
+	if len(stored) != 4 {
+		t.Fatalf("unexpected number of stored blobs: %d != 4", len(stored))
+	}
+	if indexed := indexedBlobs(t, d); !reflect.DeepEqual(indexed, stored) {
+		t.Fatalf("index does not match blob store: %v != %v", indexed, stored)
+	}
+
+	// Until the index is built, the registry walks the blob store.
+	if blobs := allBlobs(t, registry); len(blobs) != len(stored) {
+		t.Fatalf("unexpected number of enumerated blobs: %d != %d", len(blobs), len(stored))
+	}
+
+	desc, err := NewBlobIndex(d).Stat(ctx, image.manifestDigest)
+	if err != nil {
+		t.Fatalf("error statting indexed manifest: %v", err)
+	}
+	if desc.Size != stored[image.manifestDigest] {
+		t.Fatalf("unexpected size of indexed manifest: %d != %d", desc.Size, stored[image.manifestDigest])
+	}
+
+	// Removing a blob removes it from the index.
+	removed := getAnyKey(image.layers)
+	if err := NewVacuum(ctx, d).RemoveBlob(removed.String()); err != nil {
+		t.Fatalf("error removing blob: %v", err)
+	}
+
+	stored = storedBlobs(t, d)
+	if _, ok := stored[removed]; ok {
+		t.Fatalf("removed blob %s still stored", removed)
+	}
+	if indexed := indexedBlobs(t, d); !reflect.DeepEqual(indexed, stored) {
+		t.Fatalf("index does not match blob store after removal: %v != %v", indexed, stored)
+	}
+	if _, err := NewBlobIndex(d).Stat(ctx, removed); err != distribution.ErrBlobUnknown {
+		t.Fatalf("unexpected error statting removed blob: %v", err)
+	}
+}
+
+func TestBlobIndexRebuild(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	// Blobs uploaded without the index are not indexed.
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "foo/rebuild")
+	image := uploadRandomSchema2Image(t, repo)
+
+	if indexed := indexedBlobs(t, d); len(indexed) != 0 {
+		t.Fatalf("unexpected indexed blobs: %v", indexed)
+	}
+
+	// Damage the index with an entry for a blob which does not exist and a
+	// corrupted entry for one which does.
+	index := NewBlobIndex(d)
+	missing := digest.FromString("missing")
+	if err := index.Add(ctx, distribution.Descriptor{Digest: missing, Size: 7}); err != nil {
+		t.Fatalf("error adding index entry: %v", err)
+	}
+	entryPath, err := pathFor(blobIndexEntryPathSpec{digest: image.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, entryPath, []byte("garbage")); err != nil {
+		t.Fatalf("error corrupting index entry: %v", err)
+	}
+
+	if err := index.Rebuild(ctx); err != nil {
+		t.Fatalf("error rebuilding blob index: %v", err)
+	}
+
+	stored := storedBlobs(t, d)
+	if indexed := indexedBlobs(t, d); !reflect.DeepEqual(indexed, stored) {
+		t.Fatalf("rebuilt index does not match blob store: %v != %v", indexed, stored)
+	}
+
+	// Rebuilding an empty registry leaves an empty index.
+	d = inmemory.New()
+	if err := NewBlobIndex(d).Rebuild(ctx); err != nil {
+		t.Fatalf("error rebuilding empty blob index: %v", err)
+	}
+	if indexed := indexedBlobs(t, d); len(indexed) != 0 {
+		t.Fatalf("unexpected indexed blobs: %v", indexed)
+	}
+}
+
+func TestBlobIndexBuilt(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	// Blobs uploaded before the index is enabled are not indexed, so the
+	// registry walks the blob store until the index is built.
+	repo := makeRepository(t, createRegistry(t, d), "foo/built")
+	uploadRandomSchema2Image(t, repo)
+
+	registry := createRegistry(t, d, EnableBlobIndex)
+	repo = makeRepository(t, registry, "foo/built")
+	uploadRandomSchema2Image(t, repo)
+
+	index := NewBlobIndex(d)
+	if built, err := index.Built(ctx); err != nil || built {
+		t.Fatalf("unexpected built state of new index: %t, %v", built, err)
+	}
+
+	stored := storedBlobs(t, d)
+	if indexed := indexedBlobs(t, d); len(indexed) >= len(stored) {
+		t.Fatalf("unexpected number of indexed blobs: %d >= %d", len(indexed), len(stored))
+	}
+	if blobs := allBlobs(t, registry); len(blobs) != len(stored) {
+		t.Fatalf("unexpected number of enumerated blobs: %d != %d", len(blobs), len(stored))
+	}
+
+	if err := index.Rebuild(ctx); err != nil {
+		t.Fatalf("error rebuilding blob index: %v", err)
+	}
+	if built, err := index.Built(ctx); err != nil || !built {
+		t.Fatalf("unexpected built state of rebuilt index: %t, %v", built, err)
+	}
+
+	var size int64
+	for _, s := range stored {
+		size += s
+	}
+	usage, err := index.Usage(ctx)
+	if err != nil {
+		t.Fatalf("error reading blob usage: %v", err)
+	}
+	if usage.Blobs != int64(len(stored)) || usage.Bytes != size {
+		t.Fatalf("unexpected usage: %+v != {Blobs:%d Bytes:%d}", usage, len(stored), size)
+	}
+
+	// Once built, the registry enumerates the index, rather than the blob
+	// store, so a blob missing from the index is not enumerated.
+	var unindexed digest.Digest
+	for dgst := range stored {
+		unindexed = dgst
+		break
+	}
+	if err := index.Remove(ctx, unindexed); err != nil {
+		t.Fatalf("error removing index entry: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	if len(blobs) != len(stored)-1 {
+		t.Fatalf("unexpected number of enumerated blobs: %d != %d", len(blobs), len(stored)-1)
+	}
+	if _, ok := blobs[unindexed]; ok {
+		t.Fatalf("unindexed blob %s enumerated", unindexed)
+	}
+}
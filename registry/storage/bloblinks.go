@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// reserveBlobLink records that the repository links the blob identified by
+// dgst, if the number of repositories linking a blob is limited, returning
+// distribution.ErrBlobLinkLimitExceeded if as many other repositories already
+// link it. The marker is written before the repository's links, so that every
+// link made while the limit is set is counted.
+//
+// Repositories linking the blob concurrently are counted independently, so
+// the limit may be overshot by as many of them.
+func (lbs *linkedBlobStore) reserveBlobLink(ctx context.Context, dgst digest.Digest) error {
+	if lbs.registry == nil || lbs.registry.blobLinkLimit <= 0 {
+		return nil
+	}
+	limit := lbs.registry.blobLinkLimit
+	name := lbs.repository.Named().Name()
+
+	linkerPath, err := pathFor(blobLinkerPathSpec{digest: dgst, name: name})
+	if err != nil {
+		return err
+	}
+	if _, err := lbs.driver.Stat(ctx, linkerPath); err == nil {
+		// The repository is already counted.
+		return nil
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		return err
+	}
+
+	linkersPath, err := pathFor(blobLinkersPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	linkers, err := lbs.driver.List(ctx, linkersPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	if len(linkers) >= limit {
+		return distribution.ErrBlobLinkLimitExceeded{Digest: dgst, Limit: limit}
+	}
+
+	return lbs.driver.PutContent(ctx, linkerPath, []byte(name))
+}
+
+// removeBlobLinker removes the marker recording that the named repository
+// links the blob identified by dgst, once the repository no longer links it.
+// Removing a marker which does not exist is not an error.
+func removeBlobLinker(ctx context.Context, storageDriver driver.StorageDriver, name string, dgst digest.Digest) error {
+	linkerPath, err := pathFor(blobLinkerPathSpec{digest: dgst, name: name})
+	if err != nil {
+		return err
+	}
+
+	if err := storageDriver.Delete(ctx, linkerPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 )
@@ -14,13 +18,50 @@ import (
 // TODO(stevvooe): This should configurable in the future.
 const blobCacheControlMaxAge = 365 * 24 * time.Hour
 
+// defaultCopyBufferSize is the size of the buffer through which blobs are
+// copied to clients, if not configured, matching that of io.Copy.
+const defaultCopyBufferSize = 32 << 10
+
 // blobServer simply serves blobs from a driver instance using a path function
 // to identify paths and a descriptor service to fill in metadata.
 type blobServer struct {
 	driver   driver.StorageDriver
 	statter  distribution.BlobStatter
-	pathFn   func(dgst digest.Digest) (string, error)
 	redirect bool // allows disabling URLFor redirects
+
+	// redirectRanges is the policy applied to ranged requests for blobs
+	// served by redirect, empty for RedirectRangesAuto.
+	redirectRanges string
+
+	// pathFn returns the path of the content of a blob and the form it is
+	// stored in. Only blobs stored in their data file are served by
+	// redirect.
+	pathFn func(ctx context.Context, desc distribution.Descriptor) (string, blobForm, error)
+
+	// readBufferSize is the size of the buffer used when serving blobs
+	// directly, zero for the default.
+	readBufferSize int
+
+	// copyBufferSize is the size of the buffer through which blobs served
+	// directly are copied to clients, zero for the default.
+	copyBufferSize int
+
+	// contentDisposition causes blobs served directly to be marked as
+	// attachments named after their digest.
+	contentDisposition bool
+
+	// defaultContentType is the Content-Type of blobs served directly whose
+	// media type is unknown, empty for application/octet-stream.
+	defaultContentType string
+
+	// sniffContentType causes the Content-Type of blobs whose media type is
+	// unknown to be detected from their first bytes, where possible.
+	sniffContentType bool
+
+	// multipartRanges allows requests for several ranges of a blob to be
+	// served as multipart/byteranges responses. Otherwise, such requests
+	// are served the whole blob.
+	multipartRanges bool
 }
 
 func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -29,12 +70,12 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		return err
 	}
 
-	path, err := bs.pathFn(desc.Digest)
+	path, form, err := bs.pathFn(ctx, desc)
 	if err != nil {
 		return err
 	}
 
-	if bs.redirect {
+	if bs.redirect && form == blobFormData && bs.redirectsRange(r) {
 		redirectURL, err := bs.driver.URLFor(ctx, path, map[string]interface{}{"method": r.Method})
 		switch err.(type) {
 		case nil:
@@ -50,11 +91,24 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		}
 	}
 
-	br, err := newFileReader(ctx, bs.driver, path, desc.Size)
-	if err != nil {
-		return err
+	var br io.ReadSeeker
+	if form == blobFormCompressed {
+		// Compressed content is small, and served decompressed so that it
+		// matches its digest.
+		p, err := readCompressed(ctx, bs.driver, desc.Digest)
+		if err != nil {
+			return err
+		}
+		br = bytes.NewReader(p)
+	} else {
+		fr, err := newFileReader(ctx, bs.driver, path, desc.Size)
+		if err != nil {
+			return err
+		}
+		defer fr.Close()
+		fr.bufferSize = bs.readBufferSize
+		br = fr
 	}
-	defer br.Close()
 
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest)) // If-None-Match handled by ServeContent
 	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds()))
@@ -65,7 +119,15 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 
 	if w.Header().Get("Content-Type") == "" {
 		// Set the content type if not already set.
-		w.Header().Set("Content-Type", desc.MediaType)
+		contentType, err := bs.contentType(desc, br)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	if bs.contentDisposition {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", desc.Digest.Algorithm().String()+"-"+desc.Digest.Hex()))
 	}
 
 	if w.Header().Get("Content-Length") == "" {
@@ -73,6 +135,120 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
 	}
 
-	http.ServeContent(w, r, desc.Digest.String(), time.Time{}, br)
+	if !bs.multipartRanges && multipleRanges(r.Header.Get("Range")) {
+		// Ignoring the Range header is permitted, and serves the whole
+		// blob in place of a multipart/byteranges response.
+		r = withoutRange(r)
+	}
+
+	http.ServeContent(&boundedCopyWriter{ResponseWriter: w, size: bs.copyBufferSize}, r, desc.Digest.String(), time.Time{}, br)
 	return nil
 }
+
+// redirectsRange reports whether the request r may be served by redirect,
+// given any Range header it carries. Clients send the Range header again to
+// the URL they are redirected to, so by default a ranged request is only
+// redirected if that URL honors it, and the range is otherwise served
+// directly rather than the whole blob being served by redirect.
+func (bs *blobServer) redirectsRange(r *http.Request) bool {
+	if r.Header.Get("Range") == "" {
+		return true
+	}
+
+	switch bs.redirectRanges {
+	case RedirectRangesRedirect:
+		return true
+	case RedirectRangesServe:
+		return false
+	}
+	return driver.URLsHonorRange(bs.driver)
+}
+
+// boundedCopyWriter copies content written to it with ReadFrom, as by
+// io.Copy, through a buffer of a fixed size. Each buffer is only refilled
+// once the client has accepted the last, so a slow client holds back reads
+// from the backend, which stays at most the read and copy buffers ahead of
+// the client rather than being read as fast as it allows.
+type boundedCopyWriter struct {
+	http.ResponseWriter
+	size int
+}
+
+func (w *boundedCopyWriter) ReadFrom(r io.Reader) (int64, error) {
+	size := w.size
+	if size == 0 {
+		size = defaultCopyBufferSize
+	}
+
+	// The writer and reader are wrapped so that io.CopyBuffer uses the
+	// buffer rather than any ReadFrom or WriteTo methods of their own.
+	return io.CopyBuffer(struct{ io.Writer }{w.ResponseWriter}, struct{ io.Reader }{r}, make([]byte, size))
+}
+
+// multipleRanges reports whether the value of a Range header requests more
+// than one range of bytes.
+func multipleRanges(rangeHeader string) bool {
+	return strings.HasPrefix(rangeHeader, "bytes=") && strings.Contains(rangeHeader, ",")
+}
+
+// withoutRange returns a shallow copy of r without its Range header.
+func withoutRange(r *http.Request) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		if k != "Range" {
+			r2.Header[k] = v
+		}
+	}
+	return r2
+}
+
+// contentType resolves the Content-Type of the blob desc, whose content is
+// read by br. The media type of the descriptor is preferred. If it is
+// unknown, the content is sniffed for a known layer format, if enabled,
+// before falling back to the default. br is left at the start of the blob.
+func (bs *blobServer) contentType(desc distribution.Descriptor, br io.ReadSeeker) (string, error) {
+	if knownMediaType(desc.MediaType) {
+		return desc.MediaType, nil
+	}
+
+	if bs.sniffContentType {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(br, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		if _, err := br.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+
+		if mediaType := sniffLayerMediaType(head[:n]); mediaType != "" {
+			return mediaType, nil
+		}
+	}
+
+	if bs.defaultContentType != "" {
+		return bs.defaultContentType, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// knownMediaType reports whether mediaType describes a blob, rather than
+// being the placeholder given to blobs whose media type was not recorded.
+func knownMediaType(mediaType string) bool {
+	return mediaType != "" && mediaType != "application/octet-stream"
+}
+
+// sniffLayerMediaType returns the media type of the layer format head, the
+// first bytes of a blob, appears to be in, or the empty string if it is not
+// recognized.
+func sniffLayerMediaType(head []byte) string {
+	switch {
+	case isGzipped(head):
+		return schema2.MediaTypeLayer
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return schema2.MediaTypeUncompressedLayer
+	}
+	return ""
+}
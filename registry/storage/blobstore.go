@@ -1,8 +1,14 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"path"
+	"strconv"
 
 	"github.com/docker/distribution"
 	dcontext "github.com/docker/distribution/context"
@@ -17,8 +23,43 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+
+	// readBufferSize is the size of the buffer used by readers returned
+	// from Open, zero for the default.
+	readBufferSize int
+
+	// index, if not nil, is updated as blobs are written, and enumerated in
+	// place of walking the blob store.
+	index *BlobIndex
+
+	// inlineSize, if positive, is the size up to which uploaded blobs are
+	// stored inline.
+	inlineSize int64
+
+	// compressed causes blobs without a data file to be looked for stored
+	// compressed, as manifests are when compression is enabled.
+	compressed bool
 }
 
+// blobForm is the form in which the content of a blob is stored.
+type blobForm int
+
+const (
+	// blobFormData is content stored as is in the blob's data file.
+	blobFormData blobForm = iota
+
+	// blobFormInline is content stored as is in the blob's inline record.
+	blobFormInline
+
+	// blobFormCompressed is content stored gzipped, which must be
+	// decompressed to be served.
+	blobFormCompressed
+)
+
+// maxInlineBlobSize is the largest size up to which blobs may be stored
+// inline. Blobs larger than it are never looked for inline.
+const maxInlineBlobSize = 1 << 20
+
 var _ distribution.BlobProvider = &blobStore{}
 
 // Get implements the BlobReadService.Get call.
@@ -32,7 +73,33 @@ func (bs *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error
 	if err != nil {
 		switch err.(type) {
 		case driver.PathNotFoundError:
-			return nil, distribution.ErrBlobUnknown
+			return bs.getInline(ctx, dgst)
+		}
+
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// getInline returns the content of the blob identified by dgst, which was
+// stored inline, falling back to content stored compressed. Only the forms
+// which are enabled are looked for.
+func (bs *blobStore) getInline(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if bs.inlineSize <= 0 {
+		return bs.getCompressed(ctx, dgst)
+	}
+
+	bp, err := pathFor(blobInlineDataPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := getContent(ctx, bs.driver, bp)
+	if err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return bs.getCompressed(ctx, dgst)
 		}
 
 		return nil, err
@@ -47,12 +114,26 @@ func (bs *blobStore) Open(ctx context.Context, dgst digest.Digest) (distribution
 		return nil, err
 	}
 
-	path, err := bs.path(desc.Digest)
+	path, form, err := bs.contentPath(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	if form == blobFormCompressed {
+		p, err := readCompressed(ctx, bs.driver, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return nopReadSeekCloser{bytes.NewReader(p)}, nil
+	}
+
+	fr, err := newFileReader(ctx, bs.driver, path, desc.Size)
 	if err != nil {
 		return nil, err
 	}
+	fr.bufferSize = bs.readBufferSize
 
-	return newFileReader(ctx, bs.driver, path, desc.Size)
+	return fr, nil
 }
 
 // Put stores the content p in the blob store, calculating the digest. If the
@@ -76,7 +157,7 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distr
 	}
 
 	// TODO(stevvooe): Write out mediatype here, as well.
-	return distribution.Descriptor{
+	desc = distribution.Descriptor{
 		Size: int64(len(p)),
 
 		// NOTE(stevvooe): The central blob store firewalls media types from
@@ -84,10 +165,134 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distr
 		// for the specific repository.
 		MediaType: "application/octet-stream",
 		Digest:    dgst,
-	}, bs.driver.PutContent(ctx, bp, p)
+	}
+
+	if err := bs.driver.PutContent(ctx, bp, p); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return desc, bs.indexBlob(ctx, desc)
+}
+
+// nopReadSeekCloser adds a no-op Close method to a ReadSeeker, for content
+// held in memory.
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error {
+	return nil
+}
+
+// getCompressed returns the content of the blob identified by dgst, which
+// was stored gzipped by putCompressed, decompressed. The blob is unknown if
+// compression is not enabled.
+func (bs *blobStore) getCompressed(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if !bs.compressed {
+		return nil, distribution.ErrBlobUnknown
+	}
+	return readCompressed(ctx, bs.driver, dgst)
+}
+
+// readCompressed returns the content of the blob identified by dgst, read from
+// its compressed data file and decompressed.
+func readCompressed(ctx context.Context, d driver.StorageDriver, dgst digest.Digest) ([]byte, error) {
+	bp, err := pathFor(blobCompressedDataPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := getContent(ctx, d, bp)
+	if err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return nil, distribution.ErrBlobUnknown
+		}
+
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+// putCompressed stores the content p gzipped in the blob store, calculating
+// the digest over the uncompressed content. The gzipped content is stored in
+// place of the blob's data file, alongside a record of its uncompressed size,
+// so that the data file of any blob always matches its digest. If the content
+// is already present, only the digest will be returned.
+func (bs *blobStore) putCompressed(ctx context.Context, p []byte) (distribution.Descriptor, error) {
+	dgst := digest.FromBytes(p)
+	desc, err := bs.statter.Stat(ctx, dgst)
+	if err == nil {
+		// content already present, possibly uncompressed
+		return desc, nil
+	} else if err != distribution.ErrBlobUnknown {
+		dcontext.GetLogger(ctx).Errorf("blobStore: error stating content (%v): %v", dgst, err)
+		return distribution.Descriptor{}, err
+	}
+
+	desc = distribution.Descriptor{
+		Size:      int64(len(p)),
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(p); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	// The size is written first, since the blob is only known once its
+	// content is present.
+	sp, err := pathFor(blobCompressedSizePathSpec{digest: dgst})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if err := bs.driver.PutContent(ctx, sp, []byte(strconv.FormatInt(desc.Size, 10))); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	bp, err := pathFor(blobCompressedDataPathSpec{digest: dgst})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if err := bs.driver.PutContent(ctx, bp, buf.Bytes()); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return desc, bs.indexBlob(ctx, desc)
+}
+
+// isGzipped reports whether p begins with the gzip magic number.
+func isGzipped(p []byte) bool {
+	return len(p) >= 2 && p[0] == 0x1f && p[1] == 0x8b
 }
 
 func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error {
+	if bs.index != nil {
+		// An index which has not been built may be missing blobs written
+		// before it was enabled, so the blob store is walked instead.
+		built, err := bs.index.Built(ctx)
+		if err != nil {
+			return err
+		}
+		if built {
+			return bs.index.Enumerate(ctx, func(desc distribution.Descriptor) error {
+				return ingester(desc.Digest)
+			})
+		}
+	}
+
 	specPath, err := pathFor(blobsPathSpec{})
 	if err != nil {
 		return err
@@ -100,9 +305,14 @@ func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Di
 		}
 
 		currentPath := fileInfo.Path()
-		// we only want to parse paths that end with /data
-		_, fileName := path.Split(currentPath)
-		if fileName != "data" {
+		// we only want to parse paths that end with /data, or /data.gz or
+		// /inline for blobs stored compressed or inline
+		dir, fileName := path.Split(currentPath)
+		switch fileName {
+		case "data":
+		case "data.gz", "inline":
+			currentPath = path.Join(dir, "data")
+		default:
 			return nil
 		}
 
@@ -115,6 +325,15 @@ func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Di
 	})
 }
 
+// indexBlob records a blob which has been written in the index, if there is
+// one.
+func (bs *blobStore) indexBlob(ctx context.Context, desc distribution.Descriptor) error {
+	if bs.index == nil {
+		return nil
+	}
+	return bs.index.Add(ctx, desc)
+}
+
 // path returns the canonical path for the blob identified by digest. The blob
 // may or may not exist.
 func (bs *blobStore) path(dgst digest.Digest) (string, error) {
@@ -129,6 +348,54 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 	return bp, nil
 }
 
+// contentPath returns the path of the content of the blob described by desc,
+// which is its data file unless the blob is stored inline or compressed, and
+// the form it is stored in. Other forms are only looked for if they are
+// enabled, and inline only for blobs small enough to have been stored inline.
+// The data file is returned for blobs found in no form.
+func (bs *blobStore) contentPath(ctx context.Context, desc distribution.Descriptor) (string, blobForm, error) {
+	dataPath, err := bs.path(desc.Digest)
+	if err != nil {
+		return "", blobFormData, err
+	}
+
+	inline := bs.inlineSize > 0 && desc.Size <= maxInlineBlobSize
+	if !inline && !bs.compressed {
+		return dataPath, blobFormData, nil
+	}
+
+	if _, err := bs.driver.Stat(ctx, dataPath); err == nil {
+		return dataPath, blobFormData, nil
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		return "", blobFormData, err
+	}
+
+	for _, alternate := range []struct {
+		enabled bool
+		spec    pathSpec
+		form    blobForm
+	}{
+		{inline, blobInlineDataPathSpec{digest: desc.Digest}, blobFormInline},
+		{bs.compressed, blobCompressedDataPathSpec{digest: desc.Digest}, blobFormCompressed},
+	} {
+		if !alternate.enabled {
+			continue
+		}
+
+		alternatePath, err := pathFor(alternate.spec)
+		if err != nil {
+			return "", blobFormData, err
+		}
+		if _, err := bs.driver.Stat(ctx, alternatePath); err == nil {
+			return alternatePath, alternate.form, nil
+		} else if _, ok := err.(driver.PathNotFoundError); !ok {
+			return "", blobFormData, err
+		}
+	}
+
+	return dataPath, blobFormData, nil
+}
+
 // link links the path to the provided digest by writing the digest into the
 // target file. Caller must ensure that the blob actually exists.
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
@@ -154,6 +421,13 @@ func (bs *blobStore) readlink(ctx context.Context, path string) (digest.Digest,
 
 type blobStatter struct {
 	driver driver.StorageDriver
+
+	// inline and compressed cause blobs without a data file to be looked
+	// for stored inline and compressed respectively. Each costs another
+	// round trip to the driver for every blob which is not found, so they
+	// are only enabled along with the features writing them.
+	inline     bool
+	compressed bool
 }
 
 var _ distribution.BlobDescriptorService = &blobStatter{}
@@ -174,7 +448,7 @@ func (bs *blobStatter) Stat(ctx context.Context, dgst digest.Digest) (distributi
 	if err != nil {
 		switch err := err.(type) {
 		case driver.PathNotFoundError:
-			return distribution.Descriptor{}, distribution.ErrBlobUnknown
+			return bs.statInline(ctx, dgst)
 		default:
 			return distribution.Descriptor{}, err
 		}
@@ -204,6 +478,86 @@ func (bs *blobStatter) Stat(ctx context.Context, dgst digest.Digest) (distributi
 	}, nil
 }
 
+// statInline returns the descriptor for a blob stored inline, falling back to
+// one stored compressed. Only the forms which are enabled are looked for.
+func (bs *blobStatter) statInline(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	if !bs.inline {
+		return bs.statCompressed(ctx, dgst)
+	}
+
+	bp, err := pathFor(blobInlineDataPathSpec{digest: dgst})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	fi, err := bs.driver.Stat(ctx, bp)
+	if err != nil {
+		switch err := err.(type) {
+		case driver.PathNotFoundError:
+			return bs.statCompressed(ctx, dgst)
+		default:
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	return distribution.Descriptor{
+		Size:      fi.Size(),
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		ModTime:   fi.ModTime(),
+	}, nil
+}
+
+// statCompressed returns the descriptor for a blob stored compressed, whose
+// size is that of its uncompressed content.
+func (bs *blobStatter) statCompressed(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	if !bs.compressed {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+
+	sp, err := pathFor(blobCompressedSizePathSpec{digest: dgst})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	content, err := bs.driver.GetContent(ctx, sp)
+	if err != nil {
+		switch err := err.(type) {
+		case driver.PathNotFoundError:
+			return distribution.Descriptor{}, distribution.ErrBlobUnknown
+		default:
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	size, err := strconv.ParseInt(string(content), 10, 64)
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("invalid size recorded for compressed blob %s: %v", dgst, err)
+	}
+
+	bp, err := pathFor(blobCompressedDataPathSpec{digest: dgst})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	fi, err := bs.driver.Stat(ctx, bp)
+	if err != nil {
+		switch err := err.(type) {
+		case driver.PathNotFoundError:
+			return distribution.Descriptor{}, distribution.ErrBlobUnknown
+		default:
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	return distribution.Descriptor{
+		Size:      size,
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		ModTime:   fi.ModTime(),
+	}, nil
+}
+
 func (bs *blobStatter) Clear(ctx context.Context, dgst digest.Digest) error {
 	return distribution.ErrUnsupported
 }
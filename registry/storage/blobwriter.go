@@ -59,6 +59,16 @@ func (bw *blobWriter) StartedAt() time.Time {
 func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
 	dcontext.GetLogger(ctx).Debug("(*blobWriter).Commit")
 
+	if bw.blobStore.registry.deduplicateUploads {
+		canonical, ok, err := bw.findDuplicate(ctx, desc)
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+		if ok {
+			return bw.commitDuplicate(ctx, canonical, desc.Digest)
+		}
+	}
+
 	if err := bw.fileWriter.Commit(); err != nil {
 		return distribution.Descriptor{}, err
 	}
@@ -71,11 +81,18 @@ func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor)
 		return distribution.Descriptor{}, err
 	}
 
-	if err := bw.moveBlob(ctx, canonical); err != nil {
+	if bw.blobStore.registry.verifyBlobWrites {
+		if err := bw.verifyWrite(ctx, canonical); err != nil {
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	charged, err := bw.blobStore.chargeUsage(ctx, canonical)
+	if err != nil {
 		return distribution.Descriptor{}, err
 	}
 
-	if err := bw.blobStore.linkBlob(ctx, canonical, desc.Digest); err != nil {
+	if err := bw.linkCharged(ctx, canonical, desc.Digest, charged); err != nil {
 		return distribution.Descriptor{}, err
 	}
 
@@ -92,6 +109,101 @@ func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor)
 	return canonical, nil
 }
 
+// findDuplicate returns the canonical descriptor of the upload if content of
+// the digest declared by desc is already in the blob store, and the upload is
+// known to match it from the digest of the data as it was received. The data
+// of the upload is neither assembled nor read back.
+func (bw *blobWriter) findDuplicate(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, bool, error) {
+	if desc.Digest == "" || desc.Digest.Algorithm() != digest.Canonical {
+		return distribution.Descriptor{}, false, nil
+	}
+
+	size := bw.Size()
+	if desc.Size > 0 && desc.Size != size {
+		return distribution.Descriptor{}, false, nil
+	}
+
+	existing, err := bw.blobStore.blobStore.statter.Stat(ctx, desc.Digest)
+	switch err {
+	case nil:
+	case distribution.ErrBlobUnknown:
+		return distribution.Descriptor{}, false, nil
+	default:
+		return distribution.Descriptor{}, false, err
+	}
+	if existing.Size != size {
+		return distribution.Descriptor{}, false, nil
+	}
+
+	if err := bw.resumeDigest(ctx); err != nil && err != errResumableDigestNotAvailable {
+		return distribution.Descriptor{}, false, err
+	}
+	if bw.written != size || bw.digester.Digest() != desc.Digest {
+		// The upload is left to be validated, and rejected if it does
+		// not match, as usual.
+		return distribution.Descriptor{}, false, nil
+	}
+
+	desc.Size = size
+	if desc.MediaType == "" {
+		desc.MediaType = "application/octet-stream"
+	}
+	return desc, true, nil
+}
+
+// commitDuplicate completes an upload whose content is already in the blob
+// store, discarding the data of the upload and linking the existing content
+// into the repository.
+func (bw *blobWriter) commitDuplicate(ctx context.Context, canonical distribution.Descriptor, dgst digest.Digest) (distribution.Descriptor, error) {
+	if err := bw.fileWriter.Cancel(); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	charged, err := bw.blobStore.chargeUsage(ctx, canonical)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	// The content is in place, so is not moved.
+	if err := bw.linkCharged(ctx, canonical, dgst, charged); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if err := bw.removeResources(ctx); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	err = bw.blobStore.blobAccessController.SetDescriptor(ctx, canonical.Digest, canonical)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	bw.committed = true
+	return canonical, nil
+}
+
+// linkCharged moves the blob into place and links it into the repository,
+// releasing the usage charged for it if that fails.
+func (bw *blobWriter) linkCharged(ctx context.Context, canonical distribution.Descriptor, dgst digest.Digest, charged bool) (err error) {
+	if charged {
+		defer func() {
+			if err != nil {
+				bw.blobStore.releaseUsage(ctx, canonical)
+			}
+		}()
+	}
+
+	if err := bw.moveBlob(ctx, canonical); err != nil {
+		return err
+	}
+
+	if err := bw.blobStore.blobStore.indexBlob(ctx, canonical); err != nil {
+		return err
+	}
+
+	return bw.blobStore.linkBlob(ctx, canonical, dgst)
+}
+
 // Cancel the blob upload process, releasing any resources associated with
 // the writer and canceling the operation.
 func (bw *blobWriter) Cancel(ctx context.Context) error {
@@ -236,15 +348,24 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		// the same, we don't need to read the data from the backend. This is
 		// because we've written the entire file in the lifecycle of the
 		// current instance.
-		if bw.written == size && digest.Canonical == desc.Digest.Algorithm() {
+		streamed := bw.written == size && digest.Canonical == desc.Digest.Algorithm()
+		if streamed {
 			canonical = bw.digester.Digest()
 			verified = desc.Digest == canonical
 		}
 
+		requireRunningDigest := bw.blobStore.registry.requireRunningDigest
+		if !streamed && requireRunningDigest {
+			return distribution.Descriptor{}, distribution.ErrBlobInvalidDigest{
+				Digest: desc.Digest,
+				Reason: fmt.Errorf("running digest of upload is unavailable"),
+			}
+		}
+
 		// If the check based on size fails, we fall back to the slowest of
 		// paths. We may be able to make the size-based check a stronger
 		// guarantee, so this may be defensive.
-		if !verified {
+		if !verified && !requireRunningDigest {
 			digester := digest.Canonical.Digester()
 			verifier := desc.Digest.Verifier()
 
@@ -289,6 +410,28 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 	return desc, nil
 }
 
+// verifyWrite reads the upload data back from the driver, returning
+// distribution.ErrBlobWriteMismatch if it does not match the digest of desc.
+// The digest checked when validating the upload may have been computed from
+// the data as it was received, so does not detect corruption by the backend.
+func (bw *blobWriter) verifyWrite(ctx context.Context, desc distribution.Descriptor) error {
+	fr, err := newFileReader(ctx, bw.driver, bw.path, desc.Size)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	digester := desc.Digest.Algorithm().Digester()
+	if _, err := io.Copy(digester.Hash(), fr); err != nil {
+		return err
+	}
+
+	if actual := digester.Digest(); actual != desc.Digest {
+		return distribution.ErrBlobWriteMismatch{Digest: desc.Digest, Actual: actual}
+	}
+	return nil
+}
+
 // moveBlob moves the data into its final, hash-qualified destination,
 // identified by dgst. The layer should be validated before commencing the
 // move.
@@ -301,7 +444,12 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 		return err
 	}
 
-	// Check for existence
+	// Check for existence, whether stored as a data file or in another form
+	if _, form, err := bw.blobStore.contentPath(ctx, desc); err != nil {
+		return err
+	} else if form != blobFormData {
+		return nil
+	}
 	if _, err := bw.blobStore.driver.Stat(ctx, blobPath); err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError:
@@ -317,6 +465,12 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 		return nil
 	}
 
+	// Small blobs are written inline in a single operation, rather than
+	// moved into place.
+	if desc.Size <= bw.blobStore.inlineSize {
+		return bw.writeInline(ctx, desc)
+	}
+
 	// If no data was received, we may not actually have a file on disk. Check
 	// the size here and write a zero-length file to blobPath if this is the
 	// case. For the most part, this should only ever happen with zero-length
@@ -347,6 +501,32 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 	return bw.blobStore.driver.Move(ctx, bw.path, blobPath)
 }
 
+// writeInline stores the uploaded content of the blob described by desc
+// inline. The upload data is left to be removed with the other resources of
+// the upload.
+func (bw *blobWriter) writeInline(ctx context.Context, desc distribution.Descriptor) error {
+	inlinePath, err := pathFor(blobInlineDataPathSpec{digest: desc.Digest})
+	if err != nil {
+		return err
+	}
+
+	p, err := bw.blobStore.driver.GetContent(ctx, bw.path)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			// As above, only the empty blob may have no data on disk.
+			if desc.Digest != digestSha256Empty {
+				return err
+			}
+			p = []byte{}
+		default:
+			return err
+		}
+	}
+
+	return bw.blobStore.driver.PutContent(ctx, inlinePath, p)
+}
+
 // removeResources should clean up all resources associated with the upload
 // instance. An error will be returned if the clean up cannot proceed. If the
 // resources are already not present, no error will be returned.
@@ -360,6 +540,20 @@ func (bw *blobWriter) removeResources(ctx context.Context) error {
 		return err
 	}
 
+	keyRefPath, err := pathFor(uploadKeyRefPathSpec{
+		name: bw.blobStore.repository.Named().Name(),
+		id:   bw.id,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := removeIdempotencyKey(ctx, bw.blobStore.driver, keyRefPath, bw.id); err != nil {
+		dcontext.GetLogger(ctx).Errorf("unable to delete idempotency key of upload %q: %v", bw.id, err)
+		return err
+	}
+
 	// Resolve and delete the containing directory, which should include any
 	// upload related files.
 	dirPath := path.Dir(dataPath)
@@ -379,6 +573,27 @@ func (bw *blobWriter) removeResources(ctx context.Context) error {
 	return nil
 }
 
+// Fingerprint returns the digest of the data written to the upload so far.
+// The data is read back from the storage driver, so the fingerprint reflects
+// the content actually stored, rather than the content received.
+func (bw *blobWriter) Fingerprint(ctx context.Context) (digest.Digest, error) {
+	digester := digest.Canonical.Digester()
+	if bw.Size() == 0 {
+		return digester.Digest(), nil
+	}
+
+	rc, err := bw.driver.Reader(ctx, bw.path, 0)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(digester.Hash(), io.LimitReader(rc, bw.Size())); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
 func (bw *blobWriter) Reader() (io.ReadCloser, error) {
 	// todo(richardscothern): Change to exponential backoff, i=0.5, e=2, n=4
 	try := 1
@@ -404,3 +619,28 @@ func (bw *blobWriter) Reader() (io.ReadCloser, error) {
 
 	return readCloser, nil
 }
+
+// removeIdempotencyKey deletes the idempotency key referenced from the file
+// at keyRefPath in an upload directory, if the key still refers to the upload
+// with the given id. Uploads started without a key have nothing to remove.
+func removeIdempotencyKey(ctx context.Context, driver storagedriver.StorageDriver, keyRefPath, id string) error {
+	keyPath, err := getContentIfExists(ctx, driver, keyRefPath)
+	if err != nil || keyPath == nil {
+		return err
+	}
+
+	// A later upload started with the same key may have replaced this one,
+	// in which case the key is left to that upload.
+	current, err := getContentIfExists(ctx, driver, string(keyPath))
+	if err != nil || string(current) != id {
+		return err
+	}
+
+	if err := driver.Delete(ctx, string(keyPath)); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	return nil
+}
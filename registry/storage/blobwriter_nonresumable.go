@@ -3,9 +3,13 @@
 package storage
 
 import (
-	"github.com/docker/distribution/context"
+	"context"
 )
 
+// resumableDigestSupported reports whether this build can save and restore
+// the state of an upload's digest between requests.
+const resumableDigestSupported = false
+
 // resumeHashAt is a noop when resumable digest support is disabled.
 func (bw *blobWriter) resumeDigest(ctx context.Context) error {
 	return errResumableDigestNotAvailable
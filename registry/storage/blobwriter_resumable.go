@@ -14,6 +14,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// resumableDigestSupported reports whether this build can save and restore
+// the state of an upload's digest between requests.
+const resumableDigestSupported = true
+
 // resumeDigest attempts to restore the state of the internal hash function
 // by loading the most recent saved hash state equal to the current size of the blob.
 func (bw *blobWriter) resumeDigest(ctx context.Context) error {
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const (
+	// maxSwapReadBackAttempts bounds how often compareAndSwapContent reads
+	// back content it wrote on drivers without an atomic compare-and-swap.
+	maxSwapReadBackAttempts = 3
+
+	// swapReadBackInterval is the base delay between those reads.
+	swapReadBackInterval = 50 * time.Millisecond
+
+	// maxUpdateAttempts bounds how often updateContent retries an update
+	// which raced another writer.
+	maxUpdateAttempts = 5
+)
+
+// swapLocks serializes the read-modify-write cycles of compareAndSwapContent
+// within this process, on drivers without an atomic compare-and-swap. Locks
+// are shared between paths with the same hash.
+var swapLocks [64]sync.Mutex
+
+// compareAndSwapContent stores content at path only if the content currently
+// stored there equals old, reporting whether it did. A nil old matches only a
+// path which does not exist.
+//
+// The swap is atomic only if the driver implements
+// storagedriver.CompareAndSwapper, as the inmemory, gcs and azure drivers
+// do. Otherwise, the content is read and rewritten under a lock held within
+// this process, which makes the swap atomic for a single registry instance
+// only. The write is read back to detect another instance writing
+// concurrently, but that is not guaranteed to catch every race, so
+// deployments running several instances against such a driver must not rely
+// on the swap being atomic.
+func compareAndSwapContent(ctx context.Context, driver storagedriver.StorageDriver, path string, old, content []byte) (bool, error) {
+	if cas, ok := driver.(storagedriver.CompareAndSwapper); ok {
+		swapped, err := cas.CompareAndSwapContent(ctx, path, old, content)
+		if _, unsupported := err.(storagedriver.ErrUnsupportedMethod); !unsupported {
+			return swapped, err
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	lock := &swapLocks[h.Sum32()%uint32(len(swapLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, err := getContentIfExists(ctx, driver, path)
+	if err != nil {
+		return false, err
+	}
+	if !contentEqual(current, old) {
+		return false, nil
+	}
+
+	if err := driver.PutContent(ctx, path, content); err != nil {
+		return false, err
+	}
+
+	// Another registry instance may have read the same content and written
+	// after us, in which case its write wins. Read the content back to find
+	// out, retrying while an eventually consistent driver still shows the
+	// old content.
+	for attempt := 1; attempt <= maxSwapReadBackAttempts; attempt++ {
+		current, err := getContentIfExists(ctx, driver, path)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case contentEqual(current, content):
+			return true, nil
+		case contentEqual(current, old):
+			time.Sleep(time.Duration(attempt) * swapReadBackInterval)
+		default:
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("write to %s not visible after %d attempts", path, maxSwapReadBackAttempts)
+}
+
+// updateContent replaces the content at path with the result of applying
+// update to the current content, which is nil if the path does not exist.
+// The replacement is made with compareAndSwapContent, and retried with the
+// new content if another writer changed it first. If update returns nil,
+// nothing is written.
+func updateContent(ctx context.Context, driver storagedriver.StorageDriver, path string, update func(current []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		current, err := getContentIfExists(ctx, driver, path)
+		if err != nil {
+			return err
+		}
+
+		content, err := update(current)
+		if err != nil {
+			return err
+		}
+		if content == nil {
+			return nil
+		}
+
+		swapped, err := compareAndSwapContent(ctx, driver, path, current, content)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("update of %s raced other writers %d times", path, maxUpdateAttempts)
+}
+
+// getContentIfExists returns the content at path, or nil if the path does
+// not exist.
+func getContentIfExists(ctx context.Context, driver storagedriver.StorageDriver, path string) ([]byte, error) {
+	content, err := driver.GetContent(ctx, path)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	return content, nil
+}
+
+// contentEqual compares content as read by getContentIfExists, where nil
+// stands for a path which does not exist.
+func contentEqual(a, b []byte) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
@@ -29,6 +29,9 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 
 	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
 		err := handleRepository(fileInfo, root, last, func(repoPath string) error {
+			if listed, err := reg.listed(ctx, repoPath); err != nil || !listed {
+				return err
+			}
 			foundRepos = append(foundRepos, repoPath)
 			return nil
 		})
@@ -65,12 +68,28 @@ func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error)
 	}
 
 	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
-		return handleRepository(fileInfo, root, "", ingester)
+		return handleRepository(fileInfo, root, "", func(repoPath string) error {
+			if listed, err := reg.listed(ctx, repoPath); err != nil || !listed {
+				return err
+			}
+			return ingester(repoPath)
+		})
 	})
 
 	return err
 }
 
+// listed reports whether the named repository is listed in the catalog, which
+// it is not while its creation is incomplete.
+func (reg *registry) listed(ctx context.Context, name string) (bool, error) {
+	if !reg.atomicCreate {
+		return true, nil
+	}
+
+	creating, err := repositoryCreating(ctx, reg.blobStore.driver, name)
+	return !creating, err
+}
+
 // Remove removes a repository from storage
 func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 	root, err := pathFor(repositoriesRootPathSpec{})
@@ -152,6 +171,12 @@ func handleRepository(fileInfo driver.FileInfo, root, last string, fn func(repoP
 		}
 		return driver.ErrSkipDir
 	} else if strings.HasPrefix(file, "_") {
+		// Skipping a file would skip the rest of its directory, which may
+		// hold nested repositories, so files such as the creation marker
+		// are ignored instead.
+		if !fileInfo.IsDir() {
+			return nil
+		}
 		return driver.ErrSkipDir
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"reflect"
 	"testing"
 
 	"github.com/docker/distribution"
@@ -195,6 +196,64 @@ func TestCatalogEnumerate(t *testing.T) {
 	}
 }
 
+func TestCatalogRepositoryCreation(t *testing.T) {
+	d := inmemory.New()
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, d, EnableAtomicRepositoryCreation, EnableSchema1)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	// The creation of foo/a is interrupted once its manifest is stored.
+	if creating, err := BeginRepositoryCreation(ctx, d, "foo/a"); err != nil || !creating {
+		t.Fatalf("expected foo/a to be created, got %v: %v", creating, err)
+	}
+	for _, repo := range []string{"foo/a", "foo/a/nested", "foo/b"} {
+		makeRepo(ctx, t, repo, registry)
+	}
+
+	catalog := func() []string {
+		p := make([]string, 50)
+		n, err := registry.Repositories(ctx, p, "")
+		if err != io.EOF {
+			t.Fatalf("unexpected error listing catalog: %v", err)
+		}
+
+		var repos []string
+		err = registry.(distribution.RepositoryEnumerator).Enumerate(ctx, func(repoName string) error {
+			repos = append(repos, repoName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error enumerating catalog: %v", err)
+		}
+		if !reflect.DeepEqual(p[:n], repos) {
+			t.Fatalf("catalog %v differs from enumerated %v", p[:n], repos)
+		}
+		return repos
+	}
+
+	if repos := catalog(); !reflect.DeepEqual(repos, []string{"foo/a/nested", "foo/b"}) {
+		t.Fatalf("unexpected catalog: %v", repos)
+	}
+
+	// A created repository is not created again, while an interrupted
+	// creation is resumed and completed.
+	if creating, err := BeginRepositoryCreation(ctx, d, "foo/b"); err != nil || creating {
+		t.Fatalf("expected foo/b to exist, got %v: %v", creating, err)
+	}
+	if creating, err := BeginRepositoryCreation(ctx, d, "foo/a"); err != nil || !creating {
+		t.Fatalf("expected foo/a to be created, got %v: %v", creating, err)
+	}
+	if err := FinishRepositoryCreation(ctx, d, "foo/a"); err != nil {
+		t.Fatalf("error finishing creation of foo/a: %v", err)
+	}
+
+	if repos := catalog(); !reflect.DeepEqual(repos, []string{"foo/a", "foo/a/nested", "foo/b"}) {
+		t.Fatalf("unexpected catalog: %v", repos)
+	}
+}
+
 func testEq(a, b []string, size int) bool {
 	for cnt := 0; cnt < size-1; cnt++ {
 		if a[cnt] != b[cnt] {
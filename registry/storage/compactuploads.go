@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	storageDriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/uuid"
+)
+
+// CompactUploads deletes the orphaned files in the upload directories of all
+// repositories, last modified before olderThan, returning the paths deleted
+// and errors encountered. A file is orphaned if it does not belong to an
+// upload session, being outside any upload's directory or in the directory
+// of an upload without a startedat file, such as remain when removing a
+// finished or cancelled upload fails partway.
+//
+// Uploads write their startedat file before any other, so an upload in
+// progress is never compacted. Files modified since olderThan are left, in
+// case they belong to an upload being created.
+func CompactUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time) ([]string, []error) {
+	var (
+		deleted []string
+		errs    []error
+	)
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return nil, append(errs, err)
+	}
+
+	err = storageDriver.WalkFallback(ctx, driver, root, func(fileInfo storageDriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			return nil
+		}
+
+		dir := fileInfo.Path()
+		switch name := path.Base(dir); {
+		case name == "_uploads":
+			d, e := compactUploadDir(ctx, driver, dir, olderThan)
+			deleted = append(deleted, d...)
+			errs = append(errs, e...)
+			return storageDriver.ErrSkipDir
+		case name[0] == '_':
+			// Reserved directory
+			return storageDriver.ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		errs = pushError(errs, root, err)
+	}
+
+	dcontext.GetLogger(ctx).Infof("Upload compaction finished. Num deleted=%d, num errors=%d", len(deleted), len(errs))
+	return deleted, errs
+}
+
+// compactUploadDir deletes the orphaned entries of the upload directory of a
+// repository, last modified before olderThan.
+func compactUploadDir(ctx context.Context, driver storageDriver.StorageDriver, uploadsDir string, olderThan time.Time) ([]string, []error) {
+	var (
+		deleted []string
+		errs    []error
+	)
+
+	entries, err := driver.List(ctx, uploadsDir)
+	if err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, append(errs, err)
+	}
+
+	for _, entry := range entries {
+		orphaned, err := isOrphanedUpload(ctx, driver, entry, olderThan)
+		if err != nil {
+			errs = pushError(errs, entry, err)
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+
+		// Check again for a startedat file, in case an upload was created
+		// with the same directory since, however unlikely.
+		if _, err := uuid.Parse(path.Base(entry)); err == nil {
+			if _, err := driver.Stat(ctx, path.Join(entry, "startedat")); err == nil {
+				continue
+			}
+		}
+
+		dcontext.GetLogger(ctx).Infof("Removing orphaned upload files at %s", entry)
+		if err := driver.Delete(ctx, entry); err != nil {
+			if _, ok := err.(storageDriver.PathNotFoundError); !ok {
+				errs = pushError(errs, entry, err)
+			}
+			continue
+		}
+		deleted = append(deleted, entry)
+	}
+
+	return deleted, errs
+}
+
+// isOrphanedUpload reports whether the entry of an upload directory at
+// entryPath, and everything within it, is orphaned and last modified before
+// olderThan.
+func isOrphanedUpload(ctx context.Context, driver storageDriver.StorageDriver, entryPath string, olderThan time.Time) (bool, error) {
+	fi, err := driver.Stat(ctx, entryPath)
+	if err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	if !fi.IsDir() {
+		return fi.ModTime().Before(olderThan), nil
+	}
+
+	if _, err := uuid.Parse(path.Base(entryPath)); err == nil {
+		_, err := driver.Stat(ctx, path.Join(entryPath, "startedat"))
+		switch err.(type) {
+		case nil:
+			return false, nil
+		case storageDriver.PathNotFoundError:
+		default:
+			return false, err
+		}
+	}
+
+	recent := false
+	err = storageDriver.WalkFallback(ctx, driver, entryPath, func(fileInfo storageDriver.FileInfo) error {
+		if !fileInfo.IsDir() && !fileInfo.ModTime().Before(olderThan) {
+			recent = true
+			return errStopCompactionWalk
+		}
+		return nil
+	})
+	if err != nil && err != errStopCompactionWalk {
+		return false, err
+	}
+	return !recent, nil
+}
+
+// errStopCompactionWalk stops walking an upload directory once it is known
+// to have been modified recently.
+var errStopCompactionWalk = errors.New("upload directory modified recently")
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/uuid"
+)
+
+func TestCompactUploads(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	activeID := uuid.Generate().String()
+	addUploads(ctx, t, d, activeID, "test-repo", time.Now().Add(-1000*time.Hour))
+	activeDataPath, err := pathFor(uploadDataPathSpec{name: "test-repo", id: activeID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remains of an upload whose startedat file has been removed.
+	orphanID := uuid.Generate().String()
+	orphanHashStatePath, err := pathFor(uploadHashStatePathSpec{name: "test-repo", id: orphanID, alg: "sha256", offset: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, orphanHashStatePath, []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	orphanDir := path.Dir(path.Dir(path.Dir(orphanHashStatePath)))
+
+	// A file outside any upload directory.
+	strayPath := path.Join(path.Dir(orphanDir), "stray")
+	if err := d.PutContent(ctx, strayPath, []byte("stray")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files modified since the cutoff are left in place.
+	deleted, errs := CompactUploads(ctx, d, time.Now().Add(-time.Hour))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("unexpectedly deleted recent files: %v", deleted)
+	}
+
+	deleted, errs = CompactUploads(ctx, d, time.Now().Add(time.Minute))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("unexpected deleted paths: %v", deleted)
+	}
+
+	for _, p := range []string{orphanDir, strayPath} {
+		if _, err := d.Stat(ctx, p); err == nil {
+			t.Errorf("orphaned upload file %s was not deleted", p)
+		} else if _, ok := err.(driver.PathNotFoundError); !ok {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := d.Stat(ctx, activeDataPath); err != nil {
+		t.Errorf("data of active upload was deleted: %v", err)
+	}
+}
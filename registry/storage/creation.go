@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// BeginRepositoryCreation marks the named repository as being created, before
+// a manifest is put into it, if no manifest has yet been put into it. While it
+// is marked, the repository is left out of the catalog by registries with
+// EnableAtomicRepositoryCreation. It reports whether the repository is being
+// created, which it also is if an earlier creation was interrupted; the caller
+// should then call FinishRepositoryCreation once the put succeeds.
+func BeginRepositoryCreation(ctx context.Context, driver storagedriver.StorageDriver, name string) (bool, error) {
+	revisionsPath, err := pathFor(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := driver.Stat(ctx, revisionsPath); err == nil {
+		return repositoryCreating(ctx, driver, name)
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return false, err
+	}
+
+	creationPath, err := pathFor(repositoryCreationPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	if err := driver.PutContent(ctx, creationPath, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// FinishRepositoryCreation removes the mark left by BeginRepositoryCreation,
+// once the first manifest put into the named repository has succeeded.
+func FinishRepositoryCreation(ctx context.Context, driver storagedriver.StorageDriver, name string) error {
+	creationPath, err := pathFor(repositoryCreationPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	if err := driver.Delete(ctx, creationPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// repositoryCreating reports whether the named repository is marked as being
+// created.
+func repositoryCreating(ctx context.Context, driver storagedriver.StorageDriver, name string) (bool, error) {
+	creationPath, err := pathFor(repositoryCreationPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := driver.Stat(ctx, creationPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ResolveManifestDigestPrefix returns the digest of the single manifest in
+// the named repository whose digest begins with prefix, which is of the form
+// <algorithm>:<hex prefix>. If no manifest matches, including if the prefix
+// is malformed, distribution.ErrManifestUnknownRevision is returned. If more
+// than one matches, distribution.ErrManifestAmbiguous is returned. A prefix
+// at least as long as a full digest is rejected with
+// distribution.ErrDigestLengthInvalid.
+func ResolveManifestDigestPrefix(ctx context.Context, driver storagedriver.StorageDriver, name, prefix string) (digest.Digest, error) {
+	unknown := distribution.ErrManifestUnknownRevision{Name: name, Revision: digest.Digest(prefix)}
+
+	i := strings.Index(prefix, ":")
+	if i < 0 {
+		return "", unknown
+	}
+	alg, hexPrefix := digest.Algorithm(prefix[:i]), prefix[i+1:]
+	if !alg.Available() || strings.Trim(hexPrefix, "0123456789abcdef") != "" {
+		return "", unknown
+	}
+	if len(hexPrefix) >= alg.Size()*2 {
+		// A full digest would have been parsed as one, so this is not a
+		// prefix of any digest.
+		return "", distribution.ErrDigestLengthInvalid{Digest: digest.Digest(prefix), Expected: alg.Size() * 2}
+	}
+
+	// The revisions directory indexes every manifest in the repository.
+	revisionsPath, err := pathFor(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return "", err
+	}
+
+	children, err := driver.List(ctx, path.Join(revisionsPath, alg.String()))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return "", unknown
+		}
+		return "", err
+	}
+
+	var matches []digest.Digest
+	for _, child := range children {
+		_, hex := path.Split(child)
+		if !strings.HasPrefix(hex, hexPrefix) {
+			continue
+		}
+
+		// Deleting a manifest removes only its link, so check that the
+		// revision is still present.
+		dgst := digest.NewDigestFromHex(alg.String(), hex)
+		linkPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: dgst})
+		if err != nil {
+			return "", err
+		}
+		if _, err := driver.Stat(ctx, linkPath); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return "", err
+		}
+
+		matches = append(matches, dgst)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", unknown
+	case 1:
+		return matches[0], nil
+	default:
+		return "", distribution.ErrManifestAmbiguous{Name: name, Prefix: prefix, Matches: matches}
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// canonicalDigest returns dgst with its algorithm and hex encoding lowercased.
+// Digests differing only in case identify the same content, but only the
+// lowercase form is accepted by the storage layout.
+func canonicalDigest(dgst digest.Digest) digest.Digest {
+	return digest.Digest(strings.ToLower(string(dgst)))
+}
+
+// normalizeReference returns the canonical form of a digest referenced by a
+// manifest. If strict is set, a digest not already in canonical form is
+// rejected with digest.ErrDigestInvalidFormat rather than normalized. A digest
+// of the wrong length is always rejected, as by ValidateDigestLength.
+func normalizeReference(dgst digest.Digest, strict bool) (digest.Digest, error) {
+	if err := ValidateDigestLength(dgst); err != nil {
+		return dgst, err
+	}
+
+	canonical := canonicalDigest(dgst)
+	if canonical == dgst {
+		return dgst, nil
+	}
+
+	if strict || canonical.Validate() != nil {
+		return dgst, digest.ErrDigestInvalidFormat
+	}
+
+	return canonical, nil
+}
+
+// ValidateDigestLength returns distribution.ErrDigestLengthInvalid if the
+// algorithm of dgst is available but its hex portion is not exactly as long
+// as the algorithm produces, so that a truncated or padded digest is
+// rejected outright rather than being matched against others by prefix.
+// Digests of other algorithms, or without an algorithm, are left to
+// dgst.Validate.
+func ValidateDigestLength(dgst digest.Digest) error {
+	i := strings.Index(string(dgst), ":")
+	if i <= 0 {
+		return nil
+	}
+	alg := digest.Algorithm(strings.ToLower(string(dgst[:i])))
+	if !alg.Available() {
+		return nil
+	}
+	if expected := alg.Size() * 2; len(dgst)-i-1 != expected {
+		return distribution.ErrDigestLengthInvalid{Digest: dgst, Expected: expected}
+	}
+	return nil
+}
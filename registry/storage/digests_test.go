@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestValidateDigestLength(t *testing.T) {
+	hex := strings.Repeat("a", 64)
+
+	for _, tc := range []struct {
+		dgst  digest.Digest
+		valid bool
+	}{
+		{dgst: digest.Digest("sha256:" + hex), valid: true},
+		{dgst: digest.Digest("SHA256:" + strings.ToUpper(hex)), valid: true},
+		{dgst: digest.Digest("sha256:" + hex[:63])},
+		{dgst: digest.Digest("sha256:" + hex[:7])},
+		{dgst: digest.Digest("sha256:")},
+		{dgst: digest.Digest("sha256:" + hex + "a")},
+		{dgst: digest.Digest("sha256:" + hex + hex)},
+		{dgst: digest.Digest("sha512:" + hex)},
+		// Left to digest validation.
+		{dgst: digest.Digest("unknown:abc"), valid: true},
+		{dgst: digest.Digest(hex), valid: true},
+	} {
+		err := ValidateDigestLength(tc.dgst)
+		if tc.valid {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.dgst, err)
+			}
+			continue
+		}
+		if _, ok := err.(distribution.ErrDigestLengthInvalid); !ok {
+			t.Errorf("%s: expected ErrDigestLengthInvalid, got %v", tc.dgst, err)
+		}
+	}
+}
+
+func TestNormalizeReferenceLength(t *testing.T) {
+	hex := strings.Repeat("a", 64)
+
+	for _, dgst := range []digest.Digest{
+		digest.Digest("sha256:" + hex[:63]),
+		digest.Digest("sha256:" + hex + "a"),
+		digest.Digest("SHA256:" + strings.ToUpper(hex[:63])),
+	} {
+		for _, strict := range []bool{false, true} {
+			if _, err := normalizeReference(dgst, strict); err == nil {
+				t.Errorf("%s: expected a digest of the wrong length to be rejected", dgst)
+			} else if _, ok := err.(distribution.ErrDigestLengthInvalid); !ok {
+				t.Errorf("%s: expected ErrDigestLengthInvalid, got %v", dgst, err)
+			}
+		}
+	}
+}
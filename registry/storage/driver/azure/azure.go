@@ -73,12 +73,19 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		realm = azure.DefaultBaseURL
 	}
 
-	return New(fmt.Sprint(accountName), fmt.Sprint(accountKey), fmt.Sprint(container), fmt.Sprint(realm))
+	connectionPool, err := base.GetConnectionPoolFromParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(fmt.Sprint(accountName), fmt.Sprint(accountKey), fmt.Sprint(container), fmt.Sprint(realm), connectionPool)
 }
 
-// New constructs a new Driver with the given Azure Storage Account credentials
-func New(accountName, accountKey, container, realm string) (*Driver, error) {
-	api, err := azure.NewClient(accountName, accountKey, realm, azure.DefaultAPIVersion, true)
+// New constructs a new Driver with the given Azure Storage Account
+// credentials, whose connections to the service are pooled as described by
+// pool.
+func New(accountName, accountKey, container, realm string, pool base.ConnectionPool) (*Driver, error) {
+	api, err := newClient(accountName, accountKey, realm, pool)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +104,17 @@ func New(accountName, accountKey, container, realm string) (*Driver, error) {
 	return &Driver{baseEmbed: baseEmbed{Base: base.Base{StorageDriver: d}}}, nil
 }
 
+// newClient returns a client for the Azure Storage Account whose transport
+// is sized to the connection pool.
+func newClient(accountName, accountKey, realm string, pool base.ConnectionPool) (azure.Client, error) {
+	api, err := azure.NewClient(accountName, accountKey, realm, azure.DefaultAPIVersion, true)
+	if err != nil {
+		return api, err
+	}
+	api.HTTPClient = &http.Client{Transport: pool.NewTransport()}
+	return api, nil
+}
+
 // Implement the storagedriver.StorageDriver interface.
 func (d *driver) Name() string {
 	return driverName
@@ -154,6 +172,53 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 	return blobRef.CreateBlockBlobFromReader(r, nil)
 }
 
+// CompareAndSwapContent stores content at path only if the content currently
+// stored there equals old, reporting whether it did. The write is made
+// conditional on the ETag of the blob that was read, so that it fails if
+// another writer replaced the blob in the meantime.
+func (d *driver) CompareAndSwapContent(ctx context.Context, path string, old, content []byte) (bool, error) {
+	blobRef := d.client.GetContainerReference(d.container).GetBlobReference(path)
+	var current []byte
+	rc, err := blobRef.Get(nil)
+	switch {
+	case is404(err):
+	case err != nil:
+		return false, err
+	default:
+		current, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+	if (current == nil) != (old == nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+
+	options := &azure.PutBlobOptions{IfNoneMatch: "*"}
+	if current != nil {
+		etag := blobRef.Properties.Etag
+		if blobRef.Properties.BlobType != azure.BlobTypeBlock {
+			// Legacy append blobs cannot be replaced by a block blob, see
+			// PutContent, so the blob is deleted first. A writer creating
+			// the blob after the deletion wins the swap.
+			err := blobRef.Delete(&azure.DeleteBlobOptions{IfMatch: etag})
+			if err != nil {
+				return false, conditionFailed(err)
+			}
+		} else {
+			options = &azure.PutBlobOptions{IfMatch: etag}
+		}
+	}
+
+	// reset properties to empty before doing overwrite
+	blobRef.Properties = azure.BlobProperties{}
+	if err := blobRef.CreateBlockBlobFromReader(bytes.NewReader(content), options); err != nil {
+		return false, conditionFailed(err)
+	}
+	return true, nil
+}
+
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
 // given byte offset.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
@@ -273,20 +338,77 @@ func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo,
 // List returns a list of the objects that are direct descendants of the given
 // path.
 func (d *driver) List(ctx context.Context, path string) ([]string, error) {
-	if path == "/" {
-		path = ""
+	list := []string{}
+	err := d.ListStream(ctx, path, func(child string) error {
+		list = append(list, child)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return list, nil
+}
 
-	blobs, err := d.listBlobs(d.container, path)
-	if err != nil {
-		return blobs, err
+// ListStream calls f with each of the objects and directories that are direct
+// descendants of the given path, one page of results at a time. Children are
+// passed in lexical order of their names, in which a virtual container's name
+// ends with a "/".
+func (d *driver) ListStream(ctx context.Context, path string, f func(child string) error) error {
+	prefix := path
+	if !strings.HasSuffix(prefix, "/") { // containerify the path
+		prefix += "/"
 	}
 
-	list := directDescendants(blobs, path)
-	if path != "" && len(list) == 0 {
-		return nil, storagedriver.PathNotFoundError{Path: path}
+	var count int
+	marker := ""
+	containerRef := d.client.GetContainerReference(d.container)
+	for {
+		resp, err := containerRef.ListBlobs(azure.ListBlobsParameters{
+			Marker:    marker,
+			Prefix:    prefix,
+			Delimiter: "/",
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range listedNames(resp) {
+			count++
+			if err := f(name); err != nil {
+				return err
+			}
+		}
+
+		if resp.NextMarker == "" {
+			break
+		}
+		marker = resp.NextMarker
 	}
-	return list, nil
+
+	if path != "/" && count == 0 {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return nil
+}
+
+// listedNames returns the names of the blobs and virtual containers in a page
+// of listing results in lexical order, without the trailing "/" of virtual
+// containers. Azure returns the blobs and the blob prefixes each in lexical
+// order, so the two are merged.
+func listedNames(resp azure.BlobListResponse) []string {
+	names := make([]string, 0, len(resp.Blobs)+len(resp.BlobPrefixes))
+	blobs, prefixes := resp.Blobs, resp.BlobPrefixes
+	for len(blobs) > 0 || len(prefixes) > 0 {
+		if len(prefixes) > 0 && (len(blobs) == 0 || prefixes[0] < blobs[0].Name) {
+			names = append(names, strings.TrimSuffix(prefixes[0], "/"))
+			prefixes = prefixes[1:]
+			continue
+		}
+
+		names = append(names, blobs[0].Name)
+		blobs = blobs[1:]
+	}
+	return names
 }
 
 // Move moves an object stored at sourcePath to destPath, removing the original
@@ -359,46 +481,18 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	})
 }
 
+// URLsHonorRange reports that the URLs returned by URLFor honor Range
+// headers. Blob URLs signed with a SAS token serve partial content to ranged requests.
+func (d *driver) URLsHonorRange() bool {
+	return true
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
 	return storagedriver.WalkFallback(ctx, d, path, f)
 }
 
-// directDescendants will find direct descendants (blobs or virtual containers)
-// of from list of blob paths and will return their full paths. Elements in blobs
-// list must be prefixed with a "/" and
-//
-// Example: direct descendants of "/" in {"/foo", "/bar/1", "/bar/2"} is
-// {"/foo", "/bar"} and direct descendants of "bar" is {"/bar/1", "/bar/2"}
-func directDescendants(blobs []string, prefix string) []string {
-	if !strings.HasPrefix(prefix, "/") { // add trailing '/'
-		prefix = "/" + prefix
-	}
-	if !strings.HasSuffix(prefix, "/") { // containerify the path
-		prefix += "/"
-	}
-
-	out := make(map[string]bool)
-	for _, b := range blobs {
-		if strings.HasPrefix(b, prefix) {
-			rel := b[len(prefix):]
-			c := strings.Count(rel, "/")
-			if c == 0 {
-				out[b] = true
-			} else {
-				out[prefix+rel[:strings.Index(rel, "/")]] = true
-			}
-		}
-	}
-
-	var keys []string
-	for k := range out {
-		keys = append(keys, k)
-	}
-	return keys
-}
-
 func (d *driver) listBlobs(container, virtPath string) ([]string, error) {
 	if virtPath != "" && !strings.HasSuffix(virtPath, "/") { // containerify the path
 		virtPath += "/"
@@ -429,6 +523,16 @@ func (d *driver) listBlobs(container, virtPath string) ([]string, error) {
 	return out, nil
 }
 
+// conditionFailed returns nil if err reports that the condition of a
+// conditional request did not hold, and err otherwise.
+func conditionFailed(err error) error {
+	statusCodeErr, ok := err.(azure.AzureStorageServiceError)
+	if ok && (statusCodeErr.StatusCode == http.StatusPreconditionFailed || statusCodeErr.StatusCode == http.StatusConflict || statusCodeErr.StatusCode == http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
 func is404(err error) bool {
 	statusCodeErr, ok := err.(azure.AzureStorageServiceError)
 	return ok && statusCodeErr.StatusCode == http.StatusNotFound
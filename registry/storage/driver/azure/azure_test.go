@@ -1,14 +1,19 @@
 package azure
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
 
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/testsuites"
 	. "gopkg.in/check.v1"
+
+	azure "github.com/Azure/azure-sdk-for-go/storage"
 )
 
 const (
@@ -48,7 +53,7 @@ func init() {
 	}
 
 	azureDriverConstructor := func() (storagedriver.StorageDriver, error) {
-		return New(accountName, accountKey, container, realm)
+		return New(accountName, accountKey, container, realm, base.ConnectionPool{MaxIdle: base.DefaultMaxIdleConnections})
 	}
 
 	// Skip Azure storage driver tests if environment variable parameters are not provided
@@ -61,3 +66,22 @@ func init() {
 
 	testsuites.RegisterSuite(azureDriverConstructor, skipCheck)
 }
+
+func TestNewClientConnectionPool(t *testing.T) {
+	accountKey := base64.StdEncoding.EncodeToString([]byte("accountkey"))
+	api, err := newClient("accountname", accountKey, azure.DefaultBaseURL, base.ConnectionPool{MaxIdle: 7, MaxActive: 3})
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+
+	httpTransport, ok := api.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("unexpected client transport: %T", api.HTTPClient.Transport)
+	}
+	if httpTransport.MaxIdleConnsPerHost != 7 {
+		t.Errorf("unexpected idle connection limit: %d", httpTransport.MaxIdleConnsPerHost)
+	}
+	if httpTransport.MaxConnsPerHost != 3 {
+		t.Errorf("unexpected active connection limit: %d", httpTransport.MaxConnsPerHost)
+	}
+}
@@ -54,6 +54,7 @@ var (
 )
 
 func init() {
+	prometheus.StorageNamespace.Add(operationLatency)
 	metrics.Register(prometheus.StorageNamespace)
 }
 
@@ -83,6 +84,12 @@ func (base *Base) setDriverName(e error) error {
 	case storagedriver.QuotaExceededError:
 		actual.DriverName = base.StorageDriver.Name()
 		return actual
+	case storagedriver.OperationLimitExceededError:
+		actual.DriverName = base.StorageDriver.Name()
+		return actual
+	case storagedriver.OperationBudgetExceededError:
+		actual.DriverName = base.StorageDriver.Name()
+		return actual
 	default:
 		storageError := storagedriver.Error{
 			DriverName: base.StorageDriver.Name(),
@@ -102,10 +109,21 @@ func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return nil, base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
 	b, e := base.StorageDriver.GetContent(ctx, path)
 	storageAction.WithValues(base.Name(), "GetContent").UpdateSince(start)
-	return b, base.setDriverName(e)
+	operationLatency.observe(base.Name(), "Read", start)
+	return b, base.setDriverName(storagedriver.OperationBudgetError(ctx, e))
 }
 
 // PutContent wraps PutContent of underlying storage driver.
@@ -117,9 +135,20 @@ func (base *Base) PutContent(ctx context.Context, path string, content []byte) e
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.PutContent(ctx, path, content))
+	err = base.setDriverName(storagedriver.OperationBudgetError(ctx, base.StorageDriver.PutContent(ctx, path, content)))
 	storageAction.WithValues(base.Name(), "PutContent").UpdateSince(start)
+	operationLatency.observe(base.Name(), "Write", start)
 	return err
 }
 
@@ -136,7 +165,18 @@ func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.Rea
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	ctx, err := storagedriver.BudgetStream(ctx)
+	if err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	start := time.Now()
 	rc, e := base.StorageDriver.Reader(ctx, path, offset)
+	operationLatency.observe(base.Name(), "Read", start)
 	return rc, base.setDriverName(e)
 }
 
@@ -149,7 +189,18 @@ func (base *Base) Writer(ctx context.Context, path string, append bool) (storage
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	ctx, err := storagedriver.BudgetStream(ctx)
+	if err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	start := time.Now()
 	writer, e := base.StorageDriver.Writer(ctx, path, append)
+	operationLatency.observe(base.Name(), "Write", start)
 	return writer, base.setDriverName(e)
 }
 
@@ -162,10 +213,21 @@ func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return nil, base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
 	fi, e := base.StorageDriver.Stat(ctx, path)
 	storageAction.WithValues(base.Name(), "Stat").UpdateSince(start)
-	return fi, base.setDriverName(e)
+	operationLatency.observe(base.Name(), "Stat", start)
+	return fi, base.setDriverName(storagedriver.OperationBudgetError(ctx, e))
 }
 
 // List wraps List of underlying storage driver.
@@ -177,10 +239,21 @@ func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return nil, base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return nil, base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
 	str, e := base.StorageDriver.List(ctx, path)
 	storageAction.WithValues(base.Name(), "List").UpdateSince(start)
-	return str, base.setDriverName(e)
+	operationLatency.observe(base.Name(), "List", start)
+	return str, base.setDriverName(storagedriver.OperationBudgetError(ctx, e))
 }
 
 // Move wraps Move of underlying storage driver.
@@ -194,9 +267,20 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 		return storagedriver.InvalidPathError{Path: destPath, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Move(ctx, sourcePath, destPath))
+	err = base.setDriverName(storagedriver.OperationBudgetError(ctx, base.StorageDriver.Move(ctx, sourcePath, destPath)))
 	storageAction.WithValues(base.Name(), "Move").UpdateSince(start)
+	operationLatency.observe(base.Name(), "Move", start)
 	return err
 }
 
@@ -209,9 +293,20 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Delete(ctx, path))
+	err = base.setDriverName(storagedriver.OperationBudgetError(ctx, base.StorageDriver.Delete(ctx, path)))
 	storageAction.WithValues(base.Name(), "Delete").UpdateSince(start)
+	operationLatency.observe(base.Name(), "Delete", start)
 	return err
 }
 
@@ -224,10 +319,58 @@ func (base *Base) URLFor(ctx context.Context, path string, options map[string]in
 		return "", storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return "", base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return "", base.setDriverName(err)
+	}
+	defer cancel()
+
 	start := time.Now()
 	str, e := base.StorageDriver.URLFor(ctx, path, options)
 	storageAction.WithValues(base.Name(), "URLFor").UpdateSince(start)
-	return str, base.setDriverName(e)
+	return str, base.setDriverName(storagedriver.OperationBudgetError(ctx, e))
+}
+
+// CompareAndSwapContent wraps CompareAndSwapContent of underlying storage
+// driver, returning ErrUnsupportedMethod if it is not a CompareAndSwapper.
+func (base *Base) CompareAndSwapContent(ctx context.Context, path string, old, content []byte) (bool, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("%s.CompareAndSwapContent(%q)", base.Name(), path)
+
+	if !storagedriver.PathRegexp.MatchString(path) {
+		return false, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+	}
+
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return false, base.setDriverName(err)
+	}
+
+	ctx, cancel, err := storagedriver.BudgetOperation(ctx)
+	if err != nil {
+		return false, base.setDriverName(err)
+	}
+	defer cancel()
+
+	cas, ok := base.StorageDriver.(storagedriver.CompareAndSwapper)
+	if !ok {
+		return false, storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+
+	start := time.Now()
+	swapped, e := cas.CompareAndSwapContent(ctx, path, old, content)
+	storageAction.WithValues(base.Name(), "CompareAndSwapContent").UpdateSince(start)
+	operationLatency.observe(base.Name(), "Write", start)
+	return swapped, base.setDriverName(storagedriver.OperationBudgetError(ctx, e))
+}
+
+// URLsHonorRange reports whether the URLs returned by URLFor of the
+// underlying storage driver honor Range headers.
+func (base *Base) URLsHonorRange() bool {
+	return storagedriver.URLsHonorRange(base.StorageDriver)
 }
 
 // Walk wraps Walk of underlying storage driver.
@@ -239,5 +382,14 @@ func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn)
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	if err := storagedriver.CountOperation(ctx); err != nil {
+		return base.setDriverName(err)
+	}
+
+	ctx, err := storagedriver.BudgetStream(ctx)
+	if err != nil {
+		return base.setDriverName(err)
+	}
+
 	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f))
 }
@@ -0,0 +1,83 @@
+package base
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	dmetrics "github.com/docker/distribution/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets into
+// which storage operation latencies are observed, unless SetLatencyBuckets
+// configures others.
+var DefaultLatencyBuckets = prometheus.DefBuckets
+
+// operationLatency observes the latency of storage operations by driver and
+// operation type, so that percentiles of backend latency can be derived.
+var operationLatency = newLatencyHistogram(DefaultLatencyBuckets)
+
+// latencyHistogram is a histogram of storage operation latencies whose
+// buckets may be replaced once the registry is configured.
+type latencyHistogram struct {
+	mu  sync.RWMutex
+	vec *prometheus.HistogramVec
+}
+
+func newLatencyHistogram(buckets []float64) *latencyHistogram {
+	return &latencyHistogram{vec: newLatencyHistogramVec(buckets)}
+}
+
+func newLatencyHistogramVec(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: dmetrics.NamespacePrefix,
+		Subsystem: "storage",
+		Name:      "operation_latency_seconds",
+		Help:      "The latency of storage operations, by driver and operation type",
+		Buckets:   buckets,
+	}, []string{"driver", "operation"})
+}
+
+// observe records the latency of an operation started at start.
+func (h *latencyHistogram) observe(driver, operation string, start time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.vec.WithLabelValues(driver, operation).Observe(time.Since(start).Seconds())
+}
+
+// Describe implements prometheus.Collector.
+func (h *latencyHistogram) Describe(ch chan<- *prometheus.Desc) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.vec.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (h *latencyHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.vec.Collect(ch)
+}
+
+// SetLatencyBuckets sets the upper bounds, in seconds, of the buckets into
+// which storage operation latencies are observed. The bounds must be strictly
+// increasing. Latencies observed before the buckets are set are discarded, so
+// it should be called before the registry begins serving requests.
+func SetLatencyBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("no latency buckets given")
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("latency buckets must be strictly increasing: %v", buckets)
+		}
+	}
+
+	vec := newLatencyHistogramVec(buckets)
+
+	operationLatency.mu.Lock()
+	defer operationLatency.mu.Unlock()
+	operationLatency.vec = vec
+	return nil
+}
@@ -0,0 +1,77 @@
+package base
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// slowDriver is a driver whose Stat takes at least latency to complete.
+type slowDriver struct {
+	storagedriver.StorageDriver
+	latency time.Duration
+}
+
+func (d *slowDriver) Name() string {
+	return "slow"
+}
+
+func (d *slowDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	time.Sleep(d.latency)
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{Path: path}}, nil
+}
+
+func TestOperationLatencyBuckets(t *testing.T) {
+	if err := SetLatencyBuckets([]float64{0.001, 10}); err != nil {
+		t.Fatalf("unexpected error setting latency buckets: %v", err)
+	}
+	defer SetLatencyBuckets(DefaultLatencyBuckets)
+
+	d := &Base{StorageDriver: &slowDriver{latency: 20 * time.Millisecond}}
+	for i := 0; i < 3; i++ {
+		if _, err := d.Stat(context.Background(), "/file"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(operationLatency)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error gathering metrics: %v", err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatalf("unexpected metrics: %v", families)
+	}
+
+	m := families[0].GetMetric()[0]
+	labels := make(map[string]string)
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	if labels["driver"] != "slow" || labels["operation"] != "Stat" {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+
+	// Every observation falls in the second bucket.
+	histogram := m.GetHistogram()
+	if histogram.GetSampleCount() != 3 {
+		t.Fatalf("unexpected sample count: %d != 3", histogram.GetSampleCount())
+	}
+	buckets := histogram.GetBucket()
+	if len(buckets) != 2 {
+		t.Fatalf("unexpected number of buckets: %d != 2", len(buckets))
+	}
+	for i, expected := range []uint64{0, 3} {
+		if count := buckets[i].GetCumulativeCount(); count != expected {
+			t.Errorf("unexpected count in bucket %v: %d != %d", buckets[i].GetUpperBound(), count, expected)
+		}
+	}
+
+	if err := SetLatencyBuckets([]float64{1, 0.1}); err == nil {
+		t.Fatal("expected error setting decreasing latency buckets")
+	}
+}
@@ -182,3 +182,9 @@ func (r *regulator) URLFor(ctx context.Context, path string, options map[string]
 
 	return r.StorageDriver.URLFor(ctx, path, options)
 }
+
+// URLsHonorRange reports whether the URLs returned by URLFor of the
+// underlying storage driver honor Range headers.
+func (r *regulator) URLsHonorRange() bool {
+	return storagedriver.URLsHonorRange(r.StorageDriver)
+}
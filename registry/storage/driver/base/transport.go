@@ -0,0 +1,62 @@
+package base
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	// DefaultMaxIdleConnections is the number of idle connections to its
+	// backend a driver keeps open, unless configured otherwise.
+	DefaultMaxIdleConnections = 100
+
+	// DefaultMaxActiveConnections is the number of connections to its
+	// backend a driver may have open at once, unless configured otherwise.
+	// Zero means no limit.
+	DefaultMaxActiveConnections = 0
+)
+
+// ConnectionPool describes the pool of HTTP connections a driver keeps to
+// its backend.
+type ConnectionPool struct {
+	// MaxIdle is the number of idle connections kept open for reuse.
+	MaxIdle int
+
+	// MaxActive limits the number of connections, including those in
+	// use, open at once. Zero means no limit. Requests beyond the limit
+	// wait for a connection to become available.
+	MaxActive int
+}
+
+// GetConnectionPoolFromParameters returns the connection pool described by
+// the maxidleconnections and maxactiveconnections driver parameters, using
+// the defaults for those which are not set.
+//
+// If either parameter is of an invalid type this returns an error.
+func GetConnectionPoolFromParameters(parameters map[string]interface{}) (ConnectionPool, error) {
+	maxIdle, err := GetLimitFromParameter(parameters["maxidleconnections"], 0, DefaultMaxIdleConnections)
+	if err != nil {
+		return ConnectionPool{}, fmt.Errorf("maxidleconnections config error: %s", err)
+	}
+
+	maxActive, err := GetLimitFromParameter(parameters["maxactiveconnections"], 0, DefaultMaxActiveConnections)
+	if err != nil {
+		return ConnectionPool{}, fmt.Errorf("maxactiveconnections config error: %s", err)
+	}
+
+	return ConnectionPool{
+		MaxIdle:   int(maxIdle),
+		MaxActive: int(maxActive),
+	}, nil
+}
+
+// NewTransport returns an http.Transport with the settings of
+// http.DefaultTransport, sized to the connection pool. All connections go to
+// the driver's backend, so the limits apply per host as well as overall.
+func (p ConnectionPool) NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = p.MaxIdle
+	t.MaxIdleConnsPerHost = p.MaxIdle
+	t.MaxConnsPerHost = p.MaxActive
+	return t
+}
@@ -0,0 +1,64 @@
+package base
+
+import (
+	"testing"
+)
+
+func TestGetConnectionPoolFromParameters(t *testing.T) {
+	tests := []struct {
+		parameters map[string]interface{}
+		expected   ConnectionPool
+		err        bool
+	}{
+		{
+			parameters: map[string]interface{}{},
+			expected:   ConnectionPool{MaxIdle: DefaultMaxIdleConnections, MaxActive: DefaultMaxActiveConnections},
+		},
+		{
+			parameters: map[string]interface{}{"maxidleconnections": 10, "maxactiveconnections": 20},
+			expected:   ConnectionPool{MaxIdle: 10, MaxActive: 20},
+		},
+		{
+			parameters: map[string]interface{}{"maxidleconnections": "5", "maxactiveconnections": "50"},
+			expected:   ConnectionPool{MaxIdle: 5, MaxActive: 50},
+		},
+		{
+			parameters: map[string]interface{}{"maxidleconnections": "many"},
+			err:        true,
+		},
+		{
+			parameters: map[string]interface{}{"maxactiveconnections": true},
+			err:        true,
+		},
+	}
+
+	for _, test := range tests {
+		pool, err := GetConnectionPoolFromParameters(test.parameters)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected an error for %v", test.parameters)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", test.parameters, err)
+		}
+		if pool != test.expected {
+			t.Errorf("unexpected pool for %v: %+v != %+v", test.parameters, pool, test.expected)
+		}
+	}
+}
+
+func TestConnectionPoolNewTransport(t *testing.T) {
+	transport := ConnectionPool{MaxIdle: 7, MaxActive: 3}.NewTransport()
+
+	if transport.MaxIdleConns != 7 || transport.MaxIdleConnsPerHost != 7 {
+		t.Errorf("unexpected idle connection limits: %d, %d per host", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+	if transport.MaxConnsPerHost != 3 {
+		t.Errorf("unexpected active connection limit: %d", transport.MaxConnsPerHost)
+	}
+	if transport.Proxy == nil {
+		t.Error("expected the transport to keep the default proxy settings")
+	}
+}
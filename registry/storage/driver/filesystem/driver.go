@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"time"
 
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
@@ -172,7 +173,7 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 
 func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
 	fullPath := d.fullPath(subPath)
-	parentDir := path.Dir(fullPath)
+	parentDir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(parentDir, 0777); err != nil {
 		return nil, err
 	}
@@ -260,7 +261,7 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 		return storagedriver.PathNotFoundError{Path: sourcePath}
 	}
 
-	if err := os.MkdirAll(path.Dir(dest), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return err
 	}
 
@@ -296,8 +297,10 @@ func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn)
 }
 
 // fullPath returns the absolute path of a key within the Driver's storage.
+// Keys are always separated by forward slashes, and are translated to the
+// separator of the operating system only here.
 func (d *driver) fullPath(subPath string) string {
-	return path.Join(d.rootDirectory, subPath)
+	return filepath.Join(d.rootDirectory, filepath.FromSlash(subPath))
 }
 
 type fileInfo struct {
@@ -1,8 +1,10 @@
 package filesystem
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -111,3 +113,46 @@ func TestFromParametersImpl(t *testing.T) {
 	}
 
 }
+
+func TestPathSeparators(t *testing.T) {
+	ctx := context.Background()
+
+	root, err := ioutil.TempDir("", "driver-")
+	if err != nil {
+		t.Fatalf("unexpected error creating root directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	d := New(DriverParameters{RootDirectory: root, MaxThreads: defaultMaxThreads})
+
+	for _, p := range []string{`\a\b`, `/a\b`, `/a/b\c`} {
+		err := d.PutContent(ctx, p, []byte("content"))
+		if _, ok := err.(storagedriver.InvalidPathError); !ok {
+			t.Fatalf("expected invalid path error writing %q, got %v", p, err)
+		}
+	}
+
+	if err := d.PutContent(ctx, "/a/b/c", []byte("content")); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+
+	// Keys are stored using the separator of the operating system.
+	if _, err := os.Stat(filepath.Join(root, "a", "b", "c")); err != nil {
+		t.Fatalf("content not stored at the native path: %v", err)
+	}
+
+	// They are reported using forward slashes.
+	var walked []string
+	err = storagedriver.WalkFallback(ctx, d, "/", func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error walking: %v", err)
+	}
+
+	expected := []string{"/a", "/a/b", "/a/b/c"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("unexpected paths walked: %v != %v", walked, expected)
+	}
+}
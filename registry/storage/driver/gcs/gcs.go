@@ -197,12 +197,17 @@ func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDri
 		return nil, fmt.Errorf("maxconcurrency config error: %s", err)
 	}
 
+	connectionPool, err := base.GetConnectionPoolFromParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
 	params := driverParameters{
 		bucket:         fmt.Sprint(bucket),
 		rootDirectory:  fmt.Sprint(rootDirectory),
 		email:          jwtConf.Email,
 		privateKey:     jwtConf.PrivateKey,
-		client:         oauth2.NewClient(context.Background(), ts),
+		client:         newClient(ts, connectionPool),
 		chunkSize:      chunkSize,
 		maxConcurrency: maxConcurrency,
 	}
@@ -210,6 +215,17 @@ func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDri
 	return New(params)
 }
 
+// newClient returns a client authorizing its requests with tokens from ts,
+// whose transport is sized to the connection pool.
+func newClient(ts oauth2.TokenSource, pool base.ConnectionPool) *http.Client {
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, ts),
+			Base:   pool.NewTransport(),
+		},
+	}
+}
+
 // New constructs a new driver
 func New(params driverParameters) (storagedriver.StorageDriver, error) {
 	rootDirectory := strings.Trim(params.rootDirectory, "/")
@@ -279,6 +295,30 @@ func (d *driver) PutContent(context context.Context, path string, contents []byt
 	})
 }
 
+// CompareAndSwapContent stores content at path only if the content currently
+// stored there equals old, reporting whether it did. The write is made
+// conditional on the generation of the object that was read, so that it
+// fails if another writer replaced the object in the meantime.
+func (d *driver) CompareAndSwapContent(context context.Context, path string, old, content []byte) (bool, error) {
+	name := d.pathToKey(path)
+	current, generation, err := getObjectGeneration(d.client, d.bucket, name)
+	if err != nil {
+		return false, err
+	}
+	if (current == nil) != (old == nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+
+	err = putObjectIfGeneration(d.client, d.bucket, name, content, generation)
+	if status, ok := err.(*googleapi.Error); ok && status.Code == http.StatusPreconditionFailed {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Reader retrieves an io.ReadCloser for the content stored at "path"
 // with a given byte offset.
 // May be used to resume reading a stream by providing a nonzero offset.
@@ -339,6 +379,62 @@ func getObject(client *http.Client, bucket string, name string, offset int64) (*
 	return res, googleapi.CheckMediaResponse(res)
 }
 
+// getObjectGeneration returns the content of an object along with its
+// generation. The content of an object which does not exist, or which holds
+// an upload session, is nil, and the generation of an object which does not
+// exist is 0.
+func getObjectGeneration(client *http.Client, bucket string, name string) ([]byte, int64, error) {
+	res, err := getObject(client, bucket, name, 0)
+	if err != nil {
+		if res != nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusNotFound {
+				return nil, 0, nil
+			}
+		}
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	generation, err := strconv.ParseInt(res.Header.Get("X-Goog-Generation"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid generation of %s: %v", name, err)
+	}
+	if res.Header.Get("Content-Type") == uploadSessionContentType {
+		return nil, generation, nil
+	}
+
+	p, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return p, generation, nil
+}
+
+// putObjectIfGeneration stores content in an object only if its generation is
+// still the given one, where 0 requires that the object does not exist. The
+// request is not retried, since a retry could fail the precondition on its
+// own earlier write.
+func putObjectIfGeneration(client *http.Client, bucket string, name string, content []byte, generation int64) error {
+	u := &url.URL{
+		Scheme: "https",
+		Host:   "storage.googleapis.com",
+		Path:   fmt.Sprintf("/%s/%s", bucket, name),
+	}
+	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Goog-If-Generation-Match", strconv.FormatInt(generation, 10))
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return googleapi.CheckMediaResponse(res)
+}
+
 // Writer returns a FileWriter which will store the content written to it
 // at the location designated by "path" after the call to Commit.
 func (d *driver) Writer(context context.Context, path string, append bool) (storagedriver.FileWriter, error) {
@@ -641,39 +737,74 @@ func (d *driver) Stat(context context.Context, path string) (storagedriver.FileI
 // List returns a list of the objects that are direct descendants of the
 //given path.
 func (d *driver) List(context context.Context, path string) ([]string, error) {
+	list := make([]string, 0, 64)
+	err := d.ListStream(context, path, func(child string) error {
+		list = append(list, child)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ListStream calls f with each of the objects and directories that are direct
+// descendants of the given path, one page of results at a time. Children are
+// passed in lexical order of their keys, in which a directory's key ends with
+// a "/".
+func (d *driver) ListStream(context context.Context, path string, f func(child string) error) error {
 	var query *storage.Query
 	query = &storage.Query{}
 	query.Delimiter = "/"
 	query.Prefix = d.pathToDirKey(path)
-	list := make([]string, 0, 64)
+	var count int
 	for {
 		objects, err := storageListObjects(d.context(context), d.bucket, query)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, object := range objects.Results {
-			// GCS does not guarantee strong consistency between
-			// DELETE and LIST operations. Check that the object is not deleted,
-			// and filter out any objects with a non-zero time-deleted
-			if object.Deleted.IsZero() && object.ContentType != uploadSessionContentType {
-				list = append(list, d.keyToPath(object.Name))
+		for _, key := range listedKeys(objects) {
+			count++
+			if err := f(d.keyToPath(key)); err != nil {
+				return err
 			}
 		}
-		for _, subpath := range objects.Prefixes {
-			subpath = d.keyToPath(subpath)
-			list = append(list, subpath)
-		}
 		query = objects.Next
 		if query == nil {
 			break
 		}
 	}
-	if path != "/" && len(list) == 0 {
+	if path != "/" && count == 0 {
 		// Treat empty response as missing directory, since we don't actually
 		// have directories in Google Cloud Storage.
-		return nil, storagedriver.PathNotFoundError{Path: path}
+		return storagedriver.PathNotFoundError{Path: path}
 	}
-	return list, nil
+	return nil
+}
+
+// listedKeys returns the keys of the objects and directories in a page of
+// listing results in lexical order. GCS returns the objects and the
+// directories, as prefixes, each in lexical order, so the two are merged.
+func listedKeys(objects *storage.Objects) []string {
+	keys := make([]string, 0, len(objects.Results)+len(objects.Prefixes))
+	results, prefixes := objects.Results, objects.Prefixes
+	for len(results) > 0 || len(prefixes) > 0 {
+		if len(prefixes) > 0 && (len(results) == 0 || prefixes[0] < results[0].Name) {
+			keys = append(keys, prefixes[0])
+			prefixes = prefixes[1:]
+			continue
+		}
+
+		object := results[0]
+		results = results[1:]
+		// GCS does not guarantee strong consistency between
+		// DELETE and LIST operations. Check that the object is not deleted,
+		// and filter out any objects with a non-zero time-deleted
+		if object.Deleted.IsZero() && object.ContentType != uploadSessionContentType {
+			keys = append(keys, object.Name)
+		}
+	}
+	return keys
 }
 
 // Move moves an object stored at sourcePath to destPath, removing the
@@ -834,6 +965,12 @@ func (d *driver) URLFor(context context.Context, path string, options map[string
 	return storage.SignedURL(d.bucket, name, opts)
 }
 
+// URLsHonorRange reports that the URLs returned by URLFor honor Range
+// headers. Signed GCS URLs serve partial content to ranged requests.
+func (d *driver) URLsHonorRange() bool {
+	return true
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
@@ -5,11 +5,15 @@ package gcs
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	dcontext "github.com/docker/distribution/context"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/testsuites"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -219,6 +223,26 @@ func TestRetry(t *testing.T) {
 	assertError("error", err)
 }
 
+func TestNewClientConnectionPool(t *testing.T) {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	client := newClient(ts, base.ConnectionPool{MaxIdle: 7, MaxActive: 3})
+
+	oauthTransport, ok := client.Transport.(*oauth2.Transport)
+	if !ok {
+		t.Fatalf("unexpected client transport: %T", client.Transport)
+	}
+	httpTransport, ok := oauthTransport.Base.(*http.Transport)
+	if !ok {
+		t.Fatalf("unexpected base transport: %T", oauthTransport.Base)
+	}
+	if httpTransport.MaxIdleConnsPerHost != 7 {
+		t.Errorf("unexpected idle connection limit: %d", httpTransport.MaxIdleConnsPerHost)
+	}
+	if httpTransport.MaxConnsPerHost != 3 {
+		t.Errorf("unexpected active connection limit: %d", httpTransport.MaxConnsPerHost)
+	}
+}
+
 func TestEmptyRootList(t *testing.T) {
 	if skipGCS() != "" {
 		t.Skip(skipGCS())
@@ -309,3 +333,24 @@ func TestMoveDirectory(t *testing.T) {
 		t.Fatalf("Moving directory /parent/dir /parent/other should have return a non-nil error\n")
 	}
 }
+
+// TestListedKeysOrder ensures that the objects and directories of a page of
+// listing results are merged in lexical order.
+func TestListedKeysOrder(t *testing.T) {
+	objects := &storage.Objects{
+		Results: []*storage.Object{
+			{Name: "root/a"},
+			{Name: "root/c"},
+			{Name: "root/d", Deleted: time.Now()},
+			{Name: "root/e"},
+			{Name: "root/f", ContentType: uploadSessionContentType},
+		},
+		Prefixes: []string{"root/b/", "root/d/", "root/g/"},
+	}
+
+	keys := listedKeys(objects)
+	expected := []string{"root/a", "root/b/", "root/c", "root/d/", "root/e", "root/g/"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("unexpected keys: %v != %v", keys, expected)
+	}
+}
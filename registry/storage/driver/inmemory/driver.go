@@ -1,6 +1,7 @@
 package inmemory
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -102,6 +103,34 @@ func (d *driver) PutContent(ctx context.Context, p string, contents []byte) erro
 	return nil
 }
 
+// CompareAndSwapContent stores content at path only if the content currently
+// stored there equals old. A nil old matches only a path which does not exist.
+func (d *driver) CompareAndSwapContent(ctx context.Context, p string, old, content []byte) (bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	normalized := normalize(p)
+
+	if found := d.root.find(normalized); found.path() == normalized {
+		f, ok := found.(*file)
+		if !ok || old == nil || !bytes.Equal(f.data, old) {
+			return false, nil
+		}
+	} else if old != nil {
+		return false, nil
+	}
+
+	f, err := d.root.mkfile(normalized)
+	if err != nil {
+		return false, fmt.Errorf("not a file")
+	}
+
+	f.truncate()
+	f.WriteAt(content, 0)
+
+	return true, nil
+}
+
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
 // given byte offset.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
@@ -209,6 +209,13 @@ func (lh *cloudFrontStorageMiddleware) URLFor(ctx context.Context, path string,
 	return cfURL, nil
 }
 
+// URLsHonorRange reports whether the URLs returned by URLFor honor Range
+// headers. CloudFront serves partial content to ranged requests, but URLs
+// may instead be those of the storage driver, so it must honor them too.
+func (lh *cloudFrontStorageMiddleware) URLsHonorRange() bool {
+	return storagedriver.URLsHonorRange(lh.StorageDriver)
+}
+
 // init registers the cloudfront layerHandler backend.
 func init() {
 	storagemiddleware.Register("cloudfront", storagemiddleware.InitFunc(newCloudFrontStorageMiddleware))
@@ -0,0 +1,607 @@
+// Package middleware - encryption wrapper for storage drivers, encrypting the
+// content of every file at rest.
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+)
+
+// header begins every file stored through the middleware, identifying its
+// format.
+var header = []byte("ENC1")
+
+const (
+	// segmentSize is the number of bytes of content sealed in each segment
+	// of a file. Every segment but the last is full.
+	segmentSize = 64 << 10
+
+	// nonceSize is the size of the random nonce preceding each segment.
+	nonceSize = 12
+
+	// tagSize is the size of the authentication tag sealing each segment.
+	tagSize = 16
+
+	// segmentOverhead is the number of bytes stored for a segment beyond
+	// its content.
+	segmentOverhead = nonceSize + tagSize
+
+	// sealedSegmentSize is the number of bytes stored for a full segment.
+	sealedSegmentSize = segmentSize + segmentOverhead
+)
+
+// errNotEncrypted is returned reading a file which was not stored through
+// the middleware.
+var errNotEncrypted = errors.New("content is not encrypted")
+
+// encryptionStorageMiddleware encrypts the content of files with AES-GCM
+// before storing them with the wrapped driver, and decrypts it on reading.
+//
+// A file is stored as a header followed by its content in segments, each
+// sealed with its own random nonce and authenticated with its position, so
+// that a file can be read from any offset and its length known from the
+// length stored without reading it. Paths, sizes and modification times are
+// not hidden.
+//
+// A file may only be appended to in place if its content fills its last
+// segment, or if nothing is appended. Otherwise, the file is first rewritten,
+// which requires the wrapped driver to be able to read the content appended
+// to so far.
+type encryptionStorageMiddleware struct {
+	storagedriver.StorageDriver
+	aead cipher.AEAD
+}
+
+var _ storagedriver.StorageDriver = &encryptionStorageMiddleware{}
+var _ storagedriver.CompareAndSwapper = &encryptionStorageMiddleware{}
+
+// newEncryptionStorageMiddleware wraps sd, encrypting content with the AES
+// key given, base64 encoded, by the "key" option. The key must be 16, 24 or
+// 32 bytes long, selecting AES-128, AES-192 or AES-256.
+func newEncryptionStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, ok := options["key"]
+	if !ok {
+		return nil, fmt.Errorf("no encryption key provided")
+	}
+	k, ok := o.(string)
+	if !ok {
+		return nil, fmt.Errorf("encryption key must be a string")
+	}
+	key, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be base64 encoded: %v", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionStorageMiddleware{StorageDriver: sd, aead: aead}, nil
+}
+
+// newAEAD returns the AES-GCM cipher using key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// contentSize returns the length of the content of a file stored in size
+// bytes.
+func contentSize(size int64) int64 {
+	n := size - int64(len(header))
+	if n <= 0 {
+		return 0
+	}
+
+	full, rem := n/sealedSegmentSize, n%sealedSegmentSize
+	size = full * segmentSize
+	if rem > segmentOverhead {
+		size += rem - segmentOverhead
+	}
+	return size
+}
+
+// storedSize returns the number of bytes stored for a file with size bytes of
+// content.
+func storedSize(size int64) int64 {
+	full, rem := size/segmentSize, size%segmentSize
+	stored := int64(len(header)) + full*sealedSegmentSize
+	if rem > 0 {
+		stored += rem + segmentOverhead
+	}
+	return stored
+}
+
+// additionalData returns the data authenticated with the segment at index,
+// binding it to its position in the file.
+func additionalData(index int64) []byte {
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, uint64(index))
+	return ad
+}
+
+// seal appends the sealed segment at index holding p to dst.
+func (d *encryptionStorageMiddleware) seal(dst, p []byte, index int64) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return d.aead.Seal(dst, nonce, p, additionalData(index)), nil
+}
+
+// open returns the content of the sealed segment at index.
+func (d *encryptionStorageMiddleware) open(sealed []byte, index int64) ([]byte, error) {
+	if len(sealed) <= segmentOverhead {
+		return nil, fmt.Errorf("truncated segment")
+	}
+	return d.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData(index))
+}
+
+// encrypt returns the file stored for content.
+func (d *encryptionStorageMiddleware) encrypt(content []byte) ([]byte, error) {
+	stored := make([]byte, 0, storedSize(int64(len(content))))
+	stored = append(stored, header...)
+	for index := int64(0); len(content) > 0; index++ {
+		n := len(content)
+		if n > segmentSize {
+			n = segmentSize
+		}
+
+		var err error
+		if stored, err = d.seal(stored, content[:n], index); err != nil {
+			return nil, err
+		}
+		content = content[n:]
+	}
+	return stored, nil
+}
+
+// decrypt returns the content of the file stored.
+func (d *encryptionStorageMiddleware) decrypt(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return stored, nil
+	}
+	if !bytes.HasPrefix(stored, header) {
+		return nil, errNotEncrypted
+	}
+	stored = stored[len(header):]
+
+	content := make([]byte, 0, contentSize(int64(len(header)+len(stored))))
+	for index := int64(0); len(stored) > 0; index++ {
+		n := len(stored)
+		if n > sealedSegmentSize {
+			n = sealedSegmentSize
+		}
+
+		p, err := d.open(stored[:n], index)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, p...)
+		stored = stored[n:]
+	}
+	return content, nil
+}
+
+func (d *encryptionStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	stored, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := d.decrypt(stored)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %v", path, err)
+	}
+	return content, nil
+}
+
+func (d *encryptionStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	stored, err := d.encrypt(content)
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.PutContent(ctx, path, stored)
+}
+
+// Reader reads the content of the file at path from offset, starting from
+// the segment holding offset.
+func (d *encryptionStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+	}
+
+	fi, err := d.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%q is a directory", path)
+	}
+	if offset > fi.Size() {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+	}
+
+	index := offset / segmentSize
+	if index == 0 {
+		rc, err := d.StorageDriver.Reader(ctx, path, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		h := make([]byte, len(header))
+		if n, err := io.ReadFull(rc, h); err != nil {
+			rc.Close()
+			if n == 0 && err == io.EOF {
+				// An empty file has no header.
+				return &segmentReader{d: d, rc: rc, path: path}, nil
+			}
+			return nil, err
+		}
+		if !bytes.Equal(h, header) {
+			rc.Close()
+			return nil, fmt.Errorf("decrypting %s: %v", path, errNotEncrypted)
+		}
+		return &segmentReader{d: d, rc: rc, path: path, skip: offset}, nil
+	}
+
+	rc, err := d.StorageDriver.Reader(ctx, path, int64(len(header))+index*sealedSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{d: d, rc: rc, path: path, index: index, skip: offset - index*segmentSize}, nil
+}
+
+// segmentReader decrypts the segments of a file as they are read.
+type segmentReader struct {
+	d    *encryptionStorageMiddleware
+	rc   io.ReadCloser
+	path string
+
+	// index is the index of the next segment read.
+	index int64
+	// skip is the number of bytes of content to discard before the first
+	// byte returned.
+	skip int64
+
+	sealed  []byte
+	content []byte
+	err     error
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for len(r.content) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
+
+// next decrypts the next segment read into content, returning io.EOF after
+// the last.
+func (r *segmentReader) next() error {
+	if r.sealed == nil {
+		r.sealed = make([]byte, sealedSegmentSize)
+	}
+
+	n, err := io.ReadFull(r.rc, r.sealed)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+	case io.EOF:
+		return io.EOF
+	default:
+		return err
+	}
+
+	content, err := r.d.open(r.sealed[:n], r.index)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %v", r.path, err)
+	}
+	r.index++
+
+	if r.skip > 0 {
+		if r.skip > int64(len(content)) {
+			return fmt.Errorf("decrypting %s: truncated segment", r.path)
+		}
+		content = content[r.skip:]
+		r.skip = 0
+	}
+	r.content = content
+	return nil
+}
+
+func (r *segmentReader) Close() error {
+	return r.rc.Close()
+}
+
+// Writer returns a writer encrypting the content written to it. Appending to
+// a file whose last segment is not full rewrites it, but only once content
+// is written, so that a writer resumed only to commit the file does not.
+func (d *encryptionStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &encryptedWriter{ctx: ctx, d: d, path: path, fw: fw}
+	if !append || fw.Size() == 0 {
+		if _, err := fw.Write(header); err != nil {
+			fw.Cancel()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	w.size = contentSize(fw.Size())
+	if storedSize(w.size) != fw.Size() {
+		fw.Close()
+		return nil, fmt.Errorf("decrypting %s: %v", path, errNotEncrypted)
+	}
+	w.index = w.size / segmentSize
+	w.rewrite = w.size%segmentSize != 0
+	return w, nil
+}
+
+// encryptedWriter seals content in segments as it is written, holding back
+// the content of a segment until it is full, or the writer is closed or
+// committed.
+type encryptedWriter struct {
+	ctx  context.Context
+	d    *encryptionStorageMiddleware
+	path string
+	fw   storagedriver.FileWriter
+
+	// size is the length of the content written, including that appended
+	// to.
+	size int64
+	// index is the index of the next segment sealed.
+	index int64
+	// buf holds the content of the next segment.
+	buf []byte
+	// rewrite is set if the last segment of the file appended to is not
+	// full, so must be rewritten before content is appended.
+	rewrite bool
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	if w.rewrite && len(p) > 0 {
+		if err := w.rewriteFile(); err != nil {
+			return 0, err
+		}
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := segmentSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+
+		if len(w.buf) == segmentSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+		written += n
+		w.size += int64(n)
+	}
+	return written, nil
+}
+
+// flush seals the content buffered as the next segment.
+func (w *encryptedWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	sealed, err := w.d.seal(nil, w.buf, w.index)
+	if err != nil {
+		return err
+	}
+	if _, err := w.fw.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// rewriteFile replaces the file appended to with one holding its content
+// but for its last segment, which is read into the buffer, then resumes
+// appending to it. The segment cannot be resealed in place, since stored
+// content cannot be truncated.
+func (w *encryptedWriter) rewriteFile() error {
+	if err := w.fw.Close(); err != nil {
+		return err
+	}
+
+	rc, err := w.d.Reader(w.ctx, w.path, 0)
+	if err != nil {
+		return fmt.Errorf("rewriting %s to append to it: %v", w.path, err)
+	}
+	defer rc.Close()
+
+	tmpPath := w.path + ".rewrite"
+	tmp, err := w.d.Writer(w.ctx, tmpPath, false)
+	if err != nil {
+		return err
+	}
+	full := w.index * segmentSize
+	if _, err := io.CopyN(tmp, rc, full); err != nil {
+		tmp.Cancel()
+		return err
+	}
+	if err := tmp.Commit(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	tail := make([]byte, w.size-full, segmentSize)
+	if _, err := io.ReadFull(rc, tail); err != nil {
+		return err
+	}
+	if err := w.d.StorageDriver.Move(w.ctx, tmpPath, w.path); err != nil {
+		return err
+	}
+
+	fw, err := w.d.StorageDriver.Writer(w.ctx, w.path, true)
+	if err != nil {
+		return err
+	}
+	w.fw = fw
+	w.buf = tail
+	w.rewrite = false
+	return nil
+}
+
+func (w *encryptedWriter) Size() int64 {
+	return w.size
+}
+
+func (w *encryptedWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		if err := w.flush(); err != nil {
+			w.fw.Close()
+			return err
+		}
+	}
+	return w.fw.Close()
+}
+
+func (w *encryptedWriter) Cancel() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	return w.fw.Cancel()
+}
+
+func (w *encryptedWriter) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	w.committed = true
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.fw.Commit()
+}
+
+// plainFileInfo reports the length of the content of a file, rather than
+// the length stored.
+func plainFileInfo(fi storagedriver.FileInfo) storagedriver.FileInfo {
+	if fi.IsDir() {
+		return fi
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    fi.Path(),
+		Size:    contentSize(fi.Size()),
+		ModTime: fi.ModTime(),
+	}}
+}
+
+func (d *encryptionStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return plainFileInfo(fi), nil
+}
+
+func (d *encryptionStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	return d.StorageDriver.Walk(ctx, path, func(fi storagedriver.FileInfo) error {
+		return f(plainFileInfo(fi))
+	})
+}
+
+// URLFor is not supported, since content served from the backend would be
+// encrypted, so the registry serves it itself.
+func (d *encryptionStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", storagedriver.ErrUnsupportedMethod{DriverName: d.Name()}
+}
+
+// CompareAndSwapContent compares old with the decrypted content at path,
+// then swaps the content stored, so that the swap fails if the file changed
+// since it was read.
+func (d *encryptionStorageMiddleware) CompareAndSwapContent(ctx context.Context, path string, old, content []byte) (bool, error) {
+	cas, ok := d.StorageDriver.(storagedriver.CompareAndSwapper)
+	if !ok {
+		return false, storagedriver.ErrUnsupportedMethod{DriverName: d.Name()}
+	}
+
+	var oldStored []byte
+	if old != nil {
+		stored, err := d.StorageDriver.GetContent(ctx, path)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				return false, nil
+			}
+			return false, err
+		}
+		current, err := d.decrypt(stored)
+		if err != nil {
+			return false, fmt.Errorf("decrypting %s: %v", path, err)
+		}
+		if !bytes.Equal(current, old) {
+			return false, nil
+		}
+		oldStored = stored
+	}
+
+	stored, err := d.encrypt(content)
+	if err != nil {
+		return false, err
+	}
+	return cas.CompareAndSwapContent(ctx, path, oldStored, stored)
+}
+
+func init() {
+	storagemiddleware.Register("encryption", storagemiddleware.InitFunc(newEncryptionStorageMiddleware))
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type MiddlewareSuite struct{}
+
+var _ = check.Suite(&MiddlewareSuite{})
+
+func newTestDriver(c *check.C) (*encryptionStorageMiddleware, storagedriver.StorageDriver) {
+	backend := inmemory.New()
+	options := map[string]interface{}{
+		"key": base64.StdEncoding.EncodeToString(randomBytes(c, 32)),
+	}
+	d, err := newEncryptionStorageMiddleware(backend, options)
+	c.Assert(err, check.IsNil)
+	return d.(*encryptionStorageMiddleware), backend
+}
+
+func randomBytes(c *check.C, n int) []byte {
+	p := make([]byte, n)
+	_, err := rand.Read(p)
+	c.Assert(err, check.IsNil)
+	return p
+}
+
+func (s *MiddlewareSuite) TestNoConfig(c *check.C) {
+	options := make(map[string]interface{})
+	_, err := newEncryptionStorageMiddleware(nil, options)
+	c.Assert(err, check.ErrorMatches, "no encryption key provided")
+}
+
+func (s *MiddlewareSuite) TestInvalidKey(c *check.C) {
+	options := map[string]interface{}{"key": "not base64!"}
+	_, err := newEncryptionStorageMiddleware(nil, options)
+	c.Assert(err, check.ErrorMatches, "encryption key must be base64 encoded: .*")
+
+	options["key"] = base64.StdEncoding.EncodeToString([]byte("short"))
+	_, err = newEncryptionStorageMiddleware(nil, options)
+	c.Assert(err, check.ErrorMatches, "invalid encryption key: .*")
+}
+
+func (s *MiddlewareSuite) TestWriteRead(c *check.C) {
+	ctx := context.Background()
+	driver, backend := newTestDriver(c)
+
+	// The content spans several segments, the last not full.
+	content := randomBytes(c, 2*segmentSize+1000)
+
+	fw, err := driver.Writer(ctx, "/blob", false)
+	c.Assert(err, check.IsNil)
+	_, err = fw.Write(content)
+	c.Assert(err, check.IsNil)
+	c.Assert(fw.Size(), check.Equals, int64(len(content)))
+	c.Assert(fw.Commit(), check.IsNil)
+	c.Assert(fw.Close(), check.IsNil)
+
+	// The content stored is encrypted.
+	stored, err := backend.GetContent(ctx, "/blob")
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(stored, content), check.Equals, false)
+	c.Assert(bytes.Contains(stored, content[:64]), check.Equals, false)
+	c.Assert(int64(len(stored)), check.Equals, storedSize(int64(len(content))))
+
+	read, err := driver.GetContent(ctx, "/blob")
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(read, content), check.Equals, true)
+
+	fi, err := driver.Stat(ctx, "/blob")
+	c.Assert(err, check.IsNil)
+	c.Assert(fi.Size(), check.Equals, int64(len(content)))
+
+	for _, offset := range []int64{0, 100, segmentSize, segmentSize + 5, int64(len(content)) - 1, int64(len(content))} {
+		rc, err := driver.Reader(ctx, "/blob", offset)
+		c.Assert(err, check.IsNil)
+		read, err := ioutil.ReadAll(rc)
+		rc.Close()
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Equal(read, content[offset:]), check.Equals, true, check.Commentf("offset %d", offset))
+	}
+
+	_, err = driver.Reader(ctx, "/blob", int64(len(content))+1)
+	c.Assert(err, check.FitsTypeOf, storagedriver.InvalidOffsetError{})
+}
+
+func (s *MiddlewareSuite) TestPutContent(c *check.C) {
+	ctx := context.Background()
+	driver, backend := newTestDriver(c)
+
+	for _, content := range [][]byte{[]byte("content"), {}} {
+		c.Assert(driver.PutContent(ctx, "/file", content), check.IsNil)
+
+		stored, err := backend.GetContent(ctx, "/file")
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Contains(stored, content) && len(content) > 0, check.Equals, false)
+
+		read, err := driver.GetContent(ctx, "/file")
+		c.Assert(err, check.IsNil)
+		c.Assert(string(read), check.Equals, string(content))
+
+		fi, err := driver.Stat(ctx, "/file")
+		c.Assert(err, check.IsNil)
+		c.Assert(fi.Size(), check.Equals, int64(len(content)))
+	}
+}
+
+func (s *MiddlewareSuite) TestAppend(c *check.C) {
+	ctx := context.Background()
+
+	// Appending at the end of a segment appends in place, while appending
+	// within one rewrites the file.
+	for _, split := range []int{segmentSize, segmentSize + 1000} {
+		driver, _ := newTestDriver(c)
+		content := randomBytes(c, 3*segmentSize)
+
+		fw, err := driver.Writer(ctx, "/upload", false)
+		c.Assert(err, check.IsNil)
+		_, err = fw.Write(content[:split])
+		c.Assert(err, check.IsNil)
+		c.Assert(fw.Close(), check.IsNil)
+
+		fw, err = driver.Writer(ctx, "/upload", true)
+		c.Assert(err, check.IsNil)
+		c.Assert(fw.Size(), check.Equals, int64(split))
+		_, err = fw.Write(content[split:])
+		c.Assert(err, check.IsNil)
+		c.Assert(fw.Close(), check.IsNil)
+
+		// A writer resumed only to commit leaves the content as it is.
+		fw, err = driver.Writer(ctx, "/upload", true)
+		c.Assert(err, check.IsNil)
+		c.Assert(fw.Size(), check.Equals, int64(len(content)))
+		c.Assert(fw.Commit(), check.IsNil)
+		c.Assert(fw.Close(), check.IsNil)
+
+		read, err := driver.GetContent(ctx, "/upload")
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Equal(read, content), check.Equals, true, check.Commentf("split at %d", split))
+
+		_, err = driver.Stat(ctx, "/upload.rewrite")
+		c.Assert(err, check.FitsTypeOf, storagedriver.PathNotFoundError{})
+	}
+}
+
+func (s *MiddlewareSuite) TestTampered(c *check.C) {
+	ctx := context.Background()
+	driver, backend := newTestDriver(c)
+
+	c.Assert(driver.PutContent(ctx, "/file", []byte("content")), check.IsNil)
+	stored, err := backend.GetContent(ctx, "/file")
+	c.Assert(err, check.IsNil)
+	stored[len(stored)-1] ^= 1
+	c.Assert(backend.PutContent(ctx, "/file", stored), check.IsNil)
+
+	_, err = driver.GetContent(ctx, "/file")
+	c.Assert(err, check.ErrorMatches, "decrypting /file: .*")
+
+	c.Assert(backend.PutContent(ctx, "/plain", []byte("content")), check.IsNil)
+	_, err = driver.GetContent(ctx, "/plain")
+	c.Assert(err, check.ErrorMatches, "decrypting /plain: content is not encrypted")
+}
+
+func (s *MiddlewareSuite) TestURLFor(c *check.C) {
+	driver, _ := newTestDriver(c)
+	_, err := driver.URLFor(context.Background(), "/file", nil)
+	c.Assert(err, check.FitsTypeOf, storagedriver.ErrUnsupportedMethod{})
+}
@@ -0,0 +1,163 @@
+// Package middleware - replica wrapper for storage drivers, serving reads
+// from a read-only replica of the storage backend.
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+)
+
+// replicaStorageMiddleware directs reads to a read-only replica of the
+// storage backend, and writes to the wrapped primary driver. As the replica
+// lags behind the primary, reads of paths which the replica does not have
+// yet fall back to the primary.
+//
+// Content which is rewritten in place, such as tag links, may be read stale
+// from the replica until it catches up. Uploads in progress are always read
+// from the primary, since their content changes as they are written.
+type replicaStorageMiddleware struct {
+	storagedriver.StorageDriver
+	replica storagedriver.StorageDriver
+}
+
+var _ storagedriver.StorageDriver = &replicaStorageMiddleware{}
+
+// newReplicaStorageMiddleware wraps sd, reading from the replica driver named
+// by the "driver" option, created with the "parameters" option.
+func newReplicaStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, ok := options["driver"]
+	if !ok {
+		return nil, fmt.Errorf("no replica driver provided")
+	}
+	name, ok := o.(string)
+	if !ok {
+		return nil, fmt.Errorf("replica driver must be a string")
+	}
+
+	parameters := make(map[string]interface{})
+	switch p := options["parameters"].(type) {
+	case nil:
+	case map[string]interface{}:
+		parameters = p
+	case map[interface{}]interface{}:
+		for k, v := range p {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("replica parameter names must be strings")
+			}
+			parameters[key] = v
+		}
+	default:
+		return nil, fmt.Errorf("replica parameters must be a map")
+	}
+
+	replica, err := factory.Create(name, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create replica driver: %v", err)
+	}
+
+	return &replicaStorageMiddleware{StorageDriver: sd, replica: replica}, nil
+}
+
+// readFromReplica reports whether reads of path should be tried against the
+// replica first.
+func readFromReplica(path string) bool {
+	return !strings.Contains(path, "/_uploads/")
+}
+
+// isPathNotFound reports whether err reports a path missing from the
+// replica, in which case the read is retried against the primary.
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
+
+func (r *replicaStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if readFromReplica(path) {
+		content, err := r.replica.GetContent(ctx, path)
+		if !isPathNotFound(err) {
+			return content, err
+		}
+	}
+	return r.StorageDriver.GetContent(ctx, path)
+}
+
+func (r *replicaStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if readFromReplica(path) {
+		rc, err := r.replica.Reader(ctx, path, offset)
+		if !isPathNotFound(err) {
+			return rc, err
+		}
+	}
+	return r.StorageDriver.Reader(ctx, path, offset)
+}
+
+func (r *replicaStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if readFromReplica(path) {
+		fi, err := r.replica.Stat(ctx, path)
+		if !isPathNotFound(err) {
+			return fi, err
+		}
+	}
+	return r.StorageDriver.Stat(ctx, path)
+}
+
+func (r *replicaStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	if readFromReplica(path) {
+		children, err := r.replica.List(ctx, path)
+		if !isPathNotFound(err) {
+			return children, err
+		}
+	}
+	return r.StorageDriver.List(ctx, path)
+}
+
+func (r *replicaStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if readFromReplica(path) {
+		// The replica is only asked for a URL if it has the content, since
+		// a URL is not checked against the content it refers to.
+		if _, err := r.replica.Stat(ctx, path); err == nil {
+			return r.replica.URLFor(ctx, path, options)
+		} else if !isPathNotFound(err) {
+			return "", err
+		}
+	}
+	return r.StorageDriver.URLFor(ctx, path, options)
+}
+
+// URLsHonorRange reports whether the URLs returned by URLFor honor Range
+// headers, which they do only if those of both the primary driver and the
+// replica do, since either may provide them.
+func (r *replicaStorageMiddleware) URLsHonorRange() bool {
+	return storagedriver.URLsHonorRange(r.StorageDriver) && storagedriver.URLsHonorRange(r.replica)
+}
+
+func (r *replicaStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	if readFromReplica(path) {
+		err := r.replica.Walk(ctx, path, f)
+		if !isPathNotFound(err) {
+			return err
+		}
+	}
+	return r.StorageDriver.Walk(ctx, path, f)
+}
+
+// CompareAndSwapContent swaps content on the primary, since the comparison
+// must be made against its content rather than that of the lagging replica.
+func (r *replicaStorageMiddleware) CompareAndSwapContent(ctx context.Context, path string, old, content []byte) (bool, error) {
+	cas, ok := r.StorageDriver.(storagedriver.CompareAndSwapper)
+	if !ok {
+		return false, storagedriver.ErrUnsupportedMethod{DriverName: r.Name()}
+	}
+	return cas.CompareAndSwapContent(ctx, path, old, content)
+}
+
+func init() {
+	storagemiddleware.Register("replica", storagemiddleware.InitFunc(newReplicaStorageMiddleware))
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type MiddlewareSuite struct{}
+
+var _ = check.Suite(&MiddlewareSuite{})
+
+func (s *MiddlewareSuite) TestNoConfig(c *check.C) {
+	options := make(map[string]interface{})
+	_, err := newReplicaStorageMiddleware(nil, options)
+	c.Assert(err, check.ErrorMatches, "no replica driver provided")
+}
+
+func (s *MiddlewareSuite) TestUnknownDriver(c *check.C) {
+	options := make(map[string]interface{})
+	options["driver"] = "nonexistent"
+	_, err := newReplicaStorageMiddleware(nil, options)
+	c.Assert(err, check.ErrorMatches, "unable to create replica driver: .*")
+}
+
+func (s *MiddlewareSuite) TestReads(c *check.C) {
+	ctx := context.Background()
+	primary := inmemory.New()
+	replica := inmemory.New()
+	driver := &replicaStorageMiddleware{StorageDriver: primary, replica: replica}
+
+	// Content present on both reads from the replica, which is detected by
+	// storing different content on each.
+	c.Assert(primary.PutContent(ctx, "/replicated", []byte("primary")), check.IsNil)
+	c.Assert(replica.PutContent(ctx, "/replicated", []byte("replica")), check.IsNil)
+
+	content, err := driver.GetContent(ctx, "/replicated")
+	c.Assert(err, check.IsNil)
+	c.Assert(string(content), check.Equals, "replica")
+
+	fi, err := driver.Stat(ctx, "/replicated")
+	c.Assert(err, check.IsNil)
+	c.Assert(fi.Size(), check.Equals, int64(len("replica")))
+
+	// Content not replicated yet falls back to the primary.
+	c.Assert(driver.PutContent(ctx, "/lagging", []byte("primary")), check.IsNil)
+	_, err = replica.Stat(ctx, "/lagging")
+	c.Assert(err, check.FitsTypeOf, storagedriver.PathNotFoundError{})
+
+	content, err = driver.GetContent(ctx, "/lagging")
+	c.Assert(err, check.IsNil)
+	c.Assert(string(content), check.Equals, "primary")
+
+	fi, err = driver.Stat(ctx, "/lagging")
+	c.Assert(err, check.IsNil)
+	c.Assert(fi.Size(), check.Equals, int64(len("primary")))
+
+	// Content on neither is reported missing.
+	_, err = driver.GetContent(ctx, "/missing")
+	c.Assert(err, check.FitsTypeOf, storagedriver.PathNotFoundError{})
+}
+
+func (s *MiddlewareSuite) TestUploadsReadFromPrimary(c *check.C) {
+	ctx := context.Background()
+	primary := inmemory.New()
+	replica := inmemory.New()
+	driver := &replicaStorageMiddleware{StorageDriver: primary, replica: replica}
+
+	path := "/repositories/foo/_uploads/id/data"
+	c.Assert(primary.PutContent(ctx, path, []byte("primary")), check.IsNil)
+	c.Assert(replica.PutContent(ctx, path, []byte("stale")), check.IsNil)
+
+	content, err := driver.GetContent(ctx, path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(content), check.Equals, "primary")
+}
@@ -0,0 +1,202 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OperationLimitExceededError is returned when a storage operation would
+// exceed the limit on operations set on its context.
+type OperationLimitExceededError struct {
+	Limit      int64
+	DriverName string
+}
+
+func (err OperationLimitExceededError) Error() string {
+	return fmt.Sprintf("%s: limit of %d storage operations exceeded", err.DriverName, err.Limit)
+}
+
+// operationCounter counts the storage operations performed with a context.
+type operationCounter struct {
+	limit int64
+	count int64
+}
+
+type operationCounterKey struct{}
+
+// WithOperationLimit returns a context permitting at most limit storage
+// operations to be performed with it, and any context derived from it. Drivers
+// embedding base.Base enforce the limit by failing operations beyond it with
+// an OperationLimitExceededError. This caps the backend load a single request
+// can generate.
+func WithOperationLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, operationCounterKey{}, &operationCounter{limit: limit})
+}
+
+// CountOperation records a storage operation performed with ctx, returning an
+// OperationLimitExceededError if it exceeds the limit set on the context. It
+// does nothing if no limit has been set.
+func CountOperation(ctx context.Context) error {
+	counter, ok := ctx.Value(operationCounterKey{}).(*operationCounter)
+	if !ok {
+		return nil
+	}
+
+	if atomic.AddInt64(&counter.count, 1) > counter.limit {
+		return OperationLimitExceededError{Limit: counter.limit}
+	}
+	return nil
+}
+
+// OperationLimitExceeded reports whether a storage operation has been refused
+// because it exceeded the limit set on ctx.
+func OperationLimitExceeded(ctx context.Context) bool {
+	counter, ok := ctx.Value(operationCounterKey{}).(*operationCounter)
+	return ok && atomic.LoadInt64(&counter.count) > counter.limit
+}
+
+// OperationBudgetExceededError is returned when a storage operation is
+// refused, or cut short, because the time budgeted for the storage operations
+// of its context has run out.
+type OperationBudgetExceededError struct {
+	Budget     time.Duration
+	DriverName string
+}
+
+func (err OperationBudgetExceededError) Error() string {
+	return fmt.Sprintf("%s: storage operation budget of %v exceeded", err.DriverName, err.Budget)
+}
+
+// operationBudget divides the time a context may spend on storage operations
+// between the operations anticipated.
+type operationBudget struct {
+	budget      time.Duration
+	deadline    time.Time
+	anticipated int
+
+	mu       sync.Mutex
+	started  int
+	exceeded bool
+}
+
+type operationBudgetKey struct{}
+
+// budgetedOperationKey marks the context of an operation already budgeted, so
+// that drivers wrapping one another do not budget it again.
+type budgetedOperationKey struct{}
+
+// WithOperationBudget returns a context allowing the storage operations
+// performed with it, and any context derived from it, to take budget in
+// total. Drivers embedding base.Base give each operation a deadline sharing
+// the time remaining equally between the operations still anticipated, of
+// anticipated in all, so that a slow operation cannot take the time of those
+// following it. Operations beyond those anticipated share the time remaining
+// with none. Operations which run out of time fail with an
+// OperationBudgetExceededError.
+//
+// The context itself has no deadline: content read or written through a
+// reader or writer is not cut short, though opening one counts as an
+// operation and fails once the budget has run out.
+func WithOperationBudget(ctx context.Context, budget time.Duration, anticipated int) context.Context {
+	return context.WithValue(ctx, operationBudgetKey{}, &operationBudget{
+		budget:      budget,
+		deadline:    time.Now().Add(budget),
+		anticipated: anticipated,
+	})
+}
+
+// start records an operation started, returning its deadline, or false if
+// the budget has run out.
+func (b *operationBudget) start() (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	remaining := b.deadline.Sub(now)
+	if remaining <= 0 {
+		b.exceeded = true
+		return time.Time{}, false
+	}
+
+	b.started++
+	if shares := b.anticipated - b.started + 1; shares > 1 {
+		remaining /= time.Duration(shares)
+	}
+	return now.Add(remaining), true
+}
+
+func (b *operationBudget) exceed() {
+	b.mu.Lock()
+	b.exceeded = true
+	b.mu.Unlock()
+}
+
+// BudgetOperation returns the context for a storage operation performed with
+// ctx, whose deadline is the share of the budget set on ctx given to the
+// operation, and the function releasing it. It returns an
+// OperationBudgetExceededError if the budget has run out, and ctx itself if
+// no budget has been set.
+func BudgetOperation(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	budget, ok := ctx.Value(operationBudgetKey{}).(*operationBudget)
+	if !ok || ctx.Value(budgetedOperationKey{}) != nil {
+		return ctx, func() {}, nil
+	}
+
+	deadline, ok := budget.start()
+	if !ok {
+		return ctx, func() {}, OperationBudgetExceededError{Budget: budget.budget}
+	}
+
+	ctx, cancel := context.WithDeadline(context.WithValue(ctx, budgetedOperationKey{}, true), deadline)
+	return ctx, cancel, nil
+}
+
+// BudgetStream records the opening of a reader or writer with ctx as a
+// storage operation, returning the context to open it with, which has no
+// deadline. It returns an OperationBudgetExceededError if the budget set on
+// ctx has run out.
+func BudgetStream(ctx context.Context) (context.Context, error) {
+	budget, ok := ctx.Value(operationBudgetKey{}).(*operationBudget)
+	if !ok || ctx.Value(budgetedOperationKey{}) != nil {
+		return ctx, nil
+	}
+
+	if _, ok := budget.start(); !ok {
+		return ctx, OperationBudgetExceededError{Budget: budget.budget}
+	}
+	return context.WithValue(ctx, budgetedOperationKey{}, true), nil
+}
+
+// OperationBudgetError returns the error of an operation performed with ctx,
+// as returned by BudgetOperation, replaced by an
+// OperationBudgetExceededError if the operation failed once its deadline had
+// passed.
+func OperationBudgetError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	budget, ok := ctx.Value(operationBudgetKey{}).(*operationBudget)
+	if !ok {
+		return err
+	}
+
+	budget.exceed()
+	return OperationBudgetExceededError{Budget: budget.budget}
+}
+
+// OperationBudgetExceeded reports whether a storage operation has been
+// refused, or cut short, because the budget set on ctx ran out.
+func OperationBudgetExceeded(ctx context.Context) bool {
+	budget, ok := ctx.Value(operationBudgetKey{}).(*operationBudget)
+	if !ok {
+		return false
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return budget.exceeded
+}
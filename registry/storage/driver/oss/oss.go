@@ -488,6 +488,12 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	return signedURL, nil
 }
 
+// URLsHonorRange reports that the URLs returned by URLFor honor Range
+// headers. Signed OSS URLs serve partial content to ranged requests.
+func (d *driver) URLsHonorRange() bool {
+	return true
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
@@ -111,6 +111,7 @@ type DriverParameters struct {
 	SessionToken                string
 	LogS3APIRequests            bool
 	LogS3APIResponseHeaders     map[string]string
+	ConnectionPool              base.ConnectionPool
 }
 
 func init() {
@@ -377,6 +378,11 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		objectACL = objectACLString
 	}
 
+	connectionPool, err := base.GetConnectionPoolFromParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
 	sessionToken := ""
 
 	params := DriverParameters{
@@ -402,6 +408,7 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		fmt.Sprint(sessionToken),
 		logS3APIRequestsBool,
 		logS3APIResponseHeadersMap,
+		connectionPool,
 	}
 
 	return New(params)
@@ -436,6 +443,16 @@ func getParameterAsInt64(parameters map[string]interface{}, name string, default
 	return rv, nil
 }
 
+// newHTTPTransport returns the transport for requests to S3, sized to the
+// configured connection pool.
+func newHTTPTransport(params DriverParameters) *http.Transport {
+	httpTransport := params.ConnectionPool.NewTransport()
+	if params.SkipVerify {
+		httpTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return httpTransport
+}
+
 // New constructs a new Driver with the given AWS credentials, region, encryption flag, and
 // bucketName
 func New(params DriverParameters) (*Driver, error) {
@@ -474,23 +491,13 @@ func New(params DriverParameters) (*Driver, error) {
 		awsConfig.WithRegion(params.Region)
 		awsConfig.WithDisableSSL(!params.Secure)
 
-		if params.UserAgent != "" || params.SkipVerify {
-			httpTransport := http.DefaultTransport
-			if params.SkipVerify {
-				httpTransport = &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-				}
-			}
-			if params.UserAgent != "" {
-				awsConfig.WithHTTPClient(&http.Client{
-					Transport: transport.NewTransport(httpTransport, transport.NewHeaderRequestModifier(http.Header{http.CanonicalHeaderKey("User-Agent"): []string{params.UserAgent}})),
-				})
-			} else {
-				awsConfig.WithHTTPClient(&http.Client{
-					Transport: transport.NewTransport(httpTransport),
-				})
-			}
+		var modifiers []transport.RequestModifier
+		if params.UserAgent != "" {
+			modifiers = append(modifiers, transport.NewHeaderRequestModifier(http.Header{http.CanonicalHeaderKey("User-Agent"): []string{params.UserAgent}}))
 		}
+		awsConfig.WithHTTPClient(&http.Client{
+			Transport: transport.NewTransport(newHTTPTransport(params), modifiers...),
+		})
 
 		sess, err = session.NewSession(awsConfig)
 		if err != nil {
@@ -778,6 +785,22 @@ func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo,
 
 // List returns a list of the objects that are direct descendants of the given path.
 func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
+	list := []string{}
+	err := d.ListStream(ctx, opath, func(child string) error {
+		list = append(list, child)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ListStream calls f with each of the objects and directories that are direct
+// descendants of the given path, one page of results at a time. Children are
+// passed in lexical order of their keys, in which a directory's key ends with
+// a "/".
+func (d *driver) ListStream(ctx context.Context, opath string, f func(child string) error) error {
 	s := d.s3Client(ctx)
 
 	path := opath
@@ -800,20 +823,16 @@ func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
 		MaxKeys:   aws.Int64(listMax),
 	})
 	if err != nil {
-		return nil, parseError(opath, err)
+		return parseError(opath, err)
 	}
 
-	files := []string{}
-	directories := []string{}
-
+	var count int
 	for {
-		for _, key := range resp.Contents {
-			files = append(files, strings.Replace(*key.Key, d.s3Path(""), prefix, 1))
-		}
-
-		for _, commonPrefix := range resp.CommonPrefixes {
-			commonPrefix := *commonPrefix.Prefix
-			directories = append(directories, strings.Replace(commonPrefix[0:len(commonPrefix)-1], d.s3Path(""), prefix, 1))
+		for _, key := range listedKeys(resp) {
+			count++
+			if err := f(strings.Replace(key, d.s3Path(""), prefix, 1)); err != nil {
+				return err
+			}
 		}
 
 		if *resp.IsTruncated {
@@ -825,22 +844,41 @@ func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
 				Marker:    resp.NextMarker,
 			})
 			if err != nil {
-				return nil, err
+				return err
 			}
 		} else {
 			break
 		}
 	}
 
-	if opath != "/" {
-		if len(files) == 0 && len(directories) == 0 {
-			// Treat empty response as missing directory, since we don't actually
-			// have directories in s3.
-			return nil, storagedriver.PathNotFoundError{Path: opath}
-		}
+	if opath != "/" && count == 0 {
+		// Treat empty response as missing directory, since we don't actually
+		// have directories in s3.
+		return storagedriver.PathNotFoundError{Path: opath}
 	}
 
-	return append(files, directories...), nil
+	return nil
+}
+
+// listedKeys returns the keys of the objects and directories in a page of
+// listing results in lexical order, without the trailing "/" of directories.
+// S3 returns the objects and the directories, as common prefixes, each in
+// lexical order, so the two are merged.
+func listedKeys(resp *s3.ListObjectsOutput) []string {
+	keys := make([]string, 0, len(resp.Contents)+len(resp.CommonPrefixes))
+	contents, prefixes := resp.Contents, resp.CommonPrefixes
+	for len(contents) > 0 || len(prefixes) > 0 {
+		if len(prefixes) > 0 && (len(contents) == 0 || *prefixes[0].Prefix < *contents[0].Key) {
+			commonPrefix := *prefixes[0].Prefix
+			keys = append(keys, commonPrefix[0:len(commonPrefix)-1])
+			prefixes = prefixes[1:]
+			continue
+		}
+
+		keys = append(keys, *contents[0].Key)
+		contents = contents[1:]
+	}
+	return keys
 }
 
 // Move moves an object stored at sourcePath to destPath, removing the original
@@ -1071,6 +1109,12 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	return req.Presign(expiresIn)
 }
 
+// URLsHonorRange reports that the URLs returned by URLFor honor Range
+// headers. Presigned S3 URLs serve partial content to ranged requests.
+func (d *driver) URLsHonorRange() bool {
+	return true
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, from string, f storagedriver.WalkFn) error {
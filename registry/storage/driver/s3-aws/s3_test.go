@@ -18,6 +18,7 @@ import (
 
 	"github.com/docker/distribution/context"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/testsuites"
 )
 
@@ -102,6 +103,7 @@ func init() {
 			sessionToken,
 			false,
 			map[string]string{},
+			base.ConnectionPool{MaxIdle: base.DefaultMaxIdleConnections},
 		}
 
 		return New(parameters)
@@ -120,6 +122,23 @@ func init() {
 	}, skipS3)
 }
 
+func TestHTTPTransportConnectionPool(t *testing.T) {
+	httpTransport := newHTTPTransport(DriverParameters{
+		SkipVerify:     true,
+		ConnectionPool: base.ConnectionPool{MaxIdle: 7, MaxActive: 3},
+	})
+
+	if httpTransport.MaxIdleConnsPerHost != 7 {
+		t.Errorf("unexpected idle connection limit: %d", httpTransport.MaxIdleConnsPerHost)
+	}
+	if httpTransport.MaxConnsPerHost != 3 {
+		t.Errorf("unexpected active connection limit: %d", httpTransport.MaxConnsPerHost)
+	}
+	if httpTransport.TLSClientConfig == nil || !httpTransport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("expected TLS verification to be skipped")
+	}
+}
+
 func TestEmptyRootList(t *testing.T) {
 	if skipS3() != "" {
 		t.Skip(skipS3())
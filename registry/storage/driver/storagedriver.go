@@ -92,6 +92,33 @@ type StorageDriver interface {
 	Walk(ctx context.Context, path string, f WalkFn) error
 }
 
+// CompareAndSwapper is an optional interface for storage drivers which can
+// atomically replace the content stored at a path.
+type CompareAndSwapper interface {
+	// CompareAndSwapContent stores content at path only if the content
+	// currently stored there equals old, reporting whether it did. A nil old
+	// matches only a path which does not exist. Drivers wrapping another
+	// driver may return ErrUnsupportedMethod if it is not a CompareAndSwapper.
+	CompareAndSwapContent(ctx context.Context, path string, old, content []byte) (bool, error)
+}
+
+// RangeURLer is an optional interface for storage drivers whose URLs, as
+// returned by URLFor, serve part of their content to requests carrying a
+// Range header.
+type RangeURLer interface {
+	// URLsHonorRange reports whether requests to URLs returned by URLFor
+	// may carry a Range header to fetch part of the content. Drivers
+	// wrapping another driver report whether it does.
+	URLsHonorRange() bool
+}
+
+// URLsHonorRange reports whether the URLs returned by URLFor of the driver
+// honor Range headers, which is false unless it is a RangeURLer.
+func URLsHonorRange(driver StorageDriver) bool {
+	r, ok := driver.(RangeURLer)
+	return ok && r.URLsHonorRange()
+}
+
 // FileWriter provides an abstraction for an opened writable file-like object in
 // the storage backend. The FileWriter must flush all content written to it on
 // the call to Close, but is only required to make its content readable on a
@@ -115,7 +142,9 @@ type FileWriter interface {
 // file path is absolute, beginning with a slash and containing a positive
 // number of path components separated by slashes, where each component is
 // restricted to alphanumeric characters or a period, underscore, or
-// hyphen.
+// hyphen. Paths are separated by forward slashes whatever the operating
+// system, so a backslash is never valid; drivers backed by a filesystem
+// translate separators themselves.
 var PathRegexp = regexp.MustCompile(`^(/[A-Za-z0-9._-]+)+$`)
 
 // ErrUnsupportedMethod may be returned in the case where a StorageDriver implementation does not support an optional method.
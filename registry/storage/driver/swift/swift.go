@@ -657,6 +657,12 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	return tempURL, nil
 }
 
+// URLsHonorRange reports that the URLs returned by URLFor honor Range
+// headers. Swift temporary URLs serve partial content to ranged requests.
+func (d *driver) URLsHonorRange() bool {
+	return true
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
@@ -846,6 +846,48 @@ func (suite *DriverSuite) TestPutContentMultipleTimes(c *check.C) {
 	c.Assert(readContents, check.DeepEquals, contents)
 }
 
+// TestCompareAndSwapContent checks that content is only replaced when the
+// current content matches, on drivers which support compare-and-swap.
+func (suite *DriverSuite) TestCompareAndSwapContent(c *check.C) {
+	cas, ok := suite.StorageDriver.(storagedriver.CompareAndSwapper)
+	if !ok {
+		c.Skip("driver does not support compare-and-swap")
+	}
+
+	filename := randomPath(32)
+	contents := randomContents(64)
+	defer suite.deletePath(c, firstPart(filename))
+
+	swapped, err := cas.CompareAndSwapContent(suite.ctx, filename, nil, contents)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); ok {
+		c.Skip("driver does not support compare-and-swap")
+	}
+	c.Assert(err, check.IsNil)
+	c.Assert(swapped, check.Equals, true)
+
+	// A nil old content only matches a path which does not exist.
+	swapped, err = cas.CompareAndSwapContent(suite.ctx, filename, nil, randomContents(48))
+	c.Assert(err, check.IsNil)
+	c.Assert(swapped, check.Equals, false)
+
+	swapped, err = cas.CompareAndSwapContent(suite.ctx, filename, randomContents(48), randomContents(32))
+	c.Assert(err, check.IsNil)
+	c.Assert(swapped, check.Equals, false)
+
+	readContents, err := suite.StorageDriver.GetContent(suite.ctx, filename)
+	c.Assert(err, check.IsNil)
+	c.Assert(readContents, check.DeepEquals, contents)
+
+	newContents := randomContents(32)
+	swapped, err = cas.CompareAndSwapContent(suite.ctx, filename, contents, newContents)
+	c.Assert(err, check.IsNil)
+	c.Assert(swapped, check.Equals, true)
+
+	readContents, err = suite.StorageDriver.GetContent(suite.ctx, filename)
+	c.Assert(err, check.IsNil)
+	c.Assert(readContents, check.DeepEquals, newContents)
+}
+
 // TestConcurrentStreamReads checks that multiple clients can safely read from
 // the same file simultaneously with various offsets.
 func (suite *DriverSuite) TestConcurrentStreamReads(c *check.C) {
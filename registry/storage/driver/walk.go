@@ -3,7 +3,10 @@ package driver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -13,54 +16,426 @@ import (
 // as an error by any function.
 var ErrSkipDir = errors.New("skip this directory")
 
+// ErrWalkLoop is returned by a walk which reaches a directory it is already
+// listing, as when a directory's listing includes one of its ancestors.
+// Rather than recursing forever, the walk stops at the repeated directory. A
+// directory reached again through another branch is walked again.
+var ErrWalkLoop = errors.New("walk loop: directory lists its ancestor")
+
 // WalkFn is called once per file by Walk
 type WalkFn func(fileInfo FileInfo) error
 
+// ListStreamer is an optional interface for storage drivers which are able to
+// list the children of a path incrementally, such as those backed by a
+// paginated listing API. When a driver implements it, WalkFallback processes
+// entries as they are listed rather than holding the full listing in memory.
+type ListStreamer interface {
+	// ListStream calls f with each of the direct descendants of the given
+	// path, in the lexical order of their keys, in which the key of a
+	// directory ends with a "/", as paginated listings return them. If f
+	// returns an error, listing stops and that error is returned.
+	ListStream(ctx context.Context, path string, f func(child string) error) error
+}
+
+// errStopWalk is used internally to abort a ListStream once the walk has been
+// asked to stop without error.
+var errStopWalk = errors.New("stop walk")
+
+// WalkOptions configures the traversal performed by WalkFallbackWithOptions.
+type WalkOptions struct {
+	// ContinueOnError causes errors returned by the WalkFn, or encountered
+	// listing or statting a path, to be recorded rather than aborting the
+	// walk. Once the walk completes, the recorded errors are returned as
+	// WalkErrors. ErrSkipDir is not treated as an error.
+	ContinueOnError bool
+
+	// ExcludePrefixes lists paths whose subtrees are pruned from the walk.
+	// A child matching one of them, or lying beneath it, is skipped before
+	// it is statted, so neither it nor its descendants are passed to the
+	// WalkFn. Unlike ErrSkipDir, the excluded directory is never visited.
+	ExcludePrefixes []string
+
+	// Filter, if not nil, selects the files passed to the WalkFn: a file for
+	// which it returns false is not, although a directory for which it
+	// returns false is still descended into unless SkipFilteredDirs is set.
+	Filter func(fileInfo FileInfo) bool
+
+	// SkipFilteredDirs causes directories for which Filter returns false to
+	// be skipped, as if the WalkFn had returned ErrSkipDir for them.
+	SkipFilteredDirs bool
+}
+
+// filtered reports whether fileInfo is to be withheld from the WalkFn.
+func (opts WalkOptions) filtered(fileInfo FileInfo) bool {
+	return opts.Filter != nil && !opts.Filter(fileInfo)
+}
+
+// excluded reports whether path lies within one of the excluded prefixes.
+func (opts WalkOptions) excluded(path string) bool {
+	for _, prefix := range opts.ExcludePrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// WalkError records an error encountered during a walk at a path.
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (err WalkError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Path, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err WalkError) Unwrap() error {
+	return err.Err
+}
+
+// WalkErrors is returned by a walk with ContinueOnError set, aggregating the
+// errors encountered at each path.
+type WalkErrors []WalkError
+
+func (errs WalkErrors) Error() string {
+	switch len(errs) {
+	case 0:
+		return "<nil>"
+	case 1:
+		return errs[0].Error()
+	default:
+		msg := "errors:\n"
+		for _, err := range errs {
+			msg += err.Error() + "\n"
+		}
+		return msg
+	}
+}
+
+// Is reports whether any of the errors encountered at each path is target,
+// either directly or through a chain of wrapped errors.
+func (errs WalkErrors) Is(target error) bool {
+	for _, err := range errs {
+		if isError(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors encountered at each path, or of the errors
+// they wrap, which is assignable to the value pointed to by target. If one is
+// found, target is set to it and As returns true. As panics if target is not a
+// non-nil pointer.
+func (errs WalkErrors) As(target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if target == nil || val.Kind() != reflect.Ptr || val.IsNil() {
+		panic("driver: target must be a non-nil pointer")
+	}
+	for _, err := range errs {
+		if asError(err, val.Elem()) {
+			return true
+		}
+	}
+	return false
+}
+
+// isError reports whether err, or any error in the chain it wraps, is target.
+func isError(err, target error) bool {
+	canCompare := target == nil || reflect.TypeOf(target).Comparable()
+	for err != nil {
+		if canCompare && err == target {
+			return true
+		}
+		if x, ok := err.(interface{ Is(error) bool }); ok && x.Is(target) {
+			return true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = u.Unwrap()
+	}
+	return false
+}
+
+// asError sets dst to the first error in the chain wrapped by err which is
+// assignable to it, and reports whether one was found.
+func asError(err error, dst reflect.Value) bool {
+	for err != nil {
+		if reflect.TypeOf(err).AssignableTo(dst.Type()) {
+			dst.Set(reflect.ValueOf(err))
+			return true
+		}
+		if x, ok := err.(interface{ As(interface{}) bool }); ok && x.As(dst.Addr().Interface()) {
+			return true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = u.Unwrap()
+	}
+	return false
+}
+
 // WalkFallback traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file. It uses the List method and Stat to drive itself.
 // If the returned error from the WalkFn is ErrSkipDir and fileInfo refers
 // to a directory, the directory will not be entered and Walk
 // will continue the traversal.  If fileInfo refers to a normal file, processing stops
+//
+// Children are visited in lexical order. If driver implements ListStreamer,
+// they are visited as they are streamed, holding back only those which must
+// follow a directory listed after them.
 func WalkFallback(ctx context.Context, driver StorageDriver, from string, f WalkFn) error {
-	err, _ := doWalkFallback(ctx, driver, from, f)
+	return WalkFallbackWithOptions(ctx, driver, from, f, WalkOptions{})
+}
+
+// WalkFallbackWithOptions traverses a filesystem in the same manner as
+// WalkFallback, with its behavior modified by opts.
+func WalkFallbackWithOptions(ctx context.Context, driver StorageDriver, from string, f WalkFn, opts WalkOptions) error {
+	w := &walker{
+		driver:    driver,
+		f:         f,
+		opts:      opts,
+		ancestors: make(map[string]bool),
+	}
+
+	err, _ := w.walk(ctx, from)
+	if err == nil && len(w.errs) > 0 {
+		return w.errs
+	}
 	return err
 }
 
-func doWalkFallback(ctx context.Context, driver StorageDriver, from string, f WalkFn) (error, bool) {
-	children, err := driver.List(ctx, from)
+// walker holds the state of a single traversal.
+type walker struct {
+	driver StorageDriver
+	f      WalkFn
+	opts   WalkOptions
+	errs   WalkErrors
+
+	// ancestors records the directories being listed, from the root of the
+	// walk to the directory currently listed. A listing which includes one
+	// of them would make the walk loop. Only the current branch is held, so
+	// the set is bounded by the depth of the tree rather than its size.
+	ancestors map[string]bool
+}
+
+// fail handles an error encountered at path, either recording it and
+// allowing the walk to continue or aborting the walk with it.
+func (w *walker) fail(path string, err error) (error, bool) {
+	if w.opts.ContinueOnError {
+		w.errs = append(w.errs, WalkError{Path: path, Err: err})
+		return nil, true
+	}
+	return err, false
+}
+
+func (w *walker) walk(ctx context.Context, from string) (error, bool) {
+	w.ancestors[from] = true
+	defer delete(w.ancestors, from)
+
+	if ls, ok := w.driver.(ListStreamer); ok {
+		return w.walkStream(ctx, ls, from)
+	}
+
+	children, err := w.driver.List(ctx, from)
 	if err != nil {
-		return err, false
+		return w.fail(from, err)
 	}
 	sort.Stable(sort.StringSlice(children))
 	for _, child := range children {
-		// TODO(stevvooe): Calling driver.Stat for every entry is quite
-		// expensive when running against backends with a slow Stat
-		// implementation, such as s3. This is very likely a serious
-		// performance bottleneck.
-		fileInfo, err := driver.Stat(ctx, child)
-		if err != nil {
-			switch err.(type) {
-			case PathNotFoundError:
-				// repository was removed in between listing and enumeration. Ignore it.
-				logrus.WithField("path", child).Infof("ignoring deleted path")
-				continue
-			default:
-				return err, false
-			}
+		if err, ok := w.walkChild(ctx, child); err != nil || !ok {
+			return err, ok
 		}
-		err = f(fileInfo)
-		if err == nil && fileInfo.IsDir() {
-			if err, ok := doWalkFallback(ctx, driver, child, f); err != nil || !ok {
-				return err, ok
-			}
-		} else if err == ErrSkipDir {
-			// noop for folders, will just skip
-			if !fileInfo.IsDir() {
-				return nil, false // no error but stop iteration
+	}
+	return nil, true
+}
+
+func (w *walker) walkStream(ctx context.Context, ls ListStreamer, from string) (error, bool) {
+	var (
+		walkErr error
+		stop    bool
+	)
+	visit := func(children []string) error {
+		for _, child := range children {
+			err, ok := w.walkChild(ctx, child)
+			if err != nil || !ok {
+				walkErr, stop = err, true
+				return errStopWalk
 			}
-		} else if err != nil {
-			return err, false
 		}
+		return nil
+	}
+
+	order := newStreamOrder(w.driver, from)
+	err := ls.ListStream(ctx, from, func(child string) error {
+		return visit(order.add(ctx, child))
+	})
+	if err == nil {
+		err = visit(order.flush())
+	}
+	switch {
+	case stop:
+		return walkErr, false
+	case err != nil:
+		return w.fail(from, err)
+	default:
+		return nil, true
+	}
+}
+
+// streamOrder restores the lexical order of paths to children streamed in
+// the lexical order of their keys. The two differ only where the name of a
+// directory is a prefix of that of a sibling which continues with a byte
+// sorting before "/", such as "a" and "a-b": the key "a/" of the directory is
+// listed after "a-b", but the directory sorts, and so is visited, first. Such
+// siblings are held back until the directory has been listed. Whether a
+// directory of a given name exists is checked once, when a sibling it would
+// precede is first listed.
+type streamOrder struct {
+	driver StorageDriver
+
+	// nameStart is the offset of the name of each child in its path.
+	nameStart int
+
+	// held is the children listed but not yet released, in lexical order.
+	held []string
+
+	// pending records, for names which are prefixes of recently listed
+	// children, whether a directory of that name is yet to be listed.
+	pending map[string]bool
+}
+
+func newStreamOrder(driver StorageDriver, from string) *streamOrder {
+	nameStart := len(from) + 1
+	if from == "/" {
+		nameStart = 1
+	}
+	return &streamOrder{
+		driver:    driver,
+		nameStart: nameStart,
+		pending:   make(map[string]bool),
+	}
+}
+
+// add records child as listed, returning the children which may now be
+// visited, in order.
+func (o *streamOrder) add(ctx context.Context, child string) []string {
+	for name := range o.pending {
+		// Once child sorts after the key of a directory, the directory has
+		// either been listed or does not exist.
+		if name+"/" < child {
+			delete(o.pending, name)
+		}
+	}
+	if _, ok := o.pending[child]; ok {
+		o.pending[child] = false
+	}
+
+	// Children up to child may be released, unless a directory whose name
+	// is a prefix of child is yet to be listed. Only the shortest such name
+	// matters.
+	limit, inclusive := child, true
+	for i := o.nameStart + 1; i < len(child); i++ {
+		if child[i] >= '/' {
+			continue
+		}
+		name := child[:i]
+		pending, ok := o.pending[name]
+		if !ok {
+			pending = o.dirPending(ctx, name)
+			o.pending[name] = pending
+		}
+		if pending {
+			limit, inclusive = name, false
+			break
+		}
+	}
+
+	i := sort.SearchStrings(o.held, child)
+	o.held = append(o.held, "")
+	copy(o.held[i+1:], o.held[i:])
+	o.held[i] = child
+
+	n := sort.Search(len(o.held), func(i int) bool {
+		if inclusive {
+			return o.held[i] > limit
+		}
+		return o.held[i] >= limit
+	})
+	released := o.held[:n:n]
+	o.held = o.held[n:]
+	return released
+}
+
+// dirPending reports whether a directory at path, which sorts after children
+// already listed, may yet be listed. If that cannot be determined, it is
+// assumed that it may.
+func (o *streamOrder) dirPending(ctx context.Context, path string) bool {
+	fileInfo, err := o.driver.Stat(ctx, path)
+	if err != nil {
+		_, ok := err.(PathNotFoundError)
+		return !ok
+	}
+	return fileInfo.IsDir()
+}
+
+// flush returns the children still held once listing has finished, in order.
+func (o *streamOrder) flush() []string {
+	held := o.held
+	o.held = nil
+	return held
+}
+
+// walkChild visits a single child of a directory, descending into it if it is
+// itself a directory. The returned bool reports whether the walk should
+// continue.
+func (w *walker) walkChild(ctx context.Context, child string) (error, bool) {
+	if w.opts.excluded(child) {
+		return nil, true
+	}
+
+	// TODO(stevvooe): Calling driver.Stat for every entry is quite
+	// expensive when running against backends with a slow Stat
+	// implementation, such as s3. This is very likely a serious
+	// performance bottleneck.
+	fileInfo, err := w.driver.Stat(ctx, child)
+	if err != nil {
+		switch err.(type) {
+		case PathNotFoundError:
+			// repository was removed in between listing and enumeration. Ignore it.
+			logrus.WithField("path", child).Infof("ignoring deleted path")
+			return nil, true
+		default:
+			return w.fail(child, err)
+		}
+	}
+	if fileInfo.IsDir() && w.ancestors[child] {
+		return w.fail(child, ErrWalkLoop)
+	}
+	switch {
+	case !w.opts.filtered(fileInfo):
+		err = w.f(fileInfo)
+	case fileInfo.IsDir() && w.opts.SkipFilteredDirs:
+		err = ErrSkipDir
+	}
+	if err == nil && fileInfo.IsDir() {
+		if err, ok := w.walk(ctx, child); err != nil || !ok {
+			return err, ok
+		}
+	} else if err == ErrSkipDir {
+		// noop for folders, will just skip
+		if !fileInfo.IsDir() {
+			return nil, false // no error but stop iteration
+		}
+	} else if err != nil {
+		return w.fail(child, err)
 	}
 	return nil, true
 }
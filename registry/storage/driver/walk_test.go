@@ -2,7 +2,10 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -53,6 +56,141 @@ func (cfs *fileSystem) isDir(path string) bool {
 	return isDir
 }
 
+// streamingFileSystem generates a directory of many files on demand, failing
+// the test if the listing is ever materialized with List.
+type streamingFileSystem struct {
+	StorageDriver
+	t        *testing.T
+	files    int
+	streamed int
+}
+
+func (sfs *streamingFileSystem) List(_ context.Context, path string) ([]string, error) {
+	sfs.t.Fatalf("unexpected call to List for %s", path)
+	return nil, nil
+}
+
+func (sfs *streamingFileSystem) ListStream(_ context.Context, path string, f func(child string) error) error {
+	if path != "/" {
+		return nil
+	}
+	for i := 0; i < sfs.files; i++ {
+		sfs.streamed++
+		if err := f(fmt.Sprintf("/file%d", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sfs *streamingFileSystem) Stat(_ context.Context, path string) (FileInfo, error) {
+	return &FileInfoInternal{
+		FileInfoFields: FileInfoFields{
+			Path:  path,
+			IsDir: path == "/",
+		},
+	}, nil
+}
+
+func TestWalkFallbackStream(t *testing.T) {
+	d := &streamingFileSystem{t: t, files: 100000}
+
+	var walked int
+	err := WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked++
+		// Each entry must be visited as soon as it is streamed, rather than
+		// after the full listing has been collected.
+		if d.streamed != walked {
+			t.Fatalf("entry %s visited after %d entries were streamed", fileInfo.Path(), d.streamed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walked != d.files {
+		t.Fatalf("unexpected number of entries walked: %d != %d", walked, d.files)
+	}
+
+	// Stopping early must also stop the stream.
+	d = &streamingFileSystem{t: t, files: 100000}
+	err = WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		if fileInfo.Path() == "/file9" {
+			return ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.streamed != 10 {
+		t.Fatalf("unexpected number of entries streamed after stopping: %d != 10", d.streamed)
+	}
+}
+
+// keyOrderFileSystem streams the listings of a fileSystem in the lexical
+// order of their keys, in which the key of a directory ends with a "/", as
+// paginated backends list them.
+type keyOrderFileSystem struct {
+	*fileSystem
+}
+
+func (kfs *keyOrderFileSystem) ListStream(ctx context.Context, path string, f func(child string) error) error {
+	key := func(child string) string {
+		if kfs.isDir(child) {
+			return child + "/"
+		}
+		return child
+	}
+
+	children := append([]string(nil), kfs.fileset[path]...)
+	sort.Slice(children, func(i, j int) bool {
+		return key(children[i]) < key(children[j])
+	})
+	for _, child := range children {
+		if err := f(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestWalkFallbackStreamOrder ensures that children streamed in the order of
+// their keys are visited in the same order as listed children, in which a
+// directory precedes siblings whose names it is a prefix of.
+func TestWalkFallbackStreamOrder(t *testing.T) {
+	d := &fileSystem{
+		fileset: map[string][]string{
+			"/":    {"/b", "/a.c", "/a-b", "/a"},
+			"/a":   {"/a/x-y", "/a/x"},
+			"/a/x": {"/a/x/1"},
+			"/a-b": {"/a-b/1"},
+			"/a.c": {},
+			"/b":   {"/b/1"},
+		},
+	}
+
+	walk := func(d StorageDriver) []string {
+		var walked []string
+		err := WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+			walked = append(walked, fileInfo.Path())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error walking: %v", err)
+		}
+		return walked
+	}
+
+	expected := []string{"/a", "/a/x", "/a/x/1", "/a/x-y", "/a-b", "/a-b/1", "/a.c", "/b", "/b/1"}
+	if listed := walk(d); !reflect.DeepEqual(listed, expected) {
+		t.Fatalf("unexpected order walking listed children: %v != %v", listed, expected)
+	}
+	if streamed := walk(&keyOrderFileSystem{fileSystem: d}); !reflect.DeepEqual(streamed, expected) {
+		t.Fatalf("unexpected order walking streamed children: %v != %v", streamed, expected)
+	}
+}
+
 func TestWalkFileRemoved(t *testing.T) {
 	d := &changingFileSystem{
 		fileset: []string{"zoidberg", "bender"},
@@ -170,6 +308,288 @@ func TestWalkFallback(t *testing.T) {
 
 }
 
+// statRecordingFileSystem records the paths statted on a fileSystem.
+type statRecordingFileSystem struct {
+	*fileSystem
+	statted []string
+}
+
+func (sfs *statRecordingFileSystem) Stat(ctx context.Context, path string) (FileInfo, error) {
+	sfs.statted = append(sfs.statted, path)
+	return sfs.fileSystem.Stat(ctx, path)
+}
+
+func TestWalkFallbackExcludePrefixes(t *testing.T) {
+	d := &statRecordingFileSystem{fileSystem: &fileSystem{
+		fileset: map[string][]string{
+			"/":               {"/file1", "/folder1", "/folder10", "/folder2"},
+			"/folder1":        {"/folder1/file1", "/folder1/nested"},
+			"/folder1/nested": {"/folder1/nested/file1"},
+			"/folder10":       {"/folder10/file1"},
+			"/folder2":        {"/folder2/file1"},
+		},
+	}}
+
+	var walked []string
+	err := WalkFallbackWithOptions(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		return nil
+	}, WalkOptions{ExcludePrefixes: []string{"/folder1/"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A sibling sharing the prefix as a string is not excluded.
+	compareWalked(t, []string{
+		"/file1",
+		"/folder10",
+		"/folder10/file1",
+		"/folder2",
+		"/folder2/file1",
+	}, walked)
+
+	for _, path := range d.statted {
+		if path == "/folder1" || strings.HasPrefix(path, "/folder1/") {
+			t.Errorf("excluded path %s was statted", path)
+		}
+	}
+}
+
+func TestWalkFallbackFilter(t *testing.T) {
+	d := &fileSystem{
+		fileset: map[string][]string{
+			"/":               {"/file1.json", "/file2", "/folder1", "/folder2.json"},
+			"/folder1":        {"/folder1/file1.json", "/folder1/nested"},
+			"/folder1/nested": {"/folder1/nested/file1.json", "/folder1/nested/file2"},
+			"/folder2.json":   {"/folder2.json/file1"},
+		},
+	}
+	filter := func(fileInfo FileInfo) bool {
+		return strings.HasSuffix(fileInfo.Path(), ".json")
+	}
+
+	var walked []string
+	err := WalkFallbackWithOptions(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		return nil
+	}, WalkOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Directories filtered out are still descended into.
+	compareWalked(t, []string{
+		"/file1.json",
+		"/folder1/file1.json",
+		"/folder1/nested/file1.json",
+		"/folder2.json",
+	}, walked)
+
+	walked = nil
+	err = WalkFallbackWithOptions(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		return nil
+	}, WalkOptions{Filter: filter, SkipFilteredDirs: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	compareWalked(t, []string{
+		"/file1.json",
+		"/folder2.json",
+	}, walked)
+}
+
+func TestWalkFallbackContinueOnError(t *testing.T) {
+	d := &fileSystem{
+		fileset: map[string][]string{
+			"/":        {"/file1", "/folder1", "/folder2"},
+			"/folder1": {"/folder1/file1", "/folder1/file2"},
+			"/folder2": {"/folder2/file1"},
+		},
+	}
+	errBadFile := errors.New("bad file")
+
+	var walked []string
+	err := WalkFallbackWithOptions(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		switch fileInfo.Path() {
+		case "/file1", "/folder1/file2":
+			return errBadFile
+		case "/folder2":
+			return ErrSkipDir
+		}
+		return nil
+	}, WalkOptions{ContinueOnError: true})
+
+	compareWalked(t, []string{
+		"/file1",
+		"/folder1",
+		"/folder1/file1",
+		"/folder1/file2",
+		"/folder2",
+	}, walked)
+
+	walkErrs, ok := err.(WalkErrors)
+	if !ok {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+	if len(walkErrs) != 2 {
+		t.Fatalf("unexpected number of errors: %d != 2: %v", len(walkErrs), walkErrs)
+	}
+	for i, path := range []string{"/file1", "/folder1/file2"} {
+		if walkErrs[i].Path != path || walkErrs[i].Err != errBadFile {
+			t.Errorf("unexpected error %d: %v", i, walkErrs[i])
+		}
+	}
+	if !walkErrs.Is(errBadFile) {
+		t.Errorf("expected walk errors to match %v", errBadFile)
+	}
+	if walkErrs.Is(ErrWalkLoop) {
+		t.Errorf("unexpected match of %v", ErrWalkLoop)
+	}
+	var walkErr WalkError
+	if !walkErrs.As(&walkErr) || walkErr.Path != "/file1" {
+		t.Errorf("unexpected walk error: %v", walkErr)
+	}
+	var pathErr PathNotFoundError
+	if walkErrs.As(&pathErr) {
+		t.Errorf("unexpected match of %T: %v", pathErr, pathErr)
+	}
+
+	// Without ContinueOnError, the first error aborts the walk.
+	walked = nil
+	err = WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		if fileInfo.Path() == "/file1" {
+			return errBadFile
+		}
+		return nil
+	})
+	if err != errBadFile {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compareWalked(t, []string{"/file1"}, walked)
+}
+
+func TestWalkFallbackLoop(t *testing.T) {
+	// The listing of /folder1/folder2 includes its ancestor, /folder1.
+	d := &fileSystem{
+		fileset: map[string][]string{
+			"/":                {"/folder1"},
+			"/folder1":         {"/folder1/file1", "/folder1/folder2"},
+			"/folder1/folder2": {"/folder1"},
+		},
+	}
+
+	var walked []string
+	err := WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		return nil
+	})
+	if err != ErrWalkLoop {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compareWalked(t, []string{
+		"/folder1",
+		"/folder1/file1",
+		"/folder1/folder2",
+	}, walked)
+
+	// With ContinueOnError, the loop is recorded at the repeated directory.
+	err = WalkFallbackWithOptions(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		return nil
+	}, WalkOptions{ContinueOnError: true})
+	walkErrs, ok := err.(WalkErrors)
+	if !ok || len(walkErrs) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if walkErrs[0].Path != "/folder1" || walkErrs[0].Err != ErrWalkLoop {
+		t.Errorf("unexpected error: %v", walkErrs[0])
+	}
+}
+
+func TestWalkFallbackRevisit(t *testing.T) {
+	// Both folders list /shared, which is walked from each rather than
+	// being mistaken for a loop.
+	d := &fileSystem{
+		fileset: map[string][]string{
+			"/":        {"/folder1", "/folder2"},
+			"/folder1": {"/shared"},
+			"/folder2": {"/shared"},
+			"/shared":  {"/shared/file1"},
+		},
+	}
+
+	var walked []string
+	w := &walker{
+		driver: d,
+		f: func(fileInfo FileInfo) error {
+			walked = append(walked, fileInfo.Path())
+			return nil
+		},
+		ancestors: make(map[string]bool),
+	}
+	if err, _ := w.walk(context.Background(), "/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compareWalked(t, []string{
+		"/folder1",
+		"/shared",
+		"/shared/file1",
+		"/folder2",
+		"/shared",
+		"/shared/file1",
+	}, walked)
+
+	// Directories are dropped once they have been walked.
+	if len(w.ancestors) != 0 {
+		t.Errorf("unexpected directories retained after walk: %v", w.ancestors)
+	}
+}
+
+type wrappedError struct {
+	err error
+}
+
+func (err wrappedError) Error() string {
+	return "wrapped: " + err.err.Error()
+}
+
+func (err wrappedError) Unwrap() error {
+	return err.err
+}
+
+func TestWalkErrorsIsAs(t *testing.T) {
+	errs := WalkErrors{
+		{Path: "/file1", Err: ErrWalkLoop},
+		{Path: "/file2", Err: wrappedError{PathNotFoundError{Path: "/file2", DriverName: "test"}}},
+	}
+
+	if !errs.Is(ErrWalkLoop) {
+		t.Errorf("expected walk errors to match %v", ErrWalkLoop)
+	}
+	if !errs.Is(PathNotFoundError{Path: "/file2", DriverName: "test"}) {
+		t.Errorf("expected walk errors to match wrapped error")
+	}
+	if errs.Is(ErrSkipDir) {
+		t.Errorf("unexpected match of %v", ErrSkipDir)
+	}
+
+	var pathErr PathNotFoundError
+	if !errs.As(&pathErr) || pathErr.Path != "/file2" {
+		t.Errorf("unexpected path error: %v", pathErr)
+	}
+	var wrapped wrappedError
+	if !errs.As(&wrapped) {
+		t.Errorf("expected walk errors to contain %T", wrapped)
+	}
+	var invalidErr InvalidPathError
+	if errs.As(&invalidErr) {
+		t.Errorf("unexpected match of %T: %v", invalidErr, invalidErr)
+	}
+}
+
 func compareWalked(t *testing.T, expected, walked []string) {
 	if len(walked) != len(expected) {
 		t.Fatalf("Mismatch number of fileInfo walked %d expected %d; walked %s; expected %s;", len(walked), len(expected), walked, expected)
@@ -30,6 +30,10 @@ type fileReader struct {
 	path string
 	size int64 // size is the total size, must be set.
 
+	// bufferSize is the size of the read buffer, defaulting to
+	// fileReaderBufferSize if zero.
+	bufferSize int
+
 	// mutable fields
 	rc     io.ReadCloser // remote read closer
 	brd    *bufio.Reader // internal buffered io
@@ -102,8 +106,15 @@ func (fr *fileReader) Seek(offset int64, whence int) (int64, error) {
 	return fr.offset, err
 }
 
+// Close releases the driver reader and buffer. Calls after the first return
+// an error.
 func (fr *fileReader) Close() error {
-	return fr.closeWithErr(fmt.Errorf("fileReader: closed"))
+	if fr.err != nil {
+		return fr.err
+	}
+
+	fr.closeWithErr(fmt.Errorf("fileReader: closed"))
+	return nil
 }
 
 // reader prepares the current reader at the lrs offset, ensuring its buffered
@@ -135,7 +146,11 @@ func (fr *fileReader) reader() (io.Reader, error) {
 	fr.rc = rc
 
 	if fr.brd == nil {
-		fr.brd = bufio.NewReaderSize(fr.rc, fileReaderBufferSize)
+		bufferSize := fr.bufferSize
+		if bufferSize == 0 {
+			bufferSize = fileReaderBufferSize
+		}
+		fr.brd = bufio.NewReaderSize(fr.rc, bufferSize)
 	} else {
 		fr.brd.Reset(fr.rc)
 	}
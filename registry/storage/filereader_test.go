@@ -2,11 +2,14 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"io"
 	mrand "math/rand"
 	"testing"
+	"time"
 
-	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/opencontainers/go-digest"
 )
@@ -191,3 +194,102 @@ func TestFileReaderErrors(t *testing.T) {
 	//     failure cases and how the storage driver propagates these errors
 	//     up the stack.
 }
+
+// slowReadDriver injects latency into each read from the readers returned by
+// the driver it wraps, counting the reads.
+type slowReadDriver struct {
+	storagedriver.StorageDriver
+	latency time.Duration
+	reads   int
+	closed  int
+}
+
+func (d *slowReadDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &slowReader{ReadCloser: rc, driver: d}, nil
+}
+
+type slowReader struct {
+	io.ReadCloser
+	driver *slowReadDriver
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.driver.latency)
+	r.driver.reads++
+	return r.ReadCloser.Read(p)
+}
+
+func (r *slowReader) Close() error {
+	r.driver.closed++
+	return r.ReadCloser.Close()
+}
+
+func TestFileReaderBufferSize(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 64<<10)
+	if _, err := mrand.Read(content); err != nil {
+		t.Fatalf("unexpected error building random data: %v", err)
+	}
+
+	reads := make(map[int]int)
+	for _, bufferSize := range []int{1 << 10, 32 << 10} {
+		driver := &slowReadDriver{StorageDriver: inmemory.New(), latency: time.Millisecond}
+		reg, err := NewRegistry(ctx, driver, ReadBufferSize(bufferSize))
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		repoName, _ := reference.WithName("foo/buffered")
+		repo, err := reg.Repository(ctx, repoName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		bs := repo.Blobs(ctx)
+
+		desc, err := bs.Put(ctx, "application/octet-stream", content)
+		if err != nil {
+			t.Fatalf("error putting blob: %v", err)
+		}
+
+		rc, err := bs.Open(ctx, desc.Digest)
+		if err != nil {
+			t.Fatalf("error opening blob: %v", err)
+		}
+
+		// Read in small pieces, as a client copying to a connection might.
+		var read []byte
+		p := make([]byte, 512)
+		for {
+			n, err := rc.Read(p)
+			read = append(read, p[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("error reading blob: %v", err)
+			}
+		}
+		if !bytes.Equal(read, content) {
+			t.Fatalf("read content does not match blob with buffer size %d", bufferSize)
+		}
+
+		if n, err := rc.Read(p); n != 0 || err != io.EOF {
+			t.Fatalf("unexpected read after EOF: %d, %v", n, err)
+		}
+		if err := rc.Close(); err != nil {
+			t.Fatalf("error closing blob reader: %v", err)
+		}
+		if driver.closed != 1 {
+			t.Fatalf("unexpected number of closed driver readers: %d != 1", driver.closed)
+		}
+
+		reads[bufferSize] = driver.reads
+	}
+
+	if reads[32<<10] >= reads[1<<10] {
+		t.Fatalf("larger buffer did not reduce driver reads: %v", reads)
+	}
+}
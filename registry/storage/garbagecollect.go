@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest/schema2"
@@ -19,6 +20,11 @@ func emit(format string, a ...interface{}) {
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+
+	// GracePeriod, if positive, protects unreferenced blobs written less
+	// than this long ago, since the manifest referencing a newly uploaded
+	// blob may not have been pushed yet.
+	GracePeriod time.Duration
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -109,7 +115,9 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 			descriptors := manifest.References()
 			for _, descriptor := range descriptors {
-				markSet[descriptor.Digest] = struct{}{}
+				// References may have been normalized when the manifest
+				// was accepted, so mark the canonical form.
+				markSet[canonicalDigest(descriptor.Digest)] = struct{}{}
 				emit("%s: marking blob %s", repoName, descriptor.Digest)
 			}
 
@@ -152,17 +160,32 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	}
 	blobService := registry.Blobs()
 	deleteSet := make(map[digest.Digest]struct{})
+	graceCutoff := time.Now().Add(-opts.GracePeriod)
 	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
 		// check if digest is in markSet. If not, delete it!
-		if _, ok := markSet[dgst]; !ok {
-			deleteSet[dgst] = struct{}{}
+		if _, ok := markSet[dgst]; ok {
+			return nil
+		}
+
+		if opts.GracePeriod > 0 {
+			recent, err := blobWrittenAfter(ctx, storageDriver, dgst, graceCutoff)
+			if err != nil {
+				return err
+			}
+			if recent {
+				emit("blob within grace period: %s", dgst)
+				return nil
+			}
 		}
+
+		deleteSet[dgst] = struct{}{}
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("error enumerating blobs: %v", err)
 	}
 	emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
+	deleted := make([]digest.Digest, 0, len(deleteSet))
 	for dgst := range deleteSet {
 		emit("blob eligible for deletion: %s", dgst)
 		if opts.DryRun {
@@ -172,7 +195,55 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		if err != nil {
 			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
 		}
+		deleted = append(deleted, dgst)
+	}
+
+	// Content removed from the blob store is no longer counted against the
+	// quota of any repository still linking it, including repositories
+	// holding no manifest.
+	if len(deleted) > 0 {
+		err = enumerateRepositoryUsage(ctx, storageDriver, func(repoName string) error {
+			if err := vacuum.ReleaseUsage(repoName, deleted...); err != nil {
+				return fmt.Errorf("failed to release usage of %s: %v", repoName, err)
+			}
+			return nil
+		})
 	}
 
 	return err
 }
+
+// blobWrittenAfter reports whether the data of the blob dgst was written
+// after t.
+func blobWrittenAfter(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest, t time.Time) (bool, error) {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return false, err
+	}
+
+	fi, err := storageDriver.Stat(ctx, blobPath)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// The blob may be stored inline.
+		blobPath, err = pathFor(blobInlineDataPathSpec{digest: dgst})
+		if err != nil {
+			return false, err
+		}
+		fi, err = storageDriver.Stat(ctx, blobPath)
+	}
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// The blob may be stored compressed.
+		blobPath, err = pathFor(blobCompressedDataPathSpec{digest: dgst})
+		if err != nil {
+			return false, err
+		}
+		fi, err = storageDriver.Stat(ctx, blobPath)
+	}
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat blob %s: %v", dgst, err)
+	}
+
+	return fi.ModTime().After(t), nil
+}
@@ -1,12 +1,13 @@
 package storage
 
 import (
+	"context"
 	"io"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
-	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
@@ -500,3 +501,68 @@ func TestOrphanBlobDeleted(t *testing.T) {
 		}
 	}
 }
+
+// agedDriver reports every file as having been modified age earlier than
+// it was.
+type agedDriver struct {
+	driver.StorageDriver
+	age time.Duration
+}
+
+func (d *agedDriver) Stat(ctx context.Context, path string) (driver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return driver.FileInfoInternal{FileInfoFields: driver.FileInfoFields{
+		Path:    fi.Path(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().Add(-d.age),
+		IsDir:   fi.IsDir(),
+	}}, nil
+}
+
+func TestOrphanBlobGracePeriod(t *testing.T) {
+	d := &agedDriver{StorageDriver: inmemory.New()}
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "michael_z_doukas")
+	digests, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+
+	// formality to create the necessary directories
+	uploadRandomSchema2Image(t, repo)
+
+	opts := GCOpts{GracePeriod: time.Hour}
+
+	// A blob uploaded within the grace period is kept, though unreferenced.
+	if err := MarkAndSweep(context.Background(), d, registry, opts); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("Orphan layer within grace period was deleted: %v", dgst)
+		}
+	}
+
+	// Once the grace period has passed, it is reclaimed.
+	d.age = 2 * time.Hour
+	if err := MarkAndSweep(context.Background(), d, registry, opts); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs = allBlobs(t, registry)
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; ok {
+			t.Fatalf("Orphan layer past grace period is present: %v", dgst)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// gzipMagic is the header with which every gzip stream begins.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isLayerMediaType reports whether mediaType is that of an image layer, which
+// is expected to be a tar archive.
+func isLayerMediaType(mediaType string) bool {
+	switch mediaType {
+	case schema2.MediaTypeLayer, schema2.MediaTypeForeignLayer,
+		v1.MediaTypeImageLayer, v1.MediaTypeImageLayerGzip,
+		v1.MediaTypeImageLayerNonDistributable, v1.MediaTypeImageLayerNonDistributableGzip:
+		return true
+	}
+	return false
+}
+
+// layerArchives validates the layers referenced by manifests as tar
+// archives, if enabled. A layer found to be valid is marked as such in the
+// blob store, so that it is read only once.
+type layerArchives struct {
+	enabled bool
+	driver  storagedriver.StorageDriver
+}
+
+// verify checks that the blob described by desc, referenced by a manifest, is
+// a valid tar archive if its media type is that of a layer. Compression is
+// detected from the content rather than the media type, since clients do not
+// always distinguish the two.
+func (la layerArchives) verify(ctx context.Context, blobs distribution.BlobStore, desc distribution.Descriptor) error {
+	if !la.enabled || !isLayerMediaType(desc.MediaType) {
+		return nil
+	}
+
+	validPath, err := pathFor(blobArchiveValidPathSpec{digest: desc.Digest})
+	if err != nil {
+		return err
+	}
+
+	if _, err := la.driver.Stat(ctx, validPath); err == nil {
+		return nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return err
+	}
+
+	rsc, err := blobs.Open(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer rsc.Close()
+
+	if err := readArchive(rsc); err != nil {
+		return distribution.ErrBlobInvalidArchive{Digest: desc.Digest, Reason: err}
+	}
+
+	return la.driver.PutContent(ctx, validPath, []byte{})
+}
+
+// readArchive reads a tar archive, which may be gzip compressed, to its end,
+// returning an error if it is malformed.
+func readArchive(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	var archive io.Reader = br
+	if bytes.Equal(magic, gzipMagic) {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		archive = gzr
+	}
+
+	tr := tar.NewReader(archive)
+	for {
+		if _, err := tr.Next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		// Reading each entry detects archives truncated mid-file.
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// LayerDigests describes a gzip compressed layer by both of the digests it is
+// known by.
+type LayerDigests struct {
+	// Digest is the digest of the compressed layer, by which it is
+	// addressed in the blob store and referenced by manifests.
+	Digest digest.Digest
+
+	// DiffID is the digest of the uncompressed layer, by which it is listed
+	// in the rootfs of image configs.
+	DiffID digest.Digest
+
+	// Size is the length of the compressed layer.
+	Size int64
+}
+
+// DigestCompressedLayer reads the gzip compressed layer r to its end,
+// computing its compressed and uncompressed digests in a single pass. An
+// error is returned if r is not a valid gzip stream.
+func DigestCompressedLayer(r io.Reader) (LayerDigests, error) {
+	compressed := digest.Canonical.Digester()
+	uncompressed := digest.Canonical.Digester()
+	cr := &countingReader{r: io.TeeReader(r, compressed.Hash())}
+
+	gzr, err := gzip.NewReader(cr)
+	if err != nil {
+		return LayerDigests{}, err
+	}
+	defer gzr.Close()
+
+	if _, err := io.Copy(uncompressed.Hash(), gzr); err != nil {
+		return LayerDigests{}, err
+	}
+
+	// The gzip reader stops at the end of the last member, so any data
+	// it left unread must still be included in the compressed digest.
+	if _, err := io.Copy(ioutil.Discard, cr); err != nil {
+		return LayerDigests{}, err
+	}
+
+	return LayerDigests{
+		Digest: compressed.Digest(),
+		DiffID: uncompressed.Digest(),
+		Size:   cr.n,
+	}, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
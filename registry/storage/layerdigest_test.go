@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/testutil"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDigestCompressedLayer(t *testing.T) {
+	ctx := context.Background()
+	rs, diffID, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("unexpected error generating test layer file: %v", err)
+	}
+	archive, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("unexpected error reading test layer file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if _, err := gzw.Write(archive); err != nil {
+		t.Fatalf("unexpected error compressing layer: %v", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("unexpected error compressing layer: %v", err)
+	}
+	layer := buf.Bytes()
+
+	registry, err := NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	imageName, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	blobs := repository.Blobs(ctx)
+
+	desc, err := blobs.Put(ctx, v1.MediaTypeImageLayerGzip, layer)
+	if err != nil {
+		t.Fatalf("unexpected error putting layer: %v", err)
+	}
+
+	rsc, err := blobs.Open(ctx, desc.Digest)
+	if err != nil {
+		t.Fatalf("unexpected error opening layer: %v", err)
+	}
+	defer rsc.Close()
+
+	digests, err := DigestCompressedLayer(rsc)
+	if err != nil {
+		t.Fatalf("unexpected error digesting layer: %v", err)
+	}
+
+	expected := LayerDigests{
+		Digest: digest.FromBytes(layer),
+		DiffID: diffID,
+		Size:   int64(len(layer)),
+	}
+	if digests != expected {
+		t.Fatalf("unexpected layer digests: %+v != %+v", digests, expected)
+	}
+	if digests.Digest != desc.Digest {
+		t.Fatalf("compressed digest does not address the layer: %s != %s", digests.Digest, desc.Digest)
+	}
+
+	if _, err := DigestCompressedLayer(bytes.NewReader(archive)); err == nil {
+		t.Fatal("expected error digesting an uncompressed layer")
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	dcontext "github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// CurrentLayoutVersion is the version of the storage layout read and written
+// by the registry. Version 1 kept the directory of each blob directly under
+// the directory of its digest algorithm, as
+// <root>/v2/blobs/<algorithm>/<hex digest>, whereas version 2 splits the
+// directories of blobs by the first two hex bytes of their digests.
+const CurrentLayoutVersion = 2
+
+// ErrLayoutVersionUnsupported is returned when the storage layout was written
+// by a newer registry than this one.
+type ErrLayoutVersionUnsupported struct {
+	Version int
+}
+
+func (err ErrLayoutVersionUnsupported) Error() string {
+	return fmt.Sprintf("storage layout version %d is newer than the supported version %d", err.Version, CurrentLayoutVersion)
+}
+
+// LayoutVersion returns the version of the storage layout recorded in the
+// storage held by driver. Storage without a recorded version predates the
+// marker and is reported as version 1, though its blobs may already follow a
+// later layout.
+func LayoutVersion(ctx context.Context, driver storagedriver.StorageDriver) (int, error) {
+	markerPath, err := pathFor(layoutVersionPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := driver.GetContent(ctx, markerPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid storage layout version %q", content)
+	}
+	return version, nil
+}
+
+// MigrateLayout rewrites the paths of the storage held by driver to the
+// current layout, if the recorded layout is older, returning the number of
+// blobs moved. The current version is recorded once every blob is moved, so
+// an interrupted migration is resumed by running it again, and running it
+// once the layout is current does nothing.
+//
+// Blobs are unreadable from the time the registry reads the current layout
+// until they are moved, so the migration should complete before the
+// registry serves requests.
+func MigrateLayout(ctx context.Context, driver storagedriver.StorageDriver) (int, error) {
+	version, err := LayoutVersion(ctx, driver)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case version > CurrentLayoutVersion:
+		return 0, ErrLayoutVersionUnsupported{Version: version}
+	case version == CurrentLayoutVersion:
+		return 0, nil
+	}
+
+	root, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+
+	// The blobs to move are found before any are moved, so that the walk
+	// does not visit the directories they are moved to.
+	var unsplit []digest.Digest
+	err = storagedriver.WalkFallback(ctx, driver, root, func(fileInfo storagedriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			return nil
+		}
+
+		dir, name := path.Split(fileInfo.Path())
+		if len(name) == 2 {
+			// The directory of blobs split by the first two hex bytes of
+			// their digests, as in the current layout.
+			return storagedriver.ErrSkipDir
+		}
+
+		dgst := digest.NewDigestFromHex(path.Base(dir), name)
+		if dgst.Validate() != nil {
+			// The directory of a digest algorithm.
+			return nil
+		}
+		unsplit = append(unsplit, dgst)
+		return storagedriver.ErrSkipDir
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return 0, err
+		}
+	}
+
+	for i, dgst := range unsplit {
+		if err := migrateBlobLayout(ctx, driver, root, dgst); err != nil {
+			return i, err
+		}
+	}
+
+	markerPath, err := pathFor(layoutVersionPathSpec{})
+	if err != nil {
+		return len(unsplit), err
+	}
+	if err := driver.PutContent(ctx, markerPath, []byte(strconv.Itoa(CurrentLayoutVersion))); err != nil {
+		return len(unsplit), err
+	}
+
+	dcontext.GetLogger(ctx).Infof("Storage layout migrated from version %d to %d. Num blobs moved=%d", version, CurrentLayoutVersion, len(unsplit))
+	return len(unsplit), nil
+}
+
+// migrateBlobLayout moves the files of the blob identified by dgst from its
+// directory in the version 1 layout to its directory in the current layout,
+// removing the former. Content is addressed by digest, so files already moved
+// by an interrupted migration are the same as any left to move.
+func migrateBlobLayout(ctx context.Context, driver storagedriver.StorageDriver, root string, dgst digest.Digest) error {
+	components, err := digestPathComponents(dgst, false)
+	if err != nil {
+		return err
+	}
+	from := path.Join(append([]string{root}, components...)...)
+
+	to, err := pathFor(blobPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	err = storagedriver.WalkFallback(ctx, driver, from, func(fileInfo storagedriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			files = append(files, fileInfo.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := driver.Move(ctx, file, path.Join(to, strings.TrimPrefix(file, from))); err != nil {
+			return err
+		}
+	}
+
+	if err := driver.Delete(ctx, from); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
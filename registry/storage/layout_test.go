@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	name, _ := reference.WithName("foo/layout")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("error getting repository: %v", err)
+	}
+	blobs := repo.Blobs(ctx)
+
+	contents := map[digest.Digest][]byte{}
+	for _, content := range []string{"first", "second", "third"} {
+		desc, err := blobs.Put(ctx, "application/octet-stream", []byte(content))
+		if err != nil {
+			t.Fatalf("error putting blob: %v", err)
+		}
+		contents[desc.Digest] = []byte(content)
+	}
+
+	root, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := func(dgst digest.Digest, file string) string {
+		return path.Join(root, dgst.Algorithm().String(), dgst.Hex(), file)
+	}
+
+	// Seed the version 1 layout by moving the blobs out of their split
+	// directories, leaving one half moved as by an interrupted migration.
+	var interrupted digest.Digest
+	for dgst := range contents {
+		dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if interrupted == "" {
+			interrupted = dgst
+			if err := d.PutContent(ctx, oldPath(dgst, "size"), []byte("6")); err != nil {
+				t.Fatalf("error seeding blob: %v", err)
+			}
+			continue
+		}
+		if err := d.Move(ctx, dataPath, oldPath(dgst, "data")); err != nil {
+			t.Fatalf("error seeding blob: %v", err)
+		}
+	}
+
+	if version, err := LayoutVersion(ctx, d); err != nil || version != 1 {
+		t.Fatalf("expected layout version 1, got %d: %v", version, err)
+	}
+
+	moved, err := MigrateLayout(ctx, d)
+	if err != nil {
+		t.Fatalf("error migrating layout: %v", err)
+	}
+	if moved != len(contents) {
+		t.Fatalf("expected %d blobs moved, got %d", len(contents), moved)
+	}
+
+	if version, err := LayoutVersion(ctx, d); err != nil || version != CurrentLayoutVersion {
+		t.Fatalf("expected layout version %d, got %d: %v", CurrentLayoutVersion, version, err)
+	}
+
+	for dgst, content := range contents {
+		p, err := blobs.Get(ctx, dgst)
+		if err != nil {
+			t.Fatalf("error getting blob %s: %v", dgst, err)
+		}
+		if !bytes.Equal(p, content) {
+			t.Fatalf("unexpected content of blob %s: %q != %q", dgst, p, content)
+		}
+
+		if _, err := d.Stat(ctx, path.Dir(oldPath(dgst, "data"))); err == nil {
+			t.Fatalf("expected version 1 directory of blob %s to be removed", dgst)
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			t.Fatalf("unexpected error statting blob %s: %v", dgst, err)
+		}
+	}
+
+	sizePath, err := pathFor(blobCompressedSizePathSpec{digest: interrupted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, sizePath); err != nil {
+		t.Fatalf("expected remaining file of interrupted blob to be moved: %v", err)
+	}
+
+	// Migrating the current layout does nothing.
+	moved, err = MigrateLayout(ctx, d)
+	if err != nil {
+		t.Fatalf("error migrating layout again: %v", err)
+	}
+	if moved != 0 {
+		t.Fatalf("expected no blobs moved, got %d", moved)
+	}
+
+	markerPath, err := pathFor(layoutVersionPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, markerPath, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateLayout(ctx, d); err != (ErrLayoutVersionUnsupported{Version: 3}) {
+		t.Fatalf("expected unsupported layout version, got %v", err)
+	}
+}
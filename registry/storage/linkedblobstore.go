@@ -42,12 +42,19 @@ type linkedBlobStore struct {
 
 	// linkDirectoryPathSpec locates the root directories in which one might find links
 	linkDirectoryPathSpec pathSpec
+
+	// mediaTypePathFn, if not nil, locates the file recording the media
+	// type of a linked blob, written as blobs are linked.
+	mediaTypePathFn linkPathFunc
+
+	// compressContent causes content written with Put to be gzipped at rest.
+	compressContent bool
 }
 
 var _ distribution.BlobStore = &linkedBlobStore{}
 
 func (lbs *linkedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
-	return lbs.blobStore.statter.Stat(ctx, dgst)
+	return lbs.blobAccessController.Stat(ctx, dgst)
 }
 
 func (lbs *linkedBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
@@ -74,7 +81,7 @@ func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter
 		return err
 	}
 
-	if canonical.MediaType != "" {
+	if knownMediaType(canonical.MediaType) {
 		// Set the repository local content type.
 		w.Header().Set("Content-Type", canonical.MediaType)
 	}
@@ -82,23 +89,40 @@ func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter
 	return lbs.blobServer.ServeBlob(ctx, w, r, canonical.Digest)
 }
 
-func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte) (desc distribution.Descriptor, err error) {
 	dgst := digest.FromBytes(p)
+	charge := distribution.Descriptor{Digest: dgst, Size: int64(len(p))}
+	charged, err := lbs.chargeUsage(ctx, charge)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if charged {
+		defer func() {
+			if err != nil {
+				lbs.releaseUsage(ctx, charge)
+			}
+		}()
+	}
+
 	// Place the data in the blob store first.
-	desc, err := lbs.blobStore.Put(ctx, mediaType, p)
+	if lbs.compressContent {
+		desc, err = lbs.blobStore.putCompressed(ctx, p)
+	} else {
+		desc, err = lbs.blobStore.Put(ctx, mediaType, p)
+	}
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error putting into main store: %v", err)
 		return distribution.Descriptor{}, err
 	}
 
+	if lbs.mediaTypePathFn != nil && knownMediaType(mediaType) {
+		desc.MediaType = mediaType
+	}
+
 	if err := lbs.blobAccessController.SetDescriptor(ctx, dgst, desc); err != nil {
 		return distribution.Descriptor{}, err
 	}
 
-	// TODO(stevvooe): Write out mediatype if incoming differs from what is
-	// returned by Put above. Note that we should allow updates for a given
-	// repository.
-
 	return desc, lbs.linkBlob(ctx, desc)
 }
 
@@ -124,6 +148,24 @@ func WithMountFrom(ref reference.Canonical) distribution.BlobCreateOption {
 	})
 }
 
+// WithIdempotencyKey returns a BlobCreateOption which resumes the upload
+// session created with the same key by the same principal in the repository,
+// if it is still in progress, rather than starting a new one. The principal
+// is empty for anonymous clients.
+func WithIdempotencyKey(principal, key string) distribution.BlobCreateOption {
+	return optionFunc(func(v interface{}) error {
+		opts, ok := v.(*distribution.CreateOptions)
+		if !ok {
+			return fmt.Errorf("unexpected options type: %T", v)
+		}
+
+		opts.IdempotencyKey.Principal = principal
+		opts.IdempotencyKey.Key = key
+
+		return nil
+	})
+}
+
 // Writer begins a blob write session, returning a handle.
 func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
 	dcontext.GetLogger(ctx).Debug("(*linkedBlobStore).Writer")
@@ -143,6 +185,28 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 			// Mount successful, no need to initiate an upload session
 			return nil, distribution.ErrBlobMounted{From: opts.Mount.From, Descriptor: desc}
 		}
+		if _, ok := err.(distribution.ErrBlobLinkLimitExceeded); ok {
+			// Uploading the blob instead would be rejected in the same way.
+			return nil, err
+		}
+	}
+
+	var keyPath string
+	if opts.IdempotencyKey.Key != "" {
+		var err error
+		keyPath, err = pathFor(uploadIdempotencyKeyPathSpec{
+			name:      lbs.repository.Named().Name(),
+			principal: opts.IdempotencyKey.Principal,
+			key:       opts.IdempotencyKey.Key,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		bw, err := lbs.resumeByKey(ctx, keyPath)
+		if err != nil || bw != nil {
+			return bw, err
+		}
 	}
 
 	uuid := uuid.Generate().String()
@@ -171,9 +235,49 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		return nil, err
 	}
 
+	// Concurrent creates with the same key may each start a session, in which
+	// case the last to record its id is resumed by later retries. The key is
+	// referenced from the upload first, so that it is removed with the upload
+	// even if recording it fails.
+	if keyPath != "" {
+		keyRefPath, err := pathFor(uploadKeyRefPathSpec{
+			name: lbs.repository.Named().Name(),
+			id:   uuid,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lbs.blobStore.driver.PutContent(ctx, keyRefPath, []byte(keyPath)); err != nil {
+			return nil, err
+		}
+
+		if err := lbs.blobStore.driver.PutContent(ctx, keyPath, []byte(uuid)); err != nil {
+			return nil, err
+		}
+	}
+
 	return lbs.newBlobUpload(ctx, uuid, path, startedAt, false)
 }
 
+// resumeByKey resumes the upload session recorded at keyPath. A nil writer is
+// returned if no session was recorded or it is no longer in progress.
+func (lbs *linkedBlobStore) resumeByKey(ctx context.Context, keyPath string) (distribution.BlobWriter, error) {
+	id, err := lbs.blobStore.driver.GetContent(ctx, keyPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	bw, err := lbs.Resume(ctx, string(id))
+	if err == distribution.ErrBlobUploadUnknown {
+		return nil, nil
+	}
+	return bw, err
+}
+
 func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
 	dcontext.GetLogger(ctx).Debug("(*linkedBlobStore).Resume")
 
@@ -229,7 +333,7 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 	}
 
 	// Ensure the blob is available for deletion
-	_, err := lbs.blobAccessController.Stat(ctx, dgst)
+	desc, err := lbs.blobAccessController.Stat(ctx, dgst)
 	if err != nil {
 		return err
 	}
@@ -239,6 +343,12 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 		return err
 	}
 
+	lbs.releaseUsage(ctx, desc)
+
+	if err := removeBlobLinker(ctx, lbs.driver, lbs.repository.Named().Name(), desc.Digest); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -311,7 +421,21 @@ func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Name
 		MediaType: "application/octet-stream",
 		Digest:    dgst,
 	}
-	return desc, lbs.linkBlob(ctx, desc)
+	if lbs.mediaTypePathFn != nil {
+		// Carry the media type recorded in the source repository over.
+		desc.MediaType = stat.MediaType
+	}
+	charged, err := lbs.chargeUsage(ctx, desc)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if err := lbs.linkBlob(ctx, desc); err != nil {
+		if charged {
+			lbs.releaseUsage(ctx, desc)
+		}
+		return distribution.Descriptor{}, err
+	}
+	return desc, nil
 }
 
 // newBlobUpload allocates a new upload controller with the given state.
@@ -341,13 +465,13 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution.Descriptor, aliases ...digest.Digest) error {
 	dgsts := append([]digest.Digest{canonical.Digest}, aliases...)
 
-	// TODO(stevvooe): Need to write out mediatype for only canonical hash
-	// since we don't care about the aliases. They are generally unused except
-	// for tarsum but those versions don't care about mediatype.
-
 	// Don't make duplicate links.
 	seenDigests := make(map[digest.Digest]struct{}, len(dgsts))
 
+	if err := lbs.reserveBlobLink(ctx, canonical.Digest); err != nil {
+		return err
+	}
+
 	// only use the first link
 	linkPathFn := lbs.linkPathFns[0]
 
@@ -367,6 +491,20 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 		}
 	}
 
+	// The media type is only written for the canonical digest, since
+	// aliases are generally unused except for tarsum, which does not care
+	// about media types.
+	if lbs.mediaTypePathFn != nil && knownMediaType(canonical.MediaType) {
+		mediaTypePath, err := lbs.mediaTypePathFn(lbs.repository.Named().Name(), canonical.Digest)
+		if err != nil {
+			return err
+		}
+
+		if err := lbs.blobStore.driver.PutContent(ctx, mediaTypePath, []byte(canonical.MediaType)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -381,6 +519,10 @@ type linkedBlobStatter struct {
 	// removed an the blob links folder should be merged. The first entry is
 	// treated as the "canonical" link location and will be used for writes.
 	linkPathFns []linkPathFunc
+
+	// mediaTypePathFn, if not nil, locates the file recording the media
+	// type of a linked blob, which replaces the one of the global blob store.
+	mediaTypePathFn linkPathFunc
 }
 
 var _ distribution.BlobDescriptorService = &linkedBlobStatter{}
@@ -419,10 +561,29 @@ func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (dis
 		dcontext.GetLogger(ctx).Warnf("looking up blob with canonical target: %v -> %v", dgst, target)
 	}
 
-	// TODO(stevvooe): Look up repository local mediatype and replace that on
-	// the returned descriptor.
+	desc, err := lbs.blobStore.statter.Stat(ctx, target)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
 
-	return lbs.blobStore.statter.Stat(ctx, target)
+	if lbs.mediaTypePathFn != nil {
+		mediaTypePath, err := lbs.mediaTypePathFn(lbs.repository.Named().Name(), target)
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+
+		mediaType, err := lbs.blobStore.driver.GetContent(ctx, mediaTypePath)
+		switch err.(type) {
+		case nil:
+			desc.MediaType = string(mediaType)
+		case driver.PathNotFoundError:
+			// No media type was recorded for the blob.
+		default:
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	return desc, nil
 }
 
 func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (err error) {
@@ -444,6 +605,19 @@ func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (er
 		}
 	}
 
+	if lbs.mediaTypePathFn != nil {
+		mediaTypePath, err := lbs.mediaTypePathFn(lbs.repository.Named().Name(), dgst)
+		if err != nil {
+			return err
+		}
+
+		if err := lbs.blobStore.driver.Delete(ctx, mediaTypePath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -469,6 +643,12 @@ func blobLinkPath(name string, dgst digest.Digest) (string, error) {
 	return pathFor(layerLinkPathSpec{name: name, digest: dgst})
 }
 
+// blobMediaTypePath provides the path to the media type recorded for a blob
+// linked into a repository.
+func blobMediaTypePath(name string, dgst digest.Digest) (string, error) {
+	return pathFor(layerMediaTypePathSpec{name: name, digest: dgst})
+}
+
 // manifestRevisionLinkPath provides the path to the manifest revision link.
 func manifestRevisionLinkPath(name string, dgst digest.Digest) (string, error) {
 	return pathFor(manifestRevisionLinkPathSpec{name: name, revision: dgst})
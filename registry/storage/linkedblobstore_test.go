@@ -11,6 +11,7 @@ import (
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/docker/distribution/testutil"
 	"github.com/opencontainers/go-digest"
 )
@@ -262,3 +263,62 @@ func (f statCrossMountCreateOption) Apply(v interface{}) error {
 
 	return nil
 }
+
+func TestBlobLinkLimit(t *testing.T) {
+	ctx := context.Background()
+	const limit = 3
+	registry := createRegistry(t, inmemory.New(), BlobLinkLimit(limit))
+
+	source := makeRepository(t, registry, "links/source")
+	desc, err := source.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("shared content"))
+	if err != nil {
+		t.Fatalf("error putting blob: %v", err)
+	}
+	canonicalRef, err := reference.WithDigest(source.Named(), desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mount := func(name string) error {
+		_, err := makeRepository(t, registry, name).Blobs(ctx).Create(ctx, WithMountFrom(canonicalRef))
+		if _, ok := err.(distribution.ErrBlobMounted); ok {
+			return nil
+		}
+		if err == nil {
+			t.Fatalf("expected blob to be mounted into %s rather than uploaded", name)
+		}
+		return err
+	}
+
+	// The source repository counts towards the limit.
+	for i := 1; i < limit; i++ {
+		if err := mount(fmt.Sprintf("links/mount%d", i)); err != nil {
+			t.Fatalf("error mounting blob under the limit: %v", err)
+		}
+	}
+
+	expected := distribution.ErrBlobLinkLimitExceeded{Digest: desc.Digest, Limit: limit}
+	if err := mount("links/rejected"); err != expected {
+		t.Fatalf("expected mount over the limit to be rejected with %v, got %v", expected, err)
+	}
+	rejected := makeRepository(t, registry, "links/rejected")
+	if _, err := rejected.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("shared content")); err != expected {
+		t.Fatalf("expected push over the limit to be rejected with %v, got %v", expected, err)
+	}
+	if _, err := rejected.Blobs(ctx).Stat(ctx, desc.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected rejected blob to be unknown, got %v", err)
+	}
+
+	// Repositories already linking the blob may link it again.
+	if err := mount("links/mount1"); err != nil {
+		t.Fatalf("error mounting blob again: %v", err)
+	}
+
+	// Deleting the blob from a repository frees its place.
+	if err := makeRepository(t, registry, "links/mount1").Blobs(ctx).Delete(ctx, desc.Digest); err != nil {
+		t.Fatalf("error deleting blob: %v", err)
+	}
+	if err := mount("links/rejected"); err != nil {
+		t.Fatalf("error mounting blob once below the limit: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ManifestPushedAt returns the time the manifest identified by dgst was last
+// pushed to the named repository, being the modification time of its
+// revision link. A zero time is returned for an unknown manifest.
+func ManifestPushedAt(ctx context.Context, driver storagedriver.StorageDriver, name string, dgst digest.Digest) (time.Time, error) {
+	linkPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	fi, err := driver.Stat(ctx, linkPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// ManifestExpired reports whether the manifest identified by dgst was last
+// pushed to the named repository more than ttl before now. Manifests never
+// expire if ttl is not positive, and unknown manifests are not expired.
+func ManifestExpired(ctx context.Context, driver storagedriver.StorageDriver, name string, dgst digest.Digest, ttl time.Duration, now time.Time) (bool, error) {
+	if ttl <= 0 {
+		return false, nil
+	}
+
+	pushedAt, err := ManifestPushedAt(ctx, driver, name, dgst)
+	if err != nil || pushedAt.IsZero() {
+		return false, err
+	}
+
+	return now.Sub(pushedAt) > ttl, nil
+}
+
+// ExpireManifests removes the manifests of every repository of registry which
+// have expired at now, given the TTL returned by ttlFor for the repository,
+// along with the tags referring to them. The manifests removed are returned,
+// along with any errors encountered, which do not stop other repositories
+// being swept. The content of the manifests is left for garbage collection
+// to reclaim.
+func ExpireManifests(ctx context.Context, driver storagedriver.StorageDriver, registry distribution.Namespace, ttlFor func(name string) (time.Duration, error), now time.Time) ([]ManifestDel, []error) {
+	var (
+		expired []ManifestDel
+		errs    []error
+	)
+
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, append(errs, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator"))
+	}
+
+	err := repositoryEnumerator.Enumerate(ctx, func(name string) error {
+		ttl, err := ttlFor(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get manifest TTL of %s: %v", name, err))
+			return nil
+		}
+		if ttl <= 0 {
+			return nil
+		}
+
+		d, err := expireRepositoryManifests(ctx, driver, registry, name, ttl, now)
+		expired = append(expired, d...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to expire manifests of %s: %v", name, err))
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			errs = append(errs, err)
+		}
+	}
+
+	dcontext.GetLogger(ctx).Infof("Manifest expiry finished. Num expired=%d, num errors=%d", len(expired), len(errs))
+	return expired, errs
+}
+
+// expireRepositoryManifests removes the manifests of the named repository
+// which have expired at now, along with the tags referring to them.
+func expireRepositoryManifests(ctx context.Context, driver storagedriver.StorageDriver, registry distribution.Namespace, name string, ttl time.Duration, now time.Time) ([]ManifestDel, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return nil, err
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	// Manifests are removed once enumerated, so that the enumeration is not
+	// disturbed by the removal.
+	var dgsts []digest.Digest
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		expired, err := ManifestExpired(ctx, driver, name, dgst, ttl, now)
+		if err != nil {
+			return err
+		}
+		if expired {
+			dgsts = append(dgsts, dgst)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	if len(dgsts) == 0 {
+		return nil, nil
+	}
+
+	tagService := repository.Tags(ctx)
+	allTags, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return nil, err
+		}
+	}
+
+	var expired []ManifestDel
+	vacuum := NewVacuum(ctx, driver)
+	for _, dgst := range dgsts {
+		tags, err := tagService.Lookup(ctx, distribution.Descriptor{Digest: dgst})
+		if err != nil {
+			return expired, err
+		}
+		for _, tag := range tags {
+			if err := tagService.Untag(ctx, tag); err != nil {
+				return expired, err
+			}
+		}
+
+		// Every tag is passed, as the index of any tag may refer to the
+		// manifest from its history.
+		if err := vacuum.RemoveManifest(name, dgst, allTags); err != nil {
+			return expired, err
+		}
+		expired = append(expired, ManifestDel{Name: name, Digest: dgst, Tags: tags})
+	}
+
+	return expired, nil
+}
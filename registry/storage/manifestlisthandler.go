@@ -1,20 +1,27 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 
 	"github.com/docker/distribution"
 	dcontext "github.com/docker/distribution/context"
 	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema1"
 	"github.com/opencontainers/go-digest"
 )
 
 // manifestListHandler is a ManifestHandler that covers schema2 manifest lists.
 type manifestListHandler struct {
-	repository distribution.Repository
-	blobStore  distribution.BlobStore
-	ctx        context.Context
+	repository    distribution.Repository
+	blobStore     distribution.BlobStore
+	ctx           context.Context
+	strictDigests bool
+
+	// requireSignedChildren causes lists to be rejected unless every
+	// manifest they reference is a signed schema1 manifest.
+	requireSignedChildren bool
 }
 
 var _ ManifestHandler = &manifestListHandler{}
@@ -60,6 +67,10 @@ func (ms *manifestListHandler) Put(ctx context.Context, manifestList distributio
 // perspective of the registry. As a policy, the registry only tries to
 // store valid content, leaving trust policies of that content up to
 // consumers.
+//
+// The referenced manifests are checked as they are decoded from the payload,
+// rather than from a copy of every reference, so that verifying large lists
+// holds little beyond the payload itself.
 func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst manifestlist.DeserializedManifestList, skipDependencyVerification bool) error {
 	var errs distribution.ErrManifestVerification
 
@@ -77,15 +88,45 @@ func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst manifes
 			return err
 		}
 
-		for _, manifestDescriptor := range mnfst.References() {
-			exists, err := manifestService.Exists(ctx, manifestDescriptor.Digest)
+		_, payload, err := mnfst.Payload()
+		if err != nil {
+			return err
+		}
+
+		_, err = manifestlist.DecodeManifests(bytes.NewReader(payload), func(manifestDescriptor manifestlist.ManifestDescriptor) error {
+			dgst, err := normalizeReference(manifestDescriptor.Digest, ms.strictDigests)
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+
+			exists, err := manifestService.Exists(ctx, dgst)
 			if err != nil && err != distribution.ErrBlobUnknown {
 				errs = append(errs, err)
 			}
 			if err != nil || !exists {
 				// On error here, we always append unknown blob errors.
 				errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: manifestDescriptor.Digest})
+				if ms.requireSignedChildren {
+					errs = append(errs, distribution.ErrManifestUnverified{})
+				}
+				return nil
+			}
+
+			if ms.requireSignedChildren {
+				child, err := manifestService.Get(ctx, dgst)
+				if err != nil {
+					errs = append(errs, err)
+					return nil
+				}
+				if _, ok := child.(*schema1.SignedManifest); !ok {
+					errs = append(errs, distribution.ErrManifestUnverified{})
+				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
 	if len(errs) != 0 {
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+// TestVerifyLargeManifestList ensures that every manifest referenced by a
+// large manifest list is verified.
+func TestVerifyLargeManifestList(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/largelist")
+
+	manifestService, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest service: %v", err)
+	}
+
+	var images []image
+	for i := 0; i < 3; i++ {
+		images = append(images, uploadRandomSchema2Image(t, repo))
+	}
+
+	const entries = 5000
+	missing := digest.FromString("missing manifest")
+
+	descriptors := make([]manifestlist.ManifestDescriptor, entries)
+	for i := range descriptors {
+		dgst := images[i%len(images)].manifestDigest
+		if i == entries/2 {
+			dgst = missing
+		}
+		descriptors[i] = manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{
+				MediaType: schema2.MediaTypeManifest,
+				Digest:    dgst,
+			},
+			Platform: manifestlist.PlatformSpec{
+				Architecture: "amd64",
+				OS:           "linux",
+			},
+		}
+	}
+
+	list, err := manifestlist.FromDescriptors(descriptors)
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest list: %v", err)
+	}
+
+	_, err = manifestService.Put(ctx, list)
+	verificationErrs, ok := err.(distribution.ErrManifestVerification)
+	if !ok {
+		t.Fatalf("expected verification error putting manifest list, got %v", err)
+	}
+	if len(verificationErrs) != 1 {
+		t.Fatalf("unexpected number of verification errors: %d != 1: %v", len(verificationErrs), verificationErrs)
+	}
+	if unknown, ok := verificationErrs[0].(distribution.ErrManifestBlobUnknown); !ok || unknown.Digest != missing {
+		t.Fatalf("unexpected verification error: %v", verificationErrs[0])
+	}
+
+	descriptors[entries/2].Digest = images[0].manifestDigest
+	list, err = manifestlist.FromDescriptors(descriptors)
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest list: %v", err)
+	}
+
+	if _, err := manifestService.Put(ctx, list); err != nil {
+		t.Fatalf("unexpected error putting manifest list: %v", err)
+	}
+}
+
+// TestManifestListRequireSignedChildren ensures that, when signed children
+// are required, a manifest list is only accepted if every manifest it
+// references is a signed schema1 manifest.
+func TestManifestListRequireSignedChildren(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New(), RequireSignedChildren)
+	repo := makeRepository(t, registry, "foo/signedlist")
+	manifestService := makeManifestService(t, repo)
+
+	signed := uploadRandomSchema1Image(t, repo)
+	unsigned := uploadRandomSchema2Image(t, repo)
+
+	list, err := testutil.MakeManifestList(registry.BlobStatter(), []digest.Digest{signed.manifestDigest, unsigned.manifestDigest})
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest list: %v", err)
+	}
+
+	_, err = manifestService.Put(ctx, list)
+	verificationErrs, ok := err.(distribution.ErrManifestVerification)
+	if !ok {
+		t.Fatalf("expected verification error putting manifest list, got %v", err)
+	}
+	if len(verificationErrs) != 1 {
+		t.Fatalf("unexpected number of verification errors: %d != 1: %v", len(verificationErrs), verificationErrs)
+	}
+	if _, ok := verificationErrs[0].(distribution.ErrManifestUnverified); !ok {
+		t.Fatalf("unexpected verification error: %v", verificationErrs[0])
+	}
+
+	list, err = testutil.MakeManifestList(registry.BlobStatter(), []digest.Digest{signed.manifestDigest})
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest list: %v", err)
+	}
+	if _, err := manifestService.Put(ctx, list); err != nil {
+		t.Fatalf("unexpected error putting manifest list of signed manifests: %v", err)
+	}
+}
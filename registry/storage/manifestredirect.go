@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/docker/distribution"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ManifestURL returns a URL from which the manifest with digest dgst and
+// length size may be fetched directly from the storage backend, using the
+// HTTP method given. Manifests are stored in the blob store, so the URL serves
+// the manifest exactly as it was pushed. The manifest's content is resolved
+// as it is when served by the registry, looking for it in every form it may
+// be stored in; a manifest stored inline or compressed cannot be served by
+// the backend as pushed, so an empty URL is returned and the manifest must be
+// served by the registry. If the driver cannot produce URLs,
+// storagedriver.ErrUnsupportedMethod is returned.
+func ManifestURL(ctx context.Context, driver storagedriver.StorageDriver, dgst digest.Digest, size int64, method string) (string, error) {
+	bs := &blobStore{
+		driver:     driver,
+		inlineSize: maxInlineBlobSize,
+		compressed: true,
+	}
+
+	path, form, err := bs.contentPath(ctx, distribution.Descriptor{Digest: dgst, Size: size})
+	if err != nil {
+		return "", err
+	}
+	if form != blobFormData {
+		return "", nil
+	}
+
+	return driver.URLFor(ctx, path, map[string]interface{}{"method": method})
+}
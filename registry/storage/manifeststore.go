@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -129,20 +130,114 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	if ms.repository.canonicalManifests {
+		if err := verifyCanonical(manifest); err != nil {
+			return "", err
+		}
+	}
+
+	validateOnly := false
+	for _, option := range options {
+		if _, ok := option.(distribution.WithValidateOnlyOption); ok {
+			validateOnly = true
+		}
+	}
+
+	skipDependencyVerification := ms.skipDependencyVerification
+	if validateOnly || len(ms.repository.admissionHooks) > 0 {
+		dgst, err := ms.verify(manifest)
+		if err != nil {
+			return "", err
+		}
+		for _, hook := range ms.repository.admissionHooks {
+			if err := hook.Admit(ctx, ms.repository.Named(), dgst, manifest); err != nil {
+				return "", err
+			}
+		}
+		if validateOnly {
+			return dgst, nil
+		}
+
+		// The manifest has been verified already.
+		skipDependencyVerification = true
+	}
+
 	switch manifest.(type) {
 	case *schema1.SignedManifest:
-		return ms.schema1Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		return ms.schema1Handler.Put(ctx, manifest, skipDependencyVerification)
 	case *schema2.DeserializedManifest:
-		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		return ms.schema2Handler.Put(ctx, manifest, skipDependencyVerification)
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		return ms.ocischemaHandler.Put(ctx, manifest, skipDependencyVerification)
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		return ms.manifestListHandler.Put(ctx, manifest, skipDependencyVerification)
 	}
 
 	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 }
 
+// verify performs the verification Put would perform before storing
+// manifest, returning the digest manifest would be stored under.
+func (ms *manifestStore) verify(manifest distribution.Manifest) (digest.Digest, error) {
+	var err error
+	switch m := manifest.(type) {
+	case *schema1.SignedManifest:
+		handler, ok := ms.schema1Handler.(*signedManifestHandler)
+		if !ok {
+			return "", distribution.ErrSchemaV1Unsupported
+		}
+		if err := handler.verifyManifest(handler.ctx, *m, ms.skipDependencyVerification); err != nil {
+			return "", err
+		}
+		return digest.FromBytes(m.Canonical), nil
+	case *schema2.DeserializedManifest:
+		handler := ms.schema2Handler.(*schema2ManifestHandler)
+		err = handler.verifyManifest(handler.ctx, *m, ms.skipDependencyVerification)
+	case *ocischema.DeserializedManifest:
+		handler := ms.ocischemaHandler.(*ocischemaManifestHandler)
+		err = handler.verifyManifest(handler.ctx, *m, ms.skipDependencyVerification)
+	case *manifestlist.DeserializedManifestList:
+		handler := ms.manifestListHandler.(*manifestListHandler)
+		err = handler.verifyManifest(handler.ctx, *m, ms.skipDependencyVerification)
+	default:
+		return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+
+	return digest.FromBytes(payload), nil
+}
+
+// verifyCanonical checks that the payload of m is in canonical JSON form.
+// Schema1 manifests are not checked.
+func verifyCanonical(m distribution.Manifest) error {
+	if _, ok := m.(*schema1.SignedManifest); ok {
+		return nil
+	}
+
+	_, payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+
+	canonical, err := manifest.Canonicalize(payload)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(canonical, payload) {
+		return distribution.ErrManifestVerification{distribution.ErrManifestNotCanonical{}}
+	}
+
+	return nil
+}
+
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
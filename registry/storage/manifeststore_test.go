@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
@@ -575,3 +578,304 @@ func TestLinkPathFuncs(t *testing.T) {
 		}
 	}
 }
+
+// TestManifestStorageCompression ensures that manifests stored gzipped at rest
+// are returned with the same payload and digest as uncompressed manifests.
+func TestManifestStorageCompression(t *testing.T) {
+	rs, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("unexpected error generating test layer file: %v", err)
+	}
+	layer, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("unexpected error reading test layer file: %v", err)
+	}
+
+	putAndGet := func(options ...RegistryOption) (digest.Digest, []byte, []byte) {
+		repoName, _ := reference.WithName("foo/bar")
+		env := newManifestStoreTestEnv(t, repoName, "thetag", options...)
+
+		ms, err := env.repository.Manifests(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blobStore := env.repository.Blobs(env.ctx)
+		if _, err := blobStore.Put(env.ctx, v1.MediaTypeImageLayer, layer); err != nil {
+			t.Fatalf("unexpected error putting layer: %v", err)
+		}
+
+		builder := ocischema.NewManifestBuilder(blobStore, []byte{}, map[string]string{})
+		if err := builder.AppendReference(distribution.Descriptor{Digest: layerDigest}); err != nil {
+			t.Fatalf("unexpected error appending layer: %v", err)
+		}
+		m, err := builder.Build(env.ctx)
+		if err != nil {
+			t.Fatalf("unexpected error building manifest: %v", err)
+		}
+
+		dgst, err := ms.Put(env.ctx, m)
+		if err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+
+		fetched, err := ms.Get(env.ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest: %v", err)
+		}
+		_, payload, err := fetched.Payload()
+		if err != nil {
+			t.Fatalf("unexpected error getting payload: %v", err)
+		}
+
+		// The blob is read and served as the manifest, however it is
+		// stored.
+		reg := env.registry.(*registry)
+		rsc, err := reg.blobStore.Open(env.ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error opening manifest blob: %v", err)
+		}
+		opened, err := ioutil.ReadAll(rsc)
+		rsc.Close()
+		if err != nil {
+			t.Fatalf("unexpected error reading manifest blob: %v", err)
+		}
+		if !bytes.Equal(opened, payload) {
+			t.Fatalf("unexpected content for manifest blob: %s != %s", opened, payload)
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if err := reg.blobServer.ServeBlob(env.ctx, w, r, dgst); err != nil {
+			t.Fatalf("unexpected error serving manifest blob: %v", err)
+		}
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), payload) {
+			t.Fatalf("unexpected response serving manifest blob: %d %s", w.Code, w.Body.Bytes())
+		}
+
+		// The size of the blob is always that of the manifest.
+		desc, err := env.registry.BlobStatter().Stat(env.ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error stating manifest blob: %v", err)
+		}
+		if desc.Size != int64(len(payload)) {
+			t.Fatalf("unexpected size for manifest blob: %d != %d", desc.Size, len(payload))
+		}
+
+		// The data file, if present, always matches the digest.
+		bp, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := env.driver.GetContent(env.ctx, bp)
+		if err == nil && digest.FromBytes(data) != dgst {
+			t.Fatalf("stored data does not match digest %v", dgst)
+		}
+
+		var enumerated bool
+		err = env.registry.Blobs().Enumerate(env.ctx, func(enumeratedDgst digest.Digest) error {
+			enumerated = enumerated || enumeratedDgst == dgst
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error enumerating blobs: %v", err)
+		}
+		if !enumerated {
+			t.Fatalf("manifest blob %v not enumerated", dgst)
+		}
+
+		cp, err := pathFor(blobCompressedDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := env.driver.GetContent(env.ctx, cp)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				t.Fatalf("unexpected error reading compressed manifest: %v", err)
+			}
+		}
+
+		return dgst, payload, compressed
+	}
+
+	dgst, payload, stored := putAndGet()
+	if stored != nil {
+		t.Fatalf("manifest unexpectedly stored compressed")
+	}
+
+	compressedDgst, compressedPayload, compressedStored := putAndGet(EnableManifestCompression)
+	if !isGzipped(compressedStored) {
+		t.Fatalf("manifest not stored compressed")
+	}
+
+	if compressedDgst != dgst {
+		t.Fatalf("unexpected digest for compressed manifest: %v != %v", compressedDgst, dgst)
+	}
+
+	if !bytes.Equal(compressedPayload, payload) {
+		t.Fatalf("unexpected payload for compressed manifest: %s != %s", compressedPayload, payload)
+	}
+
+	if digest.FromBytes(compressedPayload) != dgst {
+		t.Fatalf("compressed manifest payload does not match digest %v", dgst)
+	}
+}
+
+func TestManifestStorageLayerOrder(t *testing.T) {
+	repoName, _ := reference.WithName("foo/bar")
+	env := newManifestStoreTestEnv(t, repoName, "thetag", EnableSchema1, EnforceLayerOrder)
+	ms, err := env.repository.Manifests(env.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	blobStore := env.repository.Blobs(env.ctx)
+	var layers []schema1.FSLayer
+	for i := 0; i < 2; i++ {
+		rs, _, err := testutil.CreateRandomTarFile()
+		if err != nil {
+			t.Fatalf("unexpected error generating test layer file: %v", err)
+		}
+		p, err := ioutil.ReadAll(rs)
+		if err != nil {
+			t.Fatalf("unexpected error reading test layer file: %v", err)
+		}
+		desc, err := blobStore.Put(env.ctx, v1.MediaTypeImageLayer, p)
+		if err != nil {
+			t.Fatalf("unexpected error putting layer: %v", err)
+		}
+		layers = append(layers, schema1.FSLayer{BlobSum: desc.Digest})
+	}
+
+	for _, testcase := range []struct {
+		name    string
+		history []string
+		valid   bool
+	}{
+		{
+			name:    "ordered",
+			history: []string{`{"id":"b","parent":"a"}`, `{"id":"a"}`},
+			valid:   true,
+		},
+		{
+			name:    "missing history",
+			history: []string{`{"id":"a"}`},
+		},
+		{
+			name:    "reversed",
+			history: []string{`{"id":"a"}`, `{"id":"b","parent":"a"}`},
+		},
+		{
+			name:    "repeated id",
+			history: []string{`{"id":"a","parent":"a"}`, `{"id":"a"}`},
+		},
+	} {
+		m := schema1.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 1},
+			Name:      env.name.Name(),
+			Tag:       env.tag,
+			FSLayers:  layers,
+		}
+		for _, h := range testcase.history {
+			m.History = append(m.History, schema1.History{V1Compatibility: h})
+		}
+
+		sm, err := schema1.Sign(&m, pk)
+		if err != nil {
+			t.Fatalf("%s: error signing manifest: %v", testcase.name, err)
+		}
+
+		_, err = ms.Put(env.ctx, sm)
+		if testcase.valid {
+			if err != nil {
+				t.Fatalf("%s: unexpected error putting manifest: %v", testcase.name, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(distribution.ErrManifestVerification)
+		if !ok || len(verr) != 1 {
+			t.Fatalf("%s: expected a single verification error, got %v", testcase.name, err)
+		}
+		if _, ok := verr[0].(distribution.ErrManifestLayerOrder); !ok {
+			t.Fatalf("%s: expected ErrManifestLayerOrder, got %#v", testcase.name, verr[0])
+		}
+	}
+}
+
+func TestManifestStorageSignatureAlgorithms(t *testing.T) {
+	repoName, _ := reference.WithName("foo/bar")
+	env := newManifestStoreTestEnv(t, repoName, "thetag", EnableSchema1, AllowedSignatureAlgorithms([]string{"ES384"}))
+	ms, err := env.repository.Manifests(env.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, _, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("unexpected error generating test layer file: %v", err)
+	}
+	p, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("unexpected error reading test layer file: %v", err)
+	}
+	desc, err := env.repository.Blobs(env.ctx).Put(env.ctx, v1.MediaTypeImageLayer, p)
+	if err != nil {
+		t.Fatalf("unexpected error putting layer: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		name     string
+		generate func() (libtrust.PrivateKey, error)
+		valid    bool
+	}{
+		{
+			name:     "ES384",
+			generate: libtrust.GenerateECP384PrivateKey,
+			valid:    true,
+		},
+		{
+			name:     "ES256",
+			generate: libtrust.GenerateECP256PrivateKey,
+		},
+	} {
+		pk, err := testcase.generate()
+		if err != nil {
+			t.Fatalf("%s: unexpected error generating private key: %v", testcase.name, err)
+		}
+
+		m := schema1.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 1},
+			Name:      env.name.Name(),
+			Tag:       env.tag,
+			FSLayers:  []schema1.FSLayer{{BlobSum: desc.Digest}},
+			History:   []schema1.History{{V1Compatibility: `{"id":"a"}`}},
+		}
+
+		sm, err := schema1.Sign(&m, pk)
+		if err != nil {
+			t.Fatalf("%s: error signing manifest: %v", testcase.name, err)
+		}
+
+		_, err = ms.Put(env.ctx, sm)
+		if testcase.valid {
+			if err != nil {
+				t.Fatalf("%s: unexpected error putting manifest: %v", testcase.name, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(distribution.ErrManifestVerification)
+		if !ok || len(verr) != 1 {
+			t.Fatalf("%s: expected a single verification error, got %v", testcase.name, err)
+		}
+		if _, ok := verr[0].(distribution.ErrManifestUnverified); !ok {
+			t.Fatalf("%s: expected ErrManifestUnverified, got %#v", testcase.name, verr[0])
+		}
+	}
+}
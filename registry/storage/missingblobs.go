@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// MissingBlob describes a blob referenced by a manifest but absent from the
+// blob store.
+type MissingBlob struct {
+	// Digest identifies the missing blob.
+	Digest digest.Digest
+
+	// Repository is the name of the repository holding the manifest.
+	Repository string
+
+	// Manifest is the digest of the manifest referencing the blob. If the
+	// manifest itself is missing, Manifest and Digest are equal.
+	Manifest digest.Digest
+}
+
+// FindMissingBlobs walks the manifest revisions of every repository in the
+// registry and checks that each manifest, and each blob it references, is
+// present in the blob store. References are gathered as garbage collection
+// marks them. fn is called for each missing blob and referencing manifest as
+// they are found, so that large registries may be reported on as they are
+// walked. A blob referenced by several manifests is reported once for each.
+// Walking stops at the first error returned by fn, which is returned.
+//
+// Revisions are read from their links, rather than through the manifest
+// service, which skips revisions whose manifest is missing.
+func FindMissingBlobs(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, fn func(MissingBlob) error) error {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	blobStore := &blobStore{driver: storageDriver}
+	statter := registry.BlobStatter()
+
+	// Each blob is only checked once, however many manifests reference it.
+	present := make(map[digest.Digest]bool)
+	isPresent := func(dgst digest.Digest) (bool, error) {
+		if p, ok := present[dgst]; ok {
+			return p, nil
+		}
+
+		_, err := statter.Stat(ctx, dgst)
+		switch err {
+		case nil:
+			present[dgst] = true
+		case distribution.ErrBlobUnknown:
+			present[dgst] = false
+		default:
+			return false, err
+		}
+		return present[dgst], nil
+	}
+
+	return repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+
+		revisionsPath, err := pathFor(manifestRevisionsPathSpec{name: repoName})
+		if err != nil {
+			return err
+		}
+
+		err = storageDriver.Walk(ctx, revisionsPath, func(fileInfo driver.FileInfo) error {
+			if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+				return nil
+			}
+
+			dgst, err := blobStore.readlink(ctx, fileInfo.Path())
+			if err != nil {
+				return fmt.Errorf("failed to read revision link %s: %v", fileInfo.Path(), err)
+			}
+
+			ok, err := isPresent(dgst)
+			if err != nil {
+				return fmt.Errorf("failed to stat manifest %v: %v", dgst, err)
+			}
+			if !ok {
+				return fn(MissingBlob{Digest: dgst, Repository: repoName, Manifest: dgst})
+			}
+
+			manifest, err := manifestService.Get(ctx, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+			}
+
+			for _, descriptor := range manifest.References() {
+				// Blobs with URLs are fetched from elsewhere, so need
+				// not be present.
+				if len(descriptor.URLs) > 0 {
+					continue
+				}
+
+				referenced := canonicalDigest(descriptor.Digest)
+				ok, err := isPresent(referenced)
+				if err != nil {
+					return fmt.Errorf("failed to stat blob %v: %v", referenced, err)
+				}
+				if !ok {
+					if err := fn(MissingBlob{Digest: referenced, Repository: repoName, Manifest: dgst}); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+
+		// A repository may hold no manifests, such as one with only
+		// unfinished uploads.
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+
+		return err
+	})
+}
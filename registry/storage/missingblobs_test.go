@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestFindMissingBlobs(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "foo/gaps")
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+
+	findMissing := func() []MissingBlob {
+		var missing []MissingBlob
+		err := FindMissingBlobs(ctx, d, registry, func(blob MissingBlob) error {
+			missing = append(missing, blob)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("error finding missing blobs: %v", err)
+		}
+		return missing
+	}
+
+	if missing := findMissing(); len(missing) != 0 {
+		t.Fatalf("unexpected missing blobs: %v", missing)
+	}
+
+	removeBlob := func(dgst digest.Digest) {
+		blobPath, err := pathFor(blobPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Delete(ctx, blobPath); err != nil {
+			t.Fatalf("error deleting blob %s: %v", dgst, err)
+		}
+	}
+
+	// Remove a layer of one image, and the manifest of the other.
+	layer := getAnyKey(image1.layers)
+	removeBlob(layer)
+	removeBlob(image2.manifestDigest)
+
+	missing := findMissing()
+	expected := map[digest.Digest]MissingBlob{
+		layer: {
+			Digest:     layer,
+			Repository: "foo/gaps",
+			Manifest:   image1.manifestDigest,
+		},
+		image2.manifestDigest: {
+			Digest:     image2.manifestDigest,
+			Repository: "foo/gaps",
+			Manifest:   image2.manifestDigest,
+		},
+	}
+	found := make(map[digest.Digest]MissingBlob)
+	for _, blob := range missing {
+		found[blob.Digest] = blob
+	}
+	if len(missing) != len(expected) || !reflect.DeepEqual(found, expected) {
+		t.Fatalf("unexpected missing blobs: %v != %v", missing, expected)
+	}
+}
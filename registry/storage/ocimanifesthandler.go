@@ -14,10 +14,13 @@ import (
 
 //ocischemaManifestHandler is a ManifestHandler that covers ocischema manifests.
 type ocischemaManifestHandler struct {
-	repository   distribution.Repository
-	blobStore    distribution.BlobStore
-	ctx          context.Context
-	manifestURLs manifestURLs
+	repository    distribution.Repository
+	blobStore     distribution.BlobStore
+	ctx           context.Context
+	manifestURLs  manifestURLs
+	strictDigests bool
+	platforms     platformAllowlist
+	archives      layerArchives
 }
 
 var _ ManifestHandler = &ocischemaManifestHandler{}
@@ -81,10 +84,19 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 	blobsService := ms.repository.Blobs(ctx)
 
 	for _, descriptor := range mnfst.References() {
-		var err error
+		dgst, err := normalizeReference(descriptor.Digest, ms.strictDigests)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		descriptor.Digest = dgst
 
 		switch descriptor.MediaType {
 		case v1.MediaTypeImageLayer, v1.MediaTypeImageLayerGzip, v1.MediaTypeImageLayerNonDistributable, v1.MediaTypeImageLayerNonDistributableGzip:
+			if u := ms.manifestURLs.insecureURL(descriptor.URLs); u != "" {
+				errs = append(errs, distribution.ErrManifestURLInsecure{URL: u})
+				continue
+			}
 			allow := ms.manifestURLs.allow
 			deny := ms.manifestURLs.deny
 			for _, u := range descriptor.URLs {
@@ -125,6 +137,24 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 		}
 	}
 
+	// Layers are read in full, so they are validated only once the manifest
+	// is known to reference no missing blobs.
+	if len(errs) == 0 {
+		for _, layer := range mnfst.Layers {
+			if len(layer.URLs) != 0 {
+				continue
+			}
+			layer.Digest = canonicalDigest(layer.Digest)
+			if err := ms.archives.verify(ctx, blobsService, layer); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := ms.platforms.verify(ctx, blobsService, mnfst.Config); err != nil {
+		errs = append(errs, err)
+	}
+
 	if len(errs) != 0 {
 		return errs
 	}
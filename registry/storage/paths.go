@@ -34,14 +34,28 @@ const (
 //							-> current/link
 // 							-> index
 //								-> <algorithm>/<hex digest>/link
+// 							-> history
+// 							-> alias
+// 							-> aliases/<alias tag>
 // 					-> _layers/
 // 						<layer links to blob store>
 // 					-> _uploads/<id>
 // 						data
 // 						startedat
 // 						hashstates/<algorithm>/<offset>
+// 					-> _usage/bytes
+// 					-> _creating
+// 					-> _quarantine/manifests/<algorithm>/<hex digest>
+// 						data
+// 						reason
+// 						quarantinedat
 //			-> blob/<algorithm>
 //				<split directory content addressable storage>
+//			-> blobindex/<algorithm>
+//				<size of each blob, split as in the blob store>
+//			-> blobindex/_built
+//			-> registrations/<name>/_registered
+//			-> layout
 //
 // The storage backend layout is broken up into a content-addressable blob
 // store and repositories. The content-addressable blob store holds most data
@@ -83,10 +97,15 @@ const (
 // 	manifestTagIndexPathSpec:              <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/
 // 	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 // 	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
+// 	manifestTagHistoryPathSpec:            <root>/v2/repositories/<name>/_manifests/tags/<tag>/history
+// 	manifestTagPushedAtPathSpec:           <root>/v2/repositories/<name>/_manifests/tags/<tag>/pushedat
+// 	manifestTagAliasPathSpec:              <root>/v2/repositories/<name>/_manifests/tags/<tag>/alias
+// 	manifestTagAliasesPathSpec:            <root>/v2/repositories/<name>/_manifests/tags/<tag>/aliases/<alias>
 //
 // 	Blobs:
 //
 // 	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
+// 	layerMediaTypePathSpec:       <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/mediatype
 // 	layersPathSpec:               <root>/v2/repositories/<name>/_layers
 //
 //	Uploads:
@@ -94,14 +113,60 @@ const (
 // 	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
 // 	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
 // 	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
+// 	uploadKeyRefPathSpec:           <root>/v2/repositories/<name>/_uploads/<id>/idempotencykey
+// 	uploadIdempotencyKeyPathSpec:   <root>/v2/repositories/<name>/_uploadkeys/<hex digest of principal and key>
+//
+//	Usage:
+//
+// 	repositoryUsagePathSpec:        <root>/v2/repositories/<name>/_usage/bytes
+//
+//	Creation:
+//
+// 	repositoryCreationPathSpec:     <root>/v2/repositories/<name>/_creating
+//
+//	Events:
+//
+// 	tagEventLogPathSpec:            <root>/v2/repositories/<name>/_events/tags
+//
+//	Quarantine:
+//
+// 	quarantinedManifestDataPathSpec:   <root>/v2/repositories/<name>/_quarantine/manifests/<algorithm>/<hex digest>/data
+// 	quarantinedManifestReasonPathSpec: <root>/v2/repositories/<name>/_quarantine/manifests/<algorithm>/<hex digest>/reason
+// 	quarantinedManifestQuarantinedAtPathSpec: <root>/v2/repositories/<name>/_quarantine/manifests/<algorithm>/<hex digest>/quarantinedat
+// 	quarantinedManifestsPathSpec:      <root>/v2/repositories/<name>/_quarantine/manifests
 //
 //	Blob Store:
 //
 //	blobsPathSpec:                  <root>/v2/blobs/
 // 	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 // 	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+// 	blobCompressedDataPathSpec:     <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data.gz
+// 	blobCompressedSizePathSpec:     <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/size
+// 	blobInlineDataPathSpec:         <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/inline
+// 	blobArchiveValidPathSpec:       <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/archivevalid
+// 	blobCorruptDataPathSpec:        <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/corrupt
+// 	blobLinkersPathSpec:            <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/_linkers
+// 	blobLinkerPathSpec:             <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/_linkers/<hex digest of name>
 // 	blobMediaTypePathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //
+//	Blob Index:
+//
+//	blobIndexPathSpec:              <root>/v2/blobindex/
+//	blobIndexBuiltPathSpec:         <root>/v2/blobindex/_built
+// 	blobIndexEntryPathSpec:         <root>/v2/blobindex/<algorithm>/<first two hex bytes of digest>/<hex digest>
+//
+//	Registrations:
+//
+// 	repositoryRegistrationPathSpec: <root>/v2/registrations/<name>/_registered
+//
+//	Repository Policies:
+//
+// 	repositoryPolicyPathSpec:       <root>/v2/policies/<name>/_policy
+//
+//	Layout:
+//
+// 	layoutVersionPathSpec:          <root>/v2/layout
+//
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
 func pathFor(spec pathSpec) (string, error) {
@@ -193,6 +258,38 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, path.Join(components...)), nil
+	case manifestTagHistoryPathSpec:
+		root, err := pathFor(manifestTagPathSpec(v))
+
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "history"), nil
+	case manifestTagPushedAtPathSpec:
+		root, err := pathFor(manifestTagPathSpec(v))
+
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "pushedat"), nil
+	case manifestTagAliasPathSpec:
+		root, err := pathFor(manifestTagPathSpec(v))
+
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "alias"), nil
+	case manifestTagAliasesPathSpec:
+		root, err := pathFor(manifestTagPathSpec{name: v.name, tag: v.tag})
+
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "aliases", v.alias), nil
 	case layerLinkPathSpec:
 		components, err := digestPathComponents(v.digest, false)
 		if err != nil {
@@ -207,6 +304,15 @@ func pathFor(spec pathSpec) (string, error) {
 		blobLinkPathComponents := append(repoPrefix, v.name, "_layers")
 
 		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "link"), nil
+	case layerMediaTypePathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		blobLinkPathComponents := append(repoPrefix, v.name, "_layers")
+
+		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "mediatype"), nil
 	case layersPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_layers")...), nil
 	case blobsPathSpec:
@@ -220,6 +326,21 @@ func pathFor(spec pathSpec) (string, error) {
 
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobIndexPathSpec:
+		return path.Join(append(rootPrefix, "blobindex")...), nil
+	case blobIndexBuiltPathSpec:
+		return path.Join(append(rootPrefix, "blobindex", "_built")...), nil
+	case blobIndexEntryPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(rootPrefix, "blobindex"), components...)...), nil
+	case repositoryRegistrationPathSpec:
+		return path.Join(append(rootPrefix, "registrations", v.name, "_registered")...), nil
+	case repositoryPolicyPathSpec:
+		return path.Join(append(rootPrefix, "policies", v.name, "_policy")...), nil
 	case blobDataPathSpec:
 		components, err := digestPathComponents(v.digest, true)
 		if err != nil {
@@ -230,6 +351,69 @@ func pathFor(spec pathSpec) (string, error) {
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
 
+	case blobCompressedDataPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "data.gz")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobCompressedSizePathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "size")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobInlineDataPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "inline")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobArchiveValidPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "archivevalid")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobCorruptDataPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "corrupt")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobLinkersPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "_linkers")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobLinkerPathSpec:
+		linkersPath, err := pathFor(blobLinkersPathSpec{digest: v.digest})
+		if err != nil {
+			return "", err
+		}
+
+		// Names are hashed so that the markers of nested names are
+		// siblings, and may be counted by listing their directory.
+		return path.Join(linkersPath, digest.FromString(v.name).Hex()), nil
 	case uploadDataPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
 	case uploadStartedAtPathSpec:
@@ -240,8 +424,53 @@ func pathFor(spec pathSpec) (string, error) {
 			offset = "" // Limit to the prefix for listing offsets.
 		}
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
+	case uploadIdempotencyKeyPathSpec:
+		// Keys are arbitrary client strings, so they are hashed to a safe
+		// path component. The principal is hashed with the key so that
+		// clients cannot resume each other's uploads by guessing keys.
+		scoped := v.principal + "\x00" + v.key
+		return path.Join(append(repoPrefix, v.name, "_uploadkeys", digest.FromString(scoped).Hex())...), nil
+	case uploadKeyRefPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "idempotencykey")...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case layoutVersionPathSpec:
+		return path.Join(append(rootPrefix, "layout")...), nil
+	case repositoryUsagePathSpec:
+		return path.Join(append(repoPrefix, v.name, "_usage", "bytes")...), nil
+	case tagEventLogPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_events", "tags")...), nil
+	case repositoryCreationPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_creating")...), nil
+	case quarantinedManifestDataPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		quarantinePathComponents := append(repoPrefix, v.name, "_quarantine", "manifests")
+
+		return path.Join(path.Join(append(quarantinePathComponents, components...)...), "data"), nil
+	case quarantinedManifestReasonPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		quarantinePathComponents := append(repoPrefix, v.name, "_quarantine", "manifests")
+
+		return path.Join(path.Join(append(quarantinePathComponents, components...)...), "reason"), nil
+	case quarantinedManifestQuarantinedAtPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		quarantinePathComponents := append(repoPrefix, v.name, "_quarantine", "manifests")
+
+		return path.Join(path.Join(append(quarantinePathComponents, components...)...), "quarantinedat"), nil
+	case quarantinedManifestsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_quarantine", "manifests")...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -328,6 +557,44 @@ type manifestTagIndexEntryPathSpec struct {
 
 func (manifestTagIndexEntryPathSpec) pathSpec() {}
 
+// manifestTagHistoryPathSpec describes the file recording the revisions a tag
+// has resolved to over time.
+type manifestTagHistoryPathSpec struct {
+	name string
+	tag  string
+}
+
+func (manifestTagHistoryPathSpec) pathSpec() {}
+
+// manifestTagPushedAtPathSpec describes the file recording when a tag was
+// last pushed.
+type manifestTagPushedAtPathSpec struct {
+	name string
+	tag  string
+}
+
+func (manifestTagPushedAtPathSpec) pathSpec() {}
+
+// manifestTagAliasPathSpec describes the file naming the tag that an alias
+// tag resolves through. An alias has no current link of its own.
+type manifestTagAliasPathSpec struct {
+	name string
+	tag  string
+}
+
+func (manifestTagAliasPathSpec) pathSpec() {}
+
+// manifestTagAliasesPathSpec describes the reverse index of the aliases of a
+// tag, holding an empty file for each alias. If alias is empty, the path of
+// the directory holding the index is returned.
+type manifestTagAliasesPathSpec struct {
+	name  string
+	tag   string
+	alias string
+}
+
+func (manifestTagAliasesPathSpec) pathSpec() {}
+
 // manifestTagIndexEntryLinkPathSpec describes the link to a revisions of a
 // manifest with given tag within the index.
 type manifestTagIndexEntryLinkPathSpec struct {
@@ -364,6 +631,18 @@ type layerLinkPathSpec struct {
 
 func (layerLinkPathSpec) pathSpec() {}
 
+// layerMediaTypePathSpec specifies the path to the media type recorded for a
+// blob linked into a repository, when media types are preserved. The file
+// holds the media type alone, such as:
+//
+// 	application/vnd.docker.image.rootfs.diff.tar.gzip
+type layerMediaTypePathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (layerMediaTypePathSpec) pathSpec() {}
+
 // blobAlgorithmReplacer does some very simple path sanitization for user
 // input. Paths should be "safe" before getting this far due to strict digest
 // requirements but we can add further path conversion here, if needed.
@@ -385,6 +664,43 @@ type blobPathSpec struct {
 
 func (blobPathSpec) pathSpec() {}
 
+// blobIndexPathSpec contains the path for the index of the blob store.
+type blobIndexPathSpec struct{}
+
+func (blobIndexPathSpec) pathSpec() {}
+
+// blobIndexBuiltPathSpec contains the path of the marker written once the
+// index of the blob store has been built. It holds the time of the build.
+type blobIndexBuiltPathSpec struct{}
+
+func (blobIndexBuiltPathSpec) pathSpec() {}
+
+// blobIndexEntryPathSpec contains the path for the entry of a blob in the
+// index of the blob store. The entry holds the size of the blob.
+type blobIndexEntryPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobIndexEntryPathSpec) pathSpec() {}
+
+// repositoryRegistrationPathSpec describes the marker recording that the
+// named repository has been registered. The marker lives in its own
+// directory so that registrations of nested names do not collide.
+type repositoryRegistrationPathSpec struct {
+	name string
+}
+
+func (repositoryRegistrationPathSpec) pathSpec() {}
+
+// repositoryPolicyPathSpec describes the document holding the policy
+// overrides of the named repository. As with registrations, the document
+// lives in its own directory so that policies of nested names do not collide.
+type repositoryPolicyPathSpec struct {
+	name string
+}
+
+func (repositoryPolicyPathSpec) pathSpec() {}
+
 // blobDataPathSpec contains the path for the registry global blob store. For
 // now, this contains layer data, exclusively.
 type blobDataPathSpec struct {
@@ -393,6 +709,66 @@ type blobDataPathSpec struct {
 
 func (blobDataPathSpec) pathSpec() {}
 
+// blobCompressedDataPathSpec contains the path of the gzipped content of a
+// blob stored compressed, which takes the place of its data file. The digest
+// is that of the uncompressed content.
+type blobCompressedDataPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobCompressedDataPathSpec) pathSpec() {}
+
+// blobCompressedSizePathSpec contains the path of the file recording the
+// uncompressed size of a blob stored compressed.
+type blobCompressedSizePathSpec struct {
+	digest digest.Digest
+}
+
+func (blobCompressedSizePathSpec) pathSpec() {}
+
+// blobInlineDataPathSpec contains the path of the content of a small blob
+// stored inline, which takes the place of its data file. The content is
+// written in a single operation and always served by the registry itself.
+type blobInlineDataPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobInlineDataPathSpec) pathSpec() {}
+
+// blobArchiveValidPathSpec contains the path of the file marking a blob as
+// having been validated as a layer archive.
+type blobArchiveValidPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobArchiveValidPathSpec) pathSpec() {}
+
+// blobCorruptDataPathSpec contains the path to which the content of a blob is
+// moved when it is found not to match the blob's digest.
+type blobCorruptDataPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobCorruptDataPathSpec) pathSpec() {}
+
+// blobLinkersPathSpec contains the path of the directory holding a marker for
+// each repository which links a blob, when the number of such repositories
+// is limited.
+type blobLinkersPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobLinkersPathSpec) pathSpec() {}
+
+// blobLinkerPathSpec contains the path of the marker recording that the named
+// repository links a blob. The marker holds the name.
+type blobLinkerPathSpec struct {
+	digest digest.Digest
+	name   string
+}
+
+func (blobLinkerPathSpec) pathSpec() {}
+
 // uploadDataPathSpec defines the path parameters of the data file for
 // uploads.
 type uploadDataPathSpec struct {
@@ -430,12 +806,99 @@ type uploadHashStatePathSpec struct {
 
 func (uploadHashStatePathSpec) pathSpec() {}
 
+// uploadIdempotencyKeyPathSpec defines the path parameters for the file that
+// stores the id of the upload session created with a client-supplied key by
+// an authenticated principal, which is empty for anonymous clients.
+type uploadIdempotencyKeyPathSpec struct {
+	name      string
+	principal string
+	key       string
+}
+
+func (uploadIdempotencyKeyPathSpec) pathSpec() {}
+
+// uploadKeyRefPathSpec defines the path parameters for the file in an upload
+// directory that stores the path of the idempotency key which refers to the
+// upload, so that the key can be removed with the upload.
+type uploadKeyRefPathSpec struct {
+	name string
+	id   string
+}
+
+func (uploadKeyRefPathSpec) pathSpec() {}
+
 // repositoriesRootPathSpec returns the root of repositories
 type repositoriesRootPathSpec struct {
 }
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
+// layoutVersionPathSpec describes the marker recording the version of the
+// storage layout, which is written when the layout is migrated.
+type layoutVersionPathSpec struct{}
+
+func (layoutVersionPathSpec) pathSpec() {}
+
+// repositoryUsagePathSpec describes the file recording the bytes of content
+// linked into a repository, and the blobs charged for them, which is
+// maintained when quotas are enabled.
+type repositoryUsagePathSpec struct {
+	name string
+}
+
+func (repositoryUsagePathSpec) pathSpec() {}
+
+// tagEventLogPathSpec describes the log of changes to the tags of a
+// repository, which is maintained when tag events are enabled.
+type tagEventLogPathSpec struct {
+	name string
+}
+
+func (tagEventLogPathSpec) pathSpec() {}
+
+// repositoryCreationPathSpec describes the marker recording that the named
+// repository is being created, and is not yet listed in the catalog.
+type repositoryCreationPathSpec struct {
+	name string
+}
+
+func (repositoryCreationPathSpec) pathSpec() {}
+
+// quarantinedManifestDataPathSpec describes the raw payload of a manifest
+// which was rejected by a repository and quarantined for inspection.
+type quarantinedManifestDataPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (quarantinedManifestDataPathSpec) pathSpec() {}
+
+// quarantinedManifestReasonPathSpec describes the reason a quarantined
+// manifest was rejected.
+type quarantinedManifestReasonPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (quarantinedManifestReasonPathSpec) pathSpec() {}
+
+// quarantinedManifestQuarantinedAtPathSpec describes the time a manifest was
+// quarantined, by which it is purged.
+type quarantinedManifestQuarantinedAtPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (quarantinedManifestQuarantinedAtPathSpec) pathSpec() {}
+
+// quarantinedManifestsPathSpec describes the directory holding the manifests
+// quarantined by a repository.
+type quarantinedManifestsPathSpec struct {
+	name string
+}
+
+func (quarantinedManifestsPathSpec) pathSpec() {}
+
 // digestPathComponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// platformAllowlist is the set of platforms, of the form os/architecture,
+// which image manifests may describe. A nil allowlist allows any platform.
+type platformAllowlist map[string]bool
+
+// newPlatformAllowlist returns an allowlist of the given platforms, which
+// must each be of the form os/architecture.
+func newPlatformAllowlist(platforms []string) (platformAllowlist, error) {
+	allowlist := make(platformAllowlist, len(platforms))
+	for _, platform := range platforms {
+		parts := strings.Split(platform, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q: must be of the form os/architecture", platform)
+		}
+		allowlist[platform] = true
+	}
+	return allowlist, nil
+}
+
+// verify checks the platform named by the image config referenced by a
+// manifest against the allowlist. Configs of other media types, such as those
+// of artifacts which are not images, are not checked. A missing config is
+// reported when the manifest's references are verified, so is ignored here.
+func (pa platformAllowlist) verify(ctx context.Context, blobs distribution.BlobService, config distribution.Descriptor) error {
+	if pa == nil {
+		return nil
+	}
+
+	switch config.MediaType {
+	case schema2.MediaTypeImageConfig, v1.MediaTypeImageConfig:
+	default:
+		return nil
+	}
+
+	p, err := blobs.Get(ctx, config.Digest)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return nil
+		}
+		return err
+	}
+
+	var image struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	}
+	if err := json.Unmarshal(p, &image); err != nil {
+		return fmt.Errorf("invalid image config %s: %v", config.Digest, err)
+	}
+
+	if !pa[image.OS+"/"+image.Architecture] {
+		return distribution.ErrManifestPlatformNotAllowed{OS: image.OS, Architecture: image.Architecture}
+	}
+	return nil
+}
@@ -26,20 +26,24 @@ func newUploadData() uploadData {
 	}
 }
 
-// PurgeUploads deletes files from the upload directory
-// created before olderThan.  The list of files deleted and errors
+// PurgeUploads deletes files from the upload directory, and quarantined
+// manifests, created before olderThan.  The list of files deleted and errors
 // encountered are returned
 func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
 	logrus.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
 	uploadData, errors := getOutstandingUploads(ctx, driver)
 	var deleted []string
-	for _, uploadData := range uploadData {
+	for id, uploadData := range uploadData {
 		if uploadData.startedAt.Before(olderThan) {
 			var err error
 			logrus.Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
 				uploadData.containingDir, uploadData.startedAt, olderThan)
 			if actuallyDelete {
-				err = driver.Delete(ctx, uploadData.containingDir)
+				keyRefPath := path.Join(uploadData.containingDir, "idempotencykey")
+				err = removeIdempotencyKey(ctx, driver, keyRefPath, id)
+				if err == nil {
+					err = driver.Delete(ctx, uploadData.containingDir)
+				}
 			}
 			if err == nil {
 				deleted = append(deleted, uploadData.containingDir)
@@ -56,12 +60,15 @@ func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, older
 // getOutstandingUploads walks the upload directory, collecting files
 // which could be eligible for deletion.  The only reliable way to
 // classify the age of a file is with the date stored in the startedAt
-// file, so gather files by UUID with a date from startedAt.
+// file, so gather files by UUID with a date from startedAt.  Quarantined
+// manifests are gathered by their directory, with the date from their
+// quarantinedat file.
 func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriver) (map[string]uploadData, []error) {
 	var errors []error
 	uploads := make(map[string]uploadData)
 
 	inUploadDir := false
+	inQuarantineDir := false
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
 		return uploads, append(errors, err)
@@ -73,13 +80,26 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 		if file[0] == '_' {
 			// Reserved directory
 			inUploadDir = (file == "_uploads")
+			inQuarantineDir = (file == "_quarantine")
 
-			if fileInfo.IsDir() && !inUploadDir {
+			if fileInfo.IsDir() && !inUploadDir && !inQuarantineDir {
 				return storageDriver.ErrSkipDir
 			}
 
 		}
 
+		if inQuarantineDir {
+			if file == "quarantinedat" {
+				if t, err := readStartedAtFile(driver, filePath); err == nil {
+					dir := path.Dir(filePath)
+					uploads[dir] = uploadData{containingDir: dir, startedAt: t}
+				} else {
+					errors = pushError(errors, filePath, err)
+				}
+			}
+			return nil
+		}
+
 		uuid, isContainingDir := uuidFromPath(filePath)
 		if uuid == "" {
 			// Cannot reliably delete
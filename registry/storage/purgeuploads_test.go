@@ -7,9 +7,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/docker/distribution/uuid"
+	"github.com/opencontainers/go-digest"
 )
 
 func testUploadFS(t *testing.T, numUploads int, repoName string, startedAt time.Time) (driver.StorageDriver, context.Context) {
@@ -164,3 +167,76 @@ func TestPurgeMissingStartedAt(t *testing.T) {
 		t.Errorf("Files unexpectedly deleted: %s", deleted)
 	}
 }
+
+// TestPurgeIdempotencyKeys ensures that the idempotency keys of uploads are
+// removed with the uploads, whether they are committed, canceled or purged.
+func TestPurgeIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRef, _ := reference.WithName("test-repo")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := repo.Blobs(ctx)
+
+	keyExists := func(principal, key string) bool {
+		keyPath, err := pathFor(uploadIdempotencyKeyPathSpec{name: "test-repo", principal: principal, key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Stat(ctx, keyPath)
+		return err == nil
+	}
+
+	canceled, err := blobs.Create(ctx, WithIdempotencyKey("alice", "canceled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := blobs.Create(ctx, WithIdempotencyKey("bob", "canceled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID() == canceled.ID() {
+		t.Fatalf("upload %s resumed with the key of another principal", canceled.ID())
+	}
+	if err := canceled.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keyExists("alice", "canceled") {
+		t.Error("key of canceled upload not removed")
+	}
+	if !keyExists("bob", "canceled") {
+		t.Error("key of another principal removed")
+	}
+
+	committed, err := blobs.Create(ctx, WithIdempotencyKey("alice", "committed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("content")
+	if _, err := committed.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := committed.Commit(ctx, distribution.Descriptor{Digest: digest.FromBytes(content)}); err != nil {
+		t.Fatal(err)
+	}
+	if keyExists("alice", "committed") {
+		t.Error("key of committed upload not removed")
+	}
+
+	deleted, errs := PurgeUploads(ctx, d, time.Now().Add(time.Hour), true)
+	if len(errs) != 0 {
+		t.Fatal("Unexpected errors:", errs)
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("Unexpected uploads purged: %s", deleted)
+	}
+	if keyExists("bob", "canceled") {
+		t.Error("key of purged upload not removed")
+	}
+}
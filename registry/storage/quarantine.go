@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrQuarantineFull is returned by QuarantineManifest when a repository
+// already holds the maximum number of quarantined manifests.
+var ErrQuarantineFull = errors.New("quarantine is full")
+
+// QuarantineManifest stores payload, a manifest rejected by the named
+// repository, along with the reason it was rejected, so that it can be
+// inspected later. Quarantined manifests are never served or referenced by
+// the repository, and are removed by upload purging once they are older than
+// the purge age. If the repository already holds limit quarantined manifests,
+// payload is not stored and ErrQuarantineFull is returned. The digest of
+// payload is returned.
+func QuarantineManifest(ctx context.Context, driver storagedriver.StorageDriver, name string, payload []byte, reason error, limit int) (digest.Digest, error) {
+	dgst := digest.FromBytes(payload)
+
+	dataPath, err := pathFor(quarantinedManifestDataPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return "", err
+	}
+
+	reasonPath, err := pathFor(quarantinedManifestReasonPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return "", err
+	}
+
+	quarantinedAtPath, err := pathFor(quarantinedManifestQuarantinedAtPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return "", err
+	}
+
+	count, err := countQuarantinedManifests(ctx, driver, name)
+	if err != nil {
+		return "", err
+	}
+
+	// A manifest quarantined again replaces its earlier copy, so does not
+	// count towards the limit.
+	if count >= limit {
+		if _, err := driver.Stat(ctx, dataPath); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				return "", ErrQuarantineFull
+			}
+			return "", err
+		}
+	}
+
+	if err := driver.PutContent(ctx, dataPath, payload); err != nil {
+		return "", err
+	}
+
+	if err := driver.PutContent(ctx, reasonPath, []byte(reason.Error())); err != nil {
+		return "", err
+	}
+
+	if err := driver.PutContent(ctx, quarantinedAtPath, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return "", err
+	}
+
+	return dgst, nil
+}
+
+// countQuarantinedManifests returns the number of manifests quarantined by
+// the named repository.
+func countQuarantinedManifests(ctx context.Context, driver storagedriver.StorageDriver, name string) (int, error) {
+	root, err := pathFor(quarantinedManifestsPathSpec{name: name})
+	if err != nil {
+		return 0, err
+	}
+
+	algorithms, err := driver.List(ctx, root)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var count int
+	for _, algorithm := range algorithms {
+		manifests, err := driver.List(ctx, algorithm)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return 0, err
+		}
+		count += len(manifests)
+	}
+
+	return count, nil
+}
+
+// QuarantinedManifest returns the payload of a manifest quarantined by the
+// named repository, and the reason it was rejected.
+func QuarantinedManifest(ctx context.Context, driver storagedriver.StorageDriver, name string, dgst digest.Digest) ([]byte, string, error) {
+	dataPath, err := pathFor(quarantinedManifestDataPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return nil, "", err
+	}
+
+	reasonPath, err := pathFor(quarantinedManifestReasonPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload, err := driver.GetContent(ctx, dataPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reason, err := driver.GetContent(ctx, reasonPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return payload, string(reason), nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+// TestQuarantineLimit ensures that no more than the limit of manifests are
+// quarantined per repository, and that quarantined manifests are purged.
+func TestQuarantineLimit(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	reason := errors.New("rejected")
+
+	for i := 0; i < 2; i++ {
+		if _, err := QuarantineManifest(ctx, d, "foo/bar", []byte(fmt.Sprint(i)), reason, 2); err != nil {
+			t.Fatalf("unexpected error quarantining manifest %d: %v", i, err)
+		}
+	}
+
+	if _, err := QuarantineManifest(ctx, d, "foo/bar", []byte("2"), reason, 2); err != ErrQuarantineFull {
+		t.Fatalf("expected quarantine to be full, got %v", err)
+	}
+
+	// Manifests already quarantined may be quarantined again, and other
+	// repositories are not affected.
+	if _, err := QuarantineManifest(ctx, d, "foo/bar", []byte("0"), reason, 2); err != nil {
+		t.Fatalf("unexpected error quarantining manifest again: %v", err)
+	}
+	if _, err := QuarantineManifest(ctx, d, "foo/baz", []byte("2"), reason, 2); err != nil {
+		t.Fatalf("unexpected error quarantining manifest of another repository: %v", err)
+	}
+
+	deleted, errs := PurgeUploads(ctx, d, time.Now().Add(-time.Hour), true)
+	if len(errs) != 0 || len(deleted) != 0 {
+		t.Fatalf("unexpected purge of recent quarantined manifests: %v %v", deleted, errs)
+	}
+
+	deleted, errs = PurgeUploads(ctx, d, time.Now().Add(time.Hour), true)
+	if len(errs) != 0 {
+		t.Fatal("Unexpected errors:", errs)
+	}
+	if len(deleted) != 3 {
+		t.Fatalf("unexpected quarantined manifests purged: %v", deleted)
+	}
+
+	if count, err := countQuarantinedManifests(ctx, d, "foo/bar"); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("%d quarantined manifests remain after purge", count)
+	}
+
+	if _, err := QuarantineManifest(ctx, d, "foo/bar", []byte("2"), reason, 2); err != nil {
+		t.Fatalf("unexpected error quarantining manifest after purge: %v", err)
+	}
+}
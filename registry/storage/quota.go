@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// repositoryQuotas holds the storage quotas, in bytes, for repositories.
+type repositoryQuotas struct {
+	defaultQuota int64
+	overrides    map[string]int64
+}
+
+// quotaFor returns the quota for the named repository. A quota of zero or
+// less is unlimited.
+func (q *repositoryQuotas) quotaFor(name string) int64 {
+	if quota, ok := q.overrides[name]; ok {
+		return quota
+	}
+	return q.defaultQuota
+}
+
+// RepositoryQuotas returns a functional option for NewRegistry. It limits the
+// bytes of content which may be linked into each repository to defaultQuota,
+// unless the repository has a quota in overrides. A quota of zero is
+// unlimited. Usage is counted from the time quotas are enabled.
+func RepositoryQuotas(defaultQuota int64, overrides map[string]int64) RegistryOption {
+	return func(registry *registry) error {
+		registry.quotas = &repositoryQuotas{
+			defaultQuota: defaultQuota,
+			overrides:    overrides,
+		}
+		return nil
+	}
+}
+
+// RepositoryUsage returns the bytes of content linked into the named
+// repository, as counted while quotas are enabled.
+func RepositoryUsage(ctx context.Context, driver storagedriver.StorageDriver, name string) (int64, error) {
+	usagePath, err := pathFor(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := getContentIfExists(ctx, driver, usagePath)
+	if err != nil {
+		return 0, err
+	}
+
+	u, err := parseUsage(content)
+	if err != nil {
+		return 0, err
+	}
+	return u.bytes, nil
+}
+
+// repositoryUsage is the usage recorded for a repository: the bytes of
+// content charged to it, and the size charged for each blob, so that a blob
+// is charged once however many times it is linked and released by digest.
+type repositoryUsage struct {
+	bytes int64
+	blobs map[digest.Digest]int64
+}
+
+// parseUsage parses recorded usage, where nil content is no usage. The total
+// is on the first line, followed by a line giving the digest and size of
+// each blob charged. Usage recorded as a total alone charged no blob by
+// digest.
+func parseUsage(content []byte) (*repositoryUsage, error) {
+	u := &repositoryUsage{blobs: make(map[digest.Digest]int64)}
+	if content == nil {
+		return u, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	total, err := strconv.ParseInt(lines[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	u.bytes = total
+
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid usage entry: %q", line)
+		}
+		dgst, err := digest.Parse(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		u.blobs[dgst] = size
+	}
+
+	return u, nil
+}
+
+// format returns the usage as it is recorded, with the blobs in order of
+// digest.
+func (u *repositoryUsage) format() []byte {
+	digests := make([]string, 0, len(u.blobs))
+	for dgst := range u.blobs {
+		digests = append(digests, dgst.String())
+	}
+	sort.Strings(digests)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n", u.bytes)
+	for _, dgst := range digests {
+		fmt.Fprintf(&buf, "%s %d\n", dgst, u.blobs[digest.Digest(dgst)])
+	}
+	return buf.Bytes()
+}
+
+// release removes the charges for digests, reporting whether any was
+// charged.
+func (u *repositoryUsage) release(digests ...digest.Digest) bool {
+	var released bool
+	for _, dgst := range digests {
+		size, ok := u.blobs[dgst]
+		if !ok {
+			continue
+		}
+		delete(u.blobs, dgst)
+		u.bytes -= size
+		if u.bytes < 0 {
+			u.bytes = 0
+		}
+		released = true
+	}
+	return released
+}
+
+// releaseRepositoryUsage removes the charges for digests from the usage of
+// the named repository, once their content is no longer linked into it.
+// Digests which were not charged are ignored, as is a repository without
+// recorded usage.
+func releaseRepositoryUsage(ctx context.Context, driver storagedriver.StorageDriver, name string, digests ...digest.Digest) error {
+	usagePath, err := pathFor(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	return updateContent(ctx, driver, usagePath, func(current []byte) ([]byte, error) {
+		if current == nil {
+			return nil, nil
+		}
+
+		u, err := parseUsage(current)
+		if err != nil {
+			return nil, err
+		}
+		if !u.release(digests...) {
+			return nil, nil
+		}
+		return u.format(), nil
+	})
+}
+
+// enumerateRepositoryUsage calls fn with the name of each repository with
+// recorded usage.
+func enumerateRepositoryUsage(ctx context.Context, driver storagedriver.StorageDriver, fn func(name string) error) error {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	err = driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			return nil
+		}
+
+		name := strings.TrimPrefix(fileInfo.Path(), root+"/")
+		switch file := path.Base(name); {
+		case file == "_usage":
+			if err := fn(path.Dir(name)); err != nil {
+				return err
+			}
+			return storagedriver.ErrSkipDir
+		case strings.HasPrefix(file, "_"):
+			return storagedriver.ErrSkipDir
+		}
+		return nil
+	})
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// chargeUsage checks that linking desc into the repository would not take it
+// over its quota, recording the additional usage if not. It reports whether
+// usage was charged, in which case the caller must release it with
+// releaseUsage if desc is not linked after all. Content already charged to
+// the repository is not charged again. The charge is recorded by digest with
+// a compare-and-swap, so concurrent pushes can neither together exceed the
+// quota nor charge the same content twice.
+func (lbs *linkedBlobStore) chargeUsage(ctx context.Context, desc distribution.Descriptor) (bool, error) {
+	if lbs.registry == nil || lbs.registry.quotas == nil {
+		return false, nil
+	}
+
+	name := lbs.repository.Named().Name()
+	quota := lbs.registry.quotas.quotaFor(name)
+	if quota <= 0 {
+		return false, nil
+	}
+
+	usagePath, err := pathFor(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	var charged bool
+	err = updateContent(ctx, lbs.driver, usagePath, func(current []byte) ([]byte, error) {
+		charged = false
+
+		u, err := parseUsage(current)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := u.blobs[desc.Digest]; ok {
+			return nil, nil
+		}
+
+		if u.bytes+desc.Size > quota {
+			return nil, distribution.ErrRepositoryQuotaExceeded{Name: name, Quota: quota}
+		}
+
+		u.bytes += desc.Size
+		u.blobs[desc.Digest] = desc.Size
+		charged = true
+		return u.format(), nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return charged, nil
+}
+
+// releaseUsage reduces the usage recorded for the repository after desc has
+// been unlinked from it, or was charged but never linked.
+func (lbs *linkedBlobStore) releaseUsage(ctx context.Context, desc distribution.Descriptor) {
+	if lbs.registry == nil || lbs.registry.quotas == nil {
+		return
+	}
+
+	if err := releaseRepositoryUsage(ctx, lbs.driver, lbs.repository.Named().Name(), desc.Digest); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error updating repository usage: %v", err)
+	}
+}
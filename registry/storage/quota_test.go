@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+// linkFailingDriver fails to write any link.
+type linkFailingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d linkFailingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if strings.HasSuffix(path, "/link") {
+		return errors.New("link failed")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func newQuotaTestRepository(t *testing.T, d storagedriver.StorageDriver, quota int64) distribution.Repository {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, d, RepositoryQuotas(quota, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// TestQuotaReleasedOnFailedLink ensures that content which fails to be linked
+// is not counted against the quota.
+func TestQuotaReleasedOnFailedLink(t *testing.T) {
+	ctx := context.Background()
+	d := linkFailingDriver{inmemory.New()}
+	repo := newQuotaTestRepository(t, d, 100)
+
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("content")); err == nil {
+		t.Fatal("expected error linking content")
+	}
+
+	usage, err := RepositoryUsage(ctx, d, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 0 {
+		t.Fatalf("unexpected usage after failed link: %d != 0", usage)
+	}
+}
+
+// TestQuotaConcurrentPuts ensures that concurrent pushes cannot together
+// exceed the quota.
+func TestQuotaConcurrentPuts(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	repo := newQuotaTestRepository(t, d, 10)
+
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			_, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte(fmt.Sprintf("%04d", i)))
+			errs <- err
+		}(i)
+	}
+
+	var succeeded int
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; err.(type) {
+		case nil:
+			succeeded++
+		case distribution.ErrRepositoryQuotaExceeded:
+		default:
+			t.Fatalf("unexpected error putting content: %v", err)
+		}
+	}
+	if succeeded != 2 {
+		t.Fatalf("unexpected number of successful puts: %d != 2", succeeded)
+	}
+
+	usage, err := RepositoryUsage(ctx, d, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 8 {
+		t.Fatalf("unexpected usage: %d != 8", usage)
+	}
+}
+
+// linkBarrierDriver holds writes of links until a number of them are
+// waiting, or a timeout passes, so that concurrent pushes all reach the link
+// together.
+type linkBarrierDriver struct {
+	storagedriver.StorageDriver
+
+	mu      sync.Mutex
+	waiting int
+	count   int
+	release chan struct{}
+}
+
+func (d *linkBarrierDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if strings.HasSuffix(path, "/link") {
+		d.mu.Lock()
+		d.waiting++
+		if d.waiting == d.count {
+			close(d.release)
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-d.release:
+		case <-time.After(time.Second):
+		}
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// TestQuotaConcurrentSameBlob ensures that content pushed into a repository
+// several times at once is charged once.
+func TestQuotaConcurrentSameBlob(t *testing.T) {
+	ctx := context.Background()
+	const pushes = 8
+	d := &linkBarrierDriver{StorageDriver: inmemory.New(), count: pushes, release: make(chan struct{})}
+	repo := newQuotaTestRepository(t, d, 100)
+
+	errs := make(chan error, pushes)
+	for i := 0; i < pushes; i++ {
+		go func() {
+			_, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("content"))
+			errs <- err
+		}()
+	}
+	for i := 0; i < pushes; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error putting content: %v", err)
+		}
+	}
+
+	usage, err := RepositoryUsage(ctx, d, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 7 {
+		t.Fatalf("unexpected usage: %d != 7", usage)
+	}
+}
+
+// TestQuotaReleasedByGarbageCollection ensures that content removed by
+// garbage collection is no longer counted against the quota.
+func TestQuotaReleasedByGarbageCollection(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d, RepositoryQuotas(100, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+
+	// The content is referenced by no manifest, so is removed.
+	if err := MarkAndSweep(ctx, d, reg, GCOpts{}); err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	usage, err := RepositoryUsage(ctx, d, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 0 {
+		t.Fatalf("unexpected usage after garbage collection: %d != 0", usage)
+	}
+}
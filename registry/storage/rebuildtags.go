@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/opencontainers/go-digest"
+)
+
+// TagConflict describes a tag which RebuildTags could not restore because
+// the manifests claiming it disagree with each other or with the tag.
+type TagConflict struct {
+	// Tag is the name of the conflicting tag.
+	Tag string
+
+	// Claimants are the digests of the manifests which name the tag.
+	Claimants []digest.Digest
+
+	// Current is the digest the tag currently resolves to, if any.
+	Current digest.Digest
+}
+
+// RebuildTags re-derives the tags of repository from the tags embedded in its
+// manifests, recreating those which no longer resolve. Only schema1
+// manifests carry the tag they were pushed to; other manifests are ignored.
+//
+// A tag is only recreated if exactly one manifest claims it. Tags which still
+// resolve are left untouched, since they may legitimately have been moved to
+// a manifest which does not name them. The conflicts found, where a missing
+// tag is claimed by several manifests or an existing tag resolves to none of
+// its claimants, are returned in tag order.
+func RebuildTags(ctx context.Context, repository distribution.Repository) ([]TagConflict, error) {
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	claims := make(map[string][]digest.Digest)
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+		}
+
+		if sm, ok := manifest.(*schema1.SignedManifest); ok && sm.Tag != "" {
+			claims[sm.Tag] = append(claims[sm.Tag], dgst)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(claims))
+	for tag := range claims {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	tagService := repository.Tags(ctx)
+	var conflicts []TagConflict
+	for _, tag := range tags {
+		claimants := claims[tag]
+		sort.Slice(claimants, func(i, j int) bool { return claimants[i] < claimants[j] })
+
+		desc, err := tagService.Get(ctx, tag)
+		switch err.(type) {
+		case nil:
+			if !containsDigest(claimants, desc.Digest) {
+				conflicts = append(conflicts, TagConflict{Tag: tag, Claimants: claimants, Current: desc.Digest})
+			}
+			continue
+		case distribution.ErrTagUnknown:
+		default:
+			return nil, fmt.Errorf("failed to resolve tag %s: %v", tag, err)
+		}
+
+		if len(claimants) > 1 {
+			conflicts = append(conflicts, TagConflict{Tag: tag, Claimants: claimants})
+			continue
+		}
+
+		if err := tagService.Tag(ctx, tag, distribution.Descriptor{Digest: claimants[0]}); err != nil {
+			return nil, fmt.Errorf("failed to restore tag %s: %v", tag, err)
+		}
+	}
+
+	return conflicts, nil
+}
+
+func containsDigest(digests []digest.Digest, dgst digest.Digest) bool {
+	for _, d := range digests {
+		if d == dgst {
+			return true
+		}
+	}
+	return false
+}
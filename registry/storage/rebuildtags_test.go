@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/testutil"
+	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestRebuildTags(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "foo/retagged")
+	tags := repo.Tags(ctx)
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pushSchema1 pushes a schema1 manifest naming tag, and tags it.
+	pushSchema1 := func(tag string) digest.Digest {
+		layers, err := testutil.CreateRandomLayers(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testutil.UploadBlobs(repo, layers); err != nil {
+			t.Fatalf("layer upload failed: %v", err)
+		}
+
+		m := &schema1.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 1,
+			},
+			Name: repo.Named().Name(),
+			Tag:  tag,
+		}
+		for dgst := range layers {
+			m.FSLayers = append(m.FSLayers, schema1.FSLayer{BlobSum: dgst})
+			m.History = append(m.History, schema1.History{V1Compatibility: ""})
+		}
+		sm, err := schema1.Sign(m, pk)
+		if err != nil {
+			t.Fatalf("error signing manifest: %v", err)
+		}
+
+		dgst, err := makeManifestService(t, repo).Put(ctx, sm)
+		if err != nil {
+			t.Fatalf("manifest upload failed: %v", err)
+		}
+		if err := tags.Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatalf("error tagging %s: %v", tag, err)
+		}
+		return dgst
+	}
+
+	v1 := pushSchema1("v1")
+	v2 := pushSchema1("v2")
+	dup1 := pushSchema1("dup")
+	dup2 := pushSchema1("dup")
+
+	// Schema2 manifests do not name their tag, so cannot be restored.
+	image := uploadRandomSchema2Image(t, repo)
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("error tagging latest: %v", err)
+	}
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: repo.Named().Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, tagsPath); err != nil {
+		t.Fatalf("error wiping tags: %v", err)
+	}
+
+	conflicts, err := RebuildTags(ctx, repo)
+	if err != nil {
+		t.Fatalf("error rebuilding tags: %v", err)
+	}
+
+	claimants := []digest.Digest{dup1, dup2}
+	sort.Slice(claimants, func(i, j int) bool { return claimants[i] < claimants[j] })
+	expected := []TagConflict{{Tag: "dup", Claimants: claimants}}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("unexpected conflicts: %v != %v", conflicts, expected)
+	}
+
+	all, err := tags.All(ctx)
+	if err != nil {
+		t.Fatalf("error listing tags: %v", err)
+	}
+	sort.Strings(all)
+	if !reflect.DeepEqual(all, []string{"v1", "v2"}) {
+		t.Fatalf("unexpected tags after rebuild: %v", all)
+	}
+
+	for tag, dgst := range map[string]digest.Digest{"v1": v1, "v2": v2} {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			t.Fatalf("error resolving %s: %v", tag, err)
+		}
+		if desc.Digest != dgst {
+			t.Fatalf("unexpected digest for %s: %s != %s", tag, desc.Digest, dgst)
+		}
+	}
+
+	// A tag which resolves to none of the manifests naming it is reported,
+	// and left untouched.
+	if err := tags.Tag(ctx, "v1", distribution.Descriptor{Digest: v2}); err != nil {
+		t.Fatalf("error moving v1: %v", err)
+	}
+
+	conflicts, err = RebuildTags(ctx, repo)
+	if err != nil {
+		t.Fatalf("error rebuilding tags: %v", err)
+	}
+
+	expected = append(expected, TagConflict{Tag: "v1", Claimants: []digest.Digest{v1}, Current: v2})
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("unexpected conflicts: %v != %v", conflicts, expected)
+	}
+
+	desc, err := tags.Get(ctx, "v1")
+	if err != nil {
+		t.Fatalf("error resolving v1: %v", err)
+	}
+	if desc.Digest != v2 {
+		t.Fatalf("conflicting tag was modified: %s != %s", desc.Digest, v2)
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrBlobReferenced is returned by CheckBlobReclaimable when the content of a
+// blob is still linked by a repository.
+type ErrBlobReferenced struct {
+	Digest     digest.Digest
+	Repository string
+}
+
+func (err ErrBlobReferenced) Error() string {
+	return fmt.Sprintf("blob %s is referenced by repository %s", err.Digest, err.Repository)
+}
+
+// CheckBlobReclaimable checks whether the content of the blob identified by
+// dgst may be removed from the storage held by driver once the blob is
+// deleted from the repository name, returning ErrBlobReferenced if any other
+// repository links the blob, as a layer or as a manifest, or if name links it
+// as a manifest. It scans every repository, so is as costly as listing the
+// catalog.
+//
+// Nothing prevents the blob being linked into a repository once checked, so
+// the content should only be removed when writes are otherwise coordinated,
+// as for garbage collection and by ReclaimBlob.
+func CheckBlobReclaimable(ctx context.Context, storageDriver driver.StorageDriver, name string, dgst digest.Digest) error {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	// Repositories are found by their _layers and _manifests directories,
+	// as a repository to which blobs have only been pushed has no manifests.
+	// The reference found is kept aside, as drivers may wrap errors returned
+	// to stop the walk.
+	var referenced error
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		repo, file := path.Split(strings.TrimPrefix(fileInfo.Path(), root+"/"))
+		repo = strings.TrimSuffix(repo, "/")
+
+		var linkPathFn linkPathFunc
+		switch {
+		case file == "_layers" && repo != name:
+			linkPathFn = blobLinkPath
+		case file == "_manifests":
+			linkPathFn = manifestRevisionLinkPath
+		case strings.HasPrefix(file, "_"):
+			return driver.ErrSkipDir
+		default:
+			return nil
+		}
+
+		linkPath, err := linkPathFn(repo, dgst)
+		if err != nil {
+			return err
+		}
+
+		_, err = storageDriver.Stat(ctx, linkPath)
+		switch err.(type) {
+		case nil:
+			referenced = ErrBlobReferenced{Digest: dgst, Repository: repo}
+			return referenced
+		case driver.PathNotFoundError:
+			return driver.ErrSkipDir
+		default:
+			return err
+		}
+	})
+	if referenced != nil {
+		return referenced
+	}
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// There are no repositories.
+		return nil
+	}
+	return err
+}
+
+// ReclaimBlob deletes the blob identified by dgst from the named repository
+// of registry, which must allow deletes, and removes its content from the
+// storage held by storageDriver. If another repository links the blob,
+// ErrBlobReferenced is returned and nothing is deleted.
+//
+// As for garbage collection, the registry must be stopped or read-only while
+// the blob is reclaimed: content linked into a repository after the check
+// would otherwise be lost.
+func ReclaimBlob(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, name reference.Named, dgst digest.Digest) error {
+	if err := CheckBlobReclaimable(ctx, storageDriver, name.Name(), dgst); err != nil {
+		return err
+	}
+
+	repository, err := registry.Repository(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := repository.Blobs(ctx).Delete(ctx, dgst); err != nil {
+		return err
+	}
+
+	return NewVacuum(ctx, storageDriver).RemoveBlob(dgst.String())
+}
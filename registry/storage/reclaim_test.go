@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+// TestReclaimBlob ensures that reclaiming a blob removes its content only
+// once no other repository links it, deleting nothing until then.
+func TestReclaimBlob(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d, EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := make(map[string]distribution.Repository)
+	var desc distribution.Descriptor
+	for _, name := range []string{"foo/reclaima", "foo/reclaimb"} {
+		named, _ := reference.WithName(name)
+		repo, err := reg.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repos[name] = repo
+
+		desc, err = repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("reclaimed content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	named := repos["foo/reclaima"].Named()
+	err = ReclaimBlob(ctx, d, reg, named, desc.Digest)
+	if _, ok := err.(ErrBlobReferenced); !ok {
+		t.Fatalf("expected ErrBlobReferenced reclaiming blob linked by two repositories, got %v", err)
+	}
+	if _, err := repos["foo/reclaima"].Blobs(ctx).Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("blob deleted although it was not reclaimed: %v", err)
+	}
+
+	if err := repos["foo/reclaimb"].Blobs(ctx).Delete(ctx, desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReclaimBlob(ctx, d, reg, named, desc.Digest); err != nil {
+		t.Fatalf("unexpected error reclaiming blob linked by one repository: %v", err)
+	}
+	if _, err := repos["foo/reclaima"].Blobs(ctx).Stat(ctx, desc.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected reclaimed blob to be unknown, got %v", err)
+	}
+
+	blobPath, err := pathFor(blobPathSpec{digest: desc.Digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, blobPath); err == nil {
+		t.Fatal("content of reclaimed blob still stored")
+	}
+}
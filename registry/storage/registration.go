@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// RegisterRepository records that the named repository may be created. When
+// automatic creation of repositories is disabled, only registered
+// repositories are available.
+func RegisterRepository(ctx context.Context, driver storagedriver.StorageDriver, name string) error {
+	registrationPath, err := pathFor(repositoryRegistrationPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	return driver.PutContent(ctx, registrationPath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// UnregisterRepository removes the registration of the named repository. The
+// content of the repository is left in place. Unregistering a repository which
+// is not registered is not an error.
+func UnregisterRepository(ctx context.Context, driver storagedriver.StorageDriver, name string) error {
+	registrationPath, err := pathFor(repositoryRegistrationPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	if err := driver.Delete(ctx, registrationPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RepositoryRegistered reports whether the named repository is registered.
+func RepositoryRegistered(ctx context.Context, driver storagedriver.StorageDriver, name string) (bool, error) {
+	registrationPath, err := pathFor(repositoryRegistrationPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := driver.Stat(ctx, registrationPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -2,10 +2,13 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 	"regexp"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/admission"
 	"github.com/docker/distribution/registry/storage/cache"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/libtrust"
@@ -19,11 +22,30 @@ type registry struct {
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
+	compressManifests            bool
+	tagAliasDeletes              string
+	tagEventLimit                int
+	blobLinkLimit                int
+	strictDigests                bool
+	canonicalManifests           bool
+	layerOrder                   bool
+	signatureAlgorithms          signatureAlgorithmAllowlist
+	allowedPlatforms             platformAllowlist
+	validateLayerArchives        bool
+	requireRunningDigest         bool
+	verifyBlobWrites             bool
+	deduplicateUploads           bool
+	preserveBlobMediaTypes       bool
+	quotas                       *repositoryQuotas
+	requireRegistration          bool
+	atomicCreate                 bool
 	schema1Enabled               bool
+	requireSignedChildren        bool
 	resumableDigestEnabled       bool
 	schema1SigningKey            libtrust.PrivateKey
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	manifestURLs                 manifestURLs
+	admissionHooks               []admission.Hook
 	driver                       storagedriver.StorageDriver
 }
 
@@ -31,6 +53,23 @@ type registry struct {
 type manifestURLs struct {
 	allow *regexp.Regexp
 	deny  *regexp.Regexp
+
+	// requireHTTPS rejects URLs not using HTTPS
+	requireHTTPS bool
+}
+
+// insecureURL returns the first of urls using plain HTTP, if HTTPS is
+// required, or the empty string otherwise.
+func (mu manifestURLs) insecureURL(urls []string) string {
+	if !mu.requireHTTPS {
+		return ""
+	}
+	for _, u := range urls {
+		if pu, err := url.Parse(u); err == nil && pu.Scheme == "http" {
+			return u
+		}
+	}
+	return ""
 }
 
 // RegistryOption is the type used for functional options for NewRegistry.
@@ -43,6 +82,38 @@ func EnableRedirect(registry *registry) error {
 	return nil
 }
 
+// Policies for requests for a range of a blob which would be served by
+// redirect, for use with RedirectRanges.
+const (
+	// RedirectRangesAuto redirects ranged requests only if the URLs of the
+	// storage driver honor Range headers, serving them directly otherwise.
+	RedirectRangesAuto = "auto"
+
+	// RedirectRangesRedirect always redirects ranged requests, relying on
+	// clients to request the range from the URL they are redirected to.
+	RedirectRangesRedirect = "redirect"
+
+	// RedirectRangesServe always serves ranged requests directly.
+	RedirectRangesServe = "serve"
+)
+
+// RedirectRanges returns a functional option for NewRegistry. It sets the
+// policy applied to requests for a range of a blob which would otherwise be
+// served by redirect, which is one of RedirectRangesAuto,
+// RedirectRangesRedirect or RedirectRangesServe. An empty policy is
+// RedirectRangesAuto.
+func RedirectRanges(policy string) RegistryOption {
+	return func(registry *registry) error {
+		switch policy {
+		case "", RedirectRangesAuto, RedirectRangesRedirect, RedirectRangesServe:
+		default:
+			return fmt.Errorf("invalid redirect ranges policy: %q", policy)
+		}
+		registry.blobServer.redirectRanges = policy
+		return nil
+	}
+}
+
 // EnableDelete is a functional option for NewRegistry. It enables deletion on
 // the registry.
 func EnableDelete(registry *registry) error {
@@ -50,6 +121,312 @@ func EnableDelete(registry *registry) error {
 	return nil
 }
 
+// EnableManifestCompression is a functional option for NewRegistry. It causes
+// manifest content to be stored gzipped in the backend. Digests are always
+// calculated over the uncompressed content. Compressed manifests are served
+// decompressed by the registry rather than by redirect, and are only found
+// while compression is enabled.
+func EnableManifestCompression(registry *registry) error {
+	registry.compressManifests = true
+	registry.blobStore.compressed = true
+	registry.statter.compressed = true
+	return nil
+}
+
+// Policies for untagging a tag which aliases resolve through, for use with
+// TagAliasDeletes.
+const (
+	// TagAliasDeletesStrict causes the untag to fail with ErrTagAliased.
+	TagAliasDeletesStrict = "strict"
+
+	// TagAliasDeletesCascade causes the aliases to be removed along with the
+	// tag.
+	TagAliasDeletesCascade = "cascade"
+)
+
+// TagAliasDeletes returns a functional option for NewRegistry. It sets the
+// policy applied when untagging a tag which aliases resolve through, which is
+// one of TagAliasDeletesStrict or TagAliasDeletesCascade. An empty policy
+// leaves the aliases dangling.
+func TagAliasDeletes(policy string) RegistryOption {
+	return func(registry *registry) error {
+		switch policy {
+		case "", TagAliasDeletesStrict, TagAliasDeletesCascade:
+		default:
+			return fmt.Errorf("invalid tag alias delete policy: %q", policy)
+		}
+		registry.tagAliasDeletes = policy
+		return nil
+	}
+}
+
+// ReadBufferSize returns a functional option for NewRegistry. It sets the
+// size, in bytes, of the buffer used when reading blobs from the storage
+// driver, so that small reads by clients become fewer, larger reads from the
+// backend. A size of zero uses the default of 4MB.
+func ReadBufferSize(size int) RegistryOption {
+	return func(registry *registry) error {
+		if size < 0 {
+			return fmt.Errorf("invalid read buffer size: %d", size)
+		}
+		registry.blobStore.readBufferSize = size
+		registry.blobServer.readBufferSize = size
+		return nil
+	}
+}
+
+// CopyBufferSize returns a functional option for NewRegistry. It sets the
+// size, in bytes, of the buffer through which blobs served by the registry
+// are copied to clients. The backend is read only as fast as clients accept
+// each buffer. A size of zero uses the default of 32KB.
+func CopyBufferSize(size int) RegistryOption {
+	return func(registry *registry) error {
+		if size < 0 {
+			return fmt.Errorf("invalid copy buffer size: %d", size)
+		}
+		registry.blobServer.copyBufferSize = size
+		return nil
+	}
+}
+
+// EnableContentDisposition is a functional option for NewRegistry. It causes
+// blobs served by the registry to carry a Content-Disposition header marking
+// them as attachments, named after their digest, so that browsers save them
+// under a meaningful name. Blobs served by redirect are not affected.
+func EnableContentDisposition(registry *registry) error {
+	registry.blobServer.contentDisposition = true
+	return nil
+}
+
+// EnableMultipartRanges is a functional option for NewRegistry. It causes
+// requests for several ranges of a blob served by the registry to be answered
+// with a multipart/byteranges response holding each range. Otherwise, the
+// whole blob is served. Blobs served by redirect are not affected.
+func EnableMultipartRanges(registry *registry) error {
+	registry.blobServer.multipartRanges = true
+	return nil
+}
+
+// DefaultBlobContentType returns a functional option for NewRegistry. It
+// sets the Content-Type of blobs served by the registry whose media type is
+// unknown, in place of application/octet-stream.
+func DefaultBlobContentType(contentType string) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobServer.defaultContentType = contentType
+		return nil
+	}
+}
+
+// EnableContentTypeSniffing is a functional option for NewRegistry. It causes
+// the Content-Type of blobs served by the registry whose media type is
+// unknown to be detected from their content when they are gzip compressed or
+// tar layers.
+func EnableContentTypeSniffing(registry *registry) error {
+	registry.blobServer.sniffContentType = true
+	return nil
+}
+
+// PreserveBlobMediaTypes is a functional option for NewRegistry. It causes the
+// media type given when a blob is put into a repository to be recorded with
+// its link, so that the blob is described and served with it in that
+// repository, and carried over when the blob is mounted into another.
+func PreserveBlobMediaTypes(registry *registry) error {
+	registry.preserveBlobMediaTypes = true
+	return nil
+}
+
+// AllowedPlatforms returns a functional option for NewRegistry. It causes
+// schema2 and OCI image manifests to be rejected unless their image config
+// describes one of the given platforms, each of the form os/architecture.
+func AllowedPlatforms(platforms []string) RegistryOption {
+	return func(registry *registry) error {
+		allowlist, err := newPlatformAllowlist(platforms)
+		if err != nil {
+			return err
+		}
+		registry.allowedPlatforms = allowlist
+		return nil
+	}
+}
+
+// InlineBlobSize returns a functional option for NewRegistry. Uploaded blobs
+// of at most size bytes are stored inline, written in a single operation
+// rather than moved into place, and served by the registry rather than by
+// redirect. The size may be at most 1MiB. Blobs stored inline are only found
+// while inline storage is enabled.
+func InlineBlobSize(size int64) RegistryOption {
+	return func(registry *registry) error {
+		if size <= 0 || size > maxInlineBlobSize {
+			return fmt.Errorf("inline blob size must be between 1 and %d bytes: %d", maxInlineBlobSize, size)
+		}
+		registry.blobStore.inlineSize = size
+		registry.statter.inline = true
+		return nil
+	}
+}
+
+// EnableStrictDigests is a functional option for NewRegistry. It causes
+// manifests referencing content by a digest that is not in canonical form,
+// such as one with uppercase hex, to be rejected rather than normalized.
+func EnableStrictDigests(registry *registry) error {
+	registry.strictDigests = true
+	return nil
+}
+
+// EnforceLayerOrder is a functional option for NewRegistry. It causes
+// schema1 manifests to be rejected unless their history describes a single
+// chain of unique layers, one for each FSLayer and in the same order.
+func EnforceLayerOrder(registry *registry) error {
+	registry.layerOrder = true
+	return nil
+}
+
+// AllowedSignatureAlgorithms returns a functional option for NewRegistry. It
+// causes schema1 manifests to be rejected with ErrManifestUnverified unless
+// every signature uses one of the given JWS algorithms, such as ES256 or
+// RS512.
+func AllowedSignatureAlgorithms(algorithms []string) RegistryOption {
+	return func(registry *registry) error {
+		allowlist, err := newSignatureAlgorithmAllowlist(algorithms)
+		if err != nil {
+			return err
+		}
+		registry.signatureAlgorithms = allowlist
+		return nil
+	}
+}
+
+// RequireSignedChildren is a functional option for NewRegistry. It causes
+// manifest lists and image indexes to be rejected with ErrManifestUnverified
+// unless every manifest they reference is present in the repository as a
+// signed schema1 manifest, whose signatures were verified when it was pushed.
+// It requires schema1 support to be enabled, since no other manifests are
+// signed.
+func RequireSignedChildren(registry *registry) error {
+	registry.requireSignedChildren = true
+	return nil
+}
+
+// TagEventLog returns a functional option for NewRegistry. It records each
+// tag created, moved or deleted in a log kept for each repository, from
+// which changes may be read with TagEvents. Only the newest limit events of
+// each repository are retained.
+func TagEventLog(limit int) RegistryOption {
+	return func(registry *registry) error {
+		if limit <= 0 {
+			return fmt.Errorf("tag event log limit must be positive: %d", limit)
+		}
+		registry.tagEventLimit = limit
+		return nil
+	}
+}
+
+// BlobLinkLimit returns a functional option for NewRegistry. It limits the
+// number of repositories which may link any one blob, such as a layer shared
+// by many images, so that its links remain few enough to account for. Pushes
+// and mounts which would link the blob into another repository are rejected
+// with distribution.ErrBlobLinkLimitExceeded. Links made before the limit was
+// set are not counted.
+func BlobLinkLimit(limit int) RegistryOption {
+	return func(registry *registry) error {
+		if limit <= 0 {
+			return fmt.Errorf("blob link limit must be positive: %d", limit)
+		}
+		registry.blobLinkLimit = limit
+		return nil
+	}
+}
+
+// EnableBlobIndex is a functional option for NewRegistry. It maintains an
+// index of every blob in the blob store and its size, which is enumerated in
+// place of walking the blob store. See BlobIndex.
+func EnableBlobIndex(registry *registry) error {
+	registry.blobStore.index = NewBlobIndex(registry.blobStore.driver)
+	return nil
+}
+
+// DisableRepositoryAutoCreate is a functional option for NewRegistry. It
+// makes only repositories registered with RegisterRepository available;
+// resolving any other name fails with ErrRepositoryUnknown, rather than
+// implicitly creating the repository.
+func DisableRepositoryAutoCreate(registry *registry) error {
+	registry.requireRegistration = true
+	return nil
+}
+
+// EnableAtomicRepositoryCreation is a functional option for NewRegistry. It
+// leaves repositories marked by BeginRepositoryCreation out of the catalog
+// until FinishRepositoryCreation is called, so that a repository whose first
+// manifest put is interrupted is not listed. Listing the catalog costs an
+// extra check of each repository.
+func EnableAtomicRepositoryCreation(registry *registry) error {
+	registry.atomicCreate = true
+	return nil
+}
+
+// ValidateLayerArchives is a functional option for NewRegistry. It causes
+// manifests referencing layers to be rejected unless those layers are valid
+// tar archives, optionally gzip compressed. Whether a blob is a layer is
+// decided by the media type the manifest gives it, since uploads carry no
+// reliable media type.
+func ValidateLayerArchives(registry *registry) error {
+	registry.validateLayerArchives = true
+	return nil
+}
+
+// RequireRunningDigest is a functional option for NewRegistry. It causes
+// completed uploads to be verified only against the digest maintained as
+// their data was written, which is saved with the upload between requests,
+// rather than by reading the data back from the storage backend. Uploads for
+// which that digest is unavailable, such as those declaring a digest of
+// another algorithm, fail to commit with ErrBlobInvalidDigest. It cannot be
+// combined with DisableDigestResumption, nor used in builds without resumable
+// digest support, since uploads made in several requests could not commit.
+func RequireRunningDigest(registry *registry) error {
+	registry.requireRunningDigest = true
+	return nil
+}
+
+// VerifyBlobWrites is a functional option for NewRegistry. It causes the data
+// of each completed upload to be read back from the storage driver and checked
+// against its digest before the blob is linked, catching content corrupted by
+// the backend as it was written.
+func VerifyBlobWrites(registry *registry) error {
+	registry.verifyBlobWrites = true
+	return nil
+}
+
+// DeduplicateUploads is a functional option for NewRegistry. It causes uploads
+// of content already in the blob store to be completed by linking the existing
+// content, discarding the data of the upload rather than assembling it, if the
+// running digest of the upload shows it matches. Uploads whose running digest
+// is unavailable are completed as usual.
+func DeduplicateUploads(registry *registry) error {
+	registry.deduplicateUploads = true
+	return nil
+}
+
+// RequireCanonicalManifests is a functional option for NewRegistry. It causes
+// manifests which are not in canonical JSON form to be rejected, so that their
+// digests are reproducible. Schema1 manifests are exempt, since their
+// signatures are computed over indented JSON.
+func RequireCanonicalManifests(registry *registry) error {
+	registry.canonicalManifests = true
+	return nil
+}
+
+// AdmitManifests returns a functional option for NewRegistry. It calls each
+// hook with every manifest put, once verified and before it is stored, in
+// order, failing the put with the first error returned. Manifests only
+// verified, as for a dry run, are also passed to the hooks, so that the
+// verification reports whether the put would be admitted.
+func AdmitManifests(hooks ...admission.Hook) RegistryOption {
+	return func(registry *registry) error {
+		registry.admissionHooks = append(registry.admissionHooks, hooks...)
+		return nil
+	}
+}
+
 // EnableSchema1 is a functional option for NewRegistry. It enables pushing of
 // schema1 manifests.
 func EnableSchema1(registry *registry) error {
@@ -80,6 +457,13 @@ func ManifestURLsDenyRegexp(r *regexp.Regexp) RegistryOption {
 	}
 }
 
+// ManifestURLsRequireHTTPS is a functional option for NewRegistry. It
+// rejects manifests with layers which may be fetched from plain HTTP URLs.
+func ManifestURLsRequireHTTPS(registry *registry) error {
+	registry.manifestURLs.requireHTTPS = true
+	return nil
+}
+
 // Schema1SigningKey returns a functional option for NewRegistry. It sets the
 // key for signing  all schema1 manifests.
 func Schema1SigningKey(key libtrust.PrivateKey) RegistryOption {
@@ -138,7 +522,7 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 		blobServer: &blobServer{
 			driver:  driver,
 			statter: statter,
-			pathFn:  bs.path,
+			pathFn:  bs.contentPath,
 		},
 		statter:                statter,
 		resumableDigestEnabled: true,
@@ -151,6 +535,16 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 		}
 	}
 
+	// Without resumable digests, every upload received in more than one
+	// request would have to be read back to be verified.
+	if registry.requireRunningDigest && !(registry.resumableDigestEnabled && resumableDigestSupported) {
+		return nil, fmt.Errorf("running digests cannot be required without resumable digests, which are disabled or unsupported by this build")
+	}
+
+	if registry.requireSignedChildren && !registry.schema1Enabled {
+		return nil, fmt.Errorf("signed children of manifest lists cannot be required without schema1 support")
+	}
+
 	return registry, nil
 }
 
@@ -164,6 +558,16 @@ func (reg *registry) Scope() distribution.Scope {
 // Instances should not be shared between goroutines but are cheap to
 // allocate. In general, they should be request scoped.
 func (reg *registry) Repository(ctx context.Context, canonicalName reference.Named) (distribution.Repository, error) {
+	if reg.requireRegistration {
+		registered, err := RepositoryRegistered(ctx, reg.driver, canonicalName.Name())
+		if err != nil {
+			return nil, err
+		}
+		if !registered {
+			return nil, distribution.ErrRepositoryUnknown{Name: canonicalName.Name()}
+		}
+	}
+
 	var descriptorCache distribution.BlobDescriptorService
 	if reg.blobDescriptorCacheProvider != nil {
 		var err error
@@ -236,6 +640,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 
 	blobStore := &linkedBlobStore{
 		ctx:                  ctx,
+		registry:             repo.registry,
 		blobStore:            repo.blobStore,
 		repository:           repo,
 		deleteEnabled:        repo.registry.deleteEnabled,
@@ -245,23 +650,31 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		// manifests. This instance cannot be used for blob checks.
 		linkPathFns:           manifestLinkPathFns,
 		linkDirectoryPathSpec: manifestDirectoryPathSpec,
+
+		compressContent: repo.registry.compressManifests,
 	}
 
 	var v1Handler ManifestHandler
 	if repo.schema1Enabled {
 		v1Handler = &signedManifestHandler{
-			ctx:               ctx,
-			schema1SigningKey: repo.schema1SigningKey,
-			repository:        repo,
-			blobStore:         blobStore,
+			ctx:                 ctx,
+			schema1SigningKey:   repo.schema1SigningKey,
+			repository:          repo,
+			blobStore:           blobStore,
+			strictDigests:       repo.strictDigests,
+			layerOrder:          repo.registry.layerOrder,
+			signatureAlgorithms: repo.registry.signatureAlgorithms,
 		}
 	} else {
 		v1Handler = &v1UnsupportedHandler{
 			innerHandler: &signedManifestHandler{
-				ctx:               ctx,
-				schema1SigningKey: repo.schema1SigningKey,
-				repository:        repo,
-				blobStore:         blobStore,
+				ctx:                 ctx,
+				schema1SigningKey:   repo.schema1SigningKey,
+				repository:          repo,
+				blobStore:           blobStore,
+				strictDigests:       repo.strictDigests,
+				layerOrder:          repo.registry.layerOrder,
+				signatureAlgorithms: repo.registry.signatureAlgorithms,
 			},
 		}
 	}
@@ -272,21 +685,35 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		blobStore:      blobStore,
 		schema1Handler: v1Handler,
 		schema2Handler: &schema2ManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:           ctx,
+			repository:    repo,
+			blobStore:     blobStore,
+			manifestURLs:  repo.registry.manifestURLs,
+			strictDigests: repo.strictDigests,
+			platforms:     repo.registry.allowedPlatforms,
+			archives: layerArchives{
+				enabled: repo.registry.validateLayerArchives,
+				driver:  repo.driver,
+			},
 		},
 		manifestListHandler: &manifestListHandler{
-			ctx:        ctx,
-			repository: repo,
-			blobStore:  blobStore,
+			ctx:                   ctx,
+			repository:            repo,
+			blobStore:             blobStore,
+			strictDigests:         repo.strictDigests,
+			requireSignedChildren: repo.registry.requireSignedChildren,
 		},
 		ocischemaHandler: &ocischemaManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:           ctx,
+			repository:    repo,
+			blobStore:     blobStore,
+			manifestURLs:  repo.registry.manifestURLs,
+			strictDigests: repo.strictDigests,
+			platforms:     repo.registry.allowedPlatforms,
+			archives: layerArchives{
+				enabled: repo.registry.validateLayerArchives,
+				driver:  repo.driver,
+			},
 		},
 	}
 
@@ -305,10 +732,16 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
 func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
+	var mediaTypePathFn linkPathFunc
+	if repo.preserveBlobMediaTypes {
+		mediaTypePathFn = blobMediaTypePath
+	}
+
 	var statter distribution.BlobDescriptorService = &linkedBlobStatter{
-		blobStore:   repo.blobStore,
-		repository:  repo,
-		linkPathFns: []linkPathFunc{blobLinkPath},
+		blobStore:       repo.blobStore,
+		repository:      repo,
+		linkPathFns:     []linkPathFunc{blobLinkPath},
+		mediaTypePathFn: mediaTypePathFn,
 	}
 
 	if repo.descriptorCache != nil {
@@ -331,6 +764,7 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		// This instance cannot be used for manifest checks.
 		linkPathFns:            []linkPathFunc{blobLinkPath},
 		linkDirectoryPathSpec:  layersPathSpec{name: repo.name.Name()},
+		mediaTypePathFn:        mediaTypePathFn,
 		deleteEnabled:          repo.registry.deleteEnabled,
 		resumableDigestEnabled: repo.resumableDigestEnabled,
 	}
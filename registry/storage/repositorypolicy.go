@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// RepositoryPolicy overrides, for a single repository, policies otherwise set
+// by the registry configuration. Fields which are not set follow the
+// configuration.
+type RepositoryPolicy struct {
+	// ImmutableTags, if set, controls whether a tag, once pushed, may be
+	// pushed again to refer to another manifest.
+	ImmutableTags *bool `json:"immutableTags,omitempty"`
+
+	// ManifestTTL, if set, controls how long after being pushed manifests
+	// expire, as a duration such as "24h". A duration of "0" disables
+	// expiry.
+	ManifestTTL *string `json:"manifestTTL,omitempty"`
+}
+
+// Validate checks that the overrides of the policy are well formed.
+func (policy RepositoryPolicy) Validate() error {
+	if policy.ManifestTTL != nil {
+		ttl, err := time.ParseDuration(*policy.ManifestTTL)
+		if err != nil {
+			return fmt.Errorf("invalid manifestTTL: %v", err)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("invalid manifestTTL: %s is negative", ttl)
+		}
+	}
+	return nil
+}
+
+// GetRepositoryPolicy returns the policy overrides stored for the named
+// repository. If none are stored, the zero RepositoryPolicy is returned.
+func GetRepositoryPolicy(ctx context.Context, driver storagedriver.StorageDriver, name string) (RepositoryPolicy, error) {
+	policyPath, err := pathFor(repositoryPolicyPathSpec{name: name})
+	if err != nil {
+		return RepositoryPolicy{}, err
+	}
+
+	content, err := driver.GetContent(ctx, policyPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return RepositoryPolicy{}, nil
+		}
+		return RepositoryPolicy{}, err
+	}
+
+	var policy RepositoryPolicy
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return RepositoryPolicy{}, err
+	}
+	return policy, nil
+}
+
+// PutRepositoryPolicy stores policy as the policy overrides of the named
+// repository, replacing any stored before.
+func PutRepositoryPolicy(ctx context.Context, driver storagedriver.StorageDriver, name string, policy RepositoryPolicy) error {
+	policyPath, err := pathFor(repositoryPolicyPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	return driver.PutContent(ctx, policyPath, content)
+}
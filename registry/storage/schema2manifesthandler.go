@@ -20,10 +20,13 @@ var (
 
 //schema2ManifestHandler is a ManifestHandler that covers schema2 manifests.
 type schema2ManifestHandler struct {
-	repository   distribution.Repository
-	blobStore    distribution.BlobStore
-	ctx          context.Context
-	manifestURLs manifestURLs
+	repository    distribution.Repository
+	blobStore     distribution.BlobStore
+	ctx           context.Context
+	manifestURLs  manifestURLs
+	strictDigests bool
+	platforms     platformAllowlist
+	archives      layerArchives
 }
 
 var _ ManifestHandler = &schema2ManifestHandler{}
@@ -87,12 +90,21 @@ func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst sche
 	blobsService := ms.repository.Blobs(ctx)
 
 	for _, descriptor := range mnfst.References() {
-		var err error
+		dgst, err := normalizeReference(descriptor.Digest, ms.strictDigests)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		descriptor.Digest = dgst
 
 		switch descriptor.MediaType {
 		case schema2.MediaTypeForeignLayer:
 			// Clients download this layer from an external URL, so do not check for
 			// its presence.
+			if u := ms.manifestURLs.insecureURL(descriptor.URLs); u != "" {
+				errs = append(errs, distribution.ErrManifestURLInsecure{URL: u})
+				continue
+			}
 			if len(descriptor.URLs) == 0 {
 				err = errMissingURL
 			}
@@ -131,6 +143,24 @@ func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst sche
 		}
 	}
 
+	// Layers are read in full, so they are validated only once the manifest
+	// is known to reference no missing blobs.
+	if len(errs) == 0 {
+		for _, layer := range mnfst.Layers {
+			if len(layer.URLs) != 0 {
+				continue
+			}
+			layer.Digest = canonicalDigest(layer.Digest)
+			if err := ms.archives.verify(ctx, blobsService, layer); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := ms.platforms.verify(ctx, blobsService, mnfst.Config); err != nil {
+		errs = append(errs, err)
+	}
+
 	if len(errs) != 0 {
 		return errs
 	}
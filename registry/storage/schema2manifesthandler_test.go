@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -134,3 +135,48 @@ func TestVerifyManifestForeignLayer(t *testing.T) {
 		}
 	}
 }
+
+func TestVerifyManifestForeignLayerRequireHTTPS(t *testing.T) {
+	ctx := context.Background()
+
+	for _, requireHTTPS := range []bool{false, true} {
+		options := []RegistryOption{ManifestURLsAllowRegexp(regexp.MustCompile("^https?://foo"))}
+		if requireHTTPS {
+			options = append(options, ManifestURLsRequireHTTPS)
+		}
+		registry := createRegistry(t, inmemory.New(), options...)
+		repo := makeRepository(t, registry, "test")
+		manifestService := makeManifestService(t, repo)
+
+		config, err := repo.Blobs(ctx).Put(ctx, schema2.MediaTypeImageConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, u := range []string{"http://foo/bar", "https://foo/bar"} {
+			dm, err := schema2.FromStruct(schema2.Manifest{
+				Versioned: schema2.SchemaVersion,
+				Config:    config,
+				Layers: []distribution.Descriptor{{
+					Digest:    "sha256:463435349086340864309863409683460843608348608934092322395278926a",
+					Size:      6323,
+					MediaType: schema2.MediaTypeForeignLayer,
+					URLs:      []string{u},
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var expected error
+			if requireHTTPS && u == "http://foo/bar" {
+				expected = distribution.ErrManifestVerification{distribution.ErrManifestURLInsecure{URL: u}}
+			}
+
+			_, err = manifestService.Put(ctx, dm)
+			if !reflect.DeepEqual(err, expected) {
+				t.Errorf("requireHTTPS=%v, %s: expected %v, got %v", requireHTTPS, u, expected, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ScrubOptions configures ScrubBlobs.
+type ScrubOptions struct {
+	// BytesPerSecond, if positive, limits the rate at which blob content is
+	// read from the storage backend.
+	BytesPerSecond int64
+
+	// Quarantine moves the content of corrupt blobs aside, so that they are
+	// no longer served and may be pushed again.
+	Quarantine bool
+}
+
+// ScrubBlobs reads the content of every blob in the blob store, whether
+// stored plainly, compressed or inline, and verifies it against the blob's
+// digest. The digests of blobs whose content does not match are returned,
+// along with any errors encountered, which do not stop the scrub. Blobs of
+// unavailable digest algorithms are skipped.
+//
+// If opts.Quarantine is set, the content of each corrupt blob is moved aside
+// to a file the registry does not read, leaving the blob unknown. Cached
+// descriptors of the blob are not cleared, so may be served until they
+// expire.
+func ScrubBlobs(ctx context.Context, driver storagedriver.StorageDriver, opts ScrubOptions) ([]digest.Digest, []error) {
+	var (
+		corrupt []digest.Digest
+		errs    []error
+	)
+
+	root, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return nil, append(errs, err)
+	}
+
+	throttle := &scrubThrottle{bytesPerSecond: opts.BytesPerSecond, start: time.Now()}
+	err = storagedriver.WalkFallback(ctx, driver, root, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		contentPath := fileInfo.Path()
+		dir, fileName := path.Split(contentPath)
+		switch fileName {
+		case "data", "data.gz", "inline":
+		default:
+			return nil
+		}
+
+		dgst, err := digestFromPath(path.Join(dir, "data"))
+		if err != nil {
+			errs = pushError(errs, contentPath, err)
+			return nil
+		}
+		if !dgst.Algorithm().Available() {
+			return nil
+		}
+
+		matches, err := verifyBlobContent(ctx, driver, contentPath, dgst, throttle)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = pushError(errs, contentPath, err)
+			return nil
+		}
+		if matches {
+			return nil
+		}
+
+		dcontext.GetLoggerWithField(ctx, "blob", dgst).Errorf("blob content at %s does not match its digest", contentPath)
+		corrupt = append(corrupt, dgst)
+		if opts.Quarantine {
+			if err := quarantineBlobContent(ctx, driver, contentPath, dgst); err != nil {
+				errs = pushError(errs, contentPath, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return corrupt, errs
+}
+
+// verifyBlobContent reports whether the content at contentPath, which is
+// gzipped if it is the compressed data of a blob, matches dgst.
+func verifyBlobContent(ctx context.Context, driver storagedriver.StorageDriver, contentPath string, dgst digest.Digest, throttle *scrubThrottle) (bool, error) {
+	rc, err := driver.Reader(ctx, contentPath, 0)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	tr := &throttledReader{ctx: ctx, r: rc, throttle: throttle}
+	var r io.Reader = tr
+	if path.Base(contentPath) == "data.gz" {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			if tr.err != nil {
+				return false, err
+			}
+			// Content which is not gzipped cannot be the blob.
+			return false, nil
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, r); err != nil {
+		if tr.err == nil {
+			// The content was read, but could not be decompressed.
+			return false, nil
+		}
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
+
+// quarantineBlobContent moves the corrupt content at contentPath aside.
+func quarantineBlobContent(ctx context.Context, driver storagedriver.StorageDriver, contentPath string, dgst digest.Digest) error {
+	corruptPath, err := pathFor(blobCorruptDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	return driver.Move(ctx, contentPath, corruptPath)
+}
+
+// scrubThrottle limits the rate at which a scrub reads content, across all
+// the blobs it reads.
+type scrubThrottle struct {
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+}
+
+// wait records n bytes read, then sleeps until reading them keeps within
+// the rate, or ctx is done.
+func (t *scrubThrottle) wait(ctx context.Context, n int) error {
+	if t.bytesPerSecond <= 0 {
+		return nil
+	}
+
+	t.read += int64(n)
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.bytesPerSecond) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledReader reads from r, limited by throttle. It records the error
+// with which reading failed, if any, to tell it apart from errors in
+// processing the content read.
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	throttle *scrubThrottle
+	err      error
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if werr := tr.throttle.wait(tr.ctx, n); werr != nil {
+		err = werr
+	}
+	if err != nil && err != io.EOF {
+		tr.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestScrubBlobs(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d, EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	name, _ := reference.WithName("foo/scrub")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("error getting repository: %v", err)
+	}
+	blobs := repo.Blobs(ctx)
+
+	var dgsts []digest.Digest
+	for _, content := range []string{"first", "second", "third"} {
+		desc, err := blobs.Put(ctx, "application/octet-stream", []byte(content))
+		if err != nil {
+			t.Fatalf("error putting blob: %v", err)
+		}
+		dgsts = append(dgsts, desc.Digest)
+	}
+
+	// Corrupt the second blob behind the registry's back.
+	corruptPath, err := pathFor(blobDataPathSpec{digest: dgsts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, corruptPath, []byte("tampered")); err != nil {
+		t.Fatalf("error corrupting blob: %v", err)
+	}
+
+	corrupt, errs := ScrubBlobs(ctx, d, ScrubOptions{})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors scrubbing: %v", errs)
+	}
+	if len(corrupt) != 1 || corrupt[0] != dgsts[1] {
+		t.Fatalf("expected only %s to be corrupt, got %v", dgsts[1], corrupt)
+	}
+
+	// Without quarantine, the corrupt blob is left in place.
+	if _, err := d.Stat(ctx, corruptPath); err != nil {
+		t.Fatalf("expected corrupt blob to remain: %v", err)
+	}
+
+	corrupt, errs = ScrubBlobs(ctx, d, ScrubOptions{Quarantine: true, BytesPerSecond: 1 << 20})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors scrubbing: %v", errs)
+	}
+	if len(corrupt) != 1 || corrupt[0] != dgsts[1] {
+		t.Fatalf("expected only %s to be corrupt, got %v", dgsts[1], corrupt)
+	}
+
+	// A quarantined blob is unknown, and not scrubbed again.
+	statter := &blobStatter{driver: d}
+	if _, err := statter.Stat(ctx, dgsts[1]); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected quarantined blob to be unknown, got %v", err)
+	}
+	for _, dgst := range []digest.Digest{dgsts[0], dgsts[2]} {
+		if _, err := statter.Stat(ctx, dgst); err != nil {
+			t.Fatalf("unexpected error statting intact blob %s: %v", dgst, err)
+		}
+	}
+
+	corrupt, errs = ScrubBlobs(ctx, d, ScrubOptions{})
+	if len(errs) != 0 || len(corrupt) != 0 {
+		t.Fatalf("expected nothing corrupt after quarantine, got %v, errors %v", corrupt, errs)
+	}
+}
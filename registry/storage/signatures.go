@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema1"
+)
+
+// signatureAlgorithms lists the JWS algorithms with which libtrust signs
+// schema1 manifests. The strength of the key is implied by the algorithm for
+// EC keys, whose curve must match it.
+var signatureAlgorithms = map[string]bool{
+	"ES256": true,
+	"ES384": true,
+	"ES512": true,
+	"RS256": true,
+	"RS384": true,
+	"RS512": true,
+}
+
+// signatureAlgorithmAllowlist is the set of JWS algorithms with which schema1
+// manifests may be signed. A nil allowlist allows any algorithm.
+type signatureAlgorithmAllowlist map[string]bool
+
+// newSignatureAlgorithmAllowlist returns an allowlist of the given
+// algorithms, which must each be one supported by libtrust.
+func newSignatureAlgorithmAllowlist(algorithms []string) (signatureAlgorithmAllowlist, error) {
+	allowlist := make(signatureAlgorithmAllowlist, len(algorithms))
+	for _, algorithm := range algorithms {
+		if !signatureAlgorithms[algorithm] {
+			return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+		}
+		allowlist[algorithm] = true
+	}
+	return allowlist, nil
+}
+
+// verify checks the algorithm of every signature of a schema1 manifest
+// against the allowlist, returning ErrManifestUnverified if any is not
+// allowed. The signatures themselves must already have been verified, since
+// libtrust verifies each with the algorithm it names.
+func (sa signatureAlgorithmAllowlist) verify(sm *schema1.SignedManifest) error {
+	if sa == nil {
+		return nil
+	}
+
+	signatures, err := sm.Signatures()
+	if err != nil {
+		return err
+	}
+
+	for _, signature := range signatures {
+		var jws struct {
+			Header struct {
+				Algorithm string `json:"alg"`
+			} `json:"header"`
+		}
+		if err := json.Unmarshal(signature, &jws); err != nil {
+			return err
+		}
+		if !sa[jws.Header.Algorithm] {
+			return distribution.ErrManifestUnverified{}
+		}
+	}
+	return nil
+}
@@ -20,6 +20,15 @@ type signedManifestHandler struct {
 	schema1SigningKey libtrust.PrivateKey
 	blobStore         distribution.BlobStore
 	ctx               context.Context
+	strictDigests     bool
+
+	// layerOrder rejects manifests whose history does not describe their
+	// FSLayers in order.
+	layerOrder bool
+
+	// signatureAlgorithms, if not nil, rejects manifests signed with other
+	// algorithms.
+	signatureAlgorithms signatureAlgorithmAllowlist
 }
 
 var _ ManifestHandler = &signedManifestHandler{}
@@ -103,7 +112,11 @@ func (ms *signedManifestHandler) verifyManifest(ctx context.Context, mnfst schem
 			})
 	}
 
-	if len(mnfst.History) != len(mnfst.FSLayers) {
+	if ms.layerOrder {
+		if err := checkLayerOrder(mnfst); err != nil {
+			errs = append(errs, err)
+		}
+	} else if len(mnfst.History) != len(mnfst.FSLayers) {
 		errs = append(errs, fmt.Errorf("mismatched history and fslayer cardinality %d != %d",
 			len(mnfst.History), len(mnfst.FSLayers)))
 	}
@@ -119,11 +132,19 @@ func (ms *signedManifestHandler) verifyManifest(ctx context.Context, mnfst schem
 				errs = append(errs, err)
 			}
 		}
+	} else if err := ms.signatureAlgorithms.verify(&mnfst); err != nil {
+		errs = append(errs, err)
 	}
 
 	if !skipDependencyVerification {
 		for _, fsLayer := range mnfst.References() {
-			_, err := ms.repository.Blobs(ctx).Stat(ctx, fsLayer.Digest)
+			dgst, err := normalizeReference(fsLayer.Digest, ms.strictDigests)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			_, err = ms.repository.Blobs(ctx).Stat(ctx, dgst)
 			if err != nil {
 				if err != distribution.ErrBlobUnknown {
 					errs = append(errs, err)
@@ -140,3 +161,43 @@ func (ms *signedManifestHandler) verifyManifest(ctx context.Context, mnfst schem
 
 	return nil
 }
+
+// checkLayerOrder ensures that the history of mnfst has one entry for each
+// FSLayer and that, in the same order, the entries form a single chain from
+// the top layer to the base, each naming the next as its parent.
+func checkLayerOrder(mnfst schema1.SignedManifest) error {
+	if len(mnfst.History) != len(mnfst.FSLayers) {
+		return distribution.ErrManifestLayerOrder{
+			Reason: fmt.Sprintf("%d history entries for %d fslayers", len(mnfst.History), len(mnfst.FSLayers)),
+		}
+	}
+
+	var v1Compat struct {
+		ID     string `json:"id"`
+		Parent string `json:"parent,omitempty"`
+	}
+	seen := make(map[string]bool, len(mnfst.History))
+	var parent string
+	for i, h := range mnfst.History {
+		v1Compat.ID, v1Compat.Parent = "", ""
+		if err := json.Unmarshal([]byte(h.V1Compatibility), &v1Compat); err != nil {
+			return distribution.ErrManifestLayerOrder{Reason: fmt.Sprintf("history entry %d: %v", i, err)}
+		}
+
+		switch {
+		case v1Compat.ID == "":
+			return distribution.ErrManifestLayerOrder{Reason: fmt.Sprintf("history entry %d has no id", i)}
+		case seen[v1Compat.ID]:
+			return distribution.ErrManifestLayerOrder{Reason: fmt.Sprintf("history entry %d repeats id %s", i, v1Compat.ID)}
+		case i > 0 && v1Compat.ID != parent:
+			return distribution.ErrManifestLayerOrder{Reason: fmt.Sprintf("history entry %d is not the parent of entry %d", i, i-1)}
+		}
+		seen[v1Compat.ID] = true
+		parent = v1Compat.Parent
+	}
+
+	if parent != "" {
+		return distribution.ErrManifestLayerOrder{Reason: "base layer has a parent"}
+	}
+	return nil
+}
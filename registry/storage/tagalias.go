@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// SetTagAlias makes tag an alias of target in the named repository, so that
+// the tag resolves to whatever target resolves to at the time it is fetched.
+// Any manifest tag previously held is replaced by the alias. Target must be a
+// known tag, and aliasing it must not create a cycle.
+func SetTagAlias(ctx context.Context, driver storagedriver.StorageDriver, name, tag, target string) error {
+	// Follow the aliases from target, to ensure they neither lead back to
+	// the tag nor dangle.
+	visited := map[string]bool{tag: true}
+	current := target
+	for {
+		if visited[current] {
+			return distribution.ErrTagAliasCycle{Tag: tag}
+		}
+		visited[current] = true
+
+		next, err := TagAlias(ctx, driver, name, current)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			break
+		}
+		current = next
+	}
+
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: current})
+	if err != nil {
+		return err
+	}
+	if _, err := driver.Stat(ctx, currentPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return distribution.ErrTagUnknown{Tag: current}
+		}
+		return err
+	}
+
+	previous, err := TagAlias(ctx, driver, name, tag)
+	if err != nil {
+		return err
+	}
+	if previous != "" && previous != target {
+		if err := removeAliasIndexEntry(ctx, driver, name, previous, tag); err != nil {
+			return err
+		}
+	}
+
+	// The reverse index is written first, so that an alias is never missing
+	// from it. Entries left behind by a failure are ignored when read.
+	indexPath, err := pathFor(manifestTagAliasesPathSpec{name: name, tag: target, alias: tag})
+	if err != nil {
+		return err
+	}
+	if err := driver.PutContent(ctx, indexPath, []byte{}); err != nil {
+		return err
+	}
+
+	aliasPath, err := pathFor(manifestTagAliasPathSpec{name: name, tag: tag})
+	if err != nil {
+		return err
+	}
+	if err := driver.PutContent(ctx, aliasPath, []byte(target)); err != nil {
+		return err
+	}
+
+	// The tag's index is left in place, so the manifests it referenced
+	// remain associated with it.
+	tagCurrentPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: tag})
+	if err != nil {
+		return err
+	}
+	if err := driver.Delete(ctx, tagCurrentPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TagAlias returns the tag that tag is an alias of in the named repository,
+// or an empty string if tag is not an alias.
+func TagAlias(ctx context.Context, driver storagedriver.StorageDriver, name, tag string) (string, error) {
+	aliasPath, err := pathFor(manifestTagAliasPathSpec{name: name, tag: tag})
+	if err != nil {
+		return "", err
+	}
+
+	content, err := driver.GetContent(ctx, aliasPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// RemoveTagAlias removes the alias held by tag in the named repository,
+// leaving the tag unknown until it is pushed again. Removing an alias which
+// does not exist is not an error.
+func RemoveTagAlias(ctx context.Context, driver storagedriver.StorageDriver, name, tag string) error {
+	target, err := TagAlias(ctx, driver, name, tag)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return nil
+	}
+
+	aliasPath, err := pathFor(manifestTagAliasPathSpec{name: name, tag: tag})
+	if err != nil {
+		return err
+	}
+
+	if err := driver.Delete(ctx, aliasPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	return removeAliasIndexEntry(ctx, driver, name, target, tag)
+}
+
+// removeAliasIndexEntry removes alias from the reverse index of the aliases
+// of target.
+func removeAliasIndexEntry(ctx context.Context, driver storagedriver.StorageDriver, name, target, alias string) error {
+	indexPath, err := pathFor(manifestTagAliasesPathSpec{name: name, tag: target, alias: alias})
+	if err != nil {
+		return err
+	}
+
+	if err := driver.Delete(ctx, indexPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveAlias follows the aliases from tag, returning the tag which holds a
+// manifest.
+func (ts *tagStore) resolveAlias(ctx context.Context, tag string) (string, error) {
+	name := ts.repository.Named().Name()
+	visited := make(map[string]bool)
+
+	for current := tag; ; {
+		if visited[current] {
+			return "", distribution.ErrTagAliasCycle{Tag: tag}
+		}
+		visited[current] = true
+
+		next, err := TagAlias(ctx, ts.blobStore.driver, name, current)
+		if err != nil {
+			return "", err
+		}
+		if next == "" {
+			return current, nil
+		}
+		current = next
+	}
+}
+
+// aliasesOf returns the tags which are aliases of tag, as recorded in the
+// reverse index kept alongside the tag. Only the aliases of the tag are read,
+// rather than every tag in the repository.
+func (ts *tagStore) aliasesOf(ctx context.Context, tag string) ([]string, error) {
+	name := ts.repository.Named().Name()
+	indexPath, err := pathFor(manifestTagAliasesPathSpec{name: name, tag: tag})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ts.blobStore.driver.List(ctx, indexPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var aliases []string
+	for _, entry := range entries {
+		candidate := path.Base(entry)
+
+		// Skip entries left behind by an alias which has since been
+		// retargeted or removed.
+		target, err := TagAlias(ctx, ts.blobStore.driver, name, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if target == tag {
+			aliases = append(aliases, candidate)
+		}
+	}
+	sort.Strings(aliases)
+
+	return aliases, nil
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/uuid"
+	"github.com/opencontainers/go-digest"
+)
+
+// Actions of tag events.
+const (
+	// TagEventCreate records a tag being pushed for the first time.
+	TagEventCreate = "create"
+	// TagEventUpdate records a tag being moved to another manifest.
+	TagEventUpdate = "update"
+	// TagEventDelete records a tag being deleted.
+	TagEventDelete = "delete"
+)
+
+// TagEvent describes a change to a tag of a repository.
+type TagEvent struct {
+	// Sequence numbers the event within the log of its repository. Events
+	// are recorded in the order in which the changes they describe were
+	// committed, so later events have higher numbers.
+	Sequence uint64 `json:"sequence"`
+	Action   string `json:"action"`
+	Tag      string `json:"tag"`
+	// Digest is the manifest the tag refers to after the change. It is
+	// empty for deletions.
+	Digest digest.Digest `json:"digest,omitempty"`
+	Time   time.Time     `json:"time"`
+}
+
+// tagEventLog is the stored log of the tag events of a repository. Events
+// are numbered in order from zero, and only the newest are retained.
+type tagEventLog struct {
+	// ID identifies the log, so that cursors into a log which has been
+	// deleted, such as with its repository, are not resumed in another.
+	ID string `json:"id"`
+	// Next is the number of the next event to be appended.
+	Next   uint64     `json:"next"`
+	Events []TagEvent `json:"events"`
+}
+
+// first returns the number of the oldest event retained.
+func (l *tagEventLog) first() uint64 {
+	return l.Next - uint64(len(l.Events))
+}
+
+// cursor returns the cursor following the event numbered seq-1.
+func (l *tagEventLog) cursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(l.ID + ":" + strconv.FormatUint(seq, 10)))
+}
+
+// parseCursor returns the number of the event following cursor, which must
+// have been returned for this log.
+func (l *tagEventLog) parseCursor(cursor string) (uint64, error) {
+	p, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, distribution.ErrTagEventCursorInvalid{Cursor: cursor, Reason: "malformed"}
+	}
+	parts := strings.SplitN(string(p), ":", 2)
+	if len(parts) != 2 {
+		return 0, distribution.ErrTagEventCursorInvalid{Cursor: cursor, Reason: "malformed"}
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, distribution.ErrTagEventCursorInvalid{Cursor: cursor, Reason: "malformed"}
+	}
+
+	switch {
+	case parts[0] != l.ID || seq > l.Next:
+		return 0, distribution.ErrTagEventCursorInvalid{Cursor: cursor, Reason: "unknown to this repository"}
+	case seq < l.first():
+		return 0, distribution.ErrTagEventCursorInvalid{Cursor: cursor, Reason: "events since the cursor are no longer retained"}
+	}
+	return seq, nil
+}
+
+// TagEvents returns at most n of the tag events of the named repository
+// which follow cursor, oldest first, as recorded by RecordTagEvent, along
+// with the cursor following the last event returned. If n is not positive,
+// all the events following cursor are returned. An empty cursor starts from
+// the oldest event retained. If the events following cursor are no longer
+// retained, distribution.ErrTagEventCursorInvalid is returned, and the tags
+// of the repository must be listed afresh.
+func TagEvents(ctx context.Context, driver storagedriver.StorageDriver, name, cursor string, n int) ([]TagEvent, string, error) {
+	logPath, err := pathFor(tagEventLogPathSpec{name: name})
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, err := getContentIfExists(ctx, driver, logPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if content == nil {
+		if cursor != "" {
+			return nil, "", distribution.ErrTagEventCursorInvalid{Cursor: cursor, Reason: "unknown to this repository"}
+		}
+		return nil, "", nil
+	}
+
+	var log tagEventLog
+	if err := json.Unmarshal(content, &log); err != nil {
+		return nil, "", err
+	}
+
+	seq := log.first()
+	if cursor != "" {
+		seq, err = log.parseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	events := log.Events[seq-log.first():]
+	if n > 0 && len(events) > n {
+		events = events[:n]
+	}
+	return events, log.cursor(seq + uint64(len(events))), nil
+}
+
+// RecordTagEvent appends event to the tag event log of the named repository,
+// numbering it and retaining only the newest limit events. The log is updated
+// with a compare-and-swap, so that concurrent events are not lost. The log is
+// kept independently of any other consumer, such as the notification system,
+// which may read it with TagEvents.
+func RecordTagEvent(ctx context.Context, driver storagedriver.StorageDriver, name string, event TagEvent, limit int) error {
+	return updateTagEventLog(ctx, driver, name, limit, func(log *tagEventLog) (*TagEvent, error) {
+		return &event, nil
+	})
+}
+
+// updateTagEventLog appends the event returned by next, given the current
+// log of the named repository, numbering it and retaining only the newest
+// limit events. next may be called again if the log is updated concurrently,
+// and nothing is appended if it returns no event.
+func updateTagEventLog(ctx context.Context, driver storagedriver.StorageDriver, name string, limit int, next func(log *tagEventLog) (*TagEvent, error)) error {
+	logPath, err := pathFor(tagEventLogPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	return updateContent(ctx, driver, logPath, func(current []byte) ([]byte, error) {
+		var log tagEventLog
+		if current != nil {
+			if err := json.Unmarshal(current, &log); err != nil {
+				// A corrupt log should not prevent recording, so start a
+				// new one, invalidating the cursors into the old one.
+				dcontext.GetLogger(ctx).Warnf("discarding unreadable tag event log of %q: %v", name, err)
+				log = tagEventLog{}
+			}
+		}
+		if log.ID == "" {
+			log.ID = uuid.Generate().String()
+		}
+
+		event, err := next(&log)
+		if err != nil || event == nil {
+			return nil, err
+		}
+
+		event.Sequence = log.Next
+		log.Events = append(log.Events, *event)
+		log.Next++
+		if len(log.Events) > limit {
+			log.Events = log.Events[len(log.Events)-limit:]
+		}
+
+		return json.Marshal(log)
+	})
+}
+
+// last returns the newest event retained for tag, or nil if there is none.
+func (l *tagEventLog) last(tag string) *TagEvent {
+	for i := len(l.Events) - 1; i >= 0; i-- {
+		if l.Events[i].Tag == tag {
+			return &l.Events[i]
+		}
+	}
+	return nil
+}
+
+// recordTagEvent records a change to tag in the tag event log of the
+// repository, if the log is enabled. before and after are the digests the
+// tag referred to before and after the change, either of which is empty if
+// the tag did not exist. Changes which leave the tag as it was are not
+// recorded.
+//
+// Concurrent changes to the tag may be committed in one order and reach the
+// log in another, so the event records the tag as read while the log is
+// updated rather than after. Each change is followed by an update of the
+// log, so the newest event of the tag always describes its committed state,
+// and the event of a change already described by a concurrent one is not
+// recorded. Consumers should therefore treat creations and updates alike, as
+// the creation of a tag may be described by an update.
+func (ts *tagStore) recordTagEvent(ctx context.Context, tag string, before, after digest.Digest) error {
+	limit := ts.repository.tagEventLimit
+	if limit <= 0 || before == after {
+		return nil
+	}
+
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = updateTagEventLog(ctx, ts.blobStore.driver, ts.repository.Named().Name(), limit, func(log *tagEventLog) (*TagEvent, error) {
+		current, err := ts.currentLink(ctx, currentPath)
+		if err != nil {
+			return nil, err
+		}
+
+		last := log.last(tag)
+		if last != nil && last.Digest == current {
+			return nil, nil
+		}
+
+		event := TagEvent{
+			Tag:    tag,
+			Digest: current,
+			Time:   time.Now().UTC(),
+		}
+		switch {
+		case current == "":
+			event.Action = TagEventDelete
+		case last != nil && last.Digest != "", last == nil && before != "":
+			event.Action = TagEventUpdate
+		default:
+			event.Action = TagEventCreate
+		}
+		return &event, nil
+	})
+	if err != nil {
+		return fmt.Errorf("recording tag event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// TagResolution describes the digest a tag resolved to at a point in time.
+type TagResolution struct {
+	Digest digest.Digest `json:"digest"`
+	Time   time.Time     `json:"time"`
+}
+
+// TagHistory returns the digests the named tag has resolved to, oldest first,
+// as recorded by RecordTagResolution. If no history has been recorded for the
+// tag, an empty slice is returned.
+func TagHistory(ctx context.Context, driver storagedriver.StorageDriver, name, tag string) ([]TagResolution, error) {
+	historyPath, err := pathFor(manifestTagHistoryPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return readTagHistory(ctx, driver, historyPath)
+}
+
+func readTagHistory(ctx context.Context, driver storagedriver.StorageDriver, historyPath string) ([]TagResolution, error) {
+	content, err := driver.GetContent(ctx, historyPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var history []TagResolution
+	if err := json.Unmarshal(content, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// RecordTagResolution appends dgst to the resolution history of the named
+// tag, unless it is the most recently recorded digest. Only the newest limit
+// entries are retained. The history is updated with a compare-and-swap, so
+// that concurrent resolutions are not lost.
+func RecordTagResolution(ctx context.Context, driver storagedriver.StorageDriver, name, tag string, dgst digest.Digest, limit int) error {
+	historyPath, err := pathFor(manifestTagHistoryPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	return updateContent(ctx, driver, historyPath, func(current []byte) ([]byte, error) {
+		var history []TagResolution
+		if current != nil {
+			if err := json.Unmarshal(current, &history); err != nil {
+				// A corrupt history should not prevent recording, so
+				// start over rather than failing forever.
+				dcontext.GetLogger(ctx).Warnf("discarding unreadable history for tag %q: %v", tag, err)
+				history = nil
+			}
+		}
+
+		if len(history) > 0 && history[len(history)-1].Digest == dgst {
+			return nil, nil
+		}
+
+		history = append(history, TagResolution{Digest: dgst, Time: time.Now().UTC()})
+		if len(history) > limit {
+			history = history[len(history)-limit:]
+		}
+
+		return json.Marshal(history)
+	})
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// TagPushedAt returns the time the named tag was last pushed. Tags pushed
+// before push times were recorded fall back to the modification time of
+// their current link. A zero time is returned for an unknown tag.
+func TagPushedAt(ctx context.Context, driver storagedriver.StorageDriver, name, tag string) (time.Time, error) {
+	pushedAtPath, err := pathFor(manifestTagPushedAtPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	content, err := driver.GetContent(ctx, pushedAtPath)
+	switch err.(type) {
+	case nil:
+		return time.Parse(time.RFC3339Nano, string(content))
+	case storagedriver.PathNotFoundError:
+	default:
+		return time.Time{}, err
+	}
+
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	fi, err := driver.Stat(ctx, currentPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// recordPushedAt records the current time as the push time of the tag.
+func (ts *tagStore) recordPushedAt(ctx context.Context, tag string) error {
+	pushedAtPath, err := pathFor(manifestTagPushedAtPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ts.blobStore.driver.PutContent(ctx, pushedAtPath, []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+}
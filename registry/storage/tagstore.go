@@ -10,6 +10,8 @@ import (
 )
 
 var _ distribution.TagService = &tagStore{}
+var _ distribution.TagSwapper = &tagStore{}
+var _ distribution.UntagChecker = &tagStore{}
 
 // tagStore provides methods to manage manifest tags in a backend storage driver.
 // This implementation uses the same on-disk layout as the (now deleted) tag
@@ -62,6 +64,15 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
+	// The previous digest is only needed to describe the change in the tag
+	// event log.
+	var previous digest.Digest
+	if ts.repository.tagEventLimit > 0 {
+		if previous, err = ts.currentLink(ctx, currentPath); err != nil {
+			return err
+		}
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -70,10 +81,85 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 	}
 
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+
+	// A tag pushed to is no longer an alias.
+	if err := RemoveTagAlias(ctx, ts.blobStore.driver, ts.repository.Named().Name(), tag); err != nil {
+		return err
+	}
+
+	if err := ts.recordPushedAt(ctx, tag); err != nil {
+		return err
+	}
+
+	return ts.recordTagEvent(ctx, tag, previous, desc.Digest)
+}
+
+// SwapTag associates the tag with desc only if it is currently associated with
+// expected. The swap is atomic if the driver implements
+// storagedriver.CompareAndSwapper. Otherwise, it is atomic only among the
+// callers within this process; see compareAndSwapContent.
+func (ts *tagStore) SwapTag(ctx context.Context, tag string, expected digest.Digest, desc distribution.Descriptor) error {
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	swapped, err := ts.swapLink(ctx, currentPath, expected, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		actual, err := ts.currentLink(ctx, currentPath)
+		if err != nil {
+			return err
+		}
+		return distribution.ErrTagPreconditionFailed{Tag: tag, Expected: expected, Actual: actual}
+	}
+
+	// Link into the index once the tag is known to reference desc.
+	if err := ts.linkedBlobStore(ctx, tag).linkBlob(ctx, desc); err != nil {
+		return err
+	}
+
+	if err := ts.recordPushedAt(ctx, tag); err != nil {
+		return err
+	}
+
+	return ts.recordTagEvent(ctx, tag, expected, desc.Digest)
+}
+
+// swapLink replaces the link at linkPath with dgst if it currently links
+// expected, reporting whether it did.
+func (ts *tagStore) swapLink(ctx context.Context, linkPath string, expected, dgst digest.Digest) (bool, error) {
+	var old []byte
+	if expected != "" {
+		old = []byte(expected)
+	}
+
+	return compareAndSwapContent(ctx, ts.blobStore.driver, linkPath, old, []byte(dgst))
+}
+
+// currentLink returns the digest linked at linkPath, or an empty digest if
+// there is no link.
+func (ts *tagStore) currentLink(ctx context.Context, linkPath string) (digest.Digest, error) {
+	dgst, err := ts.blobStore.readlink(ctx, linkPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	return dgst, nil
 }
 
-// resolve the current revision for name and tag.
+// resolve the current revision for name and tag. Aliases are followed to the
+// tag they resolve through.
 func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
 		name: ts.repository.Named().Name(),
@@ -88,6 +174,15 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 	if err != nil {
 		switch err.(type) {
 		case storagedriver.PathNotFoundError:
+			// Only aliases lack a current link, so the alias is read
+			// just for tags which would otherwise be unknown.
+			resolved, err := ts.resolveAlias(ctx, tag)
+			if err != nil {
+				return distribution.Descriptor{}, err
+			}
+			if resolved != tag {
+				return ts.Get(ctx, resolved)
+			}
 			return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
 		}
 
@@ -97,8 +192,43 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 	return distribution.Descriptor{Digest: revision}, nil
 }
 
-// Untag removes the tag association
+// CheckUntag returns ErrTagAliased if the registry's TagAliasDeletes policy
+// is TagAliasDeletesStrict and aliases resolve through the tag.
+func (ts *tagStore) CheckUntag(ctx context.Context, tag string) error {
+	if ts.repository.tagAliasDeletes != TagAliasDeletesStrict {
+		return nil
+	}
+
+	aliases, err := ts.aliasesOf(ctx, tag)
+	if err != nil {
+		return err
+	}
+	if len(aliases) > 0 {
+		return distribution.ErrTagAliased{Tag: tag, Aliases: aliases}
+	}
+	return nil
+}
+
+// Untag removes the tag association. Aliases of the tag are handled according
+// to the registry's TagAliasDeletes policy.
 func (ts *tagStore) Untag(ctx context.Context, tag string) error {
+	var aliases []string
+	if ts.repository.tagAliasDeletes != "" {
+		var err error
+		aliases, err = ts.aliasesOf(ctx, tag)
+		if err != nil {
+			return err
+		}
+		if len(aliases) > 0 && ts.repository.tagAliasDeletes == TagAliasDeletesStrict {
+			return distribution.ErrTagAliased{Tag: tag, Aliases: aliases}
+		}
+	}
+
+	// An alias being untagged is dropped from the index of its target.
+	if err := RemoveTagAlias(ctx, ts.blobStore.driver, ts.repository.Named().Name(), tag); err != nil {
+		return err
+	}
+
 	tagPath, err := pathFor(manifestTagPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -107,15 +237,43 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
+	// Aliases have no current link, so their removal is not recorded in
+	// the tag event log.
+	var previous digest.Digest
+	if ts.repository.tagEventLimit > 0 {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{
+			name: ts.repository.Named().Name(),
+			tag:  tag,
+		})
+		if err != nil {
+			return err
+		}
+		if previous, err = ts.currentLink(ctx, currentPath); err != nil {
+			return err
+		}
+	}
+
 	if err := ts.blobStore.driver.Delete(ctx, tagPath); err != nil {
 		switch err.(type) {
 		case storagedriver.PathNotFoundError:
-			return nil // Untag is idempotent, we don't care if it didn't exist
+			// Untag is idempotent, we don't care if it didn't exist
 		default:
 			return err
 		}
 	}
 
+	if err := ts.recordTagEvent(ctx, tag, previous, ""); err != nil {
+		return err
+	}
+
+	// The tag is removed before its aliases, so that a cycle of aliases
+	// written behind the registry's back terminates.
+	for _, alias := range aliases {
+		if err := ts.Untag(ctx, alias); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -2,13 +2,18 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	digest "github.com/opencontainers/go-digest"
 )
@@ -314,3 +319,454 @@ func digestMap(dgsts []digest.Digest) map[digest.Digest]struct{} {
 	}
 	return set
 }
+
+func TestTagHistory(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	dgsts := []digest.Digest{
+		digest.FromString("first"),
+		digest.FromString("second"),
+		digest.FromString("third"),
+	}
+
+	for _, dgst := range dgsts {
+		// Resolving the same digest repeatedly records a single entry.
+		for i := 0; i < 2; i++ {
+			if err := RecordTagResolution(ctx, d, "a/b", "latest", dgst, 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	history, err := TagHistory(ctx, d, "a/b", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("unexpected history length: %d != 2", len(history))
+	}
+
+	for i, entry := range history {
+		if entry.Digest != dgsts[i+1] {
+			t.Errorf("unexpected digest for history entry %d: %v != %v", i, entry.Digest, dgsts[i+1])
+		}
+	}
+
+	history, err = TagHistory(ctx, d, "a/b", "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("unexpected history for unknown tag: %v", history)
+	}
+
+	// Concurrent resolutions are not lost.
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			errs <- RecordTagResolution(ctx, d, "a/b", "concurrent", digest.FromString(fmt.Sprint(i)), 10)
+		}(i)
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error recording concurrently: %v", err)
+		}
+	}
+
+	history, err = TagHistory(ctx, d, "a/b", "concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != cap(errs) {
+		t.Fatalf("unexpected history length after concurrent records: %d != %d", len(history), cap(errs))
+	}
+}
+
+// casHidingDriver hides any CompareAndSwapper implementation of the driver it
+// wraps.
+type casHidingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func TestTagStoreSwapTag(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		driver storagedriver.StorageDriver
+	}{
+		{name: "atomic", driver: inmemory.New()},
+		{name: "fallback", driver: casHidingDriver{inmemory.New()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			reg, err := NewRegistry(ctx, tc.driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			repoRef, _ := reference.WithName("a/b")
+			repo, err := reg.Repository(ctx, repoRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags := repo.Tags(ctx).(distribution.TagSwapper)
+
+			first := distribution.Descriptor{Digest: digest.FromString("first")}
+			second := distribution.Descriptor{Digest: digest.FromString("second")}
+
+			if err := tags.SwapTag(ctx, "latest", "", first); err != nil {
+				t.Fatalf("unexpected error creating tag: %v", err)
+			}
+
+			err = tags.SwapTag(ctx, "latest", "", second)
+			expected := distribution.ErrTagPreconditionFailed{Tag: "latest", Actual: first.Digest}
+			if err != expected {
+				t.Fatalf("unexpected error creating existing tag: %v != %v", err, expected)
+			}
+
+			err = tags.SwapTag(ctx, "latest", second.Digest, first)
+			expected = distribution.ErrTagPreconditionFailed{Tag: "latest", Expected: second.Digest, Actual: first.Digest}
+			if err != expected {
+				t.Fatalf("unexpected error swapping mismatched tag: %v != %v", err, expected)
+			}
+
+			if err := tags.SwapTag(ctx, "latest", first.Digest, second); err != nil {
+				t.Fatalf("unexpected error swapping tag: %v", err)
+			}
+
+			desc, err := repo.Tags(ctx).Get(ctx, "latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != second.Digest {
+				t.Fatalf("unexpected digest for tag: %v != %v", desc.Digest, second.Digest)
+			}
+
+			// Of concurrent swaps from the same digest, only one succeeds.
+			errs := make(chan error, 10)
+			for i := 0; i < cap(errs); i++ {
+				go func(i int) {
+					desc := distribution.Descriptor{Digest: digest.FromString(fmt.Sprint(i))}
+					errs <- tags.SwapTag(ctx, "latest", second.Digest, desc)
+				}(i)
+			}
+
+			var succeeded int
+			for i := 0; i < cap(errs); i++ {
+				switch err := <-errs; err.(type) {
+				case nil:
+					succeeded++
+				case distribution.ErrTagPreconditionFailed:
+				default:
+					t.Fatalf("unexpected error swapping tag concurrently: %v", err)
+				}
+			}
+			if succeeded != 1 {
+				t.Fatalf("unexpected number of successful concurrent swaps: %d != 1", succeeded)
+			}
+		})
+	}
+}
+
+func TestTagAlias(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := repo.Tags(ctx)
+
+	first := distribution.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	second := distribution.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+
+	expectResolves := func(tag string, expected digest.Digest) {
+		t.Helper()
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			t.Fatalf("unexpected error resolving %q: %v", tag, err)
+		}
+		if desc.Digest != expected {
+			t.Fatalf("unexpected digest for %q: %s != %s", tag, desc.Digest, expected)
+		}
+	}
+
+	if err := tags.Tag(ctx, "v1", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTagAlias(ctx, d, "a/b", "stable", "v1"); err != nil {
+		t.Fatalf("unexpected error setting alias: %v", err)
+	}
+	expectResolves("stable", first.Digest)
+
+	// The alias follows its target.
+	if err := tags.Tag(ctx, "v1", second); err != nil {
+		t.Fatal(err)
+	}
+	expectResolves("stable", second.Digest)
+
+	// Aliases may alias aliases, but not form cycles.
+	if err := SetTagAlias(ctx, d, "a/b", "beta", "stable"); err != nil {
+		t.Fatalf("unexpected error setting alias: %v", err)
+	}
+	expectResolves("beta", second.Digest)
+
+	if err := SetTagAlias(ctx, d, "a/b", "stable", "beta"); err != (distribution.ErrTagAliasCycle{Tag: "stable"}) {
+		t.Fatalf("unexpected error creating alias cycle: %v", err)
+	}
+	if err := SetTagAlias(ctx, d, "a/b", "self", "self"); err != (distribution.ErrTagAliasCycle{Tag: "self"}) {
+		t.Fatalf("unexpected error aliasing tag to itself: %v", err)
+	}
+	if err := SetTagAlias(ctx, d, "a/b", "dangling", "missing"); err != (distribution.ErrTagUnknown{Tag: "missing"}) {
+		t.Fatalf("unexpected error aliasing unknown tag: %v", err)
+	}
+
+	// Cycles written behind the registry's back are detected on resolution.
+	for tag, target := range map[string]string{"c1": "c2", "c2": "c1"} {
+		aliasPath, err := pathFor(manifestTagAliasPathSpec{name: "a/b", tag: tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.PutContent(ctx, aliasPath, []byte(target)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tags.Get(ctx, "c1"); err != (distribution.ErrTagAliasCycle{Tag: "c1"}) {
+		t.Fatalf("unexpected error resolving alias cycle: %v", err)
+	}
+
+	// Pushing to an alias replaces it.
+	if err := tags.Tag(ctx, "stable", first); err != nil {
+		t.Fatal(err)
+	}
+	expectResolves("stable", first.Digest)
+	expectResolves("beta", first.Digest)
+	if target, err := TagAlias(ctx, d, "a/b", "stable"); err != nil || target != "" {
+		t.Fatalf("unexpected alias after push: %q, %v", target, err)
+	}
+
+	// Aliasing a tag replaces the manifest it held.
+	if err := SetTagAlias(ctx, d, "a/b", "stable", "v1"); err != nil {
+		t.Fatalf("unexpected error setting alias: %v", err)
+	}
+	expectResolves("stable", second.Digest)
+
+	if err := RemoveTagAlias(ctx, d, "a/b", "stable"); err != nil {
+		t.Fatalf("unexpected error removing alias: %v", err)
+	}
+	if _, err := tags.Get(ctx, "stable"); err != (distribution.ErrTagUnknown{Tag: "stable"}) {
+		t.Fatalf("unexpected error resolving removed alias: %v", err)
+	}
+}
+
+func TestTagAliasDeletes(t *testing.T) {
+	ctx := context.Background()
+	desc := distribution.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+
+	setup := func(policy string) (storagedriver.StorageDriver, distribution.TagService) {
+		t.Helper()
+		d := inmemory.New()
+		reg, err := NewRegistry(ctx, d, TagAliasDeletes(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		repoRef, _ := reference.WithName("a/b")
+		repo, err := reg.Repository(ctx, repoRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := repo.Tags(ctx)
+
+		if err := tags.Tag(ctx, "v1", desc); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetTagAlias(ctx, d, "a/b", "stable", "v1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetTagAlias(ctx, d, "a/b", "beta", "stable"); err != nil {
+			t.Fatal(err)
+		}
+		return d, tags
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		_, tags := setup(TagAliasDeletesStrict)
+
+		err := tags.Untag(ctx, "v1")
+		if !reflect.DeepEqual(err, distribution.ErrTagAliased{Tag: "v1", Aliases: []string{"stable"}}) {
+			t.Fatalf("unexpected error untagging aliased tag: %v", err)
+		}
+		for _, tag := range []string{"v1", "stable", "beta"} {
+			if _, err := tags.Get(ctx, tag); err != nil {
+				t.Fatalf("unexpected error resolving %q after rejected untag: %v", tag, err)
+			}
+		}
+
+		// Once its aliases are gone, the tag may be deleted.
+		if err := tags.Untag(ctx, "beta"); err != nil {
+			t.Fatalf("unexpected error untagging unaliased alias: %v", err)
+		}
+		if err := tags.Untag(ctx, "stable"); err != nil {
+			t.Fatalf("unexpected error untagging unaliased alias: %v", err)
+		}
+		if err := tags.Untag(ctx, "v1"); err != nil {
+			t.Fatalf("unexpected error untagging unaliased tag: %v", err)
+		}
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		d, tags := setup(TagAliasDeletesCascade)
+
+		if err := tags.Untag(ctx, "v1"); err != nil {
+			t.Fatalf("unexpected error untagging aliased tag: %v", err)
+		}
+		for _, tag := range []string{"v1", "stable", "beta"} {
+			if _, err := tags.Get(ctx, tag); err != (distribution.ErrTagUnknown{Tag: tag}) {
+				t.Fatalf("unexpected error resolving %q after cascading untag: %v", tag, err)
+			}
+			if target, err := TagAlias(ctx, d, "a/b", tag); err != nil || target != "" {
+				t.Fatalf("unexpected alias for %q after cascading untag: %q, %v", tag, target, err)
+			}
+		}
+	})
+
+	if _, err := NewRegistry(ctx, inmemory.New(), TagAliasDeletes("sometimes")); err == nil {
+		t.Fatal("expected error for invalid policy")
+	}
+}
+
+// jitterDriver delays writes other than to tag event logs, so that concurrent
+// writers interleave between changing a tag and recording the change.
+type jitterDriver struct {
+	*inmemory.Driver
+}
+
+func (d jitterDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if !strings.Contains(path, "/_events/") {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+	}
+	return d.Driver.PutContent(ctx, path, content)
+}
+
+func TestTagEventsOrderedBySwap(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		driver storagedriver.StorageDriver
+	}{
+		{name: "atomic", driver: jitterDriver{inmemory.New()}},
+		{name: "fallback", driver: casHidingDriver{jitterDriver{inmemory.New()}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			reg, err := NewRegistry(ctx, tc.driver, TagEventLog(1000))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			repoRef, _ := reference.WithName("a/b")
+			repo, err := reg.Repository(ctx, repoRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags := repo.Tags(ctx).(distribution.TagSwapper)
+
+			// Each writer repoints the tag from whatever it refers to,
+			// retrying when it loses a race, and reports the swaps it
+			// committed.
+			type swap struct{ from, to digest.Digest }
+			const writers, repoints = 4, 10
+			swaps := make(chan swap, writers*repoints)
+			errs := make(chan error, writers)
+			for w := 0; w < writers; w++ {
+				go func(w int) {
+					for i := 0; i < repoints; {
+						desc, err := repo.Tags(ctx).Get(ctx, "latest")
+						if _, ok := err.(distribution.ErrTagUnknown); ok {
+							err = nil
+						}
+						if err != nil {
+							errs <- err
+							return
+						}
+
+						to := digest.FromString(fmt.Sprintf("%d-%d", w, i))
+						switch err := tags.SwapTag(ctx, "latest", desc.Digest, distribution.Descriptor{Digest: to}); err.(type) {
+						case nil:
+							swaps <- swap{from: desc.Digest, to: to}
+							i++
+						case distribution.ErrTagPreconditionFailed:
+						default:
+							errs <- err
+							return
+						}
+					}
+					errs <- nil
+				}(w)
+			}
+			for w := 0; w < writers; w++ {
+				if err := <-errs; err != nil {
+					t.Fatalf("unexpected error repointing tag: %v", err)
+				}
+			}
+			close(swaps)
+
+			// The swaps succeeded only from the digest then committed, so
+			// they chain into the order in which they were committed.
+			next := map[digest.Digest]digest.Digest{}
+			for s := range swaps {
+				next[s.from] = s.to
+			}
+			var committed []digest.Digest
+			for d := next[""]; d != ""; d = next[d] {
+				committed = append(committed, d)
+			}
+			if len(committed) != writers*repoints {
+				t.Fatalf("unexpected number of committed swaps: %d != %d", len(committed), writers*repoints)
+			}
+
+			events, _, err := TagEvents(ctx, tc.driver, repoRef.Name(), "", 0)
+			if err != nil {
+				t.Fatalf("unexpected error reading tag events: %v", err)
+			}
+			if len(events) == 0 {
+				t.Fatal("expected tag events to be recorded")
+			}
+
+			// Concurrent swaps may be described by a single event, but the
+			// events follow the committed order, ending with the final state.
+			c := 0
+			for i, event := range events {
+				if event.Sequence != uint64(i) {
+					t.Fatalf("unexpected sequence of event %d: %d", i, event.Sequence)
+				}
+				for c < len(committed) && committed[c] != event.Digest {
+					c++
+				}
+				if c == len(committed) {
+					t.Fatalf("event %d refers to %s out of committed order", i, event.Digest)
+				}
+				// The creation of the tag may be described by the event of
+				// a concurrent repoint.
+				if event.Action != TagEventUpdate && (i != 0 || event.Action != TagEventCreate) {
+					t.Fatalf("unexpected action of event %d: %q", i, event.Action)
+				}
+			}
+
+			final, err := repo.Tags(ctx).Get(ctx, "latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := events[len(events)-1]; last.Digest != final.Digest || final.Digest != committed[len(committed)-1] {
+				t.Fatalf("last event refers to %s, expected final state %s", last.Digest, final.Digest)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/docker/distribution"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// UploadOffset returns the number of bytes written to the upload identified
+// by id in the named repository, as reported by the storage driver, without
+// resuming the upload. distribution.ErrBlobUploadUnknown is returned if the
+// upload does not exist, as once it has been completed or canceled.
+func UploadOffset(ctx context.Context, driver storagedriver.StorageDriver, name, id string) (int64, error) {
+	startedAtPath, err := pathFor(uploadStartedAtPathSpec{name: name, id: id})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := driver.Stat(ctx, startedAtPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return 0, distribution.ErrBlobUploadUnknown
+		}
+		return 0, err
+	}
+
+	dataPath, err := pathFor(uploadDataPathSpec{name: name, id: id})
+	if err != nil {
+		return 0, err
+	}
+	fi, err := driver.Stat(ctx, dataPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			// Nothing has been written yet.
+			return 0, nil
+		}
+		return 0, err
+	}
+	return fi.Size(), nil
+}
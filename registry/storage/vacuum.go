@@ -47,7 +47,9 @@ func (v Vacuum) RemoveBlob(dgst string) error {
 		return err
 	}
 
-	return nil
+	// The vacuum does not know whether the index is enabled, so always
+	// removes the blob from it.
+	return NewBlobIndex(v.driver).Remove(v.ctx, d)
 }
 
 // RemoveManifest removes a manifest from the filesystem
@@ -81,7 +83,15 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 		return err
 	}
 	dcontext.GetLogger(v.ctx).Infof("deleting manifest: %s", manifestPath)
-	return v.driver.Delete(v.ctx, manifestPath)
+	if err := v.driver.Delete(v.ctx, manifestPath); err != nil {
+		return err
+	}
+
+	if err := v.ReleaseUsage(name, dgst); err != nil {
+		return err
+	}
+
+	return removeBlobLinker(v.ctx, v.driver, name, dgst)
 }
 
 // RemoveLayerLink removes a layer link from the filesystem
@@ -103,7 +113,23 @@ func (v Vacuum) RemoveLayerLink(manifestName string, dgst digest.Digest) error {
 		}
 	}
 
-	return v.driver.Delete(v.ctx, layerLinkPath)
+	if err := v.driver.Delete(v.ctx, layerLinkPath); err != nil {
+		return err
+	}
+
+	if err := v.ReleaseUsage(manifestName, dgst); err != nil {
+		return err
+	}
+
+	return removeBlobLinker(v.ctx, v.driver, manifestName, dgst)
+}
+
+// ReleaseUsage removes the content of the given digests from the usage
+// counted against the quota of the named repository, once it is no longer
+// linked into the repository or has been removed from the blob store. Content
+// which was not counted is ignored.
+func (v Vacuum) ReleaseUsage(repoName string, digests ...digest.Digest) error {
+	return releaseRepositoryUsage(v.ctx, v.driver, repoName, digests...)
 }
 
 // RemoveRepository removes a repository directory from the
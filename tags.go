@@ -28,6 +28,25 @@ type TagService interface {
 	Lookup(ctx context.Context, digest Descriptor) ([]string, error)
 }
 
+// TagSwapper is implemented by tag services which can update a tag
+// conditionally on its current association, without racing other updates.
+// Implementations backed by storage without an atomic compare-and-swap may
+// only guarantee this within a single registry instance.
+type TagSwapper interface {
+	// SwapTag associates the tag with desc only if it is currently associated
+	// with expected, returning ErrTagPreconditionFailed otherwise. An empty
+	// expected digest requires that the tag does not exist.
+	SwapTag(ctx context.Context, tag string, expected digest.Digest, desc Descriptor) error
+}
+
+// UntagChecker is implemented by tag services which may refuse to untag a
+// tag, so that callers can find out before making other changes.
+type UntagChecker interface {
+	// CheckUntag returns the error Untag would fail with because of the
+	// aliases of the tag, such as ErrTagAliased, or nil if there is none.
+	CheckUntag(ctx context.Context, tag string) error
+}
+
 // TagManifestsProvider provides method to retrieve the digests of manifests that a tag historically
 // pointed to
 type TagManifestsProvider interface {